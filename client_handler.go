@@ -13,6 +13,7 @@ import (
 	_ "crypto/sha1"
 	_ "crypto/sha256"
 
+	"mellium.im/communique/internal/chatstate"
 	"mellium.im/communique/internal/client"
 	"mellium.im/communique/internal/client/event"
 	"mellium.im/communique/internal/storage"
@@ -20,7 +21,6 @@ import (
 	"mellium.im/xmpp/bookmarks"
 	"mellium.im/xmpp/crypto"
 	"mellium.im/xmpp/disco"
-	"mellium.im/xmpp/history"
 	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/roster"
 )
@@ -40,9 +40,16 @@ func newClientHandler(client *client.Client, pane *ui.UI, db *storage.DB, logger
 			pane.Online(jid.JID(e), jid.JID(e).Equal(client.LocalAddr()))
 		case event.StatusOffline:
 			pane.Offline(jid.JID(e), jid.JID(e).Equal(client.LocalAddr()))
+		case event.Reconnecting:
+			logger.Print(p.Sprintf("connection lost, reconnecting in %s…", time.Duration(e).Round(time.Second)))
+			pane.Reconnecting(time.Duration(e))
 		case event.FetchBookmarks:
 			for bookmark := range e.Items {
-				pane.UpdateBookmarks(bookmarks.Channel(bookmark))
+				item := bookmarks.Channel(bookmark)
+				pane.UpdateBookmarks(item)
+				if item.Autojoin && !client.Joined(item.JID) {
+					pane.Autojoin(item)
+				}
 			}
 		case event.FetchRoster:
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -53,66 +60,16 @@ func newClientHandler(client *client.Client, pane *ui.UI, db *storage.DB, logger
 			}
 			ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
 			defer cancel()
-			accountBare := client.Session.LocalAddr().Bare()
-			afterIDs := db.AfterID(ctx)
-			ids := make(map[string]storage.AfterIDResult)
-			for afterIDs.Next() {
-				id := afterIDs.Result()
-				ids[id.Addr.Bare().String()] = id
-			}
-			if err := afterIDs.Err(); err != nil {
+			lastSeen, err := lastSeen(ctx, db)
+			if err != nil {
 				logger.Print(p.Sprintf("error querying database for last seen messages: %v", err))
 				return
 			}
+			_, _, _, screenHeight := pane.GetRect()
 			err = db.ForRoster(ctx, func(item event.UpdateRoster) {
 				pane.UpdateRoster(ui.RosterItem{Item: roster.Item(item.Item)})
-				id, ok := ids[item.JID.Bare().String()]
-				go func() {
-					// We don't really care how long it takes to get history, and it will
-					// continue to be processed even if we time out, so just set this to a
-					// long time.
-					ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-					defer cancel()
-					if ok {
-						// We have some history already, catch up from the last known
-						// message if extended queries are supported, or from the last known
-						// datetime if not.
-
-						// if extended {
-						// 	_, err := history.Fetch(ctx, history.Query{
-						// 		With:    item.JID.Bare(),
-						// 		AfterID: id.ID,
-						// 	}, accountBare, client.Session)
-						// 	if err != nil {
-						// 		logger.Printf("error fetching history after %s for %s: %v", id.ID, item.JID, err)
-						// 	}
-						// 	return
-						// }
-
-						_, err := history.Fetch(ctx, history.Query{
-							With:  item.JID.Bare(),
-							Start: id.Delay,
-						}, accountBare, client.Session)
-						if err != nil {
-							logger.Print(p.Sprintf("error fetching history after %s for %s: %v", id.ID, item.JID, err))
-						}
-						return
-					}
-
-					// We don't have any history yet, so bootstrap a limited amount of
-					// history from the server.
-					_, _, _, screenHeight := pane.GetRect()
-					_, err := history.Fetch(ctx, history.Query{
-						With:    item.JID.Bare(),
-						End:     time.Now(),
-						Limit:   uint64(2 * screenHeight), // #nosec G115
-						Reverse: true,
-						Last:    true,
-					}, accountBare, client.Session)
-					if err != nil {
-						debug.Print(p.Sprintf("error bootstraping history for %s: %v", item.JID, err))
-					}
-				}()
+				last, ok := lastSeen[item.JID.Bare().String()]
+				go fetchHistory(client, item.JID, last, ok, uint64(2*screenHeight), logger, debug) // #nosec G115
 			})
 			if err != nil {
 				logger.Print(p.Sprintf("error iterating over roster items: %v", err))
@@ -132,15 +89,48 @@ func newClientHandler(client *client.Client, pane *ui.UI, db *storage.DB, logger
 			if err != nil {
 				logger.Print(p.Sprintf("error marking message %q as received: %v", e, err))
 			}
+			setDelivery(pane, string(e), deliveryReceived)
+		case event.OutboxSent:
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			outboxSent(ctx, e, pane, db, logger)
+		case event.MessageError:
+			logger.Print(p.Sprintf("message %q to %s could not be delivered: %v", e.ID, e.From, e.Err))
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			err := db.MarkFailed(ctx, e.ID)
+			if err != nil {
+				logger.Print(p.Sprintf("error marking message %q as failed: %v", e.ID, err))
+			}
+			setDelivery(pane, e.ID, deliveryFailed)
 		case event.ChatMessage:
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 			defer cancel()
-			if err := writeMessage(pane, e, false); err != nil {
+			if !e.Account {
+				// Only the server is trusted to say when a message was sent (see
+				// InsertMsg), so show others at the time they arrived like they will be
+				// when the history is loaded again.
+				e.Delay.Time = time.Time{}
+			}
+			if e.Retract != nil {
+				retractMessage(ctx, pane, db, e, client.LocalAddr(), logger)
+				return
+			}
+			if e.Displayed != nil && e.Body == "" {
+				markDisplayed(ctx, pane, db, e, logger)
+				return
+			}
+			if mergeReflection(ctx, db, e, p, logger) {
+				return
+			}
+			if err := writeMessage(pane, e, deliverySent, false); err != nil {
 				logger.Print(p.Sprintf("error writing received message to chat: %v", err))
 			}
 			if err := db.InsertMsg(ctx, e.Account, e, client.LocalAddr()); err != nil {
 				logger.Print(p.Sprintf("error writing message to database: %v", err))
 			}
+			saveConversation(ctx, pane, db, e, logger)
+			showEdit(ctx, pane, db, e, logger)
 			// If we sent the message that wasn't automated (it has a body), assume
 			// we've read everything before it.
 			if e.Sent && e.Body != "" {
@@ -152,12 +142,42 @@ func newClientHandler(client *client.Client, pane *ui.UI, db *storage.DB, logger
 		case event.HistoryMessage:
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 			defer cancel()
-			if err := writeMessage(pane, e.Result.Forward.Msg, false); err != nil {
+			if e.Result.Forward.Msg.Retract != nil {
+				retractMessage(ctx, pane, db, e.Result.Forward.Msg, client.LocalAddr(), logger)
+				return
+			}
+			if e.Result.Forward.Msg.Displayed != nil && e.Result.Forward.Msg.Body == "" {
+				markDisplayed(ctx, pane, db, e.Result.Forward.Msg, logger)
+				return
+			}
+			if mergeReflection(ctx, db, e.Result.Forward.Msg, p, logger) {
+				return
+			}
+			if err := writeMessage(pane, e.Result.Forward.Msg, deliverySent, false); err != nil {
 				logger.Print(p.Sprintf("error writing history message to chat: %v", err))
 			}
 			if err := db.InsertMsg(ctx, true, e.Result.Forward.Msg, client.LocalAddr()); err != nil {
 				logger.Print(p.Sprintf("error writing history to database: %v", err))
 			}
+			saveConversation(ctx, pane, db, e.Result.Forward.Msg, logger)
+			showEdit(ctx, pane, db, e.Result.Forward.Msg, logger)
+		case event.ChatState:
+			pane.SetTyping(e.From, e.State == chatstate.Composing)
+			pane.Redraw()
+		case event.OccupantPresence:
+			room := e.Occupant.Bare()
+			pane.SetOccupants(room, client.Occupants(room))
+			if err := writeOccupantPresence(pane, e); err != nil {
+				logger.Print(p.Sprintf("error writing channel presence to chat: %v", err))
+			}
+			pane.Redraw()
+		case event.ChannelJoined:
+			go fetchChannelHistory(client, db, jid.JID(e), channelHistoryLimit, logger, debug)
+		case event.Subject:
+			pane.SetSubject(e.From.Bare(), e.Subject)
+			pane.Redraw()
+		case event.Invitation:
+			pane.ShowInvite(e.Room, e.From, e.Password, e.Reason, e.Mediated)
 		case event.NewCaps:
 			go func() {
 				defer panicHandler()
@@ -10,6 +10,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/gdamore/tcell/v2"
@@ -18,6 +19,7 @@ import (
 
 	"mellium.im/cli"
 	"mellium.im/communique/internal/localerr"
+	"mellium.im/communique/internal/ui"
 )
 
 func genCfgCmd(p *message.Printer, logger *log.Logger) *cli.Command {
@@ -71,6 +73,11 @@ func printConfig(w io.Writer, p *message.Printer) error {
 		}},
 	}
 	defConfig.UI.Theme = "default"
+	defConfig.UI.TimeFormat = ui.DefaultTimeFormat
+	defConfig.Keys = make(map[string]interface{})
+	for action, keys := range ui.DefaultKeys() {
+		defConfig.Keys[action] = keys
+	}
 	_, err := p.Fprintf(w, `# This is a config file for Communiqué.
 # If the -f option is not provided, Communiqué will search for a config file in:
 #
@@ -131,13 +138,123 @@ type config struct {
 		Width      int      `toml:"width"`
 		FilePicker []string `toml:"file_picker"`
 		Notify     []string `toml:"notify"`
+		TimeFormat string   `toml:"time_format"`
 	} `toml:"ui"`
 
+	// Keys maps actions to a key sequence or a list of key sequences.
+	// They are checked when the bindings are read (see keys).
+	Keys map[string]interface{} `toml:"keys"`
+
+	Control struct {
+		Enabled bool   `toml:"enabled"`
+		Socket  string `toml:"socket"`
+	} `toml:"control"`
+
 	Theme []theme `toml:"theme"`
 }
 
 const configFileName = "config.toml"
 
+// loadConfig parses the config file at path, or the first config file found if
+// path is empty (see configFile).
+// Every account in the config file is returned with the default account first,
+// which is overridden by defAcct if it is not empty.
+func loadConfig(path, defAcct string, p *message.Printer, logger *log.Logger) (config, []account, error) {
+	f, fpath, err := configFile(path)
+	if err != nil {
+		return config{}, nil, localerr.Wrap(p, `%v
+
+Try running '%s config' to generate a default config file.`, err, os.Args[0])
+	}
+	cfg := config{}
+	_, err = toml.NewDecoder(f).Decode(&cfg)
+	if err != nil {
+		logger.Print(p.Sprintf("error parsing config file: %v", err))
+	}
+	if err = f.Close(); err != nil {
+		logger.Print(p.Sprintf("error closing config file: %v", err))
+	}
+
+	if defAcct != "" {
+		cfg.DefaultAcct = defAcct
+	}
+
+	if len(cfg.Account) == 0 {
+		return cfg, nil, localerr.Wrap(p, `no accounts configured, edit %q and add:
+
+	[[account]]
+	address="me@example.com"
+`, fpath)
+	}
+	if cfg.DefaultAcct == "" {
+		cfg.DefaultAcct = cfg.Account[0].Address
+	}
+	accts := make([]account, 0, len(cfg.Account))
+	for _, a := range cfg.Account {
+		if a.Address == cfg.DefaultAcct {
+			accts = append([]account{a}, accts...)
+			continue
+		}
+		accts = append(accts, a)
+	}
+	if accts[0].Address != cfg.DefaultAcct {
+		return cfg, nil, localerr.Wrap(p, "account %q not found in config file", cfg.DefaultAcct)
+	}
+	return cfg, accts, nil
+}
+
+// keys returns the key bindings set in the config file.
+// Each action may be bound to a single key sequence or a list of them, actions
+// bound to anything else are logged and skipped.
+func (cfg config) keys(p *message.Printer, logger *log.Logger) map[string][]string {
+	keys := make(map[string][]string, len(cfg.Keys))
+	for action, v := range cfg.Keys {
+		l, err := keyList(action, v, p)
+		if err != nil {
+			logger.Print(p.Sprintf("error in key bindings: %v", err))
+			continue
+		}
+		keys[action] = l
+	}
+	return keys
+}
+
+// keyList returns the key sequences that action is bound to.
+func keyList(action string, v interface{}, p *message.Printer) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, localerr.Wrap(p, "expected key sequence for %q to be a string, got %T", action, item)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	}
+	return nil, localerr.Wrap(p, "expected a key sequence or a list of key sequences for %q, got %T", action, v)
+}
+
+// timeout returns the connection timeout set in the config file, or the
+// default if it is not set or invalid.
+func (cfg config) timeout(p *message.Printer, logger *log.Logger) time.Duration {
+	timeout := 30 * time.Second
+	if cfg.Timeout != "" {
+		t, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			logger.Print(p.Sprintf("error parsing timeout, defaulting to 30s: %q", err))
+			return timeout
+		}
+		timeout = t
+	}
+	return timeout
+}
+
 // configFile attempts to open the config file for reading.
 // If a file is provided, only that file is checked, otherwise it attempts to
 // open the following (falling back if the file does not exist or cannot be
@@ -0,0 +1,49 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), configFileName)
+	err := os.WriteFile(path, []byte(`
+[[account]]
+address = "me@example.net"
+
+[keys]
+up = "k"
+down = ["j", "<Down>"]
+top = 1
+bottom = ["G", 2]
+`), 0600)
+	if err != nil {
+		t.Fatalf("error writing config file: %v", err)
+	}
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+	cfg, _, err := loadConfig(path, "", testPrinter(), logger)
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	keys := cfg.keys(testPrinter(), logger)
+	want := map[string][]string{
+		"up":   {"k"},
+		"down": {"j", "<Down>"},
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("wrong key bindings: want=%v, got=%v", want, keys)
+	}
+	if n := strings.Count(buf.String(), "error in key bindings"); n != 2 {
+		t.Errorf("expected both invalid bindings to be logged, got=%q", buf.String())
+	}
+}
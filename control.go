@@ -0,0 +1,440 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/text/message"
+
+	clientevent "mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// controlRequest is a single line of JSON sent to the control socket.
+//
+// The account field selects the account that the request applies to and
+// defaults to the default account.
+type controlRequest struct {
+	ID      string `json:"id,omitempty"`
+	Cmd     string `json:"cmd"`
+	Account string `json:"account,omitempty"`
+	To      string `json:"to,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Body    string `json:"body,omitempty"`
+	Status  string `json:"status,omitempty"`
+}
+
+// controlResponse is written to the control socket as a single line of JSON in
+// response to each request, and for each event after a subscribe request.
+type controlResponse struct {
+	ID            string                `json:"id,omitempty"`
+	OK            bool                  `json:"ok"`
+	Error         string                `json:"error,omitempty"`
+	Conversations []controlConversation `json:"conversations,omitempty"`
+	Event         *controlEvent         `json:"event,omitempty"`
+}
+
+// controlConversation is an entry in the conversations list.
+type controlConversation struct {
+	Account      string    `json:"account"`
+	JID          string    `json:"jid"`
+	Name         string    `json:"name,omitempty"`
+	Room         bool      `json:"room,omitempty"`
+	Pinned       bool      `json:"pinned,omitempty"`
+	Unread       int       `json:"unread"`
+	LastActivity time.Time `json:"last_activity,omitempty"`
+}
+
+// controlEvent is an event received by one of the clients.
+// Only the fields that make sense for the type of event are set.
+type controlEvent struct {
+	Type    string `json:"type"`
+	Account string `json:"account"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	ID      string `json:"id,omitempty"`
+	MsgType string `json:"msg_type,omitempty"`
+	Body    string `json:"body,omitempty"`
+	Sent    bool   `json:"sent,omitempty"`
+	Status  string `json:"status,omitempty"`
+	State   string `json:"state,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// controlAccount is what the control socket needs to know about each account.
+type controlAccount struct {
+	addr    jid.JID
+	handler func(interface{})
+	db      *storage.DB
+}
+
+// controlServer listens on a Unix domain socket and lets scripts drive the
+// running client using a line delimited JSON protocol.
+type controlServer struct {
+	p      *message.Printer
+	logger *log.Logger
+	debug  *log.Logger
+
+	accountsM sync.Mutex
+	accounts  []controlAccount
+
+	subsM sync.Mutex
+	subs  map[chan controlEvent]struct{}
+}
+
+func newControlServer(p *message.Printer, logger, debug *log.Logger) *controlServer {
+	return &controlServer{
+		p:      p,
+		logger: logger,
+		debug:  debug,
+		subs:   make(map[chan controlEvent]struct{}),
+	}
+}
+
+// controlSocketPath returns the default location of the control socket.
+func controlSocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("%s-%d", appName, os.Getuid()))
+	} else {
+		dir = filepath.Join(dir, appName)
+	}
+	return filepath.Join(dir, "control.sock")
+}
+
+// AddAccount makes an account available to the control socket.
+// The first account added is used when a request does not name one.
+// It returns a function that should be called with every event emitted by the
+// account's client so that it can be passed on to subscribers.
+func (s *controlServer) AddAccount(addr jid.JID, uiHandler func(interface{}), db *storage.DB) func(interface{}) {
+	s.accountsM.Lock()
+	s.accounts = append(s.accounts, controlAccount{
+		addr:    addr.Bare(),
+		handler: uiHandler,
+		db:      db,
+	})
+	s.accountsM.Unlock()
+	return func(ev interface{}) {
+		e, ok := controlEventFor(ev)
+		if !ok {
+			return
+		}
+		e.Account = addr.Bare().String()
+		s.publish(e)
+	}
+}
+
+// Listen starts accepting connections on the Unix domain socket at path and
+// returns a function that stops listening and removes the socket.
+func (s *controlServer) Listen(path string) (func() error, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	// If the socket is left over from a previous run that exited without
+	// cleaning up, nobody will be listening on it any more.
+	if conn, err := net.Dial("unix", path); err == nil {
+		/* #nosec */
+		conn.Close()
+		return nil, errors.New(s.p.Sprintf("control socket %s is already in use", path))
+	}
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		/* #nosec */
+		l.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					s.logger.Print(s.p.Sprintf("error accepting control connection: %v", err))
+				}
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return l.Close, nil
+}
+
+// serve reads requests from conn until it is closed.
+func (s *controlServer) serve(conn net.Conn) {
+	defer panicHandler()
+	/* #nosec */
+	defer conn.Close()
+
+	var writeM sync.Mutex
+	enc := json.NewEncoder(conn)
+	write := func(resp controlResponse) error {
+		writeM.Lock()
+		defer writeM.Unlock()
+		return enc.Encode(resp)
+	}
+
+	var unsubscribe func()
+	defer func() {
+		if unsubscribe != nil {
+			unsubscribe()
+		}
+	}()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var req controlRequest
+		err := json.Unmarshal(scanner.Bytes(), &req)
+		if err != nil {
+			err = write(controlResponse{Error: s.p.Sprintf("invalid request: %v", err)})
+			if err != nil {
+				return
+			}
+			continue
+		}
+		resp := controlResponse{ID: req.ID}
+		if req.Cmd == "subscribe" {
+			if unsubscribe == nil {
+				var events <-chan controlEvent
+				events, unsubscribe = s.subscribe()
+				go func() {
+					for e := range events {
+						if write(controlResponse{OK: true, Event: &e}) != nil {
+							return
+						}
+					}
+				}()
+			}
+			resp.OK = true
+		} else {
+			resp.Conversations, err = s.handle(req)
+			if err != nil {
+				resp.Error = err.Error()
+			} else {
+				resp.OK = true
+			}
+		}
+		if write(resp) != nil {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		s.debug.Print(s.p.Sprintf("error reading from control connection: %v", err))
+	}
+}
+
+// account returns the account named addr, or the default account if addr is
+// empty.
+func (s *controlServer) account(addr string) (controlAccount, error) {
+	s.accountsM.Lock()
+	defer s.accountsM.Unlock()
+	if len(s.accounts) == 0 {
+		return controlAccount{}, errors.New(s.p.Sprintf("no accounts are running"))
+	}
+	if addr == "" {
+		return s.accounts[0], nil
+	}
+	j, err := jid.Parse(addr)
+	if err != nil {
+		return controlAccount{}, err
+	}
+	for _, acct := range s.accounts {
+		if acct.addr.Equal(j.Bare()) {
+			return acct, nil
+		}
+	}
+	return controlAccount{}, errors.New(s.p.Sprintf("account %q not found", addr))
+}
+
+// handle runs a request by emitting the matching UI events.
+// Only requests that list conversations return any.
+func (s *controlServer) handle(req controlRequest) ([]controlConversation, error) {
+	p := s.p
+	if req.Cmd == "conversations" {
+		return s.conversations(req.Account)
+	}
+	acct, err := s.account(req.Account)
+	if err != nil {
+		return nil, err
+	}
+	switch req.Cmd {
+	case "send":
+		if req.Body == "" {
+			return nil, errors.New(p.Sprintf("no message body given"))
+		}
+		to, err := jid.Parse(req.To)
+		if err != nil {
+			return nil, err
+		}
+		typ := stanza.ChatMessage
+		switch req.Type {
+		case "", string(stanza.ChatMessage):
+		case string(stanza.GroupChatMessage):
+			typ = stanza.GroupChatMessage
+			to = to.Bare()
+		case string(stanza.NormalMessage):
+			typ = stanza.NormalMessage
+		default:
+			return nil, errors.New(p.Sprintf("unknown message type %q", req.Type))
+		}
+		acct.handler(event.ChatMessage{
+			Message: stanza.Message{
+				To:   to,
+				Type: typ,
+			},
+			Body: req.Body,
+		})
+	case "status":
+		switch req.Status {
+		case "online":
+			acct.handler(event.StatusOnline{})
+		case "away":
+			acct.handler(event.StatusAway{})
+		case "busy":
+			acct.handler(event.StatusBusy{})
+		case "offline":
+			acct.handler(event.StatusOffline{})
+		default:
+			return nil, errors.New(p.Sprintf("unknown status %q", req.Status))
+		}
+	case "join":
+		room, err := jid.Parse(req.To)
+		if err != nil {
+			return nil, err
+		}
+		acct.handler(event.OpenChannel(bookmarks.Channel{JID: room.Bare()}))
+	default:
+		return nil, errors.New(p.Sprintf("unknown command %q", req.Cmd))
+	}
+	return nil, nil
+}
+
+// conversations lists the conversations of the account named addr, or of every
+// account if addr is empty.
+func (s *controlServer) conversations(addr string) ([]controlConversation, error) {
+	var accts []controlAccount
+	if addr == "" {
+		s.accountsM.Lock()
+		accts = append(accts, s.accounts...)
+		s.accountsM.Unlock()
+	} else {
+		acct, err := s.account(addr)
+		if err != nil {
+			return nil, err
+		}
+		accts = []controlAccount{acct}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	list := []controlConversation{}
+	for _, acct := range accts {
+		convs, err := acct.db.Conversations(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range convs {
+			list = append(list, controlConversation{
+				Account:      acct.addr.String(),
+				JID:          c.JID.String(),
+				Name:         c.Name,
+				Room:         c.Room,
+				Pinned:       c.Pinned,
+				Unread:       c.Unread,
+				LastActivity: c.LastActivity,
+			})
+		}
+	}
+	return list, nil
+}
+
+// subscribe returns a channel that receives every client event until the
+// returned function is called.
+func (s *controlServer) subscribe() (<-chan controlEvent, func()) {
+	events := make(chan controlEvent, 64)
+	s.subsM.Lock()
+	s.subs[events] = struct{}{}
+	s.subsM.Unlock()
+	return events, func() {
+		s.subsM.Lock()
+		defer s.subsM.Unlock()
+		delete(s.subs, events)
+		close(events)
+	}
+}
+
+// publish sends an event to every subscriber.
+// Subscribers that are not keeping up miss events instead of blocking the
+// client.
+func (s *controlServer) publish(e controlEvent) {
+	s.subsM.Lock()
+	defer s.subsM.Unlock()
+	for events := range s.subs {
+		select {
+		case events <- e:
+		default:
+			s.debug.Print(s.p.Sprintf("control subscriber is not keeping up, dropping %s event", e.Type))
+		}
+	}
+}
+
+// controlEventFor converts the client events that scripts are likely to be
+// interested in.
+func controlEventFor(ev interface{}) (controlEvent, bool) {
+	switch e := ev.(type) {
+	case clientevent.ChatMessage:
+		if e.Body == "" {
+			return controlEvent{}, false
+		}
+		return controlEvent{
+			Type:    "message",
+			From:    e.From.String(),
+			To:      e.To.String(),
+			ID:      e.ID,
+			MsgType: string(e.Type),
+			Body:    e.Body,
+			Sent:    e.Sent,
+		}, true
+	case clientevent.StatusOnline:
+		return controlEvent{Type: "presence", From: jid.JID(e).String(), Status: "online"}, true
+	case clientevent.StatusAway:
+		return controlEvent{Type: "presence", From: jid.JID(e).String(), Status: "away"}, true
+	case clientevent.StatusBusy:
+		return controlEvent{Type: "presence", From: jid.JID(e).String(), Status: "busy"}, true
+	case clientevent.StatusOffline:
+		return controlEvent{Type: "presence", From: jid.JID(e).String(), Status: "offline"}, true
+	case clientevent.ChatState:
+		return controlEvent{Type: "chatstate", From: e.From.String(), State: e.State.String()}, true
+	case clientevent.Receipt:
+		return controlEvent{Type: "receipt", ID: string(e)}, true
+	case clientevent.Subject:
+		return controlEvent{Type: "subject", From: e.From.String(), Subject: e.Subject}, true
+	case clientevent.Invitation:
+		return controlEvent{Type: "invite", From: e.From.String(), To: e.Room.String(), Reason: e.Reason}, true
+	}
+	return controlEvent{}, false
+}
@@ -0,0 +1,70 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/ui"
+)
+
+// delivery is the state of a message that we sent.
+type delivery uint8
+
+// A list of delivery states.
+// The zero value is used for messages that were sent from another client or
+// loaded from the database without a receipt or an error.
+const (
+	deliverySent delivery = iota
+	deliveryPending
+	deliveryReceived
+	deliveryFailed
+)
+
+// storedDelivery returns the delivery state of the most recent message read
+// from iter.
+func storedDelivery(iter storage.MessageIter) delivery {
+	switch {
+	case iter.Failed():
+		return deliveryFailed
+	case iter.Received():
+		return deliveryReceived
+	case iter.Queued():
+		return deliveryPending
+	}
+	return deliverySent
+}
+
+// deliveryRegion returns the name of the region that holds the delivery
+// marker of the message with the given ID in the chat history.
+func deliveryRegion(id string) string {
+	// Region names are limited to a small set of characters, but IDs can be
+	// anything so hex encode them.
+	return "delivery-" + hex.EncodeToString([]byte(id))
+}
+
+// deliveryMarker returns the text that shows the delivery state at the end of
+// a message that we sent.
+func deliveryMarker(state delivery) string {
+	switch state {
+	case deliveryPending:
+		return "[::d]…[::-]"
+	case deliveryReceived:
+		return "[::d]✓✓[::-]"
+	case deliveryFailed:
+		return "[red]✗[-]"
+	}
+	return "[::d]✓[::-]"
+}
+
+// setDelivery updates the delivery marker of a message that is shown in the
+// chat history.
+func setDelivery(pane *ui.UI, id string, state delivery) {
+	if id == "" {
+		return
+	}
+	pane.SetRegionText(deliveryRegion(id), deliveryMarker(state))
+}
@@ -0,0 +1,57 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage/storagetest"
+	"mellium.im/xmpp/stanza"
+)
+
+func TestStoredDelivery(t *testing.T) {
+	ctx := context.Background()
+	var msgs []event.ChatMessage
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		msgs = append(msgs, event.ChatMessage{
+			Message:  stanza.Message{ID: id, From: testSelf, To: testJuliet, Type: stanza.ChatMessage},
+			OriginID: stanza.OriginID{ID: id},
+			Body:     "Good night, good night!",
+			Sent:     true,
+		})
+	}
+	db := storagetest.InsertMsgs(t, testSelf, msgs...)
+	if err := db.MarkReceived(ctx, event.Receipt("2")); err != nil {
+		t.Fatalf("error marking message as received: %v", err)
+	}
+	// A message that bounced after it was received is shown as failed.
+	for _, id := range []string{"3", "4"} {
+		if err := db.MarkFailed(ctx, id); err != nil {
+			t.Fatalf("error marking message as failed: %v", err)
+		}
+	}
+	if err := db.MarkReceived(ctx, event.Receipt("4")); err != nil {
+		t.Fatalf("error marking message as received: %v", err)
+	}
+	if err := db.QueueMsg(ctx, msgs[4]); err != nil {
+		t.Fatalf("error queueing message: %v", err)
+	}
+
+	iter := db.QueryHistory(ctx, testJuliet.String(), "")
+	var got []delivery
+	for iter.Next() {
+		got = append(got, storedDelivery(iter))
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error querying history: %v", err)
+	}
+	want := []delivery{deliverySent, deliveryReceived, deliveryFailed, deliveryFailed, deliveryPending}
+	if !slices.Equal(got, want) {
+		t.Errorf("wrong delivery states: want=%v, got=%v", want, got)
+	}
+}
@@ -0,0 +1,550 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/text/message"
+
+	"mellium.im/cli"
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/localerr"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+const exportDateLayout = "2006-01-02"
+
+// Namespaces used by the XEP-0227 export format.
+const (
+	nsPIE      = "urn:xmpp:pie:0"
+	nsPIEMAM   = "urn:xmpp:pie:0#mam"
+	nsMAM      = "urn:xmpp:mam:2"
+	nsForward  = "urn:xmpp:forward:0"
+	nsDelay    = "urn:xmpp:delay"
+	nsReceipts = "urn:xmpp:receipts"
+	nsRetract  = "urn:xmpp:message-retract:1"
+)
+
+// exportedMessage is a message as it is written by the export formats.
+// It is also the format of each line of JSON Lines exports.
+type exportedMessage struct {
+	Time time.Time `json:"time"`
+	// With is the bare address of the contact or channel that the message
+	// belongs to.
+	With      string `json:"with"`
+	Direction string `json:"direction"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	// Nick is the nickname of the sender in a channel.
+	Nick      string `json:"nick,omitempty"`
+	Type      string `json:"type"`
+	ID        string `json:"id,omitempty"`
+	Body      string `json:"body,omitempty"`
+	Edited    bool   `json:"edited,omitempty"`
+	Retracted bool   `json:"retracted,omitempty"`
+	// Delivered is true if the message was sent by us and the recipient sent a
+	// delivery receipt.
+	Delivered bool `json:"delivered,omitempty"`
+
+	archiveID string
+	retract   *event.Retract
+}
+
+func newExportedMessage(msg event.ChatMessage, delivered bool, archiveID string) exportedMessage {
+	with := msg.From.Bare()
+	direction := "received"
+	if msg.Sent {
+		with = msg.To.Bare()
+		direction = "sent"
+	}
+	var nick string
+	if msg.Type == stanza.GroupChatMessage {
+		nick = msg.From.Resourcepart()
+	}
+	m := exportedMessage{
+		Time:      msg.Delay.Time.UTC(),
+		With:      with.String(),
+		Direction: direction,
+		From:      msg.From.String(),
+		To:        msg.To.String(),
+		Nick:      nick,
+		Type:      string(msg.Type),
+		ID:        msg.ID,
+		Edited:    msg.Edited && !msg.Retracted,
+		Retracted: msg.Retracted,
+		Delivered: msg.Sent && delivered,
+		archiveID: archiveID,
+		retract:   msg.Retract,
+	}
+	if !msg.Retracted {
+		m.Body = msg.Body
+	}
+	return m
+}
+
+// historyWriter writes exported messages in one of the export formats.
+type historyWriter interface {
+	Begin() error
+	Message(m exportedMessage) error
+	End() error
+}
+
+// exportFormats returns the names of the supported export formats and the file
+// extension that is normally used for each.
+func exportFormats() map[string]string {
+	return map[string]string{
+		"text":  ".txt",
+		"jsonl": ".jsonl",
+		"html":  ".html",
+		"xml":   ".xml",
+	}
+}
+
+// exportFormatNames returns the names of the supported export formats, sorted.
+func exportFormatNames() []string {
+	formats := exportFormats()
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newHistoryWriter(w io.Writer, format string, addr jid.JID, p *message.Printer) (historyWriter, error) {
+	switch format {
+	case "text":
+		return &textHistory{w: w, p: p}, nil
+	case "jsonl":
+		return jsonHistory{e: json.NewEncoder(w)}, nil
+	case "html":
+		return &htmlHistory{w: w, p: p, addr: addr}, nil
+	case "xml":
+		return xmlHistory{e: xml.NewEncoder(w), w: w, addr: addr}, nil
+	}
+	return nil, localerr.Wrap(p, "unknown export format %q, expected one of: %s", format, strings.Join(exportFormatNames(), ", "))
+}
+
+// exportHistory writes the messages selected by q to w and returns the number
+// of messages that were written.
+// Chat markers and other messages without a body are skipped.
+// The address of the account, addr, is used by formats that record whose
+// history it is.
+func exportHistory(ctx context.Context, db *storage.DB, w io.Writer, format string, q storage.ExportQuery, addr jid.JID, p *message.Printer) (n int, err error) {
+	hw, err := newHistoryWriter(w, format, addr, p)
+	if err != nil {
+		return 0, err
+	}
+	if err = hw.Begin(); err != nil {
+		return 0, err
+	}
+	iter := db.Export(ctx, q)
+	defer func() {
+		e := iter.Close()
+		if err == nil {
+			err = e
+		}
+	}()
+	for iter.Next() {
+		msg := iter.Message()
+		if msg.Body == "" && !msg.Retracted {
+			continue
+		}
+		err = hw.Message(newExportedMessage(msg, iter.Received(), iter.ArchiveID()))
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err = iter.Err(); err != nil {
+		return n, err
+	}
+	return n, hw.End()
+}
+
+type textHistory struct {
+	w    io.Writer
+	p    *message.Printer
+	with string
+}
+
+func (h *textHistory) Begin() error { return nil }
+func (h *textHistory) End() error   { return nil }
+
+func (h *textHistory) Message(m exportedMessage) error {
+	if m.With != h.with {
+		sep := "\n"
+		if h.with == "" {
+			sep = ""
+		}
+		h.with = m.With
+		_, err := fmt.Fprintf(h.w, "%s== %s ==\n", sep, m.With)
+		if err != nil {
+			return err
+		}
+	}
+	var buf strings.Builder
+	buf.WriteString(m.Time.Format(time.RFC3339))
+	if m.Direction == "sent" {
+		buf.WriteString(" → ")
+	} else {
+		buf.WriteString(" ← ")
+	}
+	if m.Nick != "" {
+		buf.WriteString("[")
+		buf.WriteString(m.Nick)
+		buf.WriteString("] ")
+	}
+	if m.Retracted {
+		buf.WriteString(retractedText(h.p, m.retract))
+	} else {
+		buf.WriteString(m.Body)
+	}
+	if m.Edited {
+		buf.WriteString(" ")
+		buf.WriteString(h.p.Sprintf("(edited)"))
+	}
+	if m.Delivered {
+		buf.WriteString(" ✓")
+	}
+	buf.WriteString("\n")
+	_, err := io.WriteString(h.w, buf.String())
+	return err
+}
+
+type jsonHistory struct {
+	e *json.Encoder
+}
+
+func (h jsonHistory) Begin() error { return nil }
+func (h jsonHistory) End() error   { return nil }
+
+func (h jsonHistory) Message(m exportedMessage) error {
+	return h.e.Encode(m)
+}
+
+var htmlExport = template.Must(template.New("begin").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; }
+td { padding: 0 0.5em; vertical-align: top; }
+.time, .meta { color: gray; white-space: nowrap; }
+.body { white-space: pre-wrap; }
+.retracted { color: gray; font-style: italic; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+`))
+
+func init() {
+	template.Must(htmlExport.New("with").Parse(`{{if .Open}}</table>
+{{end}}<h2>{{.With}}</h2>
+<table>
+`))
+	template.Must(htmlExport.New("message").Parse(`<tr><td class="time">{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{if eq .Direction "sent"}}→{{else}}←{{end}}</td><td class="meta">{{.Nick}}</td><td class="body{{if .Retracted}} retracted{{end}}">{{.Body}}</td><td class="meta">{{.Meta}}</td></tr>
+`))
+	template.Must(htmlExport.New("end").Parse(`{{if .Open}}</table>
+{{end}}</body>
+</html>
+`))
+}
+
+type htmlHistory struct {
+	w    io.Writer
+	p    *message.Printer
+	addr jid.JID
+	with string
+}
+
+func (h *htmlHistory) Begin() error {
+	return htmlExport.ExecuteTemplate(h.w, "begin", struct {
+		Title string
+	}{
+		Title: h.p.Sprintf("Message history of %s", h.addr.Bare()),
+	})
+}
+
+func (h *htmlHistory) Message(m exportedMessage) error {
+	if m.With != h.with {
+		err := htmlExport.ExecuteTemplate(h.w, "with", struct {
+			Open bool
+			With string
+		}{
+			Open: h.with != "",
+			With: m.With,
+		})
+		if err != nil {
+			return err
+		}
+		h.with = m.With
+	}
+	var meta []string
+	if m.Edited {
+		meta = append(meta, h.p.Sprintf("(edited)"))
+	}
+	if m.Delivered {
+		meta = append(meta, "✓")
+	}
+	if m.Retracted {
+		m.Body = retractedText(h.p, m.retract)
+	}
+	return htmlExport.ExecuteTemplate(h.w, "message", struct {
+		exportedMessage
+		Meta string
+	}{
+		exportedMessage: m,
+		Meta:            strings.Join(meta, " "),
+	})
+}
+
+func (h *htmlHistory) End() error {
+	return htmlExport.ExecuteTemplate(h.w, "end", struct {
+		Open bool
+	}{
+		Open: h.with != "",
+	})
+}
+
+// xmlHistory writes messages as the message archive of a user in the XEP-0227
+// portable import/export format.
+// Delivery receipts are recorded by adding a receipt to the archive result
+// after the forwarded message.
+// Results are only given an ID if the message was fetched from an archive so
+// that importing the export again does not confuse row numbers for archive
+// IDs.
+type xmlHistory struct {
+	e    *xml.Encoder
+	w    io.Writer
+	addr jid.JID
+}
+
+func (h xmlHistory) Begin() error {
+	_, err := io.WriteString(h.w, xml.Header)
+	if err != nil {
+		return err
+	}
+	h.e.Indent("", "  ")
+	for _, start := range []xml.StartElement{{
+		Name: xml.Name{Space: nsPIE, Local: "server-data"},
+	}, {
+		Name: xml.Name{Local: "host"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "jid"}, Value: h.addr.Domainpart()}},
+	}, {
+		Name: xml.Name{Local: "user"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "name"}, Value: h.addr.Localpart()}},
+	}, {
+		Name: xml.Name{Space: nsPIEMAM, Local: "archive"},
+	}} {
+		if err := h.e.EncodeToken(start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h xmlHistory) Message(m exportedMessage) error {
+	result := xml.StartElement{Name: xml.Name{Space: nsMAM, Local: "result"}}
+	if m.archiveID != "" {
+		result.Attr = []xml.Attr{{Name: xml.Name{Local: "id"}, Value: m.archiveID}}
+	}
+	forwarded := xml.StartElement{Name: xml.Name{Space: nsForward, Local: "forwarded"}}
+	stamp := xml.Attr{Name: xml.Name{Local: "stamp"}, Value: m.Time.Format(time.RFC3339)}
+	msg := xml.StartElement{
+		Name: xml.Name{Space: stanza.NSClient, Local: "message"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "from"}, Value: m.From},
+			{Name: xml.Name{Local: "to"}, Value: m.To},
+			{Name: xml.Name{Local: "type"}, Value: m.Type},
+		},
+	}
+	if m.ID != "" {
+		msg.Attr = append(msg.Attr, xml.Attr{Name: xml.Name{Local: "id"}, Value: m.ID})
+	}
+
+	toks := []xml.Token{
+		result,
+		forwarded,
+		xml.StartElement{Name: xml.Name{Space: nsDelay, Local: "delay"}, Attr: []xml.Attr{stamp}},
+		xml.EndElement{Name: xml.Name{Space: nsDelay, Local: "delay"}},
+		msg,
+	}
+	if m.Retracted {
+		toks = append(toks,
+			xml.StartElement{Name: xml.Name{Space: nsRetract, Local: "retracted"}, Attr: []xml.Attr{stamp}},
+			xml.EndElement{Name: xml.Name{Space: nsRetract, Local: "retracted"}},
+		)
+	} else {
+		toks = append(toks,
+			xml.StartElement{Name: xml.Name{Local: "body"}},
+			xml.CharData(m.Body),
+			xml.EndElement{Name: xml.Name{Local: "body"}},
+		)
+	}
+	toks = append(toks, msg.End(), forwarded.End())
+	if m.Delivered {
+		toks = append(toks,
+			xml.StartElement{
+				Name: xml.Name{Space: nsReceipts, Local: "received"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: m.ID}},
+			},
+			xml.EndElement{Name: xml.Name{Space: nsReceipts, Local: "received"}},
+		)
+	}
+	toks = append(toks, result.End())
+	for _, tok := range toks {
+		if err := h.e.EncodeToken(tok); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h xmlHistory) End() error {
+	for _, name := range []xml.Name{
+		{Space: nsPIEMAM, Local: "archive"},
+		{Local: "user"},
+		{Local: "host"},
+		{Space: nsPIE, Local: "server-data"},
+	} {
+		if err := h.e.EncodeToken(xml.EndElement{Name: name}); err != nil {
+			return err
+		}
+	}
+	if err := h.e.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(h.w, "\n")
+	return err
+}
+
+// exportToFile writes the messages selected by q to the file at path.
+func exportToFile(ctx context.Context, db *storage.DB, path, format string, q storage.ExportQuery, addr jid.JID, p *message.Printer) (int, error) {
+	/* #nosec */
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(f)
+	n, err := exportHistory(ctx, db, w, format, q, addr, p)
+	if err == nil {
+		err = w.Flush()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return n, err
+}
+
+func exportCmd(cfgPath, defAcct string, p *message.Printer, logger, debug *log.Logger) *cli.Command {
+	const cmdName = "export"
+	flags := flag.NewFlagSet(cmdName, flag.ContinueOnError)
+	var (
+		format = "text"
+		with   string
+		from   string
+		until  string
+		output string
+	)
+	flags.StringVar(&format, "format", format, p.Sprintf("the output format: %s", strings.Join(exportFormatNames(), ", ")))
+	flags.StringVar(&with, "with", with, p.Sprintf("only export the conversation with this address"))
+	flags.StringVar(&from, "from", from, p.Sprintf("only export messages sent on or after this date (YYYY-MM-DD)"))
+	flags.StringVar(&until, "until", until, p.Sprintf("only export messages sent on or before this date (YYYY-MM-DD)"))
+	flags.StringVar(&output, "o", output, p.Sprintf("the file to write to instead of standard output"))
+
+	return &cli.Command{
+		Usage: cmdName + " [-format format] [-with address] [-from date] [-until date] [-o file]",
+		Description: p.Sprintf(`Exports the stored message history.
+
+Messages are read from the database of the default account (see -account)
+without logging in.`),
+		Flags: flags,
+		Run: func(*cli.Command, ...string) error {
+			var q storage.ExportQuery
+			var err error
+			if with != "" {
+				q.With, err = jid.Parse(with)
+				if err != nil {
+					return localerr.Wrap(p, "invalid address %q: %v", with, err)
+				}
+			}
+			if from != "" {
+				q.Start, err = time.ParseInLocation(exportDateLayout, from, time.Local)
+				if err != nil {
+					return localerr.Wrap(p, "invalid date %q: %v", from, err)
+				}
+			}
+			if until != "" {
+				q.End, err = time.ParseInLocation(exportDateLayout, until, time.Local)
+				if err != nil {
+					return localerr.Wrap(p, "invalid date %q: %v", until, err)
+				}
+				// Include the entire last day.
+				q.End = q.End.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			if _, ok := exportFormats()[format]; !ok {
+				return localerr.Wrap(p, "unknown export format %q, expected one of: %s", format, strings.Join(exportFormatNames(), ", "))
+			}
+
+			cfg, accts, err := loadConfig(cfgPath, defAcct, p, logger)
+			if err != nil {
+				return err
+			}
+			logger.SetOutput(os.Stderr)
+			if cfg.Log.Verbose {
+				debug.SetOutput(os.Stderr)
+			}
+			acct := accts[0]
+			j, err := jid.Parse(acct.Address)
+			if err != nil {
+				return localerr.Wrap(p, "error parsing account as XMPP address: %v", err)
+			}
+			db, err := openDB(j, acct, p, debug)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := db.Close(); err != nil {
+					debug.Print(p.Sprintf("error closing database for %q: %v", acct.Address, err))
+				}
+			}()
+
+			ctx := context.Background()
+			var n int
+			if output == "" {
+				w := bufio.NewWriter(os.Stdout)
+				n, err = exportHistory(ctx, db, w, format, q, j, p)
+				if err == nil {
+					err = w.Flush()
+				}
+			} else {
+				n, err = exportToFile(ctx, db, output, format, q, j, p)
+			}
+			if err != nil {
+				return localerr.Wrap(p, "error exporting history: %v", err)
+			}
+			debug.Print(p.Sprintf("exported %d messages", n))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/message"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/storage/storagetest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	testSelf   = jid.MustParse("me@example.net")
+	testJuliet = jid.MustParse("juliet@example.com")
+	testRoom   = jid.MustParse("room@conference.example.com")
+	testNurse  = jid.MustParse("room@conference.example.com/nurse")
+)
+
+func testPrinter() *message.Printer {
+	return message.NewPrinter(message.MatchLanguage("en"))
+}
+
+// testHistory is a short conversation with a contact and a channel where the
+// second message was received by the contact.
+func testHistory(t *testing.T) *storage.DB {
+	t.Helper()
+	db := storagetest.InsertMsgs(t, testSelf,
+		event.ChatMessage{Message: stanza.Message{ID: "1", From: testJuliet, To: testSelf, Type: stanza.ChatMessage}, Body: "Wherefore art thou Romeo?", SID: []stanza.ID{{ID: "a1", By: testSelf}}},
+		event.ChatMessage{Message: stanza.Message{ID: "2", From: testSelf, To: testJuliet, Type: stanza.ChatMessage}, Body: "Call me but love", Sent: true},
+		event.ChatMessage{Message: stanza.Message{ID: "3", From: testNurse, To: testSelf, Type: stanza.GroupChatMessage}, Body: "Romeo!"},
+		event.ChatMessage{Message: stanza.Message{ID: "4", From: testJuliet, To: testSelf, Type: stanza.ChatMessage}, Body: "Art thou not Romeo?"},
+		// Markers and other messages without a body are not exported.
+		event.ChatMessage{Message: stanza.Message{ID: "5", From: testJuliet, To: testSelf, Type: stanza.ChatMessage}},
+	)
+	if err := db.MarkReceived(context.Background(), event.Receipt("2")); err != nil {
+		t.Fatalf("error marking message as received: %v", err)
+	}
+	return db
+}
+
+var exportTestCases = [...]struct {
+	format string
+	q      storage.ExportQuery
+	n      int
+	expect string
+	err    bool
+}{
+	0: {
+		format: "text",
+		n:      4,
+		expect: `== juliet@example.com ==
+2020-01-01T00:00:00Z ← Wherefore art thou Romeo?
+2020-01-02T00:00:00Z → Call me but love ✓
+2020-01-04T00:00:00Z ← Art thou not Romeo?
+
+== room@conference.example.com ==
+2020-01-03T00:00:00Z ← [nurse] Romeo!
+`,
+	},
+	1: {
+		format: "text",
+		q: storage.ExportQuery{
+			With:  testJuliet,
+			Start: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC),
+		},
+		n: 1,
+		expect: `== juliet@example.com ==
+2020-01-02T00:00:00Z → Call me but love ✓
+`,
+	},
+	// Times are always written in UTC, whatever time zone they were selected in.
+	2: {
+		format: "text",
+		q: storage.ExportQuery{
+			Start: time.Date(2020, 1, 2, 1, 0, 1, 0, time.FixedZone("UTC+1", 60*60)),
+			End:   time.Date(2020, 1, 2, 19, 0, 0, 0, time.FixedZone("UTC-5", -5*60*60)),
+		},
+		n: 1,
+		expect: `== room@conference.example.com ==
+2020-01-03T00:00:00Z ← [nurse] Romeo!
+`,
+	},
+	3: {
+		format: "jsonl",
+		q:      storage.ExportQuery{With: testRoom},
+		n:      1,
+		expect: `{"time":"2020-01-03T00:00:00Z","with":"room@conference.example.com","direction":"received","from":"room@conference.example.com/nurse","to":"me@example.net","nick":"nurse","type":"groupchat","id":"3","body":"Romeo!"}
+`,
+	},
+	// Exporting nothing is not an error, but still writes a valid document.
+	4: {format: "text", q: storage.ExportQuery{With: jid.MustParse("tybalt@example.com")}},
+	5: {format: "jsonl", q: storage.ExportQuery{With: jid.MustParse("tybalt@example.com")}},
+	6: {
+		format: "xml",
+		q:      storage.ExportQuery{With: jid.MustParse("tybalt@example.com")},
+		expect: `<?xml version="1.0" encoding="UTF-8"?>
+<server-data xmlns="urn:xmpp:pie:0">
+  <host jid="example.net">
+    <user name="me">
+      <archive xmlns="urn:xmpp:pie:0#mam"></archive>
+    </user>
+  </host>
+</server-data>
+`,
+	},
+	// Unknown formats.
+	7: {format: "pdf", err: true},
+	8: {format: "", err: true},
+	9: {format: "TEXT", err: true},
+}
+
+func TestExportHistory(t *testing.T) {
+	db := testHistory(t)
+	for i, tc := range exportTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var buf strings.Builder
+			n, err := exportHistory(context.Background(), db, &buf, tc.format, tc.q, testSelf, testPrinter())
+			switch {
+			case err != nil && !tc.err:
+				t.Fatalf("error exporting history: %v", err)
+			case err == nil && tc.err:
+				t.Fatalf("expected error exporting history")
+			}
+			if n != tc.n {
+				t.Errorf("wrong number of messages exported: want=%d, got=%d", tc.n, n)
+			}
+			if s := buf.String(); s != tc.expect {
+				t.Errorf("wrong export: want=%q, got=%q", tc.expect, s)
+			}
+		})
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	var buf strings.Builder
+	_, err := exportHistory(context.Background(), testHistory(t), &buf, "jsonl", storage.ExportQuery{With: testJuliet}, testSelf, testPrinter())
+	if err != nil {
+		t.Fatalf("error exporting history: %v", err)
+	}
+	var got []exportedMessage
+	d := json.NewDecoder(strings.NewReader(buf.String()))
+	for d.More() {
+		var m exportedMessage
+		if err := d.Decode(&m); err != nil {
+			t.Fatalf("error decoding exported message: %v", err)
+		}
+		got = append(got, m)
+	}
+	expect := []exportedMessage{
+		{Time: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), With: testJuliet.String(), Direction: "received", From: testJuliet.String(), To: testSelf.String(), Type: "chat", ID: "1", Body: "Wherefore art thou Romeo?"},
+		{Time: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), With: testJuliet.String(), Direction: "sent", From: testSelf.String(), To: testJuliet.String(), Type: "chat", ID: "2", Body: "Call me but love", Delivered: true},
+		{Time: time.Date(2020, 1, 4, 0, 0, 0, 0, time.UTC), With: testJuliet.String(), Direction: "received", From: testJuliet.String(), To: testSelf.String(), Type: "chat", ID: "4", Body: "Art thou not Romeo?"},
+	}
+	if !slices.Equal(got, expect) {
+		t.Errorf("wrong export: want=%+v, got=%+v", expect, got)
+	}
+}
+
+// errWriter fails every write.
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestExportErrors(t *testing.T) {
+	db := testHistory(t)
+	p := testPrinter()
+	for _, format := range exportFormatNames() {
+		t.Run(format, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if _, err := exportHistory(ctx, db, io.Discard, format, storage.ExportQuery{}, testSelf, p); err == nil {
+				t.Errorf("expected error exporting with a canceled context")
+			}
+			if _, err := exportHistory(context.Background(), db, errWriter{}, format, storage.ExportQuery{}, testSelf, p); err == nil {
+				t.Errorf("expected error exporting to a failing writer")
+			}
+			path := filepath.Join(t.TempDir(), "missing", "export")
+			if _, err := exportToFile(context.Background(), db, path, format, storage.ExportQuery{}, testSelf, p); err == nil {
+				t.Errorf("expected error exporting to a missing directory")
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ require (
 	github.com/gdamore/tcell/v2 v2.7.4
 	github.com/mpvl/textutil v0.1.0
 	github.com/rivo/tview v0.0.0-20241227133733-17b7edb88c57
+	golang.org/x/crypto v0.31.0
 	golang.org/x/text v0.21.0
 	mellium.im/cli v0.1.0
 	mellium.im/filechooser v0.0.3
@@ -30,7 +31,6 @@ require (
 	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	golang.org/x/crypto v0.31.0 // indirect
 	golang.org/x/exp v0.0.0-20241217172543-b2144cdd0a67 // indirect
 	golang.org/x/mod v0.22.0 // indirect
 	golang.org/x/net v0.33.0 // indirect
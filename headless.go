@@ -0,0 +1,387 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/text/message"
+
+	"mellium.im/cli"
+	"mellium.im/communique/internal/client"
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/localerr"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// headlessHistoryLimit is the number of messages fetched from the archive for
+// conversations that we don't have any history for yet when there is no screen
+// to fill.
+const headlessHistoryLimit = 100
+
+// headlessLogs sends all logs to stderr since there is no UI to show them in.
+func headlessLogs(cfg config, logger, debug, xmlInLog, xmlOutLog *log.Logger) {
+	logger.SetOutput(os.Stderr)
+	if cfg.Log.Verbose {
+		debug.SetOutput(os.Stderr)
+	}
+	if cfg.Log.XML {
+		xmlInLog.SetOutput(os.Stderr)
+		xmlOutLog.SetOutput(os.Stderr)
+	}
+}
+
+func sendCmd(cfgPath, defAcct string, p *message.Printer, logger, debug *log.Logger) *cli.Command {
+	const cmdName = "send"
+	flags := flag.NewFlagSet(cmdName, flag.ContinueOnError)
+	var (
+		to  string
+		typ = string(stanza.ChatMessage)
+	)
+	flags.StringVar(&to, "to", to, p.Sprintf("the address to send the message to"))
+	flags.StringVar(&typ, "type", typ, p.Sprintf("the message type: chat, groupchat, or normal"))
+
+	return &cli.Command{
+		Usage: cmdName + " -to address [-type type] [message]",
+		Description: p.Sprintf(`Logs in, sends a message, and exits.
+
+If no message is given it is read from standard input.
+Messages with the groupchat type are sent to a channel, which is joined first.`),
+		Flags: flags,
+		Run: func(_ *cli.Command, args ...string) error {
+			toJID, err := jid.Parse(to)
+			if err != nil {
+				return localerr.Wrap(p, "invalid recipient %q: %v", to, err)
+			}
+			msgType := stanza.MessageType(typ)
+			switch msgType {
+			case stanza.ChatMessage, stanza.NormalMessage:
+			case stanza.GroupChatMessage:
+				toJID = toJID.Bare()
+			default:
+				return localerr.Wrap(p, "unknown message type %q", typ)
+			}
+			body := strings.Join(args, " ")
+			if body == "" {
+				b, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return localerr.Wrap(p, "error reading message from standard input: %v", err)
+				}
+				body = strings.TrimSuffix(string(b), "\n")
+			}
+			if body == "" {
+				return localerr.Wrap(p, "no message given")
+			}
+
+			xmlInLog := log.New(io.Discard, p.Sprintf("RECV")+" ", log.LstdFlags)
+			xmlOutLog := log.New(io.Discard, p.Sprintf("SENT")+" ", log.LstdFlags)
+			cfg, accts, err := loadConfig(cfgPath, defAcct, p, logger)
+			if err != nil {
+				return err
+			}
+			headlessLogs(cfg, logger, debug, xmlInLog, xmlOutLog)
+			timeout := cfg.timeout(p, logger)
+
+			acct := accts[0]
+			j, err := jid.Parse(acct.Address)
+			if err != nil {
+				return localerr.Wrap(p, "error parsing account as XMPP address: %v", err)
+			}
+			c, db, err := newAccount(j, acct, nil, os.Stderr, timeout, xmlInLog, xmlOutLog, p, logger, debug)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := db.Close(); err != nil {
+					debug.Print(p.Sprintf("error closing database for %q: %v", acct.Address, err))
+				}
+			}()
+			c.Handler(newHeadlessHandler(c, db, acct, false, logger, debug))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 3*timeout)
+			defer cancel()
+			err = c.Connect(ctx)
+			if err != nil {
+				return localerr.Wrap(p, "error logging in as %q: %v", acct.Address, err)
+			}
+			defer func() {
+				if err := c.Offline(); err != nil {
+					debug.Print(p.Sprintf("error going offline: %v", err))
+				}
+			}()
+
+			if msgType == stanza.GroupChatMessage {
+				nick := acct.Name
+				if nick == "" {
+					nick = j.Localpart()
+				}
+				room, err := toJID.WithResource(nick)
+				if err != nil {
+					return localerr.Wrap(p, "invalid nick %s in config: %v", nick, err)
+				}
+				err = c.JoinMUC(ctx, room)
+				if err != nil {
+					return localerr.Wrap(p, "error joining room %s: %v", toJID, err)
+				}
+				defer func() {
+					if err := c.LeaveMUC(ctx, toJID, ""); err != nil {
+						debug.Print(p.Sprintf("error leaving room %s: %v", toJID, err))
+					}
+				}()
+			}
+
+			msg, err := c.SendMessage(ctx, event.ChatMessage{
+				Message: stanza.Message{
+					To:   toJID,
+					Type: msgType,
+				},
+				Body: body,
+				Sent: true,
+			})
+			if err != nil {
+				return localerr.Wrap(p, "error sending message: %v", err)
+			}
+			if err = db.InsertMsg(ctx, msg.Account, msg, c.LocalAddr()); err != nil {
+				logger.Print(p.Sprintf("error writing message to database: %v", err))
+			}
+			return nil
+		},
+	}
+}
+
+func daemonCmd(cfgPath, defAcct string, p *message.Printer, logger, debug *log.Logger) *cli.Command {
+	return &cli.Command{
+		Usage: "daemon",
+		Description: p.Sprintf(`Stays online without a user interface.
+
+Every account in the config file is logged in and incoming messages, the
+roster, and the message archive are kept up to date in the database until the
+process is interrupted.
+Bookmarked channels that are set to join automatically are joined and their
+history is kept up to date as well.`),
+		Run: func(*cli.Command, ...string) error {
+			xmlInLog := log.New(io.Discard, p.Sprintf("RECV")+" ", log.LstdFlags)
+			xmlOutLog := log.New(io.Discard, p.Sprintf("SENT")+" ", log.LstdFlags)
+			cfg, accts, err := loadConfig(cfgPath, defAcct, p, logger)
+			if err != nil {
+				return err
+			}
+			headlessLogs(cfg, logger, debug, xmlInLog, xmlOutLog)
+			timeout := cfg.timeout(p, logger)
+
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, os.Interrupt, syscall.SIGQUIT, syscall.SIGTERM)
+
+			var clients []*client.Client
+			for _, acct := range accts {
+				j, err := jid.Parse(acct.Address)
+				if err != nil {
+					logger.Print(p.Sprintf("error parsing account %q as XMPP address: %v", acct.Address, err))
+					continue
+				}
+				c, db, err := newAccount(j, acct, nil, os.Stderr, timeout, xmlInLog, xmlOutLog, p, logger, debug)
+				if err != nil {
+					logger.Print(p.Sprintf("error starting account %q: %v", acct.Address, err))
+					continue
+				}
+				defer func() {
+					if err := db.Close(); err != nil {
+						debug.Print(p.Sprintf("error closing database for %q: %v", acct.Address, err))
+					}
+				}()
+				c.Handler(newHeadlessHandler(c, db, acct, true, logger, debug))
+				clients = append(clients, c)
+
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 3*timeout)
+					defer cancel()
+					if err := c.Online(ctx); err != nil {
+						logger.Print(p.Sprintf("initial login failed for %q: %v", acct.Address, err))
+						return
+					}
+					logger.Print(p.Sprintf("logged in as: %q", c.LocalAddr()))
+				}()
+			}
+			if len(clients) == 0 {
+				return localerr.Wrap(p, "no accounts could be started")
+			}
+
+			s := <-sigs
+			debug.Print(p.Sprintf("got signal: %v", s))
+			for _, c := range clients {
+				if err := c.Offline(); err != nil {
+					debug.Print(p.Sprintf("error going offline: %v", err))
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// newHeadlessHandler returns a handler for events that are emitted by the
+// client when there is no UI to update, keeping only the database up to date.
+// If sync is true the history of every contact is fetched from the server's
+// message archive when the roster is received, bookmarked channels are joined
+// if they are set to join automatically, and the history of every channel is
+// fetched from its archive when it is joined.
+func newHeadlessHandler(client *client.Client, db *storage.DB, acct account, sync bool, logger, debug *log.Logger) func(interface{}) {
+	p := client.Printer()
+	return func(ev interface{}) {
+		defer panicHandler()
+		switch e := ev.(type) {
+		case event.StatusAway, event.StatusBusy, event.StatusOnline, event.StatusOffline,
+			event.ChatState, event.OccupantPresence, event.Subject:
+			// Nothing to show and nothing to store.
+		case event.Reconnecting:
+			logger.Print(p.Sprintf("connection lost, reconnecting in %s…", time.Duration(e).Round(time.Second)))
+		case event.FetchBookmarks:
+			for bookmark := range e.Items {
+				item := bookmarks.Channel(bookmark)
+				if sync && item.Autojoin && !client.Joined(item.JID) {
+					go openChannel(item, client, acct, debug, logger)
+				}
+			}
+		case event.ChannelJoined:
+			if sync {
+				go fetchChannelHistory(client, db, jid.JID(e), channelHistoryLimit, logger, debug)
+			}
+		case event.FetchRoster:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := db.ReplaceRoster(ctx, e)
+			if err != nil {
+				logger.Print(p.Sprintf("error updating to roster ver %q: %v", e.Ver, err))
+			}
+			if !sync {
+				return
+			}
+			ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			lastSeen, err := lastSeen(ctx, db)
+			if err != nil {
+				logger.Print(p.Sprintf("error querying database for last seen messages: %v", err))
+				return
+			}
+			err = db.ForRoster(ctx, func(item event.UpdateRoster) {
+				last, ok := lastSeen[item.JID.Bare().String()]
+				go fetchHistory(client, item.JID, last, ok, headlessHistoryLimit, logger, debug)
+			})
+			if err != nil {
+				logger.Print(p.Sprintf("error iterating over roster items: %v", err))
+			}
+		case event.UpdateRoster:
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			err := db.UpdateRoster(ctx, e.Ver, e)
+			if err != nil {
+				debug.Print(p.Sprintf("error updating roster version: %v", err))
+			}
+		case event.Receipt:
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			err := db.MarkReceived(ctx, e)
+			if err != nil {
+				logger.Print(p.Sprintf("error marking message %q as received: %v", e, err))
+			}
+		case event.OutboxSent:
+			if e.Err == nil {
+				return
+			}
+			logger.Print(p.Sprintf("error sending message: %v", e.Err))
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			err := db.MarkFailed(ctx, e.ID)
+			if err != nil {
+				logger.Print(p.Sprintf("error marking message %q as failed: %v", e.ID, err))
+			}
+		case event.MessageError:
+			logger.Print(p.Sprintf("message %q to %s could not be delivered: %v", e.ID, e.From, e.Err))
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			err := db.MarkFailed(ctx, e.ID)
+			if err != nil {
+				logger.Print(p.Sprintf("error marking message %q as failed: %v", e.ID, err))
+			}
+		case event.ChatMessage:
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			storeMessage(ctx, db, e, e.Account, client.LocalAddr(), p, logger)
+			if e.Body == "" || e.Replace.ID != "" || e.Retract != nil {
+				return
+			}
+			j := e.From.Bare()
+			if e.Sent {
+				j = e.To.Bare()
+			}
+			t := e.Delay.Time
+			if t.IsZero() {
+				t = time.Now()
+			}
+			err := db.UpsertConversation(ctx, storage.Conversation{
+				JID:          j,
+				Room:         e.Type == stanza.GroupChatMessage,
+				FirstUnread:  e.ID,
+				LastActivity: t,
+			}, !e.Sent)
+			if err != nil {
+				logger.Print(p.Sprintf("error saving conversation with %s: %v", j, err))
+			}
+		case event.HistoryMessage:
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			storeMessage(ctx, db, e.Result.Forward.Msg, true, client.LocalAddr(), p, logger)
+		case event.Invitation:
+			logger.Print(p.Sprintf("%s invited you to %s", e.From.Bare(), e.Room))
+		case event.NewCaps:
+			go func() {
+				defer panicHandler()
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				err := db.InsertCaps(ctx, e.From, e.Caps)
+				if err != nil {
+					logger.Print(p.Sprintf("error inserting entity capbailities hash: %v", err))
+				}
+			}()
+		case event.NewFeatures:
+			go newFeatures(e, client, db, debug, logger)
+		default:
+			debug.Print(p.Sprintf("unrecognized client event: %T(%[1]q)", e))
+		}
+	}
+}
+
+// storeMessage saves a message, retraction, or chat marker to the database.
+func storeMessage(ctx context.Context, db *storage.DB, msg event.ChatMessage, respectDelay bool, addr jid.JID, p *message.Printer, logger *log.Logger) {
+	switch {
+	case msg.Retract != nil:
+		if err := db.Retract(ctx, msg, addr); err != nil {
+			logger.Print(p.Sprintf("error retracting message %q: %v", msg.Retract.ID, err))
+		}
+	case msg.Displayed != nil && msg.Body == "":
+		if !msg.Sent {
+			return
+		}
+		if err := db.MarkDisplayed(ctx, msg.To.Bare(), msg.Displayed.ID); err != nil {
+			logger.Print(p.Sprintf("error marking message %q to %s as displayed: %v", msg.Displayed.ID, msg.To.Bare(), err))
+		}
+	case mergeReflection(ctx, db, msg, p, logger):
+		// Already stored when we sent it.
+	default:
+		if err := db.InsertMsg(ctx, respectDelay, msg, addr); err != nil {
+			logger.Print(p.Sprintf("error writing message to database: %v", err))
+		}
+	}
+}
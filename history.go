@@ -13,10 +13,14 @@ import (
 	"time"
 
 	"github.com/rivo/tview"
+	"golang.org/x/text/message"
 
+	"mellium.im/communique/internal/client"
 	"mellium.im/communique/internal/client/event"
 	"mellium.im/communique/internal/storage"
 	"mellium.im/communique/internal/ui"
+	"mellium.im/xmpp/history"
+	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/roster"
 	"mellium.im/xmpp/stanza"
 	"mellium.im/xmpp/styling"
@@ -45,8 +49,13 @@ func writeMask(buf *strings.Builder, mask styling.Style) {
 	buf.WriteString("]")
 }
 
-func writeMessage(pane *ui.UI, msg event.ChatMessage, notNew bool) error {
-	if msg.Body == "" {
+// writeMessage shows a message in the chat history if its conversation is open
+// and marks the conversation as unread otherwise.
+// If we sent the message, state is shown at the end of it.
+func writeMessage(pane *ui.UI, msg event.ChatMessage, state delivery, notNew bool) error {
+	// Corrections and retractions are shown by reloading the conversation once
+	// they have been stored (see showEdit).
+	if !msg.Retracted && (msg.Body == "" || msg.Replace.ID != "" || msg.Retract != nil) {
 		return nil
 	}
 
@@ -58,42 +67,65 @@ func writeMessage(pane *ui.UI, msg event.ChatMessage, notNew bool) error {
 	}
 
 	var buf strings.Builder
-	var prevEnd bool
-	msg.Body = tview.Escape(msg.Body)
-	d := styling.NewDecoder(strings.NewReader(msg.Body))
-	for d.Next() {
-		tok := d.Token()
-		if prevEnd || tok.Mask != 0 {
-			prevEnd = false
-			writeMask(&buf, tok.Mask)
-		}
-		/* #nosec */
-		buf.Write(tok.Data)
-		if tok.Mask&styling.SpanEndDirective != 0 {
-			prevEnd = true
+	if msg.Retracted {
+		buf.WriteString("[::d]")
+		buf.WriteString(tview.Escape(retractedText(pane.Printer(), msg.Retract)))
+	} else {
+		var prevEnd bool
+		msg.Body = tview.Escape(msg.Body)
+		d := styling.NewDecoder(strings.NewReader(msg.Body))
+		for d.Next() {
+			tok := d.Token()
+			if prevEnd || tok.Mask != 0 {
+				prevEnd = false
+				writeMask(&buf, tok.Mask)
+			}
+			/* #nosec */
+			buf.Write(tok.Data)
+			if tok.Mask&styling.SpanEndDirective != 0 {
+				prevEnd = true
+			}
 		}
 	}
 	buf.WriteString("[::-]")
-
-	var historyLine string
-	if msg.Type == stanza.GroupChatMessage {
-		j := msg.From
-		if msg.Sent {
-			j = msg.To
-		}
-		historyLine = fmt.Sprintf("%s %s [%s] %s\n", time.Now().UTC().Format(time.RFC3339), arrow, tview.Escape(j.Resourcepart()), buf.String())
-	} else {
-		historyLine = fmt.Sprintf("%s %s %s\n", time.Now().UTC().Format(time.RFC3339), arrow, buf.String())
+	if msg.Edited && !msg.Retracted {
+		buf.WriteString(" [::d]")
+		buf.WriteString(pane.Printer().Sprintf("(edited)"))
+		buf.WriteString("[::-]")
+	}
+	if msg.Delayed {
+		buf.WriteString(" [::d]")
+		buf.WriteString(pane.Printer().Sprintf("(delayed)"))
+		buf.WriteString("[::-]")
+	}
+	if msg.Sent && !msg.Retracted && msg.ID != "" {
+		fmt.Fprintf(&buf, ` ["%s"]%s[""]`, deliveryRegion(msg.ID), deliveryMarker(state))
 	}
-
-	history := pane.History()
 
 	j := historyAddr.Bare()
 	if pane.ChatsOpen() {
 		if selected := pane.GetRosterJID(); j.Equal(selected) {
 			// If the message JID is selected and the window is open, write it to the
 			// history window.
-			_, err := io.WriteString(history, historyLine)
+			// Messages loaded from the database or the archive have the time they
+			// were sent, new ones are shown at the time they arrived.
+			t := msg.Delay.Time
+			if t.IsZero() {
+				t = time.Now()
+			}
+			var historyLine string
+			if msg.Type == stanza.GroupChatMessage {
+				nick := msg.From
+				if msg.Sent && nick.Resourcepart() == "" {
+					// Our nickname is only known once the channel has echoed the message
+					// back to us (see mergeReflection).
+					nick = msg.To
+				}
+				historyLine = fmt.Sprintf("%s %s [%s] %s\n", pane.Timestamp(t), arrow, tview.Escape(nick.Resourcepart()), buf.String())
+			} else {
+				historyLine = fmt.Sprintf("%s %s %s\n", pane.Timestamp(t), arrow, buf.String())
+			}
+			_, err := io.WriteString(pane.History(), historyLine)
 			return err
 		}
 	}
@@ -115,9 +147,167 @@ func writeMessage(pane *ui.UI, msg event.ChatMessage, notNew bool) error {
 	return nil
 }
 
-func loadBuffer(ctx context.Context, pane *ui.UI, db *storage.DB, ev roster.Item, msgID string, logger *log.Logger) error {
+// writeOccupantPresence shows that someone joined or left a channel or changed
+// their nickname if the channel is open.
+func writeOccupantPresence(pane *ui.UI, e event.OccupantPresence) error {
+	if !pane.ChatsOpen() || !e.Occupant.Bare().Equal(pane.GetRosterJID().Bare()) {
+		return nil
+	}
+	p := pane.Printer()
+	nick := e.Occupant.Resourcepart()
+	var line string
+	switch {
+	case e.NewNick != "":
+		line = p.Sprintf("%s is now known as %s", nick, e.NewNick)
+	case e.Joined:
+		line = p.Sprintf("%s joined", nick)
+	case e.Left && e.Item.Reason != "":
+		line = p.Sprintf("%s left (%s)", nick, e.Item.Reason)
+	case e.Left:
+		line = p.Sprintf("%s left", nick)
+	default:
+		return nil
+	}
+	_, err := fmt.Fprintf(pane.History(), "%s [::d]%s[::-]\n", pane.Timestamp(time.Now()), tview.Escape(line))
+	return err
+}
+
+// retractedText returns the text that is shown in place of a retracted
+// message.
+func retractedText(p *message.Printer, r *event.Retract) string {
+	if r == nil || r.Moderated == nil {
+		return p.Sprintf("This message was retracted.")
+	}
+	moderator := r.Moderated.By.Resourcepart()
+	if moderator == "" {
+		moderator = p.Sprintf("a moderator")
+	}
+	if r.Reason != "" {
+		return p.Sprintf("This message was removed by %s: %s", moderator, r.Reason)
+	}
+	return p.Sprintf("This message was removed by %s.", moderator)
+}
+
+// retractMessage replaces a message that was retracted by its sender, or by a
+// channel moderator, with a tombstone.
+func retractMessage(ctx context.Context, pane *ui.UI, db *storage.DB, msg event.ChatMessage, addr jid.JID, logger *log.Logger) {
+	if err := db.Retract(ctx, msg, addr); err != nil {
+		p := pane.Printer()
+		logger.Print(p.Sprintf("error retracting message %q: %v", msg.Retract.ID, err))
+		return
+	}
+	showEdit(ctx, pane, db, msg, logger)
+}
+
+// showEdit reloads the conversation that a message correction or retraction
+// belongs to if it is open so that the change is shown in place of the
+// original message.
+// The change must already be stored in the database.
+func showEdit(ctx context.Context, pane *ui.UI, db *storage.DB, msg event.ChatMessage, logger *log.Logger) {
+	if (msg.Replace.ID == "" && msg.Retract == nil) || !pane.ChatsOpen() {
+		return
+	}
+	j := msg.From.Bare()
+	if msg.Sent {
+		j = msg.To.Bare()
+	}
+	if !j.Equal(pane.GetRosterJID().Bare()) {
+		return
+	}
+	if err := loadBuffer(ctx, pane, db, roster.Item{JID: j}, "", 0, logger); err != nil {
+		p := pane.Printer()
+		logger.Print(p.Sprintf("error reloading chat after edit: %v", err))
+	}
+}
+
+// markDisplayed applies a chat marker that one of our other clients sent and
+// marks the conversation as read if everything in it has been displayed.
+// Markers sent by contacts only tell us that they have seen our messages, so
+// they don't change what is unread.
+func markDisplayed(ctx context.Context, pane *ui.UI, db *storage.DB, msg event.ChatMessage, logger *log.Logger) {
+	if !msg.Sent {
+		return
+	}
+	p := pane.Printer()
+	j := msg.To.Bare()
+	if err := db.MarkDisplayed(ctx, j, msg.Displayed.ID); err != nil {
+		logger.Print(p.Sprintf("error marking message %q to %s as displayed: %v", msg.Displayed.ID, j, err))
+		return
+	}
+	unread, err := db.Unread(ctx, j)
+	if err != nil {
+		logger.Print(p.Sprintf("error loading unread messages from %s: %v", j, err))
+		return
+	}
+	if len(unread) == 0 {
+		pane.MarkRead(j.String())
+	} else {
+		pane.SetUnread(j.String(), unread[0].FirstUnread, unread[0].Count)
+	}
+	pane.Redraw()
+}
+
+// saveConversation records activity in the conversation that msg belongs to if
+// it is shown in the conversations list, and whether msg is still unread.
+func saveConversation(ctx context.Context, pane *ui.UI, db *storage.DB, msg event.ChatMessage, logger *log.Logger) {
+	if msg.Body == "" || msg.Replace.ID != "" || msg.Retract != nil {
+		return
+	}
+	j := msg.From.Bare()
+	if msg.Sent {
+		j = msg.To.Bare()
+	}
+	c, ok := pane.Conversations().GetItem(j.String())
+	if !ok {
+		return
+	}
+	t := msg.Delay.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	unread := !msg.Sent && (!pane.ChatsOpen() || !j.Equal(pane.GetRosterJID()))
+	err := db.UpsertConversation(ctx, storage.Conversation{
+		JID:          j,
+		Name:         c.Name,
+		Room:         c.Room || msg.Type == stanza.GroupChatMessage,
+		FirstUnread:  msg.ID,
+		LastActivity: t,
+	}, unread)
+	if err != nil {
+		p := pane.Printer()
+		logger.Print(p.Sprintf("error saving conversation with %s: %v", j, err))
+	}
+}
+
+// restoreConversations fills the conversations list with the conversations
+// that were open when we last quit.
+func restoreConversations(ctx context.Context, pane *ui.UI, db *storage.DB, logger *log.Logger) {
+	convs, err := db.Conversations(ctx)
+	if err != nil {
+		p := pane.Printer()
+		logger.Print(p.Sprintf("error loading conversations: %v", err))
+		return
+	}
+	for _, c := range convs {
+		pane.UpdateConversations(ui.Conversation{
+			JID:    c.JID,
+			Name:   c.Name,
+			Room:   c.Room,
+			Pinned: c.Pinned,
+		})
+		if c.Unread > 0 {
+			pane.SetUnread(c.JID.String(), c.FirstUnread, c.Unread)
+		}
+	}
+	pane.Redraw()
+}
+
+// loadBuffer writes the history of a conversation to the chat view.
+// An unread marker is drawn before the message with ID msgID and, if match is
+// not zero, the message with that row ID is highlighted and scrolled into view.
+func loadBuffer(ctx context.Context, pane *ui.UI, db *storage.DB, ev roster.Item, msgID string, match int64, logger *log.Logger) error {
 	history := pane.History()
-	history.SetText("")
+	pane.ClearHistory()
 	p := pane.Printer()
 
 	iter := db.QueryHistory(ctx, ev.JID.String(), "")
@@ -129,18 +319,160 @@ func loadBuffer(ctx context.Context, pane *ui.UI, db *storage.DB, ev roster.Item
 				return err
 			}
 		}
-		err := writeMessage(pane, cur, true)
+		isMatch := match != 0 && iter.RowID() == match
+		if isMatch {
+			_, err := fmt.Fprintf(history, `["%s"]`, ui.SearchRegion)
+			if err != nil {
+				return err
+			}
+		}
+		err := writeMessage(pane, cur, storedDelivery(iter), true)
 		if err != nil {
 			msg := p.Sprintf("error writing history: %v", err)
 			history.SetText(msg)
 			logger.Println(msg)
 			return nil
 		}
+		if isMatch {
+			_, err = io.WriteString(history, `[""]`)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	if err := iter.Err(); err != nil {
 		history.SetText(err.Error())
 		logger.Print(p.Sprintf("error querying history for %s: %v", ev.JID, err))
 	}
+	if match != 0 {
+		history.Highlight(ui.SearchRegion).ScrollToHighlight()
+		return nil
+	}
+	history.Highlight(ui.UnreadRegion)
 	history.ScrollToEnd()
 	return nil
 }
+
+// lastSeen returns the last message we have stored for each conversation keyed
+// by the bare address of the contact.
+func lastSeen(ctx context.Context, db *storage.DB) (map[string]storage.AfterIDResult, error) {
+	afterIDs := db.AfterID(ctx)
+	ids := make(map[string]storage.AfterIDResult)
+	for afterIDs.Next() {
+		id := afterIDs.Result()
+		ids[id.Addr.Bare().String()] = id
+	}
+	return ids, afterIDs.Err()
+}
+
+// fetchHistory catches up on the history of the conversation with j from the
+// server's message archive.
+// If ok is false we don't have any history for j yet and the last limit
+// messages are fetched instead.
+func fetchHistory(c *client.Client, j jid.JID, last storage.AfterIDResult, ok bool, limit uint64, logger, debug *log.Logger) {
+	defer panicHandler()
+	// We don't really care how long it takes to get history, and it will
+	// continue to be processed even if we time out, so just set this to a long
+	// time.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	p := c.Printer()
+	accountBare := c.LocalAddr().Bare()
+	if ok {
+		// We have some history already, catch up from the last known message if
+		// extended queries are supported, or from the last known datetime if not.
+
+		// if extended {
+		// 	_, err := history.Fetch(ctx, history.Query{
+		// 		With:    j.Bare(),
+		// 		AfterID: last.ID,
+		// 	}, accountBare, c.Session)
+		// 	if err != nil {
+		// 		logger.Printf("error fetching history after %s for %s: %v", last.ID, j, err)
+		// 	}
+		// 	return
+		// }
+
+		_, err := history.Fetch(ctx, history.Query{
+			With:  j.Bare(),
+			Start: last.Delay,
+		}, accountBare, c.Session)
+		if err != nil {
+			logger.Print(p.Sprintf("error fetching history after %s for %s: %v", last.ID, j, err))
+		}
+		return
+	}
+
+	// We don't have any history yet, so bootstrap a limited amount of history
+	// from the server.
+	_, err := history.Fetch(ctx, history.Query{
+		With:    j.Bare(),
+		End:     time.Now(),
+		Limit:   limit,
+		Reverse: true,
+		Last:    true,
+	}, accountBare, c.Session)
+	if err != nil {
+		debug.Print(p.Sprintf("error bootstraping history for %s: %v", j, err))
+	}
+}
+
+// channelHistoryLimit is the number of messages fetched from the archive of a
+// channel that we don't have any history for yet.
+const channelHistoryLimit = 100
+
+// fetchChannelHistory catches up on the history of a channel that we just
+// joined from the channel's own archive, starting after the last message that
+// we have stored.
+// If we don't have any history for the channel yet, the last limit messages are
+// fetched instead.
+func fetchChannelHistory(c *client.Client, db *storage.DB, room jid.JID, limit uint64, logger, debug *log.Logger) {
+	defer panicHandler()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	p := c.Printer()
+	if !c.Archived(room) {
+		debug.Print(p.Sprintf("channel %s does not have an archive", room))
+		return
+	}
+	lastID, err := db.ChannelAfterID(ctx, room)
+	if err != nil {
+		logger.Print(p.Sprintf("error fetching last message info for %s from database: %v", room, err))
+		return
+	}
+	if lastID == "" {
+		_, err = history.Fetch(ctx, history.Query{
+			Limit: limit,
+			Last:  true,
+		}, room, c.Session)
+		if err != nil {
+			debug.Print(p.Sprintf("error bootstraping history for %s: %v", room, err))
+		}
+		return
+	}
+	// Page through everything that we missed, the archive decides how many
+	// messages are on each page.
+	for {
+		res, err := history.Fetch(ctx, history.Query{
+			PageID: lastID,
+		}, room, c.Session)
+		if err != nil {
+			logger.Print(p.Sprintf("error fetching history after %s for %s: %v", lastID, room, err))
+			return
+		}
+		if res.Complete || res.Set.Last == "" || res.Set.Last == lastID {
+			return
+		}
+		lastID = res.Set.Last
+	}
+}
+
+// mergeReflection reports whether msg is a channel echoing a message that we
+// sent, in which case it has already been stored and shown.
+func mergeReflection(ctx context.Context, db *storage.DB, msg event.ChatMessage, p *message.Printer, logger *log.Logger) bool {
+	merged, err := db.MergeReflection(ctx, msg)
+	if err != nil {
+		logger.Print(p.Sprintf("error updating sent message: %v", err))
+	}
+	return merged
+}
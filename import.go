@@ -0,0 +1,552 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/text/message"
+
+	"mellium.im/cli"
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/localerr"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/xmpp/forward"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// sqliteHeader is the magic string at the start of every SQLite database file.
+const sqliteHeader = "SQLite format 3\x00"
+
+// Kinds of log lines stored by Gajim that contain messages.
+const (
+	gajimGroupChat  = 2
+	gajimNormalRecv = 3
+	gajimChatRecv   = 4
+	gajimNormalSent = 5
+	gajimChatSent   = 6
+)
+
+// Message types stored by Dino.
+const (
+	dinoChat        = 1
+	dinoGroupChat   = 2
+	dinoGroupChatPM = 3
+)
+
+// importFormats returns the names of the formats that history can be imported
+// from and a description of each.
+func importFormats(p *message.Printer) map[string]string {
+	return map[string]string{
+		"gajim": p.Sprintf("the logs.db file of Gajim 1.x"),
+		"dino":  p.Sprintf("the dino.db file of Dino"),
+		"xml":   p.Sprintf("a XEP-0227 export or a dump of MAM results"),
+	}
+}
+
+// importFormatNames returns the names of the supported import formats, sorted.
+func importFormatNames(p *message.Printer) []string {
+	formats := importFormats(p)
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// importer inserts messages into the database while keeping track of the
+// conversations that they belong to.
+type importer struct {
+	db    *storage.DB
+	addr  jid.JID
+	read  int
+	convs map[string]storage.Conversation
+}
+
+// withArchiveID records that the message was stored in an archive under id.
+// Chat messages are archived by our own server and channel messages by the
+// channel.
+func (imp *importer) withArchiveID(msg *event.ChatMessage, id string) {
+	if id == "" {
+		return
+	}
+	by := imp.addr.Bare()
+	if msg.Type == stanza.GroupChatMessage {
+		by = msg.From.Bare()
+		if msg.Sent {
+			by = msg.To.Bare()
+		}
+	}
+	msg.SID = append(msg.SID, stanza.ID{ID: id, By: by})
+}
+
+// insert adds msg to the database.
+// Messages without a body (such as chat markers and retractions) and messages
+// that are still encrypted are skipped since there is nothing to show for them.
+func (imp *importer) insert(ctx context.Context, msg event.ChatMessage, delivered bool) error {
+	if msg.Body == "" || msg.Retract != nil || msg.Encrypted != nil {
+		return nil
+	}
+	imp.read++
+	if msg.ID == "" && msg.OriginID.ID == "" && len(msg.SID) == 0 {
+		// Without any IDs messages can't be deduplicated, so make one up from the
+		// message itself that will be the same if the file is imported again.
+		// Messages that were received without an ID and not imported will not
+		// match it.
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\x00%s\x00%d\x00%s", msg.From.Bare(), msg.To.Bare(), msg.Delay.Time.Unix(), msg.Body)
+		msg.OriginID.ID = "import-" + hex.EncodeToString(h.Sum(nil)[:16])
+	}
+	err := imp.db.InsertMsg(ctx, true, msg, imp.addr)
+	if err != nil {
+		return err
+	}
+	if delivered && msg.Sent {
+		id := msg.OriginID.ID
+		if id == "" {
+			id = msg.ID
+		}
+		if id != "" {
+			err = imp.db.MarkReceived(ctx, event.Receipt(id))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	j := msg.From.Bare()
+	if msg.Sent {
+		j = msg.To.Bare()
+	}
+	c, ok := imp.convs[j.String()]
+	if !ok {
+		c = storage.Conversation{JID: j}
+	}
+	c.Room = c.Room || msg.Type == stanza.GroupChatMessage
+	if msg.Delay.Time.After(c.LastActivity) {
+		c.LastActivity = msg.Delay.Time
+	}
+	imp.convs[j.String()] = c
+	return nil
+}
+
+// importHistory imports the messages from the file at path into db and returns
+// the number of messages that were imported and the number that were skipped
+// because they were already stored.
+// If format is empty, it is detected from the file.
+func importHistory(ctx context.Context, db *storage.DB, path, format string, addr jid.JID, p *message.Printer) (imported, skipped int, err error) {
+	if format == "" {
+		format, err = detectImportFormat(ctx, path, p)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	before, err := db.MessageCount(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	imp := &importer{
+		db:    db,
+		addr:  addr,
+		convs: make(map[string]storage.Conversation),
+	}
+	switch format {
+	case "xml":
+		err = importXMLFile(ctx, imp, path)
+	case "gajim":
+		err = importSQLite(ctx, path, func(src *sql.DB) error {
+			return importGajim(ctx, imp, src)
+		})
+	case "dino":
+		err = importSQLite(ctx, path, func(src *sql.DB) error {
+			return importDino(ctx, imp, src)
+		})
+	default:
+		return 0, 0, localerr.Wrap(p, "unknown import format %q, expected one of: %s", format, strings.Join(importFormatNames(p), ", "))
+	}
+	// Even if the import failed part way through, the messages that were already
+	// imported should show up.
+	for _, c := range imp.convs {
+		e := db.UpsertConversation(ctx, c, false)
+		if err == nil {
+			err = e
+		}
+	}
+	after, e := db.MessageCount(ctx)
+	if err == nil {
+		err = e
+	}
+	imported = after - before
+	return imported, imp.read - imported, err
+}
+
+// detectImportFormat guesses the format of the file at path.
+func detectImportFormat(ctx context.Context, path string, p *message.Printer) (string, error) {
+	/* #nosec */
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	header := make([]byte, len(sqliteHeader))
+	_, err = io.ReadFull(f, header)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	switch {
+	case err == nil && string(header) == sqliteHeader:
+	case err == nil, errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return "xml", nil
+	default:
+		return "", err
+	}
+
+	var format string
+	err = importSQLite(ctx, path, func(src *sql.DB) error {
+		tables := make(map[string]bool)
+		rows, err := src.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type='table'`)
+		if err != nil {
+			return err
+		}
+		/* #nosec */
+		defer rows.Close()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return err
+			}
+			tables[name] = true
+		}
+		switch {
+		case tables["logs"] && tables["jids"]:
+			format = "gajim"
+		case tables["message"] && tables["jid"] && tables["account"]:
+			format = "dino"
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return "", err
+	}
+	if format == "" {
+		return "", localerr.Wrap(p, "%s is not a Gajim or Dino database", path)
+	}
+	return format, nil
+}
+
+// importSQLite opens the SQLite database at path read-only and calls f with it.
+func importSQLite(ctx context.Context, path string, f func(*sql.DB) error) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	u := url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}
+	src, err := sql.Open("sqlite", u.String())
+	if err != nil {
+		return err
+	}
+	err = src.PingContext(ctx)
+	if err == nil {
+		err = f(src)
+	}
+	if e := src.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// unixTime converts a timestamp stored as (possibly fractional) seconds since
+// the Unix epoch.
+func unixTime(sec float64) time.Time {
+	whole, frac := math.Modf(sec)
+	return time.Unix(int64(whole), int64(frac*1e9))
+}
+
+// importGajim imports the messages of our account from a Gajim 1.x logs
+// database.
+// Status changes and errors are not imported.
+func importGajim(ctx context.Context, imp *importer, src *sql.DB) error {
+	rows, err := src.QueryContext(ctx, `
+SELECT j.jid, IFNULL(l.contact_name, ''), l.time, l.kind, IFNULL(l.message, ''),
+		IFNULL(l.stanza_id, ''), IFNULL(l.message_id, ''), l.marker IS NOT NULL
+	FROM logs AS l
+		INNER JOIN jids AS j ON j.jid_id=l.jid_id
+		INNER JOIN jids AS a ON a.jid_id=l.account_id
+	WHERE a.jid=$1 AND l.kind IN ($2, $3, $4, $5, $6)
+	ORDER BY l.time ASC, l.log_line_id ASC`,
+		imp.addr.Bare().String(), gajimGroupChat, gajimNormalRecv, gajimChatRecv, gajimNormalSent, gajimChatSent)
+	if err != nil {
+		return err
+	}
+	/* #nosec */
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			addr, nick, archiveID string
+			sec                   float64
+			kind                  int
+			delivered             bool
+			msg                   event.ChatMessage
+		)
+		err = rows.Scan(&addr, &nick, &sec, &kind, &msg.Body, &archiveID, &msg.ID, &delivered)
+		if err != nil {
+			return err
+		}
+		j, err := jid.Parse(addr)
+		if err != nil {
+			// Gajim does not validate every address that it logs, so skip the
+			// ones that we can't store.
+			continue
+		}
+		msg.Delay.Time = unixTime(sec)
+		switch kind {
+		case gajimGroupChat:
+			msg.Type = stanza.GroupChatMessage
+			msg.From = j.Bare()
+			if nick != "" {
+				if occupant, err := msg.From.WithResource(nick); err == nil {
+					msg.From = occupant
+				}
+			}
+			msg.To = imp.addr
+		case gajimNormalRecv, gajimChatRecv:
+			msg.Type = stanza.ChatMessage
+			if kind == gajimNormalRecv {
+				msg.Type = stanza.NormalMessage
+			}
+			msg.From = j
+			msg.To = imp.addr
+		case gajimNormalSent, gajimChatSent:
+			msg.Type = stanza.ChatMessage
+			if kind == gajimNormalSent {
+				msg.Type = stanza.NormalMessage
+			}
+			msg.From = imp.addr
+			msg.To = j
+			msg.Sent = true
+		}
+		imp.withArchiveID(&msg, archiveID)
+		err = imp.insert(ctx, msg, delivered)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// importDino imports the messages of our account from a Dino database.
+func importDino(ctx context.Context, imp *importer, src *sql.DB) error {
+	rows, err := src.QueryContext(ctx, `
+SELECT j.bare_jid, IFNULL(m.counterpart_resource, ''), m.type, m.direction, m.time,
+		IFNULL(m.body, ''), IFNULL(m.server_id, ''), IFNULL(m.stanza_id, ''),
+		-- The message was received, read, or acknowledged by the recipient.
+		m.marked IN (1, 2, 3)
+	FROM message AS m
+		INNER JOIN jid AS j ON j.id=m.counterpart_id
+		INNER JOIN account AS a ON a.id=m.account_id
+	WHERE a.bare_jid=$1 AND m.type IN ($2, $3, $4)
+	ORDER BY m.time ASC, m.id ASC`,
+		imp.addr.Bare().String(), dinoChat, dinoGroupChat, dinoGroupChatPM)
+	if err != nil {
+		return err
+	}
+	/* #nosec */
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			addr, resource, archiveID string
+			typ                       int
+			sent, delivered           bool
+			sec                       int64
+			msg                       event.ChatMessage
+		)
+		err = rows.Scan(&addr, &resource, &typ, &sent, &sec, &msg.Body, &archiveID, &msg.ID, &delivered)
+		if err != nil {
+			return err
+		}
+		j, err := jid.Parse(addr)
+		if err != nil {
+			continue
+		}
+		if resource != "" && (typ != dinoGroupChat || !sent) {
+			if full, err := j.WithResource(resource); err == nil {
+				j = full
+			}
+		}
+		msg.Delay.Time = time.Unix(sec, 0)
+		msg.Type = stanza.ChatMessage
+		if typ == dinoGroupChat {
+			msg.Type = stanza.GroupChatMessage
+		}
+		msg.Sent = sent
+		if sent {
+			msg.From = imp.addr
+			msg.To = j
+		} else {
+			msg.From = j
+			msg.To = imp.addr
+		}
+		imp.withArchiveID(&msg, archiveID)
+		err = imp.insert(ctx, msg, delivered)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// archivedMessage is a message in a XEP-0227 export or MAM result.
+// Our own exports record delivery receipts as a receipt in the result.
+type archivedMessage struct {
+	ID      string `xml:"id,attr"`
+	Forward struct {
+		forward.Forwarded
+		Msg event.ChatMessage `xml:"message"`
+	} `xml:"urn:xmpp:forward:0 forwarded"`
+	Received *struct{} `xml:"urn:xmpp:receipts received"`
+}
+
+func importXMLFile(ctx context.Context, imp *importer, path string) error {
+	/* #nosec */
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = importXML(ctx, imp, bufio.NewReader(f))
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// importXML imports every archived message found in r, wherever it appears
+// in the document.
+// This lets us read XEP-0227 exports as well as MAM results that were logged
+// one stanza after another.
+func importXML(ctx context.Context, imp *importer, r io.Reader) error {
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "result" || !strings.HasPrefix(start.Name.Space, "urn:xmpp:mam:") {
+			continue
+		}
+		var result archivedMessage
+		err = d.DecodeElement(&result, &start)
+		if err != nil {
+			return err
+		}
+		msg := result.Forward.Msg
+		if msg.Type == stanza.ErrorMessage || msg.Type == stanza.HeadlineMessage {
+			continue
+		}
+		msg.Delay = result.Forward.Delay
+		msg.Sent = msg.From.Bare().Equal(imp.addr.Bare())
+		if msg.To.Equal(jid.JID{}) {
+			msg.To = imp.addr
+		}
+		imp.withArchiveID(&msg, result.ID)
+		err = imp.insert(ctx, msg, result.Received != nil)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func importCmd(cfgPath, defAcct string, p *message.Printer, logger, debug *log.Logger) *cli.Command {
+	const cmdName = "import"
+	flags := flag.NewFlagSet(cmdName, flag.ContinueOnError)
+	var format string
+	flags.StringVar(&format, "format", format, p.Sprintf("the format of the files: %s (default: detected from the file)", strings.Join(importFormatNames(p), ", ")))
+
+	var descr bytes.Buffer
+	formats := importFormats(p)
+	for _, name := range importFormatNames(p) {
+		fmt.Fprintf(&descr, "\n  %-6s %s", name, formats[name])
+	}
+
+	return &cli.Command{
+		Usage: cmdName + " [-format format] file...",
+		Description: p.Sprintf(`Imports message history from other clients.
+
+Messages are stored in the database of the default account (see -account)
+without logging in.
+Only messages to and from that account are imported from other clients'
+databases.
+Messages that are already stored are skipped, so importing the same file again
+is safe.
+
+The supported formats are:
+%s`, descr.String()),
+		Flags: flags,
+		Run: func(_ *cli.Command, args ...string) error {
+			if len(args) == 0 {
+				return errors.New(p.Sprintf("no files to import"))
+			}
+			if _, ok := importFormats(p)[format]; format != "" && !ok {
+				return localerr.Wrap(p, "unknown import format %q, expected one of: %s", format, strings.Join(importFormatNames(p), ", "))
+			}
+
+			cfg, accts, err := loadConfig(cfgPath, defAcct, p, logger)
+			if err != nil {
+				return err
+			}
+			logger.SetOutput(os.Stderr)
+			if cfg.Log.Verbose {
+				debug.SetOutput(os.Stderr)
+			}
+			acct := accts[0]
+			j, err := jid.Parse(acct.Address)
+			if err != nil {
+				return localerr.Wrap(p, "error parsing account as XMPP address: %v", err)
+			}
+			db, err := openDB(j, acct, p, debug)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := db.Close(); err != nil {
+					debug.Print(p.Sprintf("error closing database for %q: %v", acct.Address, err))
+				}
+			}()
+
+			ctx := context.Background()
+			for _, path := range args {
+				imported, skipped, err := importHistory(ctx, db, path, format, j, p)
+				if err != nil {
+					return localerr.Wrap(p, "error importing %s after %d messages: %v", path, imported, err)
+				}
+				fmt.Println(p.Sprintf("%s: imported %d messages, skipped %d that were already stored", path, imported, skipped))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/storage/storagetest"
+	"mellium.im/xmpp/stanza"
+)
+
+// exportXML returns the history in db as an XML export.
+func exportXML(t *testing.T, db *storage.DB) string {
+	t.Helper()
+	var buf strings.Builder
+	_, err := exportHistory(context.Background(), db, &buf, "xml", storage.ExportQuery{}, testSelf, testPrinter())
+	if err != nil {
+		t.Fatalf("error exporting history: %v", err)
+	}
+	return buf.String()
+}
+
+// writeFile writes s to a new file and returns its path.
+func writeFile(t *testing.T, s string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(path, []byte(s), 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	return path
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := testHistory(t)
+	// Messages without any ID are still only imported once.
+	err := src.InsertMsg(ctx, false, event.ChatMessage{Message: stanza.Message{From: testJuliet, To: testSelf, Type: stanza.ChatMessage}, Body: "Deny thy father"}, testSelf)
+	if err != nil {
+		t.Fatalf("error inserting message: %v", err)
+	}
+	want := exportXML(t, src)
+	path := writeFile(t, want)
+
+	dst := storagetest.OpenDB(t)
+	for i, expect := range [][2]int{{5, 0}, {0, 5}} {
+		imported, skipped, err := importHistory(ctx, dst, path, "", testSelf, testPrinter())
+		if err != nil {
+			t.Fatalf("error importing history %d: %v", i, err)
+		}
+		if imported != expect[0] || skipped != expect[1] {
+			t.Errorf("wrong counts on import %d: want=%v, got=[%d %d]", i, expect, imported, skipped)
+		}
+	}
+	if got := exportXML(t, dst); got != want {
+		t.Errorf("imported history does not match:\nwant=%s\ngot=%s", want, got)
+	}
+	if !strings.Contains(want, `id="a1"`) {
+		t.Errorf("expected archive ID to be exported, got=%s", want)
+	}
+
+	// Importing our own export again must not replace the archive IDs.
+	if _, _, err := importHistory(ctx, src, path, "xml", testSelf, testPrinter()); err != nil {
+		t.Fatalf("error importing history into the original database: %v", err)
+	}
+	if got := exportXML(t, src); !strings.Contains(got, `id="a1"`) {
+		t.Errorf("archive ID was lost on import, got=%s", got)
+	}
+
+	convs, err := dst.Conversations(ctx)
+	if err != nil {
+		t.Fatalf("error listing conversations: %v", err)
+	}
+	if len(convs) != 2 {
+		t.Errorf("expected imported conversations to be listed, got=%+v", convs)
+	}
+}
+
+const mamResult = `<result xmlns="urn:xmpp:mam:2" id="a1"><forwarded xmlns="urn:xmpp:forward:0"><delay xmlns="urn:xmpp:delay" stamp="2020-01-01T00:00:00Z"/><message xmlns="jabber:client" from="juliet@example.com/balcony" to="me@example.net" type="chat" id="1"><body>Wherefore art thou Romeo?</body></message></forwarded></result>`
+
+var importTestCases = [...]struct {
+	file     string
+	format   string
+	imported int
+	err      bool
+}{
+	0: {file: mamResult, imported: 1},
+	// Results can be logged one after another without a root element.
+	1: {file: mamResult + "\n" + strings.NewReplacer(`id="1"`, `id="2"`, `id="a1"`, `id="a2"`).Replace(mamResult), imported: 2},
+	// The same message in the same file is only imported once.
+	2: {file: mamResult + mamResult, imported: 1},
+	// Errors and headlines are not part of the history.
+	3: {file: strings.ReplaceAll(mamResult, `type="chat"`, `type="error"`)},
+	4: {file: strings.ReplaceAll(mamResult, `type="chat"`, `type="headline"`)},
+	// Files without any results are empty, not invalid.
+	5: {file: ""},
+	6: {file: `<history xmlns="urn:xmpp:mam:2"/>`},
+	// Messages before a syntax error are still imported.
+	7: {file: mamResult + "<result", imported: 1, err: true},
+	8: {file: `<result xmlns="urn:xmpp:mam:2"><forwarded`, err: true},
+	// Unknown or wrong formats.
+	9:  {file: mamResult, format: "pdf", err: true},
+	10: {file: mamResult, format: "gajim", err: true},
+	11: {file: mamResult, format: "dino", err: true},
+}
+
+func TestImportHistory(t *testing.T) {
+	for i, tc := range importTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			db := storagetest.OpenDB(t)
+			imported, _, err := importHistory(context.Background(), db, writeFile(t, tc.file), tc.format, testSelf, testPrinter())
+			switch {
+			case err != nil && !tc.err:
+				t.Fatalf("error importing history: %v", err)
+			case err == nil && tc.err:
+				t.Fatalf("expected error importing history")
+			}
+			if imported != tc.imported {
+				t.Errorf("wrong number of messages imported: want=%d, got=%d", tc.imported, imported)
+			}
+			n, err := db.MessageCount(context.Background())
+			if err != nil {
+				t.Fatalf("error counting messages: %v", err)
+			}
+			if n != tc.imported {
+				t.Errorf("wrong number of messages stored: want=%d, got=%d", tc.imported, n)
+			}
+		})
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	ctx := context.Background()
+	db := storagetest.OpenDB(t)
+	p := testPrinter()
+	for _, format := range append(importFormatNames(p), "") {
+		path := filepath.Join(t.TempDir(), "missing")
+		if _, _, err := importHistory(ctx, db, path, format, testSelf, p); err == nil {
+			t.Errorf("expected error importing a missing file as %q", format)
+		}
+	}
+
+	// Our own database is neither a Gajim nor a Dino database.
+	other := filepath.Join(t.TempDir(), "other.db")
+	otherDB, err := storage.OpenDB(ctx, "communiqué", "other", other, storage.Schema(), p, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("error creating database: %v", err)
+	}
+	if err := otherDB.Close(); err != nil {
+		t.Fatalf("error closing database: %v", err)
+	}
+	if _, _, err := importHistory(ctx, db, other, "", testSelf, p); err == nil {
+		t.Errorf("expected error importing an unknown database")
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := importHistory(canceled, db, writeFile(t, mamResult), "xml", testSelf, p); err == nil {
+		t.Errorf("expected error importing with a canceled context")
+	}
+}
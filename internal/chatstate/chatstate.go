@@ -0,0 +1,77 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package chatstate contains the chat states used to tell a contact whether we
+// are typing (XEP-0085: Chat State Notifications).
+package chatstate // import "mellium.im/communique/internal/chatstate"
+
+import (
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+)
+
+// NS is the namespace used by chat state notifications.
+const NS = "http://jabber.org/protocol/chatstates"
+
+// State is the state of a conversation from the point of view of the person
+// sending it.
+type State uint8
+
+// A list of possible chat states.
+// The zero value is Active, which is the state of a conversation that somebody
+// is paying attention to.
+const (
+	Active State = iota
+	Composing
+	Paused
+	Inactive
+	Gone
+)
+
+var names = [...]string{
+	Active:    "active",
+	Composing: "composing",
+	Paused:    "paused",
+	Inactive:  "inactive",
+	Gone:      "gone",
+}
+
+// String returns the local name of the element used for the state.
+func (s State) String() string {
+	if int(s) >= len(names) {
+		return ""
+	}
+	return names[s]
+}
+
+// Parse returns the state that uses the element with the given name.
+// If name is not the name of a chat state, ok is false.
+func Parse(name xml.Name) (s State, ok bool) {
+	if name.Space != NS {
+		return Active, false
+	}
+	for i, n := range names {
+		if n == name.Local {
+			return State(i), true
+		}
+	}
+	return Active, false
+}
+
+// Names returns the names of the elements used by all chat states.
+func Names() []xml.Name {
+	n := make([]xml.Name, 0, len(names))
+	for _, local := range names {
+		n = append(n, xml.Name{Space: NS, Local: local})
+	}
+	return n
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (s State) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NS, Local: s.String()},
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package chatstate_test
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"mellium.im/communique/internal/chatstate"
+	"mellium.im/xmlstream"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range chatstate.Names() {
+		t.Run(name.Local, func(t *testing.T) {
+			s, ok := chatstate.Parse(name)
+			if !ok {
+				t.Fatalf("failed to parse %v", name)
+			}
+			var buf strings.Builder
+			e := xml.NewEncoder(&buf)
+			_, err := xmlstream.Copy(e, s.TokenReader())
+			if err != nil {
+				t.Fatalf("error encoding state: %v", err)
+			}
+			if err = e.Flush(); err != nil {
+				t.Fatalf("error flushing encoder: %v", err)
+			}
+			want := `<` + name.Local + ` xmlns="` + chatstate.NS + `"></` + name.Local + `>`
+			if out := buf.String(); out != want {
+				t.Errorf("wrong encoding: want=%s, got=%s", want, out)
+			}
+		})
+	}
+}
+
+func TestParseUnknown(t *testing.T) {
+	for _, name := range []xml.Name{
+		{Space: chatstate.NS, Local: "typing"},
+		{Space: "jabber:client", Local: "composing"},
+	} {
+		if _, ok := chatstate.Parse(name); ok {
+			t.Errorf("unexpectedly parsed %v", name)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/stanza"
+)
+
+// Affiliation returns our affiliation with the given channel.
+// If we have not joined the channel, AffiliationNone is returned.
+func (c *Client) Affiliation(room jid.JID) muc.Affiliation {
+	return c.self(room).Affiliation
+}
+
+// SetRole changes the role of an occupant of a channel.
+// Setting the role to RoleNone kicks the occupant out of the channel.
+func (c *Client) SetRole(ctx context.Context, room jid.JID, nick string, role muc.Role, reason string) error {
+	return c.admin(ctx, room, []xml.Attr{
+		{Name: xml.Name{Local: "nick"}, Value: nick},
+		{Name: xml.Name{Local: "role"}, Value: role.String()},
+	}, reason)
+}
+
+// SetAffiliation changes the affiliation of someone with a channel.
+// If j is the zero value the occupant using nick is changed instead, otherwise
+// j should be their real address.
+// Setting the affiliation to AffiliationOutcast bans them from the channel.
+func (c *Client) SetAffiliation(ctx context.Context, room, j jid.JID, nick string, a muc.Affiliation, reason string) error {
+	attr := []xml.Attr{{Name: xml.Name{Local: "affiliation"}, Value: a.String()}}
+	if j.Equal(jid.JID{}) {
+		attr = append(attr, xml.Attr{Name: xml.Name{Local: "nick"}, Value: nick})
+	} else {
+		attr = append(attr, xml.Attr{Name: xml.Name{Local: "jid"}, Value: j.Bare().String()})
+	}
+	return c.admin(ctx, room, attr, reason)
+}
+
+// Affiliations returns everyone with the given affiliation, for example the
+// ban list if a is AffiliationOutcast.
+func (c *Client) Affiliations(ctx context.Context, room jid.JID, a muc.Affiliation) ([]muc.Item, error) {
+	var list struct {
+		Items []muc.Item `xml:"http://jabber.org/protocol/muc#admin item"`
+	}
+	err := c.UnmarshalIQ(ctx, stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   room.Bare(),
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "item"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "affiliation"}, Value: a.String()}},
+		}),
+		xml.StartElement{Name: xml.Name{Space: muc.NSAdmin, Local: "query"}},
+	)), &list)
+	return list.Items, err
+}
+
+// admin sends a request to change the role or affiliation of the item with the
+// given attributes.
+func (c *Client) admin(ctx context.Context, room jid.JID, attr []xml.Attr, reason string) error {
+	return c.UnmarshalIQ(ctx, stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   room.Bare(),
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Wrap(
+			omitEmpty(reason, xml.Name{Local: "reason"}),
+			xml.StartElement{Name: xml.Name{Local: "item"}, Attr: attr},
+		),
+		xml.StartElement{Name: xml.Name{Space: muc.NSAdmin, Local: "query"}},
+	)), nil)
+}
@@ -5,21 +5,25 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/xml"
 	"errors"
 	"io"
 	"log"
+	"math/rand/v2"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/text/message"
 
+	"mellium.im/communique/internal/chatstate"
 	"mellium.im/communique/internal/client/event"
 	"mellium.im/communique/internal/localerr"
 	legacybookmarks "mellium.im/legacy/bookmarks"
@@ -62,14 +66,27 @@ func New(j jid.JID, logger, debug *log.Logger, opts ...Option) *Client {
 		receiptsHandler: &receipts.Handler{
 			Unhandled: func(id string) { c.handler(event.Receipt(id)) },
 		},
-		// TODO: mediated muc invitations
 		mucClient: &muc.Client{},
 		channels:  make(map[string]*muc.Channel),
+		archived:  make(map[string]bool),
+		searches:  make(map[string]*archiveSearch),
+		sm:        &streamManager{},
+
+		occupants:    make(map[string]muc.Item),
+		joined:       make(map[string]struct{}),
+		renamed:      make(map[string]struct{}),
+		created:      make(map[string]struct{}),
+		chatStates:   make(map[string]struct{}),
+		omemoFetched: make(map[string]time.Time),
+		outboxWake:   make(chan struct{}, 1),
 	}
 
 	for _, opt := range opts {
 		opt(c)
 	}
+	if c.outbox != nil {
+		go c.sendQueued()
+	}
 
 	return c
 }
@@ -102,7 +119,114 @@ func (c *Client) Handler(h func(interface{})) {
 	c.handler = h
 }
 
+// reconnect establishes a session if we are not already online.
+// It is meant to be called when the user explicitly changes their status and
+// cancels any automatic reconnection that might be pending.
 func (c *Client) reconnect(ctx context.Context) error {
+	// Interrupt any reconnect attempt that is in progress before waiting on the
+	// connection lock that it holds.
+	c.stopRetry()
+	c.connM.Lock()
+	defer c.connM.Unlock()
+	c.closing = false
+	c.stopRetry()
+	return c.connect(ctx)
+}
+
+// stopRetry cancels any pending automatic reconnection, including an attempt
+// that is currently in progress.
+// It may be called with or without connM held.
+func (c *Client) stopRetry() {
+	c.retryM.Lock()
+	defer c.retryM.Unlock()
+	if c.retryCancel != nil {
+		c.retryCancel()
+		c.retryCancel = nil
+	}
+}
+
+// retry attempts to re-establish the session after the connection was lost,
+// waiting longer between each attempt.
+// If the previous stream could not be resumed we also restore the status that
+// was set before the connection was lost.
+func (c *Client) retry(ctx context.Context) {
+	p := c.Printer()
+	for attempt := 0; ; attempt++ {
+		// Don't show a countdown for an attempt that will never be made because
+		// we went offline in the meantime.
+		if ctx.Err() != nil {
+			return
+		}
+		d := backoff(attempt)
+		c.handler(event.Reconnecting(d))
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		c.connM.Lock()
+		// Check again with the lock held in case we went offline or the user
+		// reconnected manually while we were waiting.
+		if ctx.Err() != nil || c.online {
+			c.connM.Unlock()
+			return
+		}
+		// Each attempt gets its own deadline so that a stalled dial or
+		// negotiation does not hold the lock forever.
+		attemptCtx, attemptCancel := context.WithTimeout(ctx, c.timeout)
+		err := c.connect(attemptCtx)
+		attemptCancel()
+		show := c.show
+		c.connM.Unlock()
+		if err != nil {
+			c.logger.Print(p.Sprintf("error reconnecting: %v", err))
+			continue
+		}
+
+		if resumed, _ := c.sm.Resumed(); resumed {
+			// The server remembered our presence, so just let the UI know that
+			// we're back.
+			switch show {
+			case "away":
+				c.handler(event.StatusAway(c.LocalAddr()))
+			case "dnd":
+				c.handler(event.StatusBusy(c.LocalAddr()))
+			default:
+				c.handler(event.StatusOnline(c.LocalAddr()))
+			}
+			return
+		}
+		err = c.sendPresence(ctx, show)
+		if err != nil {
+			c.logger.Print(p.Sprintf("error restoring status: %v", err))
+		}
+		return
+	}
+}
+
+// backoff returns how long to wait before reconnect attempt n (starting at 0).
+// The delay grows exponentially up to a maximum and half of it is randomized so
+// that many clients disconnected at once by a server restart do not all come
+// back at the same time.
+func backoff(n int) time.Duration {
+	const (
+		minDelay = time.Second
+		maxDelay = 5 * time.Minute
+	)
+	d := maxDelay
+	if n < 16 {
+		d = min(minDelay<<n, maxDelay)
+	}
+	return d/2 + rand.N(d/2)
+}
+
+// connect dials the server and negotiates a session, resuming the previous
+// stream if possible.
+// It must be called with connM held.
+func (c *Client) connect(ctx context.Context) error {
 	if c.online {
 		return nil
 	}
@@ -122,6 +246,7 @@ func (c *Client) reconnect(ctx context.Context) error {
 	if err != nil {
 		return localerr.Wrap(p, "error dialing connection: %v", err)
 	}
+	smIn, smOut := c.sm.tees()
 
 	var mechanisms []sasl.Mechanism
 	if c.addr.Localpart() == "" {
@@ -147,35 +272,69 @@ func (c *Client) reconnect(ctx context.Context) error {
 				xmpp.StartTLS(c.dialer.TLSConfig),
 				saslFeature,
 				roster.Versioning(),
-				xmpp.BindResource(),
+				c.sm.Bind(),
 			},
-			TeeIn:  c.win,
-			TeeOut: c.wout,
+			TeeIn:  teeWriter(c.win, smIn),
+			TeeOut: teeWriter(c.wout, smOut),
 		}
 	})
-	c.Session, err = xmpp.NewSession(ctx, c.addr.Domain(), c.addr, conn, 0, negotiator)
+	session, err := xmpp.NewSession(ctx, c.addr.Domain(), c.addr, conn, 0, negotiator)
 	if err != nil {
+		c.sm.Close()
 		return localerr.Wrap(p, "error negotiating session: %v", err)
 	}
+	c.Session = session
 
 	c.online = true
 
 	go func() {
-		err := c.Serve(newXMPPHandler(c))
+		err := session.Serve(newXMPPHandler(c))
 		if err != nil {
 			c.logger.Print(p.Sprintf("Error while handling XMPP streams: %q", err))
 		}
+		c.sm.Close()
 
-		c.handler(event.StatusOffline(c.LocalAddr()))
-		err = c.Offline()
-		if err != nil {
-			c.logger.Print(p.Sprintf("Error going offline: %q", err))
+		c.connM.Lock()
+		c.online = false
+		closing := c.closing
+		var retryCtx context.Context
+		if !closing {
+			c.retryM.Lock()
+			retryCtx, c.retryCancel = context.WithCancel(context.Background())
+			c.retryM.Unlock()
 		}
+		c.connM.Unlock()
+
+		c.handler(event.StatusOffline(session.LocalAddr()))
 		if err = conn.Close(); err != nil {
 			c.logger.Print(p.Sprintf("Error closing the connection: %q", err))
 		}
+		if !closing {
+			go c.retry(retryCtx)
+		}
 	}()
 
+	// Send anything that the server did not acknowledge before we lost the
+	// previous connection.
+	resumed, resend := c.sm.Resumed()
+	for _, s := range resend {
+		err = c.Session.Send(ctx, xml.NewDecoder(bytes.NewReader(s.raw)))
+		if err != nil {
+			c.logger.Print(p.Sprintf("error resending %s: %v", s.name, err))
+		}
+	}
+	// Then anything that was written while we were offline.
+	c.wakeOutbox()
+	if resumed {
+		// The server kept our roster and presence around, but channels are tied
+		// to the session they were joined with so they still need to be
+		// recreated.
+		mucCtx, mucCancel := context.WithTimeout(context.Background(), c.timeout)
+		defer mucCancel()
+		c.rejoinMUCs(mucCtx, muc.MaxHistory(0))
+		return nil
+	}
+
 	// If the stream contained entity capabilities, go ahead and send an alert so
 	// that we can fetch the disco
 	if caps, ok := disco.ServerCaps(c.Session); ok {
@@ -232,9 +391,71 @@ func (c *Client) reconnect(ctx context.Context) error {
 		c.logger.Print(p.Sprintf("error fetching bookmarks: %q", err))
 	}
 
+	// Make sure our OMEMO keys are published so that others can start encrypted
+	// sessions with us.
+	if c.omemo != nil {
+		omemoCtx, omemoCancel := context.WithTimeout(context.Background(), c.timeout)
+		defer omemoCancel()
+		err = c.publishOMEMO(omemoCtx)
+		if err != nil {
+			c.logger.Print(p.Sprintf("error publishing OMEMO keys: %v", err))
+		}
+	}
+
+	// Rejoin any channels we were in before the connection was lost.
+	mucCtx, mucCancel := context.WithTimeout(context.Background(), c.timeout)
+	defer mucCancel()
+	c.rejoinMUCs(mucCtx)
+
 	return nil
 }
 
+// rejoinMUCs joins all channels that we were in on a previous session using
+// the current session.
+// Any options in opts override the default history limit.
+func (c *Client) rejoinMUCs(ctx context.Context, opts ...muc.Option) {
+	p := c.Printer()
+	c.chanM.Lock()
+	rooms := make(map[string]jid.JID, len(c.channels))
+	for s, mucChan := range c.channels {
+		rooms[s] = mucChan.Me()
+	}
+	c.chanM.Unlock()
+
+	// Find out which channels have an archive before taking the lock so that a
+	// slow channel does not block everything else that uses it.
+	maxHistory := make(map[string]muc.Option, len(rooms))
+	for s, me := range rooms {
+		maxHistory[s] = c.maxHistory(me)
+	}
+
+	c.chanM.Lock()
+	defer c.chanM.Unlock()
+	for s, me := range rooms {
+		if _, ok := c.channels[s]; !ok {
+			// We left the channel in the mean time.
+			continue
+		}
+		// The channel sends us the full list of occupants again when we join.
+		c.forgetChannel(s)
+		newChan, err := c.mucClient.Join(ctx, me, c.Session, append([]muc.Option{maxHistory[s]}, opts...)...)
+		if err != nil {
+			c.logger.Print(p.Sprintf("error rejoining %s: %v", s, err))
+			continue
+		}
+		c.channels[s] = newChan
+	}
+}
+
+// teeWriter returns a writer that writes to both w and sm, or just sm if w is
+// nil.
+func teeWriter(w, sm io.Writer) io.Writer {
+	if w == nil {
+		return sm
+	}
+	return io.MultiWriter(sm, w)
+}
+
 // Client represents an XMPP client.
 type Client struct {
 	*xmpp.Session
@@ -247,6 +468,12 @@ type Client struct {
 	dialer          *dial.Dialer
 	getPass         func(context.Context) (string, error)
 	online          bool
+	closing         bool
+	show            string
+	connM           sync.Mutex
+	retryM          sync.Mutex
+	retryCancel     context.CancelFunc
+	sm              *streamManager
 	handler         func(interface{})
 	receiptsHandler *receipts.Handler
 	rosterVer       string
@@ -254,6 +481,24 @@ type Client struct {
 	mucClient       *muc.Client
 	chanM           sync.Mutex
 	channels        map[string]*muc.Channel
+	archivedM       sync.Mutex
+	archived        map[string]bool
+	searchesM       sync.Mutex
+	searches        map[string]*archiveSearch
+	occupantsM      sync.Mutex
+	occupants       map[string]muc.Item
+	joined          map[string]struct{}
+	renamed         map[string]struct{}
+	created         map[string]struct{}
+	chatStatesM     sync.Mutex
+	chatStates      map[string]struct{}
+	omemo           OMEMOStore
+	omemoM          sync.Mutex
+	omemoFetchedM   sync.Mutex
+	omemoFetched    map[string]time.Time
+	outbox          OutboxStore
+	outboxM         sync.Mutex
+	outboxWake      chan struct{}
 	p               *message.Printer
 	httpClient      *http.Client
 }
@@ -269,16 +514,41 @@ func (c *Client) Printer() *message.Printer {
 // have to re-establish the session, so if it includes a timeout make sure to
 // account for the fact that we might reconnect.
 func (c *Client) Online(ctx context.Context) error {
+	return c.setStatus(ctx, "")
+}
+
+// Connect logs in if we are not already connected without sending any
+// presence, so contacts do not see us come online.
+func (c *Client) Connect(ctx context.Context) error {
+	return c.reconnect(ctx)
+}
+
+// setStatus reconnects if necessary and sends an available presence with the
+// provided show value, remembering it so that it can be restored if the
+// connection is lost.
+func (c *Client) setStatus(ctx context.Context, show string) error {
 	err := c.reconnect(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = c.Send(ctx, stanza.Presence{Type: stanza.AvailablePresence}.Wrap(nil))
-	if err != nil {
-		return err
+	c.connM.Lock()
+	c.show = show
+	c.connM.Unlock()
+	return c.sendPresence(ctx, show)
+}
+
+func (c *Client) sendPresence(ctx context.Context, show string) error {
+	var payload xml.TokenReader
+	if show != "" {
+		payload = xmlstream.Wrap(
+			xmlstream.ReaderFunc(func() (xml.Token, error) {
+				return xml.CharData(show), io.EOF
+			}),
+			xml.StartElement{Name: xml.Name{Local: "show"}},
+		)
 	}
-	return nil
+	return c.Send(ctx, stanza.Presence{Type: stanza.AvailablePresence}.Wrap(payload))
 }
 
 // Bookmarks fetches the users list of bookmarked chat rooms.
@@ -405,55 +675,34 @@ func (c *Client) Roster(ctx context.Context) error {
 
 // Away sets the status to away.
 func (c *Client) Away(ctx context.Context) error {
-	err := c.reconnect(ctx)
-	if err != nil {
-		return err
-	}
-
-	err = c.Send(
-		ctx,
-		stanza.Presence{Type: stanza.AvailablePresence}.Wrap(
-			xmlstream.Wrap(
-				xmlstream.ReaderFunc(func() (xml.Token, error) {
-					return xml.CharData("away"), io.EOF
-				}),
-				xml.StartElement{Name: xml.Name{Local: "show"}},
-			)))
-	if err != nil {
-		return err
-	}
-	return nil
+	return c.setStatus(ctx, "away")
 }
 
 // Busy sets the status to busy.
 func (c *Client) Busy(ctx context.Context) error {
-	err := c.reconnect(ctx)
-	if err != nil {
-		return err
-	}
-
-	err = c.Send(
-		ctx,
-		stanza.Presence{Type: stanza.AvailablePresence}.Wrap(
-			xmlstream.Wrap(
-				xmlstream.ReaderFunc(func() (xml.Token, error) {
-					return xml.CharData("dnd"), io.EOF
-				}),
-				xml.StartElement{Name: xml.Name{Local: "show"}},
-			)))
-	if err != nil {
-		return err
-	}
-	return nil
+	return c.setStatus(ctx, "dnd")
 }
 
 // Offline logs the client off.
 func (c *Client) Offline() error {
-	if !c.online {
+	// Interrupt any reconnect attempt that is in progress before waiting on the
+	// connection lock that it holds.
+	c.stopRetry()
+	c.connM.Lock()
+	c.closing = true
+	c.stopRetry()
+	online := c.online
+	c.connM.Unlock()
+	if !online {
 		return nil
 	}
+	// Closing the stream cleanly ends it on the server too, so there will be
+	// nothing to resume.
+	c.sm.Forget()
 	defer func() {
+		c.connM.Lock()
 		c.online = false
+		c.connM.Unlock()
 	}()
 
 	err := c.SetCloseDeadline(time.Now().Add(2 * c.timeout))
@@ -485,7 +734,49 @@ func (c *Client) SendMessage(ctx context.Context, msg event.ChatMessage) (event.
 		msg.OriginID.ID = id
 	}
 
-	return msg, c.Session.Send(ctx, receipts.Request(encodeMessage(msg)))
+	r, err := c.messageReader(ctx, msg)
+	if err != nil {
+		return msg, err
+	}
+	return msg, c.send(ctx, r)
+}
+
+// messageReader encodes msg, encrypting it if encryption is enabled for the
+// conversation.
+func (c *Client) messageReader(ctx context.Context, msg event.ChatMessage) (xml.TokenReader, error) {
+	var encrypted bool
+	if c.omemo != nil && msg.Body != "" {
+		var err error
+		encrypted, err = c.omemo.OMEMOEnabled(ctx, msg.To.Bare())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !encrypted {
+		return encodeMessage(msg), nil
+	}
+	enc, err := c.encrypt(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	p := c.Printer()
+	return encodeEncrypted(msg, enc, p.Sprintf("I sent you an OMEMO encrypted message but your client doesn't seem to support that.")), nil
+}
+
+// send sends an encoded message with a receipt request and asks the server to
+// acknowledge it.
+// Once the message has been written it is stream management's job to deliver
+// it, so failing to request an acknowledgement is not reported as an error.
+func (c *Client) send(ctx context.Context, r xml.TokenReader) error {
+	err := c.Session.Send(ctx, receipts.Request(r))
+	if err != nil {
+		return err
+	}
+	err = c.sm.Request(ctx, c.Session)
+	if err != nil {
+		c.debug.Print(c.Printer().Sprintf("error requesting acknowledgement: %v", err))
+	}
+	return nil
 }
 
 func omitEmpty(s string, name xml.Name) xml.TokenReader {
@@ -502,20 +793,80 @@ func encodeMessage(e event.ChatMessage) xml.TokenReader {
 	e.Message.XMLName = xml.Name{Space: "jabber:client", Local: "message"}
 	return e.Message.Wrap(xmlstream.MultiReader(
 		omitEmpty(e.Body, xml.Name{Local: "body"}),
+		e.Replace.TokenReader(),
 		e.OriginID.TokenReader(),
+		chatHints(e),
 	))
 }
 
+// chatHints returns the chat state and the request for chat markers that are
+// sent along with messages that we type.
+// Both are only used in one-to-one chats.
+func chatHints(e event.ChatMessage) xml.TokenReader {
+	if e.Type != stanza.ChatMessage || e.Body == "" {
+		return xmlstream.MultiReader()
+	}
+	return xmlstream.MultiReader(
+		chatstate.Active.TokenReader(),
+		event.Markable{}.TokenReader(),
+	)
+}
+
+// SendDisplayed tells a contact that we have displayed the message with the
+// given ID, and all messages before it.
+// The marker is stored in our archive so that our other clients can find out
+// what we have read.
+func (c *Client) SendDisplayed(ctx context.Context, to jid.JID, id string) error {
+	return c.Session.Send(ctx, stanza.Message{
+		XMLName: xml.Name{Space: stanza.NSClient, Local: "message"},
+		ID:      randomID(),
+		To:      to.Bare(),
+		Type:    stanza.ChatMessage,
+	}.Wrap(xmlstream.MultiReader(
+		event.Displayed{ID: id}.TokenReader(),
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsHints, Local: "store"}}),
+	)))
+}
+
+// setChatStates records that a contact supports chat state notifications.
+func (c *Client) setChatStates(j jid.JID) {
+	c.chatStatesM.Lock()
+	defer c.chatStatesM.Unlock()
+	c.chatStates[j.Bare().String()] = struct{}{}
+}
+
+// SendChatState tells a contact whether we are typing a message to them.
+// Chat states are only sent to contacts that have sent us one before, since
+// otherwise they are unlikely to be supported.
+func (c *Client) SendChatState(ctx context.Context, to jid.JID, state chatstate.State) error {
+	c.chatStatesM.Lock()
+	_, ok := c.chatStates[to.Bare().String()]
+	c.chatStatesM.Unlock()
+	if !ok {
+		return nil
+	}
+	return c.Session.Send(ctx, stanza.Message{
+		XMLName: xml.Name{Space: stanza.NSClient, Local: "message"},
+		To:      to.Bare(),
+		Type:    stanza.ChatMessage,
+	}.Wrap(xmlstream.MultiReader(
+		state.TokenReader(),
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsHints, Local: "no-store"}}),
+	)))
+}
+
 // JoinMUC joins a multi-user chat, or rejoins it if it was already joined.
-func (c *Client) JoinMUC(ctx context.Context, room jid.JID) error {
+// Options such as the password of the channel can be passed in opts.
+func (c *Client) JoinMUC(ctx context.Context, room jid.JID, opts ...muc.Option) error {
 	s := room.Bare().String()
+	maxHistory := c.maxHistory(room)
 	c.chanM.Lock()
 	defer c.chanM.Unlock()
 	mucChan, ok := c.channels[s]
 	if ok {
-		return mucChan.Join(ctx)
+		return mucChan.Join(ctx, opts...)
 	}
-	mucChan, err := c.mucClient.Join(ctx, room, c.Session, muc.MaxHistory(100))
+	mucChan, err := c.mucClient.Join(ctx, room, c.Session, append([]muc.Option{maxHistory}, opts...)...)
 	if err != nil {
 		return err
 	}
@@ -523,6 +874,14 @@ func (c *Client) JoinMUC(ctx context.Context, room jid.JID) error {
 	return nil
 }
 
+// Joined reports whether we have joined the given multi-user chat.
+func (c *Client) Joined(room jid.JID) bool {
+	c.chanM.Lock()
+	defer c.chanM.Unlock()
+	_, ok := c.channels[room.Bare().String()]
+	return ok
+}
+
 // LeaveMUC exits the given multi-user chat..
 func (c *Client) LeaveMUC(ctx context.Context, room jid.JID, reason string) error {
 	s := room.Bare().String()
@@ -537,9 +896,179 @@ func (c *Client) LeaveMUC(ctx context.Context, room jid.JID, reason string) erro
 		return err
 	}
 	delete(c.channels, s)
+	c.forgetChannel(s)
 	return nil
 }
 
+// SetNick changes our nickname in a channel we have joined.
+func (c *Client) SetNick(ctx context.Context, room jid.JID, nick string) error {
+	c.chanM.Lock()
+	defer c.chanM.Unlock()
+	mucChan, ok := c.channels[room.Bare().String()]
+	if !ok {
+		return errors.New(c.p.Sprintf("not joined to %s", room.Bare()))
+	}
+	return mucChan.Join(ctx, muc.Nick(nick))
+}
+
+// SetSubject changes the subject of a channel we have joined.
+func (c *Client) SetSubject(ctx context.Context, room jid.JID, subject string) error {
+	c.chanM.Lock()
+	defer c.chanM.Unlock()
+	mucChan, ok := c.channels[room.Bare().String()]
+	if !ok {
+		return errors.New(c.p.Sprintf("not joined to %s", room.Bare()))
+	}
+	return mucChan.Subject(ctx, subject)
+}
+
+// setOccupant records the details of a channel occupant, or forgets them if
+// the occupant left, and returns the event describing the change.
+// If self is true the presence is our own, and entered reports whether it
+// means that we have just joined the channel.
+func (c *Client) setOccupant(occupant jid.JID, item muc.Item, left, self bool, newNick string) (e event.OccupantPresence, entered bool) {
+	c.occupantsM.Lock()
+	defer c.occupantsM.Unlock()
+
+	room := occupant.Bare().String()
+	key := occupant.String()
+	_, joined := c.joined[room]
+	e = event.OccupantPresence{
+		Occupant: occupant,
+		Item:     item,
+		NewNick:  newNick,
+	}
+	if left {
+		delete(c.occupants, key)
+		if newNick != "" {
+			// The occupant will be back under their new nickname.
+			newOccupant, err := occupant.WithResource(newNick)
+			if err == nil {
+				c.renamed[newOccupant.String()] = struct{}{}
+			}
+			return e, false
+		}
+		e.Left = joined
+		if self {
+			c.forgetChannelLocked(room)
+		}
+		return e, false
+	}
+	_, known := c.occupants[key]
+	_, renamed := c.renamed[key]
+	delete(c.renamed, key)
+	e.Joined = joined && !known && !renamed
+	item.JID = item.JID.Bare()
+	item.Nick = occupant.Resourcepart()
+	c.occupants[key] = item
+	// Our own presence is the last one sent while joining, so everyone after it
+	// is new.
+	if self {
+		entered = !joined
+		c.joined[room] = struct{}{}
+	}
+	return e, entered
+}
+
+// forgetChannel forgets everyone in the channel with the given bare address.
+func (c *Client) forgetChannel(room string) {
+	c.occupantsM.Lock()
+	defer c.occupantsM.Unlock()
+	c.forgetChannelLocked(room)
+}
+
+func (c *Client) forgetChannelLocked(room string) {
+	delete(c.joined, room)
+	delete(c.created, room)
+	for occupant := range c.occupants {
+		j, err := jid.Parse(occupant)
+		if err == nil && j.Bare().String() == room {
+			delete(c.occupants, occupant)
+		}
+	}
+}
+
+// Occupants returns everyone that is currently in the channel, including
+// ourselves, sorted by nickname.
+// The nickname of each occupant is set, and their real address if the channel
+// reveals it.
+func (c *Client) Occupants(room jid.JID) []muc.Item {
+	c.occupantsM.Lock()
+	defer c.occupantsM.Unlock()
+	room = room.Bare()
+	var items []muc.Item
+	for occupant, item := range c.occupants {
+		j, err := jid.Parse(occupant)
+		if err != nil || !j.Bare().Equal(room) {
+			continue
+		}
+		items = append(items, item)
+	}
+	slices.SortFunc(items, func(a, b muc.Item) int {
+		return strings.Compare(strings.ToLower(a.Nick), strings.ToLower(b.Nick))
+	})
+	return items
+}
+
+// OccupantJIDs returns the real addresses of everyone in a channel other than
+// ourselves.
+// Only channels that are not anonymous reveal these addresses.
+func (c *Client) OccupantJIDs(room jid.JID) []jid.JID {
+	c.occupantsM.Lock()
+	defer c.occupantsM.Unlock()
+	room = room.Bare()
+	self := c.LocalAddr().Bare()
+	var addrs []jid.JID
+	for occupant, item := range c.occupants {
+		j, err := jid.Parse(occupant)
+		real := item.JID
+		if err != nil || !j.Bare().Equal(room) || real.Equal(jid.JID{}) || real.Equal(self) {
+			continue
+		}
+		if !slices.ContainsFunc(addrs, real.Equal) {
+			addrs = append(addrs, real)
+		}
+	}
+	return addrs
+}
+
+// occupantJID returns the real address of the occupant that sent a message from
+// the given device.
+// If the occupant has already left, we fall back to checking who owns the
+// device.
+func (c *Client) occupantJID(ctx context.Context, occupant jid.JID, device uint32) (jid.JID, bool) {
+	c.occupantsM.Lock()
+	item, ok := c.occupants[occupant.String()]
+	c.occupantsM.Unlock()
+	if ok && !item.JID.Equal(jid.JID{}) {
+		return item.JID, true
+	}
+	owners, err := c.omemo.OMEMODeviceOwners(ctx, device)
+	if err != nil || len(owners) != 1 {
+		return jid.JID{}, false
+	}
+	return owners[0], true
+}
+
+// Role returns our role in the given channel.
+// If we have not joined the channel, RoleNone is returned.
+func (c *Client) Role(room jid.JID) muc.Role {
+	return c.self(room).Role
+}
+
+// self returns our own details in the given channel.
+func (c *Client) self(room jid.JID) muc.Item {
+	c.chanM.Lock()
+	mucChan, ok := c.channels[room.Bare().String()]
+	c.chanM.Unlock()
+	if !ok {
+		return muc.Item{}
+	}
+	c.occupantsM.Lock()
+	defer c.occupantsM.Unlock()
+	return c.occupants[mucChan.Me().String()]
+}
+
 // Upload HTTP-uploads a file specified by path to the service specified by jid
 // and returns the GET URL.
 func (c *Client) Upload(ctx context.Context, path string, jid jid.JID) (string, error) {
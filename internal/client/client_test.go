@@ -0,0 +1,23 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRetryCanceled(t *testing.T) {
+	var events []interface{}
+	c := &Client{handler: func(e interface{}) {
+		events = append(events, e)
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.retry(ctx)
+	if len(events) != 0 {
+		t.Errorf("expected no events after going offline, got=%v", events)
+	}
+}
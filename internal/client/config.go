@@ -0,0 +1,77 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/stanza"
+)
+
+// Created reports whether joining room created it and it is still waiting for
+// its initial configuration.
+// Until it is configured, nobody else can join the channel.
+func (c *Client) Created(room jid.JID) bool {
+	c.occupantsM.Lock()
+	defer c.occupantsM.Unlock()
+	_, ok := c.created[room.Bare().String()]
+	return ok
+}
+
+func (c *Client) setCreated(room jid.JID, created bool) {
+	c.occupantsM.Lock()
+	defer c.occupantsM.Unlock()
+	if created {
+		c.created[room.Bare().String()] = struct{}{}
+		return
+	}
+	delete(c.created, room.Bare().String())
+}
+
+// ChannelConfig requests the configuration form of a channel we own.
+func (c *Client) ChannelConfig(ctx context.Context, room jid.JID) (*form.Data, error) {
+	return muc.GetConfig(ctx, room.Bare(), c.Session)
+}
+
+// SetChannelConfig submits a configuration form previously returned by
+// ChannelConfig.
+// If we just created the channel, this also unlocks it.
+func (c *Client) SetChannelConfig(ctx context.Context, room jid.JID, config *form.Data) error {
+	err := muc.SetConfig(ctx, room.Bare(), config, c.Session)
+	if err != nil {
+		return err
+	}
+	c.setCreated(room, false)
+	return nil
+}
+
+// CancelChannelConfig tells the channel that we will not submit its
+// configuration form.
+// If we just created the channel the server destroys it, so we forget about it
+// as well.
+func (c *Client) CancelChannelConfig(ctx context.Context, room jid.JID) error {
+	created := c.Created(room)
+	err := c.UnmarshalIQ(ctx, stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   room.Bare(),
+	}.Wrap(xmlstream.Wrap(
+		form.Cancel("", "").TokenReader(),
+		xml.StartElement{Name: xml.Name{Space: muc.NSOwner, Local: "query"}},
+	)), nil)
+	if err != nil || !created {
+		return err
+	}
+	s := room.Bare().String()
+	c.chanM.Lock()
+	delete(c.channels, s)
+	c.chanM.Unlock()
+	c.forgetChannel(s)
+	return nil
+}
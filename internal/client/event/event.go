@@ -6,15 +6,34 @@
 package event // import "mellium.im/communique/internal/client/event"
 
 import (
+	"encoding/xml"
+	"time"
+
+	"mellium.im/communique/internal/chatstate"
+	"mellium.im/communique/internal/omemo"
+	"mellium.im/xmlstream"
 	"mellium.im/xmpp/bookmarks"
 	"mellium.im/xmpp/delay"
 	"mellium.im/xmpp/disco"
 	"mellium.im/xmpp/forward"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
 	"mellium.im/xmpp/roster"
 	"mellium.im/xmpp/stanza"
 )
 
+// A list of namespaces used by payloads that are part of messages.
+const (
+	// NSCorrect is the namespace used by message corrections.
+	NSCorrect = "urn:xmpp:message-correct:0"
+	// NSRetract is the namespace used by message retractions.
+	NSRetract = "urn:xmpp:message-retract:1"
+	// NSModerate is the namespace used by moderated message retractions.
+	NSModerate = "urn:xmpp:message-moderate:1"
+	// NSMarkers is the namespace used by chat markers.
+	NSMarkers = "urn:xmpp:chat-markers:0"
+)
+
 type (
 	// StatusOnline is sent when the user should come online.
 	StatusOnline jid.JID
@@ -28,6 +47,10 @@ type (
 	// StatusBusy is sent when the user should change their status to busy.
 	StatusBusy jid.JID
 
+	// Reconnecting is sent when the connection was lost unexpectedly and the
+	// client will try to connect again after the given delay.
+	Reconnecting time.Duration
+
 	// FetchRoster is sent when a roster is fetched.
 	FetchRoster struct {
 		Ver   string
@@ -60,12 +83,39 @@ type (
 		SID      []stanza.ID     `xml:"urn:xmpp:sid:0 stanza-id"`
 		Delay    delay.Delay     `xml:"urn:xmpp:delay delay"`
 
+		// Replace is set if the message is a correction of an earlier message.
+		Replace Replace `xml:"urn:xmpp:message-correct:0 replace"`
+
+		// Retract is set if the message retracts an earlier message.
+		Retract *Retract `xml:"urn:xmpp:message-retract:1 retract"`
+
+		// Markable is set if the sender would like to know when the message has
+		// been displayed.
+		Markable *Markable `xml:"urn:xmpp:chat-markers:0 markable"`
+		// Displayed is set if the message is a chat marker saying that the message
+		// with the given ID and all earlier messages have been displayed.
+		Displayed *Displayed `xml:"urn:xmpp:chat-markers:0 displayed"`
+
+		// Encrypted is set if the message was encrypted using OMEMO.
+		// If the message was decrypted successfully, the body is replaced by the
+		// decrypted text.
+		Encrypted *omemo.Encrypted `xml:"eu.siacs.conversations.axolotl encrypted"`
+
 		// Sent is true if this message is one that we sent from another device (for
 		// example, a message forwarded to us by message carbons).
 		Sent bool `xml:"-"`
 		// Account is true if this message was sent by the server (empty from, or
 		// from matching the bare JID of the authenticated account).
 		Account bool `xml:"-"`
+		// Edited is true if the body is from a later correction of this message.
+		Edited bool `xml:"-"`
+		// Retracted is true if the message was retracted by its sender or by a
+		// moderator and only a tombstone remains.
+		// Retract then holds the moderator and the reason, if any.
+		Retracted bool `xml:"-"`
+		// Delayed is true if the message carried a delay when it was received live
+		// (for example because it was stored while we were offline).
+		Delayed bool `xml:"-"`
 	}
 
 	// HistoryMessage is sent on incoming messages resulting from a history query.
@@ -81,12 +131,73 @@ type (
 		} `xml:"urn:xmpp:mam:2 result"`
 	}
 
+	// ChatState is sent when a contact tells us whether they are typing a
+	// message to us.
+	ChatState struct {
+		stanza.Message
+		State chatstate.State
+	}
+
+	// OccupantPresence is sent when someone joins or leaves a channel, or when
+	// their nickname, role, or affiliation changes.
+	OccupantPresence struct {
+		// Occupant is the address of the occupant in the channel.
+		Occupant jid.JID
+		Item     muc.Item
+		// Joined and Left are only set for changes after we joined the channel,
+		// not for the occupants that were already in it.
+		Joined bool
+		Left   bool
+		// NewNick is set if the occupant left because they changed their
+		// nickname.
+		NewNick string
+	}
+
+	// ChannelJoined is sent when we have joined a channel, including when it is
+	// joined again after reconnecting.
+	ChannelJoined jid.JID
+
+	// Subject is sent when we join a channel and whenever its subject changes.
+	Subject struct {
+		stanza.Message
+		Subject string `xml:"subject"`
+	}
+
+	// Invitation is sent when someone invites us to join a channel, either
+	// directly (XEP-0249) or through the channel itself.
+	// Mediated invitations can be declined, direct ones cannot.
+	Invitation struct {
+		Room     jid.JID
+		From     jid.JID
+		Password string
+		Reason   string
+		Mediated bool
+	}
+
 	// Receipt is sent when a message receipt is received and represents the ID of
 	// the message that should be marked as received.
 	// It may be sent by itself, or in addition to a ChatMessage event (or others)
 	// if the payload containing the receipt also contains other events.
 	Receipt string
 
+	// OutboxSent is sent when a message from the outbox was sent to the server.
+	// If the message could not be sent at all (for example because it could
+	// not be encrypted), Err is set.
+	// Either way the message is no longer in the outbox.
+	OutboxSent struct {
+		ChatMessage
+		Err error
+	}
+
+	// MessageError is sent when a message that we sent bounced with a stanza
+	// error.
+	// ID is the ID of the message that could not be delivered.
+	MessageError struct {
+		ID   string
+		From jid.JID
+		Err  stanza.Error
+	}
+
 	// NewCaps is sent when new capabilities have been discovered.
 	NewCaps struct {
 		From jid.JID
@@ -102,3 +213,68 @@ type (
 		}
 	}
 )
+
+// Replace is the payload of a message correction.
+// ID is the ID of the message being corrected.
+type Replace struct {
+	ID string `xml:"id,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+// If no ID is set, no payload is returned.
+func (r Replace) TokenReader() xml.TokenReader {
+	if r.ID == "" {
+		return xmlstream.MultiReader()
+	}
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSCorrect, Local: "replace"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: r.ID}},
+	})
+}
+
+// Retract is the payload of a message retraction.
+// In one-to-one chats ID is the origin ID of the message being retracted, in
+// channels it is the stanza ID assigned by the channel.
+type Retract struct {
+	ID        string     `xml:"id,attr"`
+	Moderated *Moderated `xml:"urn:xmpp:message-moderate:1 moderated"`
+	Reason    string     `xml:"reason"`
+}
+
+// Moderated is set on a retraction if a channel moderator removed the message.
+// By is the address of the moderator.
+type Moderated struct {
+	By jid.JID `xml:"by,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (r Retract) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSRetract, Local: "retract"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: r.ID}},
+	})
+}
+
+// Markable asks the recipient of a message to send chat markers for it.
+type Markable struct{}
+
+// TokenReader implements xmlstream.Marshaler.
+func (Markable) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSMarkers, Local: "markable"},
+	})
+}
+
+// Displayed is a chat marker saying that a message was displayed.
+// ID is the ID of the message.
+type Displayed struct {
+	ID string `xml:"id,attr"`
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (d Displayed) TokenReader() xml.TokenReader {
+	return xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: NSMarkers, Local: "displayed"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: d.ID}},
+	})
+}
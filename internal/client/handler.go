@@ -5,14 +5,18 @@
 package client
 
 import (
+	"context"
 	"encoding/xml"
 	"io"
 
+	"mellium.im/communique/internal/chatstate"
 	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/omemo"
 	"mellium.im/xmlstream"
 	"mellium.im/xmpp"
 	"mellium.im/xmpp/carbons"
 	"mellium.im/xmpp/disco"
+	"mellium.im/xmpp/disco/info"
 	"mellium.im/xmpp/history"
 	"mellium.im/xmpp/jid"
 	"mellium.im/xmpp/muc"
@@ -24,17 +28,34 @@ import (
 
 func newXMPPHandler(c *Client) xmpp.Handler {
 	msgHandler := newMessageHandler(c)
-	return mux.New(
-		c.In().XMLNS,
+	encHandler := newEncryptedHandler(c)
+	mucHandler := newMUCPresenceHandler(c)
+	userPresence := xml.Name{Space: muc.NSUser, Local: "x"}
+	encrypted := xml.Name{Space: omemo.NS, Local: "encrypted"}
+	retractHandler := newRetractHandler(c)
+	retract := xml.Name{Space: event.NSRetract, Local: "retract"}
+	displayed := xml.Name{Space: event.NSMarkers, Local: "displayed"}
+	opts := []mux.Option{
 		disco.Handle(),
+		mux.Feature(features{
+			{Var: event.NSCorrect},
+			{Var: event.NSRetract},
+			{Var: chatstate.NS},
+			{Var: event.NSMarkers},
+			{Var: muc.NSConf},
+		}),
 		disco.HandleCaps(func(p stanza.Presence, caps disco.Caps) {
 			c.handler(event.NewCaps{
 				From: p.From,
 				Caps: caps,
 			})
 		}),
-		muc.HandleClient(c.mucClient),
-		// TODO: direct muc invitations.
+		// This is the same as muc.HandleClient except that we also keep track of
+		// the real addresses of occupants.
+		mux.Presence(stanza.AvailablePresence, userPresence, mucHandler),
+		mux.Presence(stanza.UnavailablePresence, userPresence, mucHandler),
+		mux.Message(stanza.NormalMessage, userPresence, newMediatedInviteHandler(c)),
+		mux.Message(stanza.NormalMessage, xml.Name{Space: muc.NSConf, Local: "x"}, newDirectInviteHandler(c)),
 		roster.Handle(roster.Handler{
 			Push: func(ver string, item roster.Item) error {
 				c.rosterVer = ver
@@ -50,7 +71,10 @@ func newXMPPHandler(c *Client) xmpp.Handler {
 				if err != nil {
 					return err
 				}
-				c.handler(e)
+				e.Delayed = !e.Delay.Time.IsZero()
+				if c.decryptMessage(&e) {
+					c.handler(e)
+				}
 				return nil
 			},
 		}),
@@ -58,9 +82,43 @@ func newXMPPHandler(c *Client) xmpp.Handler {
 		mux.Message(stanza.NormalMessage, xml.Name{Local: "body"}, msgHandler),
 		mux.Message(stanza.ChatMessage, xml.Name{Local: "body"}, msgHandler),
 		mux.Message(stanza.GroupChatMessage, xml.Name{Local: "body"}, msgHandler),
+		mux.Message(stanza.NormalMessage, encrypted, encHandler),
+		mux.Message(stanza.ChatMessage, encrypted, encHandler),
+		mux.Message(stanza.GroupChatMessage, encrypted, encHandler),
+		mux.Message(stanza.NormalMessage, retract, retractHandler),
+		mux.Message(stanza.ChatMessage, retract, retractHandler),
+		mux.Message(stanza.GroupChatMessage, retract, retractHandler),
+		mux.Message(stanza.ChatMessage, displayed, newMarkerHandler(c)),
+		mux.Message(stanza.GroupChatMessage, xml.Name{Local: "subject"}, newSubjectHandler(c)),
+		mux.Message(stanza.ErrorMessage, xml.Name{Local: "error"}, newMessageErrorHandler(c)),
 		receipts.Handle(c.receiptsHandler),
 		history.Handle(history.NewHandler(newHistoryHandler(c))),
-	)
+		mux.HandleFunc(xml.Name{Space: nsSM, Local: "r"}, c.sm.Handle),
+		mux.HandleFunc(xml.Name{Space: nsSM, Local: "a"}, c.sm.Handle),
+	}
+	stateHandler := newChatStateHandler(c)
+	for _, name := range chatstate.Names() {
+		opts = append(opts, mux.Message(stanza.ChatMessage, name, stateHandler))
+	}
+	return mux.New(c.In().XMLNS, opts...)
+}
+
+// features is a list of features that we support but that are not advertised
+// by any of the handlers.
+type features []info.Feature
+
+// ForFeatures implements info.FeatureIter.
+func (f features) ForFeatures(node string, fn func(info.Feature) error) error {
+	if node != "" {
+		return nil
+	}
+	for _, feature := range f {
+		err := fn(feature)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func newPresenceHandler(c *Client) mux.PresenceHandlerFunc {
@@ -127,15 +185,318 @@ func newMessageHandler(c *Client) mux.MessageHandlerFunc {
 		if err != nil {
 			return err
 		}
+		// Encrypted messages are handled by the encrypted message handler and
+		// retractions by the retraction handler, in which case the body is only a
+		// fallback.
+		if (msg.Encrypted != nil && c.omemo != nil) || msg.Retract != nil {
+			return nil
+		}
+		fromBare := msg.From.Bare()
+		if fromBare.Equal(jid.JID{}) || fromBare.Equal(c.LocalAddr().Bare()) {
+			msg.Account = true
+		}
+		msg.Delayed = !msg.Delay.Time.IsZero()
+		c.handler(msg)
+		return nil
+	}
+}
+
+func newRetractHandler(c *Client) mux.MessageHandlerFunc {
+	return func(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+		msg := event.ChatMessage{}
+		d := xml.NewTokenDecoder(r)
+		err := d.Decode(&msg)
+		if err != nil {
+			return err
+		}
+		if msg.Retract == nil || !validRetraction(msg) {
+			return nil
+		}
+		msg.Body = ""
+		c.handler(msg)
+		return nil
+	}
+}
+
+// validRetraction reports whether we can trust a retraction.
+// In channels moderators act through the channel itself while occupants
+// retract their own messages, which is checked against the nickname that sent
+// the message when the retraction is stored.
+func validRetraction(msg event.ChatMessage) bool {
+	if msg.Retract.ID == "" {
+		return false
+	}
+	if msg.Type == stanza.GroupChatMessage {
+		moderated := msg.Retract.Moderated != nil
+		return moderated == msg.From.Equal(msg.From.Bare())
+	}
+	return true
+}
+
+func newMarkerHandler(c *Client) mux.MessageHandlerFunc {
+	return func(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+		msg := event.ChatMessage{}
+		d := xml.NewTokenDecoder(r)
+		err := d.Decode(&msg)
+		if err != nil {
+			return err
+		}
+		// Markers that come with a body are rare, but if they do the body handler
+		// takes care of the message.
+		if msg.Displayed == nil || msg.Displayed.ID == "" || msg.Body != "" {
+			return nil
+		}
+		c.handler(msg)
+		return nil
+	}
+}
+
+func newChatStateHandler(c *Client) mux.MessageHandlerFunc {
+	return func(m stanza.Message, r xmlstream.TokenReadEncoder) error {
+		var msg struct {
+			stanza.Message
+			Payloads []struct {
+				XMLName xml.Name
+			} `xml:",any"`
+		}
+		d := xml.NewTokenDecoder(r)
+		err := d.Decode(&msg)
+		if err != nil {
+			return err
+		}
+		for _, payload := range msg.Payloads {
+			state, ok := chatstate.Parse(payload.XMLName)
+			if !ok {
+				continue
+			}
+			c.setChatStates(m.From)
+			c.handler(event.ChatState{Message: m, State: state})
+			return nil
+		}
+		return nil
+	}
+}
+
+func newMessageErrorHandler(c *Client) mux.MessageHandlerFunc {
+	return func(msg stanza.Message, r xmlstream.TokenReadEncoder) error {
+		if msg.ID == "" {
+			return nil
+		}
+		// Skip over the message start token and the elements that come before the
+		// error (often a copy of the original message).
+		d := xml.NewTokenDecoder(r)
+		_, err := d.Token()
+		if err != nil {
+			return err
+		}
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return err
+			}
+			start, ok := tok.(xml.StartElement)
+			if !ok {
+				continue
+			}
+			if start.Name.Local != "error" {
+				if err = d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			e := event.MessageError{ID: msg.ID, From: msg.From}
+			err = d.DecodeElement(&e.Err, &start)
+			if err != nil {
+				return err
+			}
+			c.handler(e)
+			return nil
+		}
+	}
+}
+
+func newEncryptedHandler(c *Client) mux.MessageHandlerFunc {
+	return func(_ stanza.Message, r xmlstream.TokenReadEncoder) error {
+		// Without OMEMO support the fallback body is shown by the normal message
+		// handler.
+		if c.omemo == nil {
+			return nil
+		}
+		msg := event.ChatMessage{}
+		d := xml.NewTokenDecoder(r)
+		err := d.Decode(&msg)
+		if err != nil {
+			return err
+		}
+		if !c.decryptMessage(&msg) {
+			return nil
+		}
 		fromBare := msg.From.Bare()
 		if fromBare.Equal(jid.JID{}) || fromBare.Equal(c.LocalAddr().Bare()) {
 			msg.Account = true
 		}
+		msg.Delayed = !msg.Delay.Time.IsZero()
 		c.handler(msg)
 		return nil
 	}
 }
 
+// decryptMessage decrypts msg if it was encrypted using OMEMO and reports
+// whether it should be shown.
+// If decryption fails the fallback body is left in place.
+func (c *Client) decryptMessage(msg *event.ChatMessage) bool {
+	if msg.Encrypted == nil || c.omemo == nil {
+		return true
+	}
+	p := c.Printer()
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	err := c.decrypt(ctx, msg)
+	switch err {
+	case nil:
+	case errKeyTransport:
+		return false
+	default:
+		c.logger.Print(p.Sprintf("error decrypting message from %s: %v", msg.From, err))
+	}
+	return msg.Body != ""
+}
+
+// Status codes used in channel presences.
+const (
+	statusSelf       = 110
+	statusCreated    = 201
+	statusNickChange = 303
+)
+
+func newSubjectHandler(c *Client) mux.MessageHandlerFunc {
+	return func(m stanza.Message, r xmlstream.TokenReadEncoder) error {
+		msg := event.Subject{}
+		err := xml.NewTokenDecoder(r).Decode(&msg)
+		if err != nil {
+			return err
+		}
+		c.handler(msg)
+		return nil
+	}
+}
+
+// newMediatedInviteHandler handles invitations that were sent through a
+// channel.
+// The muc package decodes these as well, but it drops the address of the
+// channel and of the person that invited us.
+func newMediatedInviteHandler(c *Client) mux.MessageHandlerFunc {
+	return func(m stanza.Message, r xmlstream.TokenReadEncoder) error {
+		var msg struct {
+			stanza.Message
+			X struct {
+				Invite *struct {
+					From   jid.JID `xml:"from,attr"`
+					Reason string  `xml:"reason"`
+				} `xml:"invite"`
+				Password string `xml:"password"`
+			} `xml:"http://jabber.org/protocol/muc#user x"`
+		}
+		err := xml.NewTokenDecoder(r).Decode(&msg)
+		if err != nil {
+			return err
+		}
+		if msg.X.Invite == nil {
+			return nil
+		}
+		c.handler(event.Invitation{
+			Room:     m.From.Bare(),
+			From:     msg.X.Invite.From,
+			Password: msg.X.Password,
+			Reason:   msg.X.Invite.Reason,
+			Mediated: true,
+		})
+		return nil
+	}
+}
+
+func newDirectInviteHandler(c *Client) mux.MessageHandlerFunc {
+	return func(m stanza.Message, r xmlstream.TokenReadEncoder) error {
+		var msg struct {
+			stanza.Message
+			X muc.Invitation `xml:"jabber:x:conference x"`
+		}
+		err := xml.NewTokenDecoder(r).Decode(&msg)
+		if err != nil {
+			return err
+		}
+		if msg.X.JID.Equal(jid.JID{}) {
+			return nil
+		}
+		c.handler(event.Invitation{
+			Room:     msg.X.JID.Bare(),
+			From:     m.From,
+			Password: msg.X.Password,
+			Reason:   msg.X.Reason,
+		})
+		return nil
+	}
+}
+
+func newMUCPresenceHandler(c *Client) mux.PresenceHandlerFunc {
+	return func(p stanza.Presence, t xmlstream.TokenReadEncoder) error {
+		toks, err := xmlstream.ReadAll(t)
+		if err != nil {
+			return err
+		}
+		replay := func() xml.TokenReader {
+			var i int
+			return xmlstream.ReaderFunc(func() (xml.Token, error) {
+				if i >= len(toks) {
+					return nil, io.EOF
+				}
+				i++
+				return toks[i-1], nil
+			})
+		}
+		var pres struct {
+			stanza.Presence
+			X struct {
+				Item   muc.Item `xml:"item"`
+				Status []struct {
+					Code int `xml:"code,attr"`
+				} `xml:"status"`
+			} `xml:"http://jabber.org/protocol/muc#user x"`
+		}
+		err = xml.NewTokenDecoder(replay()).Decode(&pres)
+		if err != nil {
+			return err
+		}
+		var self, created bool
+		var newNick string
+		for _, status := range pres.X.Status {
+			switch status.Code {
+			case statusSelf:
+				self = true
+			case statusCreated:
+				created = true
+			case statusNickChange:
+				newNick = pres.X.Item.Nick
+			}
+		}
+		if self && created {
+			c.setCreated(p.From.Bare(), true)
+		}
+		e, entered := c.setOccupant(p.From, pres.X.Item, p.Type == stanza.UnavailablePresence, self, newNick)
+		c.handler(e)
+		if entered {
+			c.handler(event.ChannelJoined(p.From.Bare()))
+		}
+		return c.mucClient.HandlePresence(p, struct {
+			xml.TokenReader
+			xmlstream.Encoder
+		}{
+			TokenReader: replay(),
+			Encoder:     t,
+		})
+	}
+}
+
 func newHistoryHandler(c *Client) mux.MessageHandlerFunc {
 	p := c.Printer()
 	return func(m stanza.Message, r xmlstream.TokenReadEncoder) error {
@@ -146,17 +507,51 @@ func newHistoryHandler(c *Client) mux.MessageHandlerFunc {
 		if err != nil {
 			return err
 		}
-		if !msg.From.Equal(jid.JID{}) && !msg.From.Equal(c.LocalAddr().Bare()) {
+		// Results come from our own archive or from the archive of a channel that
+		// we are in.
+		archive := msg.From
+		if archive.Equal(jid.JID{}) {
+			archive = c.LocalAddr().Bare()
+		}
+		if !archive.Equal(c.LocalAddr().Bare()) && (!archive.Equal(archive.Bare()) || !c.inChannel(archive)) {
 			c.debug.Print(p.Sprintf("possibly spoofed history message from %s", msg.From))
 			return nil
 		}
+		// The result ID is the stanza ID that the archive assigned to the message,
+		// but the message itself doesn't always include it.
+		if msg.Result.ID != "" && !hasStanzaID(msg.Result.Forward.Msg.SID, archive) {
+			msg.Result.Forward.Msg.SID = append(msg.Result.Forward.Msg.SID, stanza.ID{
+				ID: msg.Result.ID,
+				By: archive,
+			})
+		}
 		fromBare := msg.Result.Forward.Msg.From.Bare()
 		if fromBare.Equal(jid.JID{}) || fromBare.Equal(c.LocalAddr().Bare()) {
 			msg.Result.Forward.Msg.Account = true
 		}
 		msg.Result.Forward.Msg.Sent = fromBare.Equal(c.LocalAddr().Bare())
 		msg.Result.Forward.Msg.Delay = msg.Result.Forward.Delay
+		if msg.Result.Forward.Msg.Retract != nil && !validRetraction(msg.Result.Forward.Msg) {
+			return nil
+		}
+		if !c.decryptMessage(&msg.Result.Forward.Msg) {
+			return nil
+		}
+		if c.addSearchResult(msg) {
+			return nil
+		}
 		c.handler(msg)
 		return nil
 	}
 }
+
+// hasStanzaID reports whether ids contains a stanza ID assigned by the given
+// entity.
+func hasStanzaID(ids []stanza.ID, by jid.JID) bool {
+	for _, id := range ids {
+		if id.By.Equal(by) {
+			return true
+		}
+	}
+	return false
+}
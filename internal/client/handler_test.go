@@ -0,0 +1,51 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"strconv"
+	"testing"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+var validRetractionTestCases = [...]struct {
+	typ       stanza.MessageType
+	from      string
+	id        string
+	moderated bool
+	valid     bool
+}{
+	0: {typ: stanza.ChatMessage, from: "juliet@example.com/balcony", id: "1", valid: true},
+	1: {typ: stanza.ChatMessage, from: "juliet@example.com/balcony"},
+	// Occupants retract their own messages.
+	2: {typ: stanza.GroupChatMessage, from: "capulets@muc.example.com/tybalt", id: "1", valid: true},
+	// Moderators act through the channel.
+	3: {typ: stanza.GroupChatMessage, from: "capulets@muc.example.com", id: "1", moderated: true, valid: true},
+	// Occupants can't claim to be a moderator and the channel never retracts
+	// messages on its own.
+	4: {typ: stanza.GroupChatMessage, from: "capulets@muc.example.com/tybalt", id: "1", moderated: true},
+	5: {typ: stanza.GroupChatMessage, from: "capulets@muc.example.com", id: "1"},
+	6: {typ: stanza.GroupChatMessage, from: "capulets@muc.example.com/tybalt"},
+}
+
+func TestValidRetraction(t *testing.T) {
+	for i, tc := range validRetractionTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			msg := event.ChatMessage{
+				Message: stanza.Message{From: jid.MustParse(tc.from), Type: tc.typ},
+				Retract: &event.Retract{ID: tc.id},
+			}
+			if tc.moderated {
+				msg.Retract.Moderated = &event.Moderated{By: jid.MustParse("capulets@muc.example.com/escalus")}
+			}
+			if valid := validRetraction(msg); valid != tc.valid {
+				t.Errorf("wrong result: want=%t, got=%t", tc.valid, valid)
+			}
+		})
+	}
+}
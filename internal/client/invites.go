@@ -0,0 +1,47 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/stanza"
+)
+
+// Invite sends a direct invitation (XEP-0249) to join room to j.
+func (c *Client) Invite(ctx context.Context, room, j jid.JID, reason string) error {
+	return c.Session.Send(ctx, stanza.Message{
+		XMLName: xml.Name{Space: stanza.NSClient, Local: "message"},
+		To:      j.Bare(),
+		Type:    stanza.NormalMessage,
+	}.Wrap(muc.Invitation{
+		XMLName: xml.Name{Space: muc.NSConf, Local: "x"},
+		JID:     room.Bare(),
+		Reason:  reason,
+	}.MarshalDirect()))
+}
+
+// DeclineInvite tells the person that invited us to room through the channel
+// that we will not be joining it.
+func (c *Client) DeclineInvite(ctx context.Context, room, from jid.JID, reason string) error {
+	return c.Session.Send(ctx, stanza.Message{
+		XMLName: xml.Name{Space: stanza.NSClient, Local: "message"},
+		To:      room.Bare(),
+		Type:    stanza.NormalMessage,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.Wrap(
+			omitEmpty(reason, xml.Name{Local: "reason"}),
+			xml.StartElement{
+				Name: xml.Name{Local: "decline"},
+				Attr: []xml.Attr{{Name: xml.Name{Local: "to"}, Value: from.String()}},
+			},
+		),
+		xml.StartElement{Name: xml.Name{Space: muc.NSUser, Local: "x"}},
+	)))
+}
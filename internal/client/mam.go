@@ -0,0 +1,238 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/localerr"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/form"
+	"mellium.im/xmpp/history"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/paging"
+	"mellium.im/xmpp/stanza"
+)
+
+// fullTextFields are the names of the archive query form fields that servers
+// use for full text search, in order of preference.
+// The first is defined by XEP-0431: Full Text Search in MAM, the second is the
+// older name used by ejabberd and Prosody before that.
+var fullTextFields = []string{
+	"{urn:xmpp:fulltext:0}fulltext",
+	"withtext",
+}
+
+// searchQueryPrefix is added to the query ID of archive searches so that the
+// results can be told apart from other history.
+const searchQueryPrefix = "search-"
+
+var archiveQueryID atomic.Uint64
+
+// ArchiveSearch is a full text search of the message archive belonging to our
+// account.
+type ArchiveSearch struct {
+	Text  string
+	With  jid.JID
+	Start time.Time
+	End   time.Time
+	Limit uint64
+}
+
+// archiveSearchField discovers which form field, if any, the archive supports
+// for full text search.
+func (c *Client) archiveSearchField(ctx context.Context, archive jid.JID) (string, error) {
+	var resp struct {
+		XMLName xml.Name  `xml:"urn:xmpp:mam:2 query"`
+		Form    form.Data `xml:"jabber:x:data x"`
+	}
+	err := c.UnmarshalIQ(ctx, stanza.IQ{
+		Type: stanza.GetIQ,
+		To:   archive,
+	}.Wrap(xmlstream.Wrap(nil, xml.StartElement{
+		Name: xml.Name{Space: history.NS, Local: "query"},
+	})), &resp)
+	if err != nil {
+		return "", err
+	}
+	found := make(map[string]struct{})
+	resp.Form.ForFields(func(f form.FieldData) {
+		found[f.Var] = struct{}{}
+	})
+	for _, field := range fullTextFields {
+		if _, ok := found[field]; ok {
+			return field, nil
+		}
+	}
+	return "", nil
+}
+
+// archiveSearch collects the messages found by an archive search.
+type archiveSearch struct {
+	msgs []event.ChatMessage
+	seen map[string]struct{}
+}
+
+// addSearchResult adds a message to the results of the archive search that it
+// belongs to and reports whether it was the result of a search at all.
+// Search results are never passed to the handler since they are not part of
+// the conversation history that is being shown.
+func (c *Client) addSearchResult(msg event.HistoryMessage) bool {
+	if !strings.HasPrefix(msg.Result.QueryID, searchQueryPrefix) {
+		return false
+	}
+	c.searchesM.Lock()
+	defer c.searchesM.Unlock()
+	search, ok := c.searches[msg.Result.QueryID]
+	if !ok {
+		// The search already timed out.
+		return true
+	}
+	if id := msg.Result.ID; id != "" {
+		if _, dup := search.seen[id]; dup {
+			return true
+		}
+		search.seen[id] = struct{}{}
+	}
+	search.msgs = append(search.msgs, msg.Result.Forward.Msg)
+	return true
+}
+
+// SearchArchive queries our archive for messages containing the search text
+// and returns them in the order that the archive sent them.
+// Messages that the archive returns more than once are only included once.
+// The results are not emitted as events, it is up to the caller to store them.
+// If the archive does not support full text search an error is returned.
+func (c *Client) SearchArchive(ctx context.Context, q ArchiveSearch) ([]event.ChatMessage, error) {
+	p := c.Printer()
+	archive := c.LocalAddr().Bare()
+	field, err := c.archiveSearchField(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	if field == "" {
+		return nil, localerr.Wrap(p, "the archive at %s does not support full text search", archive)
+	}
+
+	filter := form.New(
+		form.Hidden("FORM_TYPE", form.Value(history.NS)),
+		form.JID("with"),
+		form.Text("start"),
+		form.Text("end"),
+		form.Text(field),
+	)
+	/* #nosec */
+	filter.Set(field, q.Text)
+	if !q.With.Equal(jid.JID{}) {
+		/* #nosec */
+		filter.Set("with", q.With)
+	}
+	if !q.Start.IsZero() {
+		/* #nosec */
+		filter.Set("start", q.Start.UTC().Format(time.RFC3339))
+	}
+	if !q.End.IsZero() {
+		/* #nosec */
+		filter.Set("end", q.End.UTC().Format(time.RFC3339))
+	}
+	submission, _ := filter.Submit()
+
+	queryID := searchQueryPrefix + strconv.FormatUint(archiveQueryID.Add(1), 10)
+	search := &archiveSearch{seen: make(map[string]struct{})}
+	c.searchesM.Lock()
+	c.searches[queryID] = search
+	c.searchesM.Unlock()
+	defer func() {
+		c.searchesM.Lock()
+		delete(c.searches, queryID)
+		c.searchesM.Unlock()
+	}()
+
+	// The archive sends all of the results before it responds to the query.
+	var result history.Result
+	err = c.UnmarshalIQ(ctx, stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   archive,
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.MultiReader(
+			submission,
+			(&paging.RequestPrev{Max: q.Limit}).TokenReader(),
+		),
+		xml.StartElement{
+			Name: xml.Name{Space: history.NS, Local: "query"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "queryid"}, Value: queryID}},
+		},
+	)), &result)
+	if err != nil {
+		return nil, err
+	}
+	c.searchesM.Lock()
+	defer c.searchesM.Unlock()
+	return search.msgs, nil
+}
+
+// defaultMaxHistory is the number of messages that channels without an archive
+// send us when we join them.
+const defaultMaxHistory = 100
+
+// Archived reports whether the channel keeps a message archive that we can
+// query.
+// The answer is remembered for each channel so that only the first call has to
+// wait for the network, which makes it safe to call before rejoining every
+// channel after a reconnect.
+func (c *Client) Archived(room jid.JID) bool {
+	s := room.Bare().String()
+	c.archivedM.Lock()
+	archived, ok := c.archived[s]
+	c.archivedM.Unlock()
+	if ok {
+		return archived
+	}
+
+	info, err := c.Disco(room.Bare())
+	if err != nil {
+		// Don't remember failures, the channel may just be unreachable right now.
+		c.debug.Print(c.p.Sprintf("error discovering archive support for %s: %v", room.Bare(), err))
+		return false
+	}
+	for _, feature := range info.Features {
+		if feature.Var == history.NS {
+			archived = true
+			break
+		}
+	}
+	c.archivedM.Lock()
+	c.archived[s] = archived
+	c.archivedM.Unlock()
+	return archived
+}
+
+// maxHistory returns the option that limits how many old messages the channel
+// sends us when we join it.
+// If the channel has an archive we catch up from there instead (see
+// event.ChannelJoined), so it doesn't need to send any.
+// Because this may have to ask the channel it must not be called with chanM
+// held.
+func (c *Client) maxHistory(room jid.JID) muc.Option {
+	if c.Archived(room) {
+		return muc.MaxHistory(0)
+	}
+	return muc.MaxHistory(defaultMaxHistory)
+}
+
+// inChannel reports whether we are currently an occupant of the channel.
+func (c *Client) inChannel(room jid.JID) bool {
+	c.occupantsM.Lock()
+	defer c.occupantsM.Unlock()
+	_, ok := c.joined[room.Bare().String()]
+	return ok
+}
@@ -0,0 +1,53 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"slices"
+	"testing"
+
+	"mellium.im/communique/internal/client/event"
+)
+
+func historyMsg(queryID, id, body string) event.HistoryMessage {
+	var msg event.HistoryMessage
+	msg.Result.QueryID = queryID
+	msg.Result.ID = id
+	msg.Result.Forward.Msg.Body = body
+	return msg
+}
+
+func TestAddSearchResult(t *testing.T) {
+	c := &Client{searches: make(map[string]*archiveSearch)}
+	search := &archiveSearch{seen: make(map[string]struct{})}
+	c.searches[searchQueryPrefix+"1"] = search
+
+	if c.addSearchResult(historyMsg("history-1", "a", "not a search")) {
+		t.Errorf("history that isn't from a search was treated as a search result")
+	}
+	for _, msg := range []event.HistoryMessage{
+		historyMsg(searchQueryPrefix+"1", "a", "one"),
+		historyMsg(searchQueryPrefix+"1", "b", "two"),
+		// Duplicate stanza ID.
+		historyMsg(searchQueryPrefix+"1", "a", "one again"),
+		// Results without an ID can't be deduplicated.
+		historyMsg(searchQueryPrefix+"1", "", "three"),
+		historyMsg(searchQueryPrefix+"1", "", "four"),
+		// A search that already finished.
+		historyMsg(searchQueryPrefix+"2", "c", "late"),
+	} {
+		if !c.addSearchResult(msg) {
+			t.Errorf("search result %q was passed on", msg.Result.Forward.Msg.Body)
+		}
+	}
+
+	var got []string
+	for _, msg := range search.msgs {
+		got = append(got, msg.Body)
+	}
+	if want := []string{"one", "two", "three", "four"}; !slices.Equal(want, got) {
+		t.Errorf("wrong search results: want=%v, got=%v", want, got)
+	}
+}
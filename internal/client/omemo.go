@@ -0,0 +1,471 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"slices"
+	"time"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/localerr"
+	"mellium.im/communique/internal/omemo"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/pubsub"
+	"mellium.im/xmpp/stanza"
+)
+
+const (
+	// preKeyCount is the number of one time prekeys we try to keep published.
+	preKeyCount = 100
+	// deviceListTTL is how long a fetched device list is used before we fetch it
+	// again when sending a message.
+	deviceListTTL = 10 * time.Minute
+	nsHints       = "urn:xmpp:hints"
+)
+
+// OMEMOStore persists the keys, sessions, and trust decisions used for OMEMO
+// encryption.
+type OMEMOStore interface {
+	OMEMOIdentity(context.Context) (omemo.Identity, omemo.SignedPreKey, bool, error)
+	SetOMEMOIdentity(context.Context, omemo.Identity, omemo.SignedPreKey) error
+	OMEMOPreKeys(context.Context) ([]omemo.PreKey, error)
+	OMEMOPreKey(context.Context, uint32) (*omemo.PreKey, error)
+	AddOMEMOPreKeys(context.Context, []omemo.PreKey) error
+	RemoveOMEMOPreKey(context.Context, uint32) error
+	OMEMODevices(context.Context, jid.JID) ([]omemo.Device, error)
+	SetOMEMODevices(context.Context, jid.JID, omemo.DeviceList) error
+	SetOMEMODeviceIdentity(context.Context, jid.JID, uint32, omemo.PublicKey) error
+	OMEMOSession(context.Context, jid.JID, uint32) (*omemo.Session, error)
+	SetOMEMOSession(context.Context, jid.JID, uint32, *omemo.Session) error
+	OMEMODeviceOwners(context.Context, uint32) ([]jid.JID, error)
+	OMEMOEnabled(context.Context, jid.JID) (bool, error)
+}
+
+// omemoKeys loads our identity and prekeys, creating them if this is the first
+// time OMEMO has been used with this account.
+func (c *Client) omemoKeys(ctx context.Context) (omemo.Identity, omemo.SignedPreKey, []omemo.PreKey, error) {
+	id, spk, ok, err := c.omemo.OMEMOIdentity(ctx)
+	if err != nil {
+		return id, spk, nil, err
+	}
+	if !ok {
+		id, err = omemo.NewIdentity()
+		if err != nil {
+			return id, spk, nil, err
+		}
+		spk, err = omemo.NewSignedPreKey(id, 1)
+		if err != nil {
+			return id, spk, nil, err
+		}
+		err = c.omemo.SetOMEMOIdentity(ctx, id, spk)
+		if err != nil {
+			return id, spk, nil, err
+		}
+	}
+	preKeys, err := c.omemo.OMEMOPreKeys(ctx)
+	if err != nil {
+		return id, spk, nil, err
+	}
+	if len(preKeys) < preKeyCount {
+		var next uint32 = 1
+		if len(preKeys) > 0 {
+			next = preKeys[len(preKeys)-1].ID + 1
+		}
+		newKeys, err := omemo.NewPreKeys(next, preKeyCount-len(preKeys))
+		if err != nil {
+			return id, spk, nil, err
+		}
+		err = c.omemo.AddOMEMOPreKeys(ctx, newKeys)
+		if err != nil {
+			return id, spk, nil, err
+		}
+		preKeys = append(preKeys, newKeys...)
+	}
+	return id, spk, preKeys, nil
+}
+
+// publishOMEMO publishes our bundle and makes sure that our device is in the
+// device list.
+func (c *Client) publishOMEMO(ctx context.Context) error {
+	p := c.Printer()
+	c.omemoM.Lock()
+	defer c.omemoM.Unlock()
+
+	id, spk, preKeys, err := c.omemoKeys(ctx)
+	if err != nil {
+		return localerr.Wrap(p, "error loading OMEMO keys: %v", err)
+	}
+	node := omemo.BundleNode(id.DeviceID)
+	_, err = pubsub.Publish(ctx, c.Session, node, "current", omemo.NewBundle(id, spk, preKeys).TokenReader())
+	if err != nil {
+		return localerr.Wrap(p, "error publishing OMEMO bundle: %v", err)
+	}
+	c.openNode(ctx, node)
+
+	own := c.LocalAddr().Bare()
+	list, err := c.fetchDeviceList(ctx, own)
+	if err != nil {
+		return localerr.Wrap(p, "error fetching own OMEMO device list: %v", err)
+	}
+	if !slices.Contains(list, id.DeviceID) {
+		list = append(list, id.DeviceID)
+		_, err = pubsub.Publish(ctx, c.Session, omemo.NodeDevices, "current", list.TokenReader())
+		if err != nil {
+			return localerr.Wrap(p, "error publishing OMEMO device list: %v", err)
+		}
+		c.openNode(ctx, omemo.NodeDevices)
+	}
+	return c.omemo.SetOMEMODevices(ctx, own, list)
+}
+
+// openNode tries to make a PEP node readable by anyone so that people we share
+// a channel with can start sessions with us even if they are not in our roster.
+// If the server does not let us configure the node it is left with the
+// default access model.
+func (c *Client) openNode(ctx context.Context, node string) {
+	p := c.Printer()
+	cfg, err := pubsub.GetConfig(ctx, c.Session, node)
+	if err != nil {
+		c.debug.Print(p.Sprintf("error fetching configuration of %s: %v", node, err))
+		return
+	}
+	if model, _ := cfg.GetString("pubsub#access_model"); model == "open" {
+		return
+	}
+	_, err = cfg.Set("pubsub#access_model", "open")
+	if err == nil {
+		err = pubsub.SetConfig(ctx, c.Session, node, cfg)
+	}
+	if err != nil {
+		c.debug.Print(p.Sprintf("error setting access model of %s: %v", node, err))
+	}
+}
+
+func (c *Client) fetchDeviceList(ctx context.Context, j jid.JID) (omemo.DeviceList, error) {
+	var list omemo.DeviceList
+	iter := pubsub.FetchIQ(ctx, stanza.IQ{To: j.Bare()}, c.Session, pubsub.Query{
+		Node:     omemo.NodeDevices,
+		MaxItems: 1,
+	})
+	for iter.Next() {
+		_, r := iter.Item()
+		if r == nil {
+			continue
+		}
+		err := xml.NewTokenDecoder(r).Decode(&list)
+		if err != nil {
+			/* #nosec */
+			iter.Close()
+			return nil, err
+		}
+	}
+	err := iter.Err()
+	// A missing node just means that the account has never used OMEMO.
+	if errors.Is(err, stanza.Error{Condition: stanza.ItemNotFound}) {
+		err = nil
+	}
+	return list, errors.Join(err, iter.Close())
+}
+
+func (c *Client) fetchBundle(ctx context.Context, j jid.JID, id uint32) (omemo.Bundle, error) {
+	var bundle omemo.Bundle
+	var found bool
+	iter := pubsub.FetchIQ(ctx, stanza.IQ{To: j.Bare()}, c.Session, pubsub.Query{
+		Node:     omemo.BundleNode(id),
+		MaxItems: 1,
+	})
+	for iter.Next() {
+		_, r := iter.Item()
+		if r == nil {
+			continue
+		}
+		err := xml.NewTokenDecoder(r).Decode(&bundle)
+		if err != nil {
+			/* #nosec */
+			iter.Close()
+			return bundle, err
+		}
+		found = true
+	}
+	err := errors.Join(iter.Err(), iter.Close())
+	if err == nil && !found {
+		err = stanza.Error{Condition: stanza.ItemNotFound}
+	}
+	return bundle, err
+}
+
+// OMEMODevices refreshes the list of devices belonging to j (if it has not
+// been fetched recently) and returns all known devices.
+func (c *Client) OMEMODevices(ctx context.Context, j jid.JID) ([]omemo.Device, error) {
+	if c.omemo == nil {
+		return nil, nil
+	}
+	p := c.Printer()
+	bare := j.Bare()
+	c.omemoFetchedM.Lock()
+	last := c.omemoFetched[bare.String()]
+	c.omemoFetchedM.Unlock()
+	if time.Since(last) > deviceListTTL {
+		list, err := c.fetchDeviceList(ctx, bare)
+		if err != nil {
+			// Fall back to whatever devices we already know about.
+			c.debug.Print(p.Sprintf("error fetching OMEMO devices of %s: %v", bare, err))
+		} else {
+			err = c.omemo.SetOMEMODevices(ctx, bare, list)
+			if err != nil {
+				return nil, err
+			}
+			c.omemoFetchedM.Lock()
+			c.omemoFetched[bare.String()] = time.Now()
+			c.omemoFetchedM.Unlock()
+		}
+	}
+	return c.omemo.OMEMODevices(ctx, bare)
+}
+
+// FetchOMEMOIdentities makes sure that we know the identity key of every
+// active device belonging to j so that they can be shown to the user.
+func (c *Client) FetchOMEMOIdentities(ctx context.Context, j jid.JID) ([]omemo.Device, error) {
+	devices, err := c.OMEMODevices(ctx, j)
+	if err != nil {
+		return nil, err
+	}
+	var fetched bool
+	for _, dev := range devices {
+		if !dev.Active || dev.Identity != (omemo.PublicKey{}) {
+			continue
+		}
+		bundle, err := c.fetchBundle(ctx, j, dev.ID)
+		if err != nil {
+			c.debug.Print(c.Printer().Sprintf("error fetching OMEMO bundle %d of %s: %v", dev.ID, j, err))
+			continue
+		}
+		err = c.omemo.SetOMEMODeviceIdentity(ctx, j, dev.ID, bundle.IdentityKey)
+		if err != nil {
+			return nil, err
+		}
+		fetched = true
+	}
+	if !fetched {
+		return devices, nil
+	}
+	return c.omemo.OMEMODevices(ctx, j)
+}
+
+// OMEMOFingerprint returns the fingerprint of our own identity key.
+func (c *Client) OMEMOFingerprint(ctx context.Context) (uint32, string, error) {
+	if c.omemo == nil {
+		return 0, "", nil
+	}
+	id, _, ok, err := c.omemo.OMEMOIdentity(ctx)
+	if err != nil || !ok {
+		return 0, "", err
+	}
+	return id.DeviceID, id.KeyPair().Public.Fingerprint(), nil
+}
+
+// session returns the existing session with a device or starts a new one.
+func (c *Client) session(ctx context.Context, own omemo.Identity, j jid.JID, dev omemo.Device) (*omemo.Session, error) {
+	s, err := c.omemo.OMEMOSession(ctx, j, dev.ID)
+	if err != nil || s != nil {
+		return s, err
+	}
+	bundle, err := c.fetchBundle(ctx, j, dev.ID)
+	if err != nil {
+		return nil, err
+	}
+	s, err = omemo.NewOutgoingSession(own, bundle)
+	if err != nil {
+		return nil, err
+	}
+	return s, c.omemo.SetOMEMODeviceIdentity(ctx, j, dev.ID, bundle.IdentityKey)
+}
+
+// encrypt encrypts body for every trusted device of the recipients and for our
+// own other devices.
+// If the message is going to a group chat the recipients are the real
+// addresses of the room occupants.
+func (c *Client) encrypt(ctx context.Context, msg event.ChatMessage) (omemo.Encrypted, error) {
+	p := c.Printer()
+	c.omemoM.Lock()
+	defer c.omemoM.Unlock()
+
+	own, _, ok, err := c.omemo.OMEMOIdentity(ctx)
+	switch {
+	case err != nil:
+		return omemo.Encrypted{}, err
+	case !ok:
+		return omemo.Encrypted{}, localerr.Wrap(p, "OMEMO keys have not been published yet")
+	}
+
+	self := c.LocalAddr().Bare()
+	var recipients []jid.JID
+	if msg.Type == stanza.GroupChatMessage {
+		recipients = c.OccupantJIDs(msg.To)
+	} else {
+		recipients = []jid.JID{msg.To.Bare()}
+	}
+	recipients = append(recipients, self)
+
+	payload, iv, key, err := omemo.EncryptPayload([]byte(msg.Body))
+	if err != nil {
+		return omemo.Encrypted{}, err
+	}
+	enc := omemo.Encrypted{SID: own.DeviceID, IV: iv, Payload: payload}
+	var others int
+	for _, j := range recipients {
+		devices, err := c.OMEMODevices(ctx, j)
+		if err != nil {
+			return enc, err
+		}
+		for _, dev := range devices {
+			if !dev.Active || dev.Trust == omemo.Untrusted || (dev.ID == own.DeviceID && j.Equal(self)) {
+				continue
+			}
+			s, err := c.session(ctx, own, j, dev)
+			if err != nil {
+				c.debug.Print(p.Sprintf("error starting OMEMO session with device %d of %s: %v", dev.ID, j, err))
+				continue
+			}
+			data, preKey, err := s.Encrypt(key)
+			if err != nil {
+				return enc, err
+			}
+			err = c.omemo.SetOMEMOSession(ctx, j, dev.ID, s)
+			if err != nil {
+				return enc, err
+			}
+			enc.Keys = append(enc.Keys, omemo.Key{RID: dev.ID, PreKey: preKey, Data: data})
+			if !j.Equal(self) {
+				others++
+			}
+		}
+	}
+	if others == 0 {
+		return enc, localerr.Wrap(p, "no trusted OMEMO devices found for %s", msg.To.Bare())
+	}
+	return enc, nil
+}
+
+// encodeEncrypted is like encodeMessage except that the body is replaced by
+// its encrypted form and a fallback explaining that the message is encrypted.
+func encodeEncrypted(e event.ChatMessage, enc omemo.Encrypted, fallback string) xml.TokenReader {
+	e.Message.XMLName = xml.Name{Space: "jabber:client", Local: "message"}
+	return e.Message.Wrap(xmlstream.MultiReader(
+		omitEmpty(fallback, xml.Name{Local: "body"}),
+		enc.TokenReader(),
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsHints, Local: "store"}}),
+		e.Replace.TokenReader(),
+		e.OriginID.TokenReader(),
+		chatHints(e),
+	))
+}
+
+// errKeyTransport is returned when an encrypted message contains no payload.
+// Such messages are only used to set up or advance sessions.
+var errKeyTransport = errors.New("key transport message")
+
+// decrypt replaces the body of an encrypted message with its decrypted form.
+func (c *Client) decrypt(ctx context.Context, msg *event.ChatMessage) error {
+	p := c.Printer()
+	enc := msg.Encrypted
+	sender := msg.From.Bare()
+	if msg.Type == stanza.GroupChatMessage {
+		var ok bool
+		sender, ok = c.occupantJID(ctx, msg.From, enc.SID)
+		if !ok {
+			return localerr.Wrap(p, "unknown sender %s of encrypted message", msg.From)
+		}
+	}
+
+	c.omemoM.Lock()
+	defer c.omemoM.Unlock()
+	own, spk, ok, err := c.omemo.OMEMOIdentity(ctx)
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return localerr.Wrap(p, "OMEMO keys have not been published yet")
+	}
+	var key *omemo.Key
+	for i, k := range enc.Keys {
+		if k.RID == own.DeviceID {
+			key = &enc.Keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return localerr.Wrap(p, "message was not encrypted for this device")
+	}
+
+	s, err := c.omemo.OMEMOSession(ctx, sender, enc.SID)
+	if err != nil {
+		return err
+	}
+	var keyMaterial []byte
+	if s != nil {
+		keyMaterial, err = s.Decrypt(key.Data, key.PreKey)
+	}
+	if (s == nil || err != nil) && key.PreKey {
+		// The other side started a new session with us.
+		info, ok := omemo.ParsePreKeyMessage(key.Data)
+		if !ok {
+			return localerr.Wrap(p, "invalid OMEMO prekey message")
+		}
+		var pk *omemo.PreKey
+		if info.HasPreKey {
+			pk, err = c.omemo.OMEMOPreKey(ctx, info.PreKeyID)
+			if err != nil {
+				return err
+			}
+		}
+		s, keyMaterial, err = omemo.NewIncomingSession(own, spk, pk, key.Data)
+		if err != nil {
+			return err
+		}
+		err = c.omemo.SetOMEMODeviceIdentity(ctx, sender, enc.SID, info.IdentityKey)
+		if err != nil {
+			return err
+		}
+		if pk != nil {
+			err = c.omemo.RemoveOMEMOPreKey(ctx, pk.ID)
+			if err != nil {
+				return err
+			}
+			// Replace the used prekey in the published bundle.
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+				defer cancel()
+				err := c.publishOMEMO(ctx)
+				if err != nil {
+					c.debug.Print(p.Sprintf("error republishing OMEMO bundle: %v", err))
+				}
+			}()
+		}
+	}
+	switch {
+	case err != nil:
+		return err
+	case s == nil:
+		return omemo.ErrNoSession
+	}
+	err = c.omemo.SetOMEMOSession(ctx, sender, enc.SID, s)
+	if err != nil {
+		return err
+	}
+	if enc.Payload == nil {
+		return errKeyTransport
+	}
+	plain, err := omemo.DecryptPayload(enc.Payload, enc.IV, keyMaterial)
+	if err != nil {
+		return err
+	}
+	msg.Body = string(plain)
+	return nil
+}
@@ -75,6 +75,25 @@ func Tee(in io.Writer, out io.Writer) Option {
 	}
 }
 
+// OMEMO enables end-to-end encryption using the provided store for keys,
+// sessions, and trust decisions.
+// Whether a given conversation is encrypted is also looked up in the store.
+func OMEMO(store OMEMOStore) Option {
+	return func(c *Client) {
+		c.omemo = store
+	}
+}
+
+// Outbox stores messages passed to Queue in the provided store until they can
+// be sent, including across restarts.
+// Without this option Queue sends messages immediately and fails if we are not
+// online.
+func Outbox(store OutboxStore) Option {
+	return func(c *Client) {
+		c.outbox = store
+	}
+}
+
 func emptyPass(context.Context) (string, error) {
 	return "", nil
 }
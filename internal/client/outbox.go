@@ -0,0 +1,127 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+
+	"mellium.im/communique/internal/client/event"
+)
+
+// OutboxStore persists messages that are waiting to be sent.
+// Messages are identified by their origin ID.
+type OutboxStore interface {
+	QueueMsg(context.Context, event.ChatMessage) error
+	NextQueued(context.Context) (event.ChatMessage, bool, error)
+	Dequeue(context.Context, string) (bool, error)
+}
+
+// Queue adds a message to the outbox to be sent as soon as we are online and
+// all messages that were queued before it have been sent.
+// The message is given an ID and origin ID if it does not have one already and
+// is returned so that it can be shown and stored.
+// Once the message has been sent, or if it cannot be sent at all, an
+// event.OutboxSent is emitted.
+//
+// If the client was not configured with an outbox, the message is sent
+// immediately instead.
+func (c *Client) Queue(ctx context.Context, msg event.ChatMessage) (event.ChatMessage, error) {
+	if msg.ID == "" {
+		msg.ID = randomID()
+	}
+	if msg.OriginID.ID == "" {
+		msg.OriginID.ID = msg.ID
+	}
+	if c.outbox == nil {
+		return c.SendMessage(ctx, msg)
+	}
+	err := c.outbox.QueueMsg(ctx, msg)
+	if err != nil {
+		return msg, err
+	}
+	c.wakeOutbox()
+	return msg, nil
+}
+
+// Cancel removes a message from the outbox and reports whether it was still
+// waiting to be sent.
+// If the message is being sent when Cancel is called, Cancel waits until it
+// is done.
+func (c *Client) Cancel(ctx context.Context, originID string) (bool, error) {
+	if c.outbox == nil {
+		return false, nil
+	}
+	c.outboxM.Lock()
+	defer c.outboxM.Unlock()
+	return c.outbox.Dequeue(ctx, originID)
+}
+
+// wakeOutbox tells the sender goroutine to look for messages to send.
+func (c *Client) wakeOutbox() {
+	select {
+	case c.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// sendQueued sends the messages in the outbox in order every time it is woken
+// up by a new message or a new session.
+// It stops at the first message that cannot be sent because we are offline and
+// waits to be woken up again.
+func (c *Client) sendQueued() {
+	for range c.outboxWake {
+		for c.sendNext() {
+		}
+	}
+}
+
+// sendNext sends the oldest message in the outbox and reports whether there
+// might be more to send.
+func (c *Client) sendNext() bool {
+	p := c.Printer()
+	c.outboxM.Lock()
+	defer c.outboxM.Unlock()
+
+	c.connM.Lock()
+	online := c.online
+	c.connM.Unlock()
+	if !online {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	msg, ok, err := c.outbox.NextQueued(ctx)
+	if err != nil {
+		c.logger.Print(p.Sprintf("error reading the outbox: %v", err))
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	r, err := c.messageReader(ctx, msg)
+	if err == nil {
+		// send only fails if the message could not be written, in which case the
+		// connection was most likely lost so leave the message in the outbox and
+		// try again once we're back.
+		// Once it has been written it is in the stream management queue which
+		// replays it on resume, so keeping it here too would deliver it twice.
+		err = c.send(ctx, r)
+		if err != nil {
+			c.debug.Print(p.Sprintf("error sending queued message %q, will retry: %v", msg.OriginID.ID, err))
+			return false
+		}
+	}
+	// Either the message was written to the stream, or it can't be encrypted
+	// (which retrying won't fix) and would block everything behind it.
+	_, dqErr := c.outbox.Dequeue(ctx, msg.OriginID.ID)
+	if dqErr != nil {
+		c.logger.Print(p.Sprintf("error removing message %q from the outbox: %v", msg.OriginID.ID, dqErr))
+		return false
+	}
+	c.handler(event.OutboxSent{ChatMessage: msg, Err: err})
+	return true
+}
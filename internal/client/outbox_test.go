@@ -0,0 +1,160 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stream"
+)
+
+type memOutbox struct {
+	m    sync.Mutex
+	msgs []event.ChatMessage
+}
+
+func (o *memOutbox) QueueMsg(_ context.Context, msg event.ChatMessage) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.msgs = append(o.msgs, msg)
+	return nil
+}
+
+func (o *memOutbox) NextQueued(context.Context) (event.ChatMessage, bool, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if len(o.msgs) == 0 {
+		return event.ChatMessage{}, false, nil
+	}
+	return o.msgs[0], true, nil
+}
+
+func (o *memOutbox) Dequeue(_ context.Context, id string) (bool, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	for i, msg := range o.msgs {
+		if msg.OriginID.ID == id {
+			o.msgs = append(o.msgs[:i], o.msgs[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// failConn records everything written to it and fails any write that contains
+// fail.
+type failConn struct {
+	fail []byte
+	buf  bytes.Buffer
+}
+
+func (c *failConn) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (c *failConn) Write(p []byte) (int, error) {
+	if len(c.fail) > 0 && bytes.Contains(p, c.fail) {
+		return 0, errors.New("write failed")
+	}
+	return c.buf.Write(p)
+}
+
+func newTestClient(t *testing.T, conn io.ReadWriter) *Client {
+	t.Helper()
+	j := jid.MustParse("romeo@example.net")
+	discard := log.New(io.Discard, "", 0)
+	c := New(j, discard, discard, Printer(message.NewPrinter(language.English)))
+	session, err := xmpp.NewSession(context.Background(), j.Domain(), j, conn, 0,
+		func(context.Context, *stream.Info, *stream.Info, *xmpp.Session, interface{}) (xmpp.SessionState, io.ReadWriter, interface{}, error) {
+			return xmpp.Ready, nil, nil, nil
+		})
+	if err != nil {
+		t.Fatalf("error creating session: %v", err)
+	}
+	c.Session = session
+	c.online = true
+	return c
+}
+
+var sendNextTestCases = [...]struct {
+	name      string
+	fail      string
+	sent      bool
+	remaining int
+}{
+	{
+		name: "sent",
+		sent: true,
+	},
+	{
+		// Once the message is written stream management is responsible for it.
+		name: "ack request failed",
+		fail: `<r xmlns="urn:xmpp:sm:3"`,
+		sent: true,
+	},
+	{
+		name:      "write failed",
+		fail:      "<message",
+		remaining: 1,
+	},
+}
+
+func TestSendNext(t *testing.T) {
+	for _, tc := range sendNextTestCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &failConn{fail: []byte(tc.fail)}
+			c := newTestClient(t, conn)
+			// Pretend that stream management is enabled so that acks are requested.
+			c.sm.outOn = true
+			box := &memOutbox{}
+			c.outbox = box
+			var sent []event.OutboxSent
+			c.Handler(func(e interface{}) {
+				if e, ok := e.(event.OutboxSent); ok {
+					sent = append(sent, e)
+				}
+			})
+
+			msg := event.ChatMessage{Body: "hi"}
+			msg.To = jid.MustParse("juliet@example.com")
+			msg, err := c.Queue(context.Background(), msg)
+			if err != nil {
+				t.Fatalf("error queueing message: %v", err)
+			}
+
+			more := c.sendNext()
+			if more != tc.sent {
+				t.Errorf("wrong result from sendNext: want=%t, got=%t", tc.sent, more)
+			}
+			if len(box.msgs) != tc.remaining {
+				t.Errorf("wrong number of queued messages: want=%d, got=%d", tc.remaining, len(box.msgs))
+			}
+			if !tc.sent {
+				if len(sent) != 0 {
+					t.Errorf("unexpected sent event for unsent message: %+v", sent)
+				}
+				return
+			}
+			if len(sent) != 1 || sent[0].OriginID.ID != msg.OriginID.ID || sent[0].Err != nil {
+				t.Errorf("wrong sent events: %+v", sent)
+			}
+			if !bytes.Contains(conn.buf.Bytes(), []byte(msg.OriginID.ID)) {
+				t.Errorf("message was not written: %s", conn.buf.Bytes())
+			}
+		})
+	}
+}
@@ -12,6 +12,12 @@ import (
 // idLen is the standard length of stanza identifiers in bytes.
 const idLen = 16
 
+// NewID returns a new random identifier that can be used as the ID and origin
+// ID of a message that is shown or stored before it is sent.
+func NewID() string {
+	return randomID()
+}
+
 // randomID generates a new random identifier of length IDLen. If the OS's
 // entropy pool isn't initialized, or we can't generate random numbers for some
 // other reason, panic.
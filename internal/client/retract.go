@@ -0,0 +1,76 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"encoding/xml"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+	"mellium.im/xmpp/stanza"
+)
+
+const nsFallback = "urn:xmpp:fallback:0"
+
+// Retract asks the recipient of a message that we sent to forget it.
+// ID is the origin ID of the message and typ is the type of the message, which
+// is groupchat for messages sent to a channel.
+func (c *Client) Retract(ctx context.Context, to jid.JID, typ stanza.MessageType, id string) error {
+	p := c.Printer()
+	msg := stanza.Message{
+		XMLName: xml.Name{Space: stanza.NSClient, Local: "message"},
+		ID:      randomID(),
+		To:      to.Bare(),
+		Type:    typ,
+	}
+	return c.Session.Send(ctx, msg.Wrap(xmlstream.MultiReader(
+		event.Retract{ID: id}.TokenReader(),
+		xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Space: nsFallback, Local: "fallback"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "for"}, Value: event.NSRetract}},
+		}),
+		omitEmpty(p.Sprintf("This person attempted to retract a previous message, but it's unsupported by your client."), xml.Name{Local: "body"}),
+		xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsHints, Local: "store"}}),
+	)))
+}
+
+// Moderate asks a channel to retract a message sent by another occupant.
+// ID is the stanza ID that the channel assigned to the message.
+func (c *Client) Moderate(ctx context.Context, room jid.JID, id, reason string) error {
+	return c.UnmarshalIQ(ctx, stanza.IQ{
+		Type: stanza.SetIQ,
+		To:   room.Bare(),
+	}.Wrap(xmlstream.Wrap(
+		xmlstream.MultiReader(
+			xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: event.NSRetract, Local: "retract"}}),
+			omitEmpty(reason, xml.Name{Local: "reason"}),
+		),
+		xml.StartElement{
+			Name: xml.Name{Space: event.NSModerate, Local: "moderate"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "id"}, Value: id}},
+		},
+	)), nil)
+}
+
+// Moderator reports whether we are a moderator in the given channel and the
+// channel supports moderating messages.
+func (c *Client) Moderator(room jid.JID) (bool, error) {
+	if c.Role(room) != muc.RoleModerator {
+		return false, nil
+	}
+	info, err := c.Disco(room.Bare())
+	if err != nil {
+		return false, err
+	}
+	for _, f := range info.Features {
+		if f.Var == event.NSModerate {
+			return true, nil
+		}
+	}
+	return false, nil
+}
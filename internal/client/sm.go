@@ -0,0 +1,568 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	"mellium.im/xmlstream"
+	"mellium.im/xmpp"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// nsSM is the namespace used by stream management (XEP-0198).
+const nsSM = "urn:xmpp:sm:3"
+
+// unackedStanza is a stanza that we have sent but that the server has not yet
+// acknowledged.
+type unackedStanza struct {
+	name string
+	raw  []byte
+}
+
+// streamManager tracks XEP-0198 stream management state.
+// Unlike the session it outlives a single connection so that the stream can be
+// resumed (or at least unacknowledged messages resent) after reconnecting.
+type streamManager struct {
+	m sync.Mutex
+
+	// State of the stream that can be resumed.
+	id     string
+	resume bool
+	addr   jid.JID
+
+	// Stanza counts and the queue of unacknowledged outgoing stanzas.
+	// Counters wrap around at 2^32 as described in the XEP.
+	inCount  uint32
+	inOn     bool
+	outCount uint32
+	outOn    bool
+	acked    uint32
+	unacked  []unackedStanza
+	requests []uint32
+
+	// Stanzas that should be sent again once the session is established.
+	resend   []unackedStanza
+	resumed  bool
+	in, out  *elementTee
+	closeTee func()
+}
+
+// tees returns writers that must see every byte read from and written to the
+// connection so that the stanza counts can be kept up to date.
+// Any previous tees are shut down.
+func (sm *streamManager) tees() (in, out io.Writer) {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	if sm.closeTee != nil {
+		sm.closeTee()
+	}
+	sm.inOn = false
+	sm.outOn = false
+	sm.requests = nil
+	sm.resumed = false
+	sm.resend = nil
+	sm.in = newElementTee(sm.handleIn)
+	sm.out = newElementTee(sm.handleOut)
+	tIn, tOut := sm.in, sm.out
+	sm.closeTee = func() {
+		tIn.Close()
+		tOut.Close()
+	}
+	return sm.in, sm.out
+}
+
+// Close shuts down the tees for the current connection.
+func (sm *streamManager) Close() {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	if sm.closeTee != nil {
+		sm.closeTee()
+		sm.closeTee = nil
+	}
+}
+
+// Forget drops the resumption state, for example because the stream was closed
+// cleanly and the server has discarded it.
+func (sm *streamManager) Forget() {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	sm.id = ""
+	sm.resume = false
+}
+
+// Enabled reports whether stream management is enabled on the current stream.
+func (sm *streamManager) Enabled() bool {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	return sm.outOn
+}
+
+// Resumed reports whether the current stream is a resumed stream and returns
+// any stanzas that must be sent again.
+func (sm *streamManager) Resumed() (bool, []unackedStanza) {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	resend := sm.resend
+	sm.resend = nil
+	return sm.resumed, resend
+}
+
+func (sm *streamManager) handleIn(start xml.StartElement, _ []byte) {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	switch ns := rawNS(start); {
+	case isStanza(start, ns):
+		if sm.inOn {
+			sm.inCount++
+		}
+	case ns != nsSM:
+	case start.Name.Local == "enabled":
+		sm.inCount = 0
+		sm.inOn = true
+	case start.Name.Local == "resumed":
+		sm.inOn = true
+	case start.Name.Local == "r":
+		// Remember how many stanzas came in before the request so that the
+		// answer does not include stanzas that were read from the connection but
+		// not yet handled.
+		sm.requests = append(sm.requests, sm.inCount)
+	}
+}
+
+func (sm *streamManager) handleOut(start xml.StartElement, raw []byte) {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	switch ns := rawNS(start); {
+	case isStanza(start, ns):
+		if !sm.outOn {
+			return
+		}
+		sm.outCount++
+		sm.unacked = append(sm.unacked, unackedStanza{
+			name: start.Name.Local,
+			raw:  append([]byte(nil), raw...),
+		})
+		// The ack may have been processed before we saw the stanza go out.
+		sm.trim()
+	case ns != nsSM:
+	case start.Name.Local == "enable":
+		sm.outCount = 0
+		sm.acked = 0
+		sm.unacked = nil
+		sm.outOn = true
+	case start.Name.Local == "resume":
+		sm.outOn = true
+	}
+}
+
+// trim drops stanzas from the front of the queue that have been acknowledged.
+// It must be called with the lock held.
+func (sm *streamManager) trim() {
+	first := sm.outCount - uint32(len(sm.unacked))
+	n := sm.acked - first
+	if n > uint32(len(sm.unacked)) {
+		// Either the ack is for stanzas we have not seen go out yet, or it is
+		// older than ones we have already processed.
+		if int32(n) < 0 {
+			return
+		}
+		n = uint32(len(sm.unacked))
+	}
+	sm.unacked = sm.unacked[n:]
+}
+
+func (sm *streamManager) ack(h uint32) {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	sm.acked = h
+	sm.trim()
+}
+
+// answer returns the count of handled stanzas for the oldest outstanding
+// request.
+func (sm *streamManager) answer() uint32 {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	if len(sm.requests) == 0 {
+		return sm.inCount
+	}
+	h := sm.requests[0]
+	sm.requests = sm.requests[1:]
+	return h
+}
+
+// Request asks the server to acknowledge the stanzas we have sent so far if
+// stream management is enabled.
+func (sm *streamManager) Request(ctx context.Context, s *xmpp.Session) error {
+	if !sm.Enabled() {
+		return nil
+	}
+	return s.Send(ctx, xmlstream.Wrap(nil, xml.StartElement{Name: xml.Name{Space: nsSM, Local: "r"}}))
+}
+
+// Handle answers requests and processes acknowledgements from the server.
+func (sm *streamManager) Handle(t xmlstream.TokenReadEncoder, start *xml.StartElement) error {
+	switch start.Name.Local {
+	case "r":
+		a := xml.StartElement{
+			Name: xml.Name{Space: nsSM, Local: "a"},
+			Attr: []xml.Attr{{
+				Name:  xml.Name{Local: "h"},
+				Value: strconv.FormatUint(uint64(sm.answer()), 10),
+			}},
+		}
+		err := t.EncodeToken(a)
+		if err != nil {
+			return err
+		}
+		return t.EncodeToken(a.End())
+	case "a":
+		h, ok := attrH(*start)
+		if ok {
+			sm.ack(h)
+		}
+	}
+	return nil
+}
+
+// Bind returns a resource binding stream feature that resumes the previous
+// stream if possible and otherwise binds a new resource and then tries to
+// enable stream management.
+func (sm *streamManager) Bind() xmpp.StreamFeature {
+	feature := xmpp.BindResource()
+	negotiate := feature.Negotiate
+	feature.Negotiate = func(ctx context.Context, session *xmpp.Session, data interface{}) (xmpp.SessionState, io.ReadWriter, error) {
+		_, supported := session.Feature(nsSM)
+		if supported {
+			ok, err := sm.tryResume(session)
+			if err != nil {
+				return 0, nil, err
+			}
+			if ok {
+				return xmpp.Ready, nil, nil
+			}
+		}
+
+		mask, rw, err := negotiate(ctx, session, data)
+		if err != nil {
+			return mask, rw, err
+		}
+		sm.rebind(session.LocalAddr())
+		if supported {
+			err = sm.enable(session)
+		}
+		return mask, rw, err
+	}
+	return feature
+}
+
+func (sm *streamManager) tryResume(session *xmpp.Session) (bool, error) {
+	sm.m.Lock()
+	id, resume, inCount, addr := sm.id, sm.resume, sm.inCount, sm.addr
+	sm.m.Unlock()
+	if id == "" || !resume {
+		return false, nil
+	}
+
+	start, err := smRoundTrip(session, xml.StartElement{
+		Name: xml.Name{Space: nsSM, Local: "resume"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "h"}, Value: strconv.FormatUint(uint64(inCount), 10)},
+			{Name: xml.Name{Local: "previd"}, Value: id},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	if !sm.handleResume(start) {
+		return false, nil
+	}
+	session.UpdateAddr(addr)
+	return true, nil
+}
+
+// handleResume processes the servers response to a resumption request and
+// reports whether the stream was resumed.
+// If it was, every stanza that the server has not acknowledged is queued to be
+// sent again.
+func (sm *streamManager) handleResume(start xml.StartElement) bool {
+	h, hasH := attrH(start)
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	if hasH {
+		sm.acked = h
+		sm.trim()
+	}
+	if start.Name.Local != "resumed" {
+		sm.id = ""
+		sm.resume = false
+		return false
+	}
+	sm.resumed = true
+	sm.resend = sm.unacked
+	sm.unacked = nil
+	sm.outCount = sm.acked
+	return true
+}
+
+// rebind is called after a new resource has been bound, at which point any
+// state from the old stream is gone.
+// Only unacknowledged messages are queued to be sent again: presence would be
+// stale and IQ responses cannot be matched up with requests made on the old
+// stream anyways.
+func (sm *streamManager) rebind(addr jid.JID) {
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	sm.id = ""
+	sm.resume = false
+	sm.addr = addr
+	sm.outOn = false
+	for _, s := range sm.unacked {
+		if s.name == "message" {
+			sm.resend = append(sm.resend, s)
+		}
+	}
+	sm.unacked = nil
+}
+
+func (sm *streamManager) enable(session *xmpp.Session) error {
+	start, err := smRoundTrip(session, xml.StartElement{
+		Name: xml.Name{Space: nsSM, Local: "enable"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "resume"}, Value: "true"}},
+	})
+	if err != nil {
+		return err
+	}
+	if start.Name.Local != "enabled" {
+		// The server refused to enable stream management. This isn't fatal, we
+		// just won't be able to resume the stream.
+		sm.m.Lock()
+		sm.outOn = false
+		sm.unacked = nil
+		sm.m.Unlock()
+		return nil
+	}
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	for _, a := range start.Attr {
+		switch a.Name.Local {
+		case "id":
+			sm.id = a.Value
+		case "resume":
+			sm.resume = a.Value == "true" || a.Value == "1"
+		}
+	}
+	return nil
+}
+
+// smRoundTrip writes the nonza req and returns the start element of the
+// servers response, consuming the rest of it.
+func smRoundTrip(session *xmpp.Session, req xml.StartElement) (xml.StartElement, error) {
+	w := session.TokenWriter()
+	defer w.Close()
+	err := w.EncodeToken(req)
+	if err != nil {
+		return xml.StartElement{}, err
+	}
+	err = w.EncodeToken(req.End())
+	if err != nil {
+		return xml.StartElement{}, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return xml.StartElement{}, err
+	}
+
+	r := session.TokenReader()
+	defer r.Close()
+	d := xml.NewTokenDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		err = d.Skip()
+		if err != nil {
+			return start, err
+		}
+		if start.Name.Space != nsSM {
+			return start, errors.New("unexpected element during stream management negotiation")
+		}
+		return start, nil
+	}
+}
+
+func attrH(start xml.StartElement) (uint32, bool) {
+	for _, a := range start.Attr {
+		if a.Name.Local == "h" {
+			h, err := strconv.ParseUint(a.Value, 10, 32)
+			return uint32(h), err == nil
+		}
+	}
+	return 0, false
+}
+
+// rawNS returns the default namespace declared on a raw start element.
+func rawNS(start xml.StartElement) string {
+	for _, a := range start.Attr {
+		if a.Name.Space == "" && a.Name.Local == "xmlns" {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func isStanza(start xml.StartElement, ns string) bool {
+	if start.Name.Space != "" || (ns != "" && ns != stanza.NSClient) {
+		return false
+	}
+	switch start.Name.Local {
+	case "message", "presence", "iq":
+		return true
+	}
+	return false
+}
+
+// elementTee is an io.Writer that tokenizes the XML written to it and calls f
+// with the start element and raw bytes of every top level element in the
+// stream (ie. stanzas and nonzas).
+// Writes block until all complete tokens have been processed so that anything
+// f records is up to date by the time the session sees the same bytes.
+type elementTee struct {
+	f        func(start xml.StartElement, raw []byte)
+	chunks   chan []byte
+	consumed chan struct{}
+	quit     chan struct{}
+	once     sync.Once
+
+	cur     []byte
+	pending bool
+	rec     []byte
+	base    int64
+}
+
+func newElementTee(f func(xml.StartElement, []byte)) *elementTee {
+	t := &elementTee{
+		f:        f,
+		chunks:   make(chan []byte),
+		consumed: make(chan struct{}),
+		quit:     make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Write never returns an error since that would break the connection being
+// teed.
+func (t *elementTee) Write(p []byte) (int, error) {
+	select {
+	case t.chunks <- p:
+	case <-t.quit:
+		return len(p), nil
+	}
+	select {
+	case <-t.consumed:
+	case <-t.quit:
+	}
+	return len(p), nil
+}
+
+// Close stops the tokenizer. Future writes are discarded.
+func (t *elementTee) Close() {
+	t.once.Do(func() { close(t.quit) })
+}
+
+func (t *elementTee) ReadByte() (byte, error) {
+	for len(t.cur) == 0 {
+		if t.pending {
+			t.pending = false
+			select {
+			case t.consumed <- struct{}{}:
+			case <-t.quit:
+				return 0, io.EOF
+			}
+		}
+		select {
+		case t.cur = <-t.chunks:
+			t.pending = true
+		case <-t.quit:
+			return 0, io.EOF
+		}
+	}
+	b := t.cur[0]
+	t.cur = t.cur[1:]
+	t.rec = append(t.rec, b)
+	return b, nil
+}
+
+func (t *elementTee) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b, err := t.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	p[0] = b
+	return 1, nil
+}
+
+func (t *elementTee) run() {
+	// Once the tokenizer stops, unblock any writers.
+	defer t.Close()
+
+	d := xml.NewDecoder(t)
+	var (
+		depth int
+		start xml.StartElement
+		off   int64
+	)
+	for {
+		if depth <= 1 {
+			// Throw away everything that isn't part of the current element.
+			off = d.InputOffset()
+			t.rec = t.rec[off-t.base:]
+			t.base = off
+		}
+		tok, err := d.RawToken()
+		if err != nil {
+			return
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if tok.Name.Space == "stream" && tok.Name.Local == "stream" {
+				// Stream restarts (eg. after StartTLS or authentication) open a new
+				// stream without closing the old one.
+				depth = 1
+				continue
+			}
+			depth++
+			if depth == 2 {
+				start = tok.Copy()
+			}
+		case xml.EndElement:
+			depth--
+			if depth == 1 {
+				end := d.InputOffset()
+				t.f(start, bytes.Clone(t.rec[off-t.base:end-t.base]))
+			}
+			if depth < 0 {
+				depth = 0
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+const (
+	streamHeader = `<stream:stream xmlns="jabber:client" xmlns:stream="http://etherx.jabber.org/streams">`
+	enableSM     = `<enable xmlns="urn:xmpp:sm:3" resume="true"/>`
+	enabledSM    = `<enabled xmlns="urn:xmpp:sm:3" id="abc" resume="true"/>`
+)
+
+func msg(id string) string {
+	return `<message id="` + id + `" to="juliet@example.com"><body>hi</body></message>`
+}
+
+func write(t *testing.T, w io.Writer, s ...string) {
+	t.Helper()
+	for _, chunk := range s {
+		_, err := io.WriteString(w, chunk)
+		if err != nil {
+			t.Fatalf("error writing %q: %v", chunk, err)
+		}
+	}
+}
+
+func unacked(names ...string) []unackedStanza {
+	var q []unackedStanza
+	for _, name := range names {
+		q = append(q, unackedStanza{name: name, raw: []byte(name)})
+	}
+	return q
+}
+
+func names(q []unackedStanza) []string {
+	var out []string
+	for _, s := range q {
+		out = append(out, string(s.raw))
+	}
+	return out
+}
+
+var trimTestCases = [...]struct {
+	outCount uint32
+	queue    []string
+	acked    uint32
+	want     []string
+}{
+	0: {
+		outCount: 3,
+		queue:    []string{"a", "b", "c"},
+		acked:    2,
+		want:     []string{"c"},
+	},
+	1: {
+		outCount: 3,
+		queue:    []string{"a", "b", "c"},
+		acked:    3,
+	},
+	2: {
+		// Wrapped after the first stanza was sent.
+		outCount: 1,
+		queue:    []string{"a", "b", "c"},
+		acked:    math.MaxUint32,
+		want:     []string{"b", "c"},
+	},
+	3: {
+		outCount: 1,
+		queue:    []string{"a", "b", "c"},
+		acked:    0,
+		want:     []string{"c"},
+	},
+	4: {
+		outCount: 1,
+		queue:    []string{"a", "b", "c"},
+		acked:    1,
+	},
+	5: {
+		// An old ack from before the wrap must not trim anything.
+		outCount: 1,
+		queue:    []string{"a", "b", "c"},
+		acked:    math.MaxUint32 - 5,
+		want:     []string{"a", "b", "c"},
+	},
+	6: {
+		// Acks for stanzas that the tee has not reported yet.
+		outCount: 3,
+		queue:    []string{"a", "b", "c"},
+		acked:    5,
+	},
+	7: {
+		outCount: math.MaxUint32,
+		acked:    math.MaxUint32,
+	},
+}
+
+func TestTrim(t *testing.T) {
+	for i, tc := range trimTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			sm := &streamManager{
+				outCount: tc.outCount,
+				unacked:  unacked(tc.queue...),
+			}
+			sm.ack(tc.acked)
+			if got := names(sm.unacked); !slices.Equal(got, tc.want) {
+				t.Errorf("wrong queue after ack: want=%v, got=%v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCountWraparound(t *testing.T) {
+	sm := &streamManager{}
+	in, out := sm.tees()
+	defer sm.Close()
+
+	write(t, out, streamHeader, enableSM)
+	write(t, in, streamHeader, enabledSM)
+	if !sm.Enabled() {
+		t.Fatalf("stream management not enabled")
+	}
+
+	sm.m.Lock()
+	sm.inCount = math.MaxUint32
+	sm.outCount = math.MaxUint32
+	sm.acked = math.MaxUint32
+	sm.m.Unlock()
+
+	write(t, out, msg("1"), msg("2"))
+	write(t, in, msg("3"))
+
+	sm.m.Lock()
+	inCount, outCount, queue := sm.inCount, sm.outCount, len(sm.unacked)
+	sm.m.Unlock()
+	if inCount != 0 {
+		t.Errorf("wrong inbound count: want=0, got=%d", inCount)
+	}
+	if outCount != 1 {
+		t.Errorf("wrong outbound count: want=1, got=%d", outCount)
+	}
+	if queue != 2 {
+		t.Fatalf("wrong number of unacked stanzas: want=2, got=%d", queue)
+	}
+
+	sm.ack(0)
+	sm.m.Lock()
+	q := sm.unacked
+	sm.m.Unlock()
+	if len(q) != 1 || string(q[0].raw) != msg("2") {
+		t.Errorf("wrong queue after ack: want=[%s], got=%v", msg("2"), names(q))
+	}
+}
+
+func TestTeeIgnoresUntracked(t *testing.T) {
+	sm := &streamManager{}
+	in, out := sm.tees()
+	defer sm.Close()
+
+	// Nothing is counted until stream management is enabled.
+	write(t, out, streamHeader, msg("1"))
+	write(t, in, streamHeader, msg("2"))
+	write(t, out, enableSM, `<r xmlns="urn:xmpp:sm:3"/>`)
+	write(t, in, enabledSM, `<a xmlns="urn:xmpp:sm:3" h="0"/>`)
+
+	sm.m.Lock()
+	defer sm.m.Unlock()
+	if sm.inCount != 0 || sm.outCount != 0 {
+		t.Errorf("nonzas or stanzas before enable were counted: in=%d, out=%d", sm.inCount, sm.outCount)
+	}
+	if len(sm.unacked) != 0 {
+		t.Errorf("unexpected stanzas queued: %v", names(sm.unacked))
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	sm := &streamManager{}
+	in, _ := sm.tees()
+	defer sm.Close()
+
+	write(t, in, streamHeader, enabledSM, msg("1"), `<r xmlns="urn:xmpp:sm:3"/>`, msg("2"))
+	if h := sm.answer(); h != 1 {
+		t.Errorf("wrong answer to first request: want=1, got=%d", h)
+	}
+	if h := sm.answer(); h != 2 {
+		t.Errorf("wrong answer with no outstanding requests: want=2, got=%d", h)
+	}
+}
+
+func resumeResponse(local string, h uint32) xml.StartElement {
+	return xml.StartElement{
+		Name: xml.Name{Space: nsSM, Local: local},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "h"}, Value: strconv.FormatUint(uint64(h), 10)},
+			{Name: xml.Name{Local: "previd"}, Value: "abc"},
+		},
+	}
+}
+
+func TestReplayAfterResume(t *testing.T) {
+	sm := &streamManager{
+		id:       "abc",
+		resume:   true,
+		outCount: 1,
+		acked:    math.MaxUint32 - 1,
+		unacked:  unacked("message", "iq", "presence"),
+	}
+	if !sm.handleResume(resumeResponse("resumed", math.MaxUint32)) {
+		t.Fatalf("stream was not resumed")
+	}
+	resumed, resend := sm.Resumed()
+	if !resumed {
+		t.Errorf("expected stream to be reported as resumed")
+	}
+	if want, got := []string{"iq", "presence"}, names(resend); !slices.Equal(want, got) {
+		t.Errorf("wrong stanzas to resend: want=%v, got=%v", want, got)
+	}
+	if sm.outCount != math.MaxUint32 {
+		t.Errorf("outbound count not reset to the servers count: want=%d, got=%d", uint32(math.MaxUint32), sm.outCount)
+	}
+	if len(sm.unacked) != 0 {
+		t.Errorf("resent stanzas still queued: %v", names(sm.unacked))
+	}
+	if _, resend = sm.Resumed(); len(resend) != 0 {
+		t.Errorf("stanzas returned for resending twice: %v", names(resend))
+	}
+}
+
+func TestResumeFailed(t *testing.T) {
+	sm := &streamManager{
+		id:       "abc",
+		resume:   true,
+		outCount: 3,
+		unacked:  unacked("message", "iq", "message"),
+	}
+	if sm.handleResume(resumeResponse("failed", 1)) {
+		t.Fatalf("stream should not have been resumed")
+	}
+	if sm.id != "" || sm.resume {
+		t.Errorf("resumption state was not forgotten: id=%q, resume=%t", sm.id, sm.resume)
+	}
+
+	// Only messages are sent again on the new stream.
+	sm.rebind(sm.addr)
+	resumed, resend := sm.Resumed()
+	if resumed {
+		t.Errorf("expected stream not to be reported as resumed")
+	}
+	if want, got := []string{"message"}, names(resend); !slices.Equal(want, got) {
+		t.Errorf("wrong stanzas to resend: want=%v, got=%v", want, got)
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package omemo implements the cryptographic parts of OMEMO end-to-end
+// encryption as deployed by most clients today (the
+// "eu.siacs.conversations.axolotl" namespace of XEP-0384).
+//
+// It contains the Signal protocol key agreement and double ratchet, the
+// encryption of message payloads, and the XML elements used to publish keys and
+// send messages.
+// Storing keys and sessions, and fetching them over the network, is left to the
+// caller.
+package omemo // import "mellium.im/communique/internal/omemo"
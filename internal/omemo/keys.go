@@ -0,0 +1,246 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// djbType is the prefix byte used by libsignal when serializing Curve25519
+// public keys.
+const djbType = 0x05
+
+var errKeyLen = errors.New("omemo: invalid key length")
+
+// randReader is the source of randomness for ratchet and prekey generation.
+// It is only replaced in tests that need reproducible messages.
+var randReader io.Reader = rand.Reader
+
+// PublicKey is a Curve25519 public key.
+type PublicKey [32]byte
+
+// ParsePublicKey parses a public key serialized with or without the type
+// prefix.
+func ParsePublicKey(b []byte) (PublicKey, error) {
+	var k PublicKey
+	switch {
+	case len(b) == 33 && b[0] == djbType:
+		copy(k[:], b[1:])
+	case len(b) == 32:
+		copy(k[:], b)
+	default:
+		return k, errKeyLen
+	}
+	return k, nil
+}
+
+// Serialize returns the key with the type prefix used on the wire.
+func (k PublicKey) Serialize() []byte {
+	return append([]byte{djbType}, k[:]...)
+}
+
+// Fingerprint returns the key in the form usually shown to users for
+// verification: lowercase hex in groups of eight characters.
+func (k PublicKey) Fingerprint() string {
+	h := hex.EncodeToString(k[:])
+	var buf strings.Builder
+	for i := 0; i < len(h); i += 8 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(h[i : i+8])
+	}
+	return buf.String()
+}
+
+// KeyPair is a Curve25519 key pair.
+type KeyPair struct {
+	Private [32]byte
+	Public  PublicKey
+}
+
+// GenerateKeyPair creates a new random key pair.
+func GenerateKeyPair() (KeyPair, error) {
+	var kp KeyPair
+	_, err := io.ReadFull(randReader, kp.Private[:])
+	if err != nil {
+		return kp, err
+	}
+	pub, err := curve25519.X25519(kp.Private[:], curve25519.Basepoint)
+	if err != nil {
+		return kp, err
+	}
+	copy(kp.Public[:], pub)
+	return kp, nil
+}
+
+// NewKeyPair returns the key pair for a stored private key.
+func NewKeyPair(priv []byte) (KeyPair, error) {
+	var kp KeyPair
+	if len(priv) != len(kp.Private) {
+		return kp, errKeyLen
+	}
+	copy(kp.Private[:], priv)
+	pub, err := curve25519.X25519(kp.Private[:], curve25519.Basepoint)
+	if err != nil {
+		return kp, err
+	}
+	copy(kp.Public[:], pub)
+	return kp, nil
+}
+
+func dh(priv [32]byte, pub PublicKey) ([]byte, error) {
+	return curve25519.X25519(priv[:], pub[:])
+}
+
+// Identity is the long term identity of this device.
+// The Ed25519 seed is used to derive both the Curve25519 key used in key
+// agreement and the key used to sign prekeys.
+type Identity struct {
+	DeviceID uint32
+	Seed     []byte
+}
+
+// NewIdentity creates a random identity and device ID.
+func NewIdentity() (Identity, error) {
+	id := Identity{Seed: make([]byte, ed25519.SeedSize)}
+	_, err := rand.Read(id.Seed)
+	if err != nil {
+		return id, err
+	}
+	var b [4]byte
+	for id.DeviceID == 0 {
+		_, err = rand.Read(b[:])
+		if err != nil {
+			return id, err
+		}
+		id.DeviceID = binary.BigEndian.Uint32(b[:]) & 0x7fffffff
+	}
+	return id, nil
+}
+
+// KeyPair returns the Curve25519 form of the identity key.
+func (id Identity) KeyPair() KeyPair {
+	h := sha512.Sum512(id.Seed)
+	var kp KeyPair
+	copy(kp.Private[:], h[:32])
+	kp.Private[0] &= 248
+	kp.Private[31] &= 127
+	kp.Private[31] |= 64
+	pub, err := curve25519.X25519(kp.Private[:], curve25519.Basepoint)
+	if err != nil {
+		// This can only happen if the private key is all zeros, which the
+		// clamping above prevents.
+		panic(err)
+	}
+	copy(kp.Public[:], pub)
+	return kp
+}
+
+// sign creates an XEdDSA signature over msg that can be checked against the
+// Curve25519 form of the identity key.
+func (id Identity) sign(msg []byte) []byte {
+	priv := ed25519.NewKeyFromSeed(id.Seed)
+	sig := ed25519.Sign(priv, msg)
+	edPub := priv.Public().(ed25519.PublicKey)
+	// The Montgomery form of the key loses the sign of the Edwards x-coordinate,
+	// so it is carried in the otherwise unused top bit of the signature.
+	sig[63] |= edPub[31] & 0x80
+	return sig
+}
+
+var (
+	fieldPrime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	one        = big.NewInt(1)
+)
+
+// verify checks an XEdDSA signature made by the owner of pub.
+func verify(pub PublicKey, msg, sig []byte) bool {
+	if len(sig) != ed25519.SignatureSize {
+		return false
+	}
+
+	// Convert the Montgomery u-coordinate to an Edwards y-coordinate:
+	// y = (u - 1) / (u + 1)
+	le := pub
+	le[31] &= 0x7f
+	u := new(big.Int).SetBytes(reverse(le[:]))
+	den := new(big.Int).Add(u, one)
+	den.Mod(den, fieldPrime)
+	if den.Sign() == 0 {
+		return false
+	}
+	num := new(big.Int).Sub(u, one)
+	num.Mod(num, fieldPrime)
+	y := num.Mul(num, den.ModInverse(den, fieldPrime))
+	y.Mod(y, fieldPrime)
+
+	edPub := make([]byte, ed25519.PublicKeySize)
+	y.FillBytes(edPub)
+	edPub = reverse(edPub)
+	edPub[31] |= sig[63] & 0x80
+
+	s := make([]byte, len(sig))
+	copy(s, sig)
+	s[63] &= 0x7f
+	return ed25519.Verify(ed25519.PublicKey(edPub), msg, s)
+}
+
+func reverse(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		out[len(b)-1-i] = c
+	}
+	return out
+}
+
+// PreKey is a one time prekey.
+type PreKey struct {
+	ID      uint32
+	KeyPair KeyPair
+}
+
+// NewPreKeys generates n prekeys with sequential IDs starting at start.
+func NewPreKeys(start uint32, n int) ([]PreKey, error) {
+	keys := make([]PreKey, 0, n)
+	for i := 0; i < n; i++ {
+		kp, err := GenerateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, PreKey{ID: start + uint32(i), KeyPair: kp})
+	}
+	return keys, nil
+}
+
+// SignedPreKey is a medium term prekey signed by the identity key.
+type SignedPreKey struct {
+	ID        uint32
+	KeyPair   KeyPair
+	Signature []byte
+}
+
+// NewSignedPreKey generates a new signed prekey with the given ID.
+func NewSignedPreKey(id Identity, keyID uint32) (SignedPreKey, error) {
+	kp, err := GenerateKeyPair()
+	if err != nil {
+		return SignedPreKey{}, err
+	}
+	return SignedPreKey{
+		ID:        keyID,
+		KeyPair:   kp,
+		Signature: id.sign(kp.Public.Serialize()),
+	}, nil
+}
@@ -0,0 +1,270 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// The vectors in testdata/libsignal.json were produced by running this package
+// against go.mau.fi/libsignal, a port of libsignal-protocol-java.
+// Every signature and message that we produced in them was verified or
+// decrypted by libsignal, and every signature and message that libsignal
+// produced was verified or decrypted by us, so matching them byte for byte
+// means we still interoperate with other OMEMO clients.
+
+type hexBytes []byte
+
+func (h *hexBytes) UnmarshalText(b []byte) error {
+	var err error
+	*h, err = hex.DecodeString(string(b))
+	return err
+}
+
+type libsignalBundle struct {
+	IdentityKey           hexBytes `json:"identityKey"`
+	SignedPreKeyID        uint32   `json:"signedPreKeyId"`
+	SignedPreKey          hexBytes `json:"signedPreKey"`
+	SignedPreKeySignature hexBytes `json:"signedPreKeySignature"`
+	PreKeyID              uint32   `json:"preKeyId"`
+	PreKey                hexBytes `json:"preKey"`
+}
+
+type libsignalVectors struct {
+	// Signatures made by libsignal.
+	Signatures []struct {
+		Key       hexBytes `json:"key"`
+		Message   hexBytes `json:"message"`
+		Signature hexBytes `json:"signature"`
+	} `json:"signatures"`
+	// Signatures made by us and verified by libsignal.
+	OwnSignatures []struct {
+		Seed      hexBytes `json:"seed"`
+		Message   hexBytes `json:"message"`
+		Signature hexBytes `json:"signature"`
+	} `json:"ownSignatures"`
+	// Root and chain keys calculated by libsignal when starting a session.
+	Agreements []struct {
+		Identity       hexBytes `json:"identity"`
+		Base           hexBytes `json:"base"`
+		RemoteIdentity hexBytes `json:"remoteIdentity"`
+		SignedPreKey   hexBytes `json:"signedPreKey"`
+		PreKey         hexBytes `json:"preKey"`
+		RootKey        hexBytes `json:"rootKey"`
+		ChainKey       hexBytes `json:"chainKey"`
+	} `json:"agreements"`
+	// Conversations with a libsignal device.
+	// If Bundle is set we start the session, otherwise libsignal starts it
+	// using our signed prekey and prekey.
+	// Random is everything that we read from randReader, in order.
+	Sessions []struct {
+		Name           string           `json:"name"`
+		Seed           hexBytes         `json:"seed"`
+		DeviceID       uint32           `json:"deviceId"`
+		SignedPreKeyID uint32           `json:"signedPreKeyId"`
+		SignedPreKey   hexBytes         `json:"signedPreKey"`
+		PreKeyID       uint32           `json:"preKeyId"`
+		PreKey         hexBytes         `json:"preKey"`
+		Bundle         *libsignalBundle `json:"bundle"`
+		Random         hexBytes         `json:"random"`
+		Steps          []struct {
+			Sent      bool     `json:"sent"`
+			PreKey    bool     `json:"preKey"`
+			Plaintext string   `json:"plaintext"`
+			Message   hexBytes `json:"message"`
+		} `json:"steps"`
+	} `json:"sessions"`
+}
+
+func loadLibsignalVectors(t *testing.T) libsignalVectors {
+	t.Helper()
+	b, err := os.ReadFile("testdata/libsignal.json")
+	if err != nil {
+		t.Fatalf("error reading vectors: %v", err)
+	}
+	var v libsignalVectors
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		t.Fatalf("error decoding vectors: %v", err)
+	}
+	return v
+}
+
+func mustPublicKey(t *testing.T, b []byte) PublicKey {
+	t.Helper()
+	k, err := ParsePublicKey(b)
+	if err != nil {
+		t.Fatalf("error parsing public key %x: %v", b, err)
+	}
+	return k
+}
+
+func mustKeyPair(t *testing.T, priv []byte) KeyPair {
+	t.Helper()
+	kp, err := NewKeyPair(priv)
+	if err != nil {
+		t.Fatalf("error loading key pair: %v", err)
+	}
+	return kp
+}
+
+func TestLibsignalVerify(t *testing.T) {
+	v := loadLibsignalVectors(t)
+	for i, tc := range v.Signatures {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			pub := mustPublicKey(t, tc.Key)
+			if !verify(pub, tc.Message, tc.Signature) {
+				t.Fatalf("libsignal signature did not verify")
+			}
+
+			// Flipping the sign bit, or any bit of the message or the rest of the
+			// signature, must invalidate the signature.
+			sig := bytes.Clone(tc.Signature)
+			sig[63] ^= 0x80
+			if verify(pub, tc.Message, sig) {
+				t.Errorf("signature with the wrong sign bit verified")
+			}
+			sig = bytes.Clone(tc.Signature)
+			sig[0] ^= 1
+			if verify(pub, tc.Message, sig) {
+				t.Errorf("modified signature verified")
+			}
+			msg := append(bytes.Clone(tc.Message), 0)
+			if verify(pub, msg, tc.Signature) {
+				t.Errorf("signature verified for a different message")
+			}
+			if verify(pub, tc.Message, tc.Signature[:63]) {
+				t.Errorf("truncated signature verified")
+			}
+		})
+	}
+}
+
+func TestLibsignalSign(t *testing.T) {
+	v := loadLibsignalVectors(t)
+	for i, tc := range v.OwnSignatures {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			id := Identity{Seed: tc.Seed}
+			sig := id.sign(tc.Message)
+			if !bytes.Equal(sig, tc.Signature) {
+				t.Errorf("wrong signature: want=%x, got=%x", []byte(tc.Signature), sig)
+			}
+			if !verify(id.KeyPair().Public, tc.Message, sig) {
+				t.Errorf("could not verify our own signature")
+			}
+		})
+	}
+}
+
+func TestLibsignalAgreement(t *testing.T) {
+	v := loadLibsignalVectors(t)
+	for i, tc := range v.Agreements {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ik := mustKeyPair(t, tc.Identity)
+			base := mustKeyPair(t, tc.Base)
+			remoteIdentity := mustPublicKey(t, tc.RemoteIdentity)
+			spk := mustPublicKey(t, tc.SignedPreKey)
+
+			var secrets [][]byte
+			for _, pair := range []struct {
+				priv [32]byte
+				pub  PublicKey
+			}{
+				{ik.Private, spk},
+				{base.Private, remoteIdentity},
+				{base.Private, spk},
+			} {
+				s, err := dh(pair.priv, pair.pub)
+				if err != nil {
+					t.Fatalf("error calculating shared secret: %v", err)
+				}
+				secrets = append(secrets, s)
+			}
+			if len(tc.PreKey) > 0 {
+				s, err := dh(base.Private, mustPublicKey(t, tc.PreKey))
+				if err != nil {
+					t.Fatalf("error calculating shared secret: %v", err)
+				}
+				secrets = append(secrets, s)
+			}
+			root, chainKey := deriveInitial(secrets)
+			if !bytes.Equal(root, tc.RootKey) {
+				t.Errorf("wrong root key: want=%x, got=%x", []byte(tc.RootKey), root)
+			}
+			if !bytes.Equal(chainKey, tc.ChainKey) {
+				t.Errorf("wrong chain key: want=%x, got=%x", []byte(tc.ChainKey), chainKey)
+			}
+		})
+	}
+}
+
+func TestLibsignalSession(t *testing.T) {
+	v := loadLibsignalVectors(t)
+	for _, tc := range v.Sessions {
+		t.Run(tc.Name, func(t *testing.T) {
+			oldReader := randReader
+			defer func() {
+				randReader = oldReader
+			}()
+			randReader = bytes.NewReader(tc.Random)
+
+			id := Identity{DeviceID: tc.DeviceID, Seed: tc.Seed}
+			var s *Session
+			var spk SignedPreKey
+			var preKey *PreKey
+			if b := tc.Bundle; b != nil {
+				var err error
+				s, err = NewOutgoingSession(id, Bundle{
+					SignedPreKeyID:        b.SignedPreKeyID,
+					SignedPreKey:          mustPublicKey(t, b.SignedPreKey),
+					SignedPreKeySignature: b.SignedPreKeySignature,
+					IdentityKey:           mustPublicKey(t, b.IdentityKey),
+					PreKeys:               []BundlePreKey{{ID: b.PreKeyID, Key: mustPublicKey(t, b.PreKey)}},
+				})
+				if err != nil {
+					t.Fatalf("error creating outgoing session: %v", err)
+				}
+			} else {
+				spk = SignedPreKey{ID: tc.SignedPreKeyID, KeyPair: mustKeyPair(t, tc.SignedPreKey)}
+				preKey = &PreKey{ID: tc.PreKeyID, KeyPair: mustKeyPair(t, tc.PreKey)}
+			}
+
+			for i, step := range tc.Steps {
+				if step.Sent {
+					msg, isPreKey, err := s.Encrypt([]byte(step.Plaintext))
+					if err != nil {
+						t.Fatalf("step %d: error encrypting: %v", i, err)
+					}
+					if isPreKey != step.PreKey {
+						t.Errorf("step %d: wrong message type: want prekey=%t, got=%t", i, step.PreKey, isPreKey)
+					}
+					if !bytes.Equal(msg, step.Message) {
+						t.Fatalf("step %d: wrong message: want=%x, got=%x", i, []byte(step.Message), msg)
+					}
+					continue
+				}
+
+				var plain []byte
+				var err error
+				if s == nil {
+					s, plain, err = NewIncomingSession(id, spk, preKey, step.Message)
+				} else {
+					plain, err = s.Decrypt(step.Message, step.PreKey)
+				}
+				if err != nil {
+					t.Fatalf("step %d: error decrypting: %v", i, err)
+				}
+				if string(plain) != step.Plaintext {
+					t.Fatalf("step %d: wrong plaintext: want=%q, got=%q", i, step.Plaintext, plain)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"mellium.im/communique/internal/omemo"
+	"mellium.im/xmlstream"
+)
+
+type device struct {
+	id      omemo.Identity
+	spk     omemo.SignedPreKey
+	preKeys []omemo.PreKey
+}
+
+func newDevice(t *testing.T) device {
+	t.Helper()
+	id, err := omemo.NewIdentity()
+	if err != nil {
+		t.Fatalf("error creating identity: %v", err)
+	}
+	spk, err := omemo.NewSignedPreKey(id, 1)
+	if err != nil {
+		t.Fatalf("error creating signed prekey: %v", err)
+	}
+	preKeys, err := omemo.NewPreKeys(1, 1)
+	if err != nil {
+		t.Fatalf("error creating prekeys: %v", err)
+	}
+	return device{id: id, spk: spk, preKeys: preKeys}
+}
+
+// roundTrip marshals and unmarshals v to make sure the XML representations are
+// used in the tests.
+func roundTrip(t *testing.T, in xmlstream.Marshaler, out interface{}) {
+	t.Helper()
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	_, err := xmlstream.Copy(e, in.TokenReader())
+	if err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	err = e.Flush()
+	if err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	err = xml.NewDecoder(&buf).Decode(out)
+	if err != nil {
+		t.Fatalf("error decoding %s: %v", buf.String(), err)
+	}
+}
+
+func TestSession(t *testing.T) {
+	alice := newDevice(t)
+	bob := newDevice(t)
+
+	var bundle omemo.Bundle
+	roundTrip(t, omemo.NewBundle(bob.id, bob.spk, bob.preKeys), &bundle)
+	aliceSess, err := omemo.NewOutgoingSession(alice.id, bundle)
+	if err != nil {
+		t.Fatalf("error creating outgoing session: %v", err)
+	}
+
+	msg, preKey, err := aliceSess.Encrypt([]byte("one"))
+	if err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	if !preKey {
+		t.Fatalf("expected first message to be a prekey message")
+	}
+	info, ok := omemo.ParsePreKeyMessage(msg)
+	if !ok || !info.HasPreKey || info.PreKeyID != 1 || info.IdentityKey != alice.id.KeyPair().Public {
+		t.Fatalf("unexpected prekey info: %+v", info)
+	}
+	bobSess, plain, err := omemo.NewIncomingSession(bob.id, bob.spk, &bob.preKeys[0], msg)
+	if err != nil {
+		t.Fatalf("error creating incoming session: %v", err)
+	}
+	if string(plain) != "one" {
+		t.Fatalf("wrong plaintext: want=%q, got=%q", "one", plain)
+	}
+
+	// Send a few messages back and forth, including out of order ones, to
+	// exercise the ratchet.
+	send := func(from, to *omemo.Session, text string) []byte {
+		t.Helper()
+		msg, _, err := from.Encrypt([]byte(text))
+		if err != nil {
+			t.Fatalf("error encrypting %q: %v", text, err)
+		}
+		return msg
+	}
+	recv := func(s *omemo.Session, msg []byte, preKey bool, want string) {
+		t.Helper()
+		plain, err := s.Decrypt(msg, preKey)
+		if err != nil {
+			t.Fatalf("error decrypting %q: %v", want, err)
+		}
+		if string(plain) != want {
+			t.Fatalf("wrong plaintext: want=%q, got=%q", want, plain)
+		}
+	}
+
+	recv(aliceSess, send(bobSess, aliceSess, "two"), false, "two")
+	msg, preKey, err = aliceSess.Encrypt([]byte("three"))
+	if err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	if preKey {
+		t.Fatalf("did not expect a prekey message after a reply")
+	}
+	four := send(aliceSess, bobSess, "four")
+	recv(bobSess, four, false, "four")
+	recv(bobSess, msg, false, "three")
+	_, err = bobSess.Decrypt(msg, false)
+	if err != omemo.ErrDuplicate {
+		t.Fatalf("wrong error decrypting duplicate: want=%v, got=%v", omemo.ErrDuplicate, err)
+	}
+	for _, text := range []string{"five", "six"} {
+		recv(aliceSess, send(bobSess, aliceSess, text), false, text)
+		recv(bobSess, send(aliceSess, bobSess, text), false, text)
+	}
+
+	msg = send(aliceSess, bobSess, "tampered")
+	msg[len(msg)-1] ^= 1
+	_, err = bobSess.Decrypt(msg, false)
+	if err != omemo.ErrBadMAC {
+		t.Fatalf("wrong error decrypting tampered message: want=%v, got=%v", omemo.ErrBadMAC, err)
+	}
+}
+
+func TestBadSignature(t *testing.T) {
+	bob := newDevice(t)
+	b := omemo.NewBundle(bob.id, bob.spk, bob.preKeys)
+	b.SignedPreKeySignature[0] ^= 1
+	_, err := omemo.NewOutgoingSession(newDevice(t).id, b)
+	if err != omemo.ErrBadSignature {
+		t.Fatalf("wrong error: want=%v, got=%v", omemo.ErrBadSignature, err)
+	}
+}
+
+func TestPayload(t *testing.T) {
+	payload, iv, key, err := omemo.EncryptPayload([]byte("secret"))
+	if err != nil {
+		t.Fatalf("error encrypting: %v", err)
+	}
+	var e omemo.Encrypted
+	roundTrip(t, omemo.Encrypted{
+		SID:     1,
+		Keys:    []omemo.Key{{RID: 2, PreKey: true, Data: key}},
+		IV:      iv,
+		Payload: payload,
+	}, &e)
+	if e.SID != 1 || len(e.Keys) != 1 || e.Keys[0].RID != 2 || !e.Keys[0].PreKey {
+		t.Fatalf("wrong header: %+v", e)
+	}
+	plain, err := omemo.DecryptPayload(e.Payload, e.IV, e.Keys[0].Data)
+	if err != nil {
+		t.Fatalf("error decrypting: %v", err)
+	}
+	if string(plain) != "secret" {
+		t.Fatalf("wrong plaintext: want=%q, got=%q", "secret", plain)
+	}
+}
+
+func TestDeviceList(t *testing.T) {
+	var l omemo.DeviceList
+	roundTrip(t, omemo.DeviceList{1, 2, 3}, &l)
+	if len(l) != 3 || l[0] != 1 || l[2] != 3 {
+		t.Fatalf("wrong device list: %v", l)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+const (
+	payloadKeyLen = 16
+	payloadIVLen  = 12
+	payloadTagLen = 16
+)
+
+var errPayload = errors.New("omemo: invalid payload key")
+
+// EncryptPayload encrypts a message body with a new random key.
+// The returned key material (key and authentication tag) is what is encrypted
+// for each recipient device.
+func EncryptPayload(plain []byte) (payload, iv, keyMaterial []byte, err error) {
+	key := make([]byte, payloadKeyLen)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	iv = make([]byte, payloadIVLen)
+	_, err = rand.Read(iv)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sealed := aead.Seal(nil, iv, plain, nil)
+	ct, tag := sealed[:len(sealed)-payloadTagLen], sealed[len(sealed)-payloadTagLen:]
+	return ct, iv, append(key, tag...), nil
+}
+
+// DecryptPayload decrypts a message body using the key material decrypted from
+// the recipient's key element.
+func DecryptPayload(payload, iv, keyMaterial []byte) ([]byte, error) {
+	var key []byte
+	ct := append([]byte{}, payload...)
+	switch {
+	case len(keyMaterial) >= payloadKeyLen+payloadTagLen:
+		key = keyMaterial[:payloadKeyLen]
+		ct = append(ct, keyMaterial[payloadKeyLen:payloadKeyLen+payloadTagLen]...)
+	case len(keyMaterial) == payloadKeyLen:
+		// Some older clients append the tag to the payload instead.
+		key = keyMaterial
+	default:
+		return nil, errPayload
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, iv, ct, nil)
+}
@@ -0,0 +1,174 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The messages exchanged by sessions are small protocol buffers.
+// Only the handful of field types used by libsignal are supported here so that
+// we do not need to pull in a full protobuf implementation.
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+var errProto = errors.New("omemo: malformed protobuf")
+
+func appendVarint(b []byte, field int, v uint32) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|wireVarint))
+	return binary.AppendUvarint(b, uint64(v))
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|wireBytes))
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// parseProto calls f for each field in b.
+// Varint fields are passed as v, length delimited fields as data.
+func parseProto(b []byte, f func(field int, v uint32, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errProto
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case wireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errProto
+			}
+			b = b[n:]
+			err := f(field, uint32(v), nil)
+			if err != nil {
+				return err
+			}
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errProto
+			}
+			data := b[n : n+int(l)]
+			b = b[n+int(l):]
+			err := f(field, 0, data)
+			if err != nil {
+				return err
+			}
+		default:
+			return errProto
+		}
+	}
+	return nil
+}
+
+// whisperMessage is a message in an established session.
+type whisperMessage struct {
+	RatchetKey      PublicKey
+	Counter         uint32
+	PreviousCounter uint32
+	Ciphertext      []byte
+}
+
+func (m whisperMessage) marshal() []byte {
+	b := []byte{messageVersion}
+	b = appendBytes(b, 1, m.RatchetKey.Serialize())
+	b = appendVarint(b, 2, m.Counter)
+	b = appendVarint(b, 3, m.PreviousCounter)
+	return appendBytes(b, 4, m.Ciphertext)
+}
+
+// parseWhisperMessage parses a message and returns it along with the portion
+// of the input covered by the MAC and the MAC itself.
+func parseWhisperMessage(b []byte) (whisperMessage, []byte, []byte, error) {
+	var m whisperMessage
+	if len(b) < 1+macLen || b[0]>>4 != messageVersion>>4 {
+		return m, nil, nil, errProto
+	}
+	body, mac := b[:len(b)-macLen], b[len(b)-macLen:]
+	var haveKey bool
+	err := parseProto(body[1:], func(field int, v uint32, data []byte) error {
+		var err error
+		switch field {
+		case 1:
+			m.RatchetKey, err = ParsePublicKey(data)
+			haveKey = true
+		case 2:
+			m.Counter = v
+		case 3:
+			m.PreviousCounter = v
+		case 4:
+			m.Ciphertext = data
+		}
+		return err
+	})
+	if err == nil && (!haveKey || m.Ciphertext == nil) {
+		err = errProto
+	}
+	return m, body, mac, err
+}
+
+// preKeyWhisperMessage is the first message in a session, which carries the
+// information needed for the recipient to build the session.
+type preKeyWhisperMessage struct {
+	RegistrationID uint32
+	PreKeyID       uint32
+	HasPreKey      bool
+	SignedPreKeyID uint32
+	BaseKey        PublicKey
+	IdentityKey    PublicKey
+	Message        []byte
+}
+
+func (m preKeyWhisperMessage) marshal() []byte {
+	b := []byte{messageVersion}
+	b = appendVarint(b, 5, m.RegistrationID)
+	if m.HasPreKey {
+		b = appendVarint(b, 1, m.PreKeyID)
+	}
+	b = appendVarint(b, 6, m.SignedPreKeyID)
+	b = appendBytes(b, 2, m.BaseKey.Serialize())
+	b = appendBytes(b, 3, m.IdentityKey.Serialize())
+	return appendBytes(b, 4, m.Message)
+}
+
+func parsePreKeyWhisperMessage(b []byte) (preKeyWhisperMessage, error) {
+	var m preKeyWhisperMessage
+	if len(b) < 1 || b[0]>>4 != messageVersion>>4 {
+		return m, errProto
+	}
+	var haveBase, haveIdent bool
+	err := parseProto(b[1:], func(field int, v uint32, data []byte) error {
+		var err error
+		switch field {
+		case 1:
+			m.PreKeyID = v
+			m.HasPreKey = true
+		case 2:
+			m.BaseKey, err = ParsePublicKey(data)
+			haveBase = true
+		case 3:
+			m.IdentityKey, err = ParsePublicKey(data)
+			haveIdent = true
+		case 4:
+			m.Message = data
+		case 5:
+			m.RegistrationID = v
+		case 6:
+			m.SignedPreKeyID = v
+		}
+		return err
+	})
+	if err == nil && (!haveBase || !haveIdent || m.Message == nil) {
+		err = errProto
+	}
+	return m, err
+}
@@ -0,0 +1,468 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// messageVersion is the current and minimum supported version of the
+	// message format, both packed into a single byte.
+	messageVersion = 3<<4 | 3
+	macLen         = 8
+
+	// maxSkip is the maximum number of message keys we will derive and hold on
+	// to for messages that have not arrived yet.
+	maxSkip = 2000
+	// maxReceiverChains is the number of old receiver chains that are kept
+	// around for delayed messages.
+	maxReceiverChains = 5
+)
+
+// Errors returned when decrypting messages.
+var (
+	ErrBadSignature = errors.New("omemo: invalid signed prekey signature")
+	ErrBadMAC       = errors.New("omemo: message authentication failed")
+	ErrDuplicate    = errors.New("omemo: message key already used")
+	ErrTooFarAhead  = errors.New("omemo: message too far in the future")
+	ErrNoSession    = errors.New("omemo: no session for message")
+)
+
+type chain struct {
+	RatchetKey PublicKey
+	Key        []byte
+	Index      uint32
+}
+
+func (c *chain) next() []byte {
+	seed := hmacSHA256(c.Key, []byte{0x01})
+	c.Key = hmacSHA256(c.Key, []byte{0x02})
+	c.Index++
+	return seed
+}
+
+type skippedKey struct {
+	RatchetKey PublicKey
+	Index      uint32
+	Seed       []byte
+}
+
+// pendingPreKey holds the information needed to let the other side build a
+// session until they acknowledge it by replying.
+type pendingPreKey struct {
+	HasPreKey      bool
+	PreKeyID       uint32
+	SignedPreKeyID uint32
+	BaseKey        PublicKey
+}
+
+// Session is a double ratchet session with a single remote device.
+// Sessions can be stored by marshaling them as JSON.
+type Session struct {
+	LocalIdentity   PublicKey
+	RemoteIdentity  PublicKey
+	RegistrationID  uint32
+	RootKey         []byte
+	SenderRatchet   KeyPair
+	SenderChain     chain
+	PreviousCounter uint32
+	ReceiverChains  []chain
+	Skipped         []skippedKey
+	Pending         *pendingPreKey
+}
+
+// NewOutgoingSession starts a session with the device that published b.
+// The first messages encrypted with the session carry the information the
+// other side needs to build its half of the session.
+func NewOutgoingSession(own Identity, b Bundle) (*Session, error) {
+	if !verify(b.IdentityKey, b.SignedPreKey.Serialize(), b.SignedPreKeySignature) {
+		return nil, ErrBadSignature
+	}
+	ik := own.KeyPair()
+	base, err := GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := &pendingPreKey{
+		SignedPreKeyID: b.SignedPreKeyID,
+		BaseKey:        base.Public,
+	}
+	secrets := [][]byte{}
+	for _, pair := range []struct {
+		priv [32]byte
+		pub  PublicKey
+	}{
+		{ik.Private, b.SignedPreKey},
+		{base.Private, b.IdentityKey},
+		{base.Private, b.SignedPreKey},
+	} {
+		s, err := dh(pair.priv, pair.pub)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, s)
+	}
+	if len(b.PreKeys) > 0 {
+		n, err := rand.Int(randReader, big.NewInt(int64(len(b.PreKeys))))
+		if err != nil {
+			return nil, err
+		}
+		pk := b.PreKeys[n.Int64()]
+		s, err := dh(base.Private, pk.Key)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, s)
+		pending.HasPreKey = true
+		pending.PreKeyID = pk.ID
+	}
+	root, chainKey := deriveInitial(secrets)
+
+	ratchet, err := GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	root, sendKey, err := kdfRoot(root, b.SignedPreKey, ratchet)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		LocalIdentity:  ik.Public,
+		RemoteIdentity: b.IdentityKey,
+		RegistrationID: own.DeviceID,
+		RootKey:        root,
+		SenderRatchet:  ratchet,
+		SenderChain:    chain{RatchetKey: ratchet.Public, Key: sendKey},
+		ReceiverChains: []chain{{RatchetKey: b.SignedPreKey, Key: chainKey}},
+		Pending:        pending,
+	}, nil
+}
+
+// PreKeyInfo is the information needed to look up the keys used by a remote
+// device to build a session with us.
+type PreKeyInfo struct {
+	HasPreKey      bool
+	PreKeyID       uint32
+	SignedPreKeyID uint32
+	IdentityKey    PublicKey
+}
+
+// ParsePreKeyMessage returns the prekeys and identity used by an incoming
+// session setup message so that they can be loaded before calling
+// NewIncomingSession.
+// If msg is not a prekey message, ok is false.
+func ParsePreKeyMessage(msg []byte) (info PreKeyInfo, ok bool) {
+	m, err := parsePreKeyWhisperMessage(msg)
+	if err != nil {
+		return info, false
+	}
+	return PreKeyInfo{
+		HasPreKey:      m.HasPreKey,
+		PreKeyID:       m.PreKeyID,
+		SignedPreKeyID: m.SignedPreKeyID,
+		IdentityKey:    m.IdentityKey,
+	}, true
+}
+
+// NewIncomingSession builds a session from a prekey message sent by another
+// device and decrypts the message.
+// If the message did not use a one time prekey, preKey may be nil.
+func NewIncomingSession(own Identity, spk SignedPreKey, preKey *PreKey, msg []byte) (*Session, []byte, error) {
+	m, err := parsePreKeyWhisperMessage(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.SignedPreKeyID != spk.ID || (m.HasPreKey && (preKey == nil || preKey.ID != m.PreKeyID)) {
+		return nil, nil, ErrNoSession
+	}
+	ik := own.KeyPair()
+
+	secrets := [][]byte{}
+	for _, pair := range []struct {
+		priv [32]byte
+		pub  PublicKey
+	}{
+		{spk.KeyPair.Private, m.IdentityKey},
+		{ik.Private, m.BaseKey},
+		{spk.KeyPair.Private, m.BaseKey},
+	} {
+		s, err := dh(pair.priv, pair.pub)
+		if err != nil {
+			return nil, nil, err
+		}
+		secrets = append(secrets, s)
+	}
+	if m.HasPreKey {
+		s, err := dh(preKey.KeyPair.Private, m.BaseKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		secrets = append(secrets, s)
+	}
+	root, chainKey := deriveInitial(secrets)
+	s := &Session{
+		LocalIdentity:  ik.Public,
+		RemoteIdentity: m.IdentityKey,
+		RegistrationID: own.DeviceID,
+		RootKey:        root,
+		SenderRatchet:  spk.KeyPair,
+		SenderChain:    chain{RatchetKey: spk.KeyPair.Public, Key: chainKey},
+	}
+	plain, err := s.decryptWhisper(m.Message)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, plain, nil
+}
+
+// Encrypt encrypts plain and advances the sending chain.
+// If the other side has not yet replied to us, the message is wrapped so that
+// it can be used to build the session and preKey is true.
+func (s *Session) Encrypt(plain []byte) (msg []byte, preKey bool, err error) {
+	cipherKey, macKey, iv := messageKeys(s.SenderChain.next())
+	ct, err := encryptCBC(cipherKey, iv, plain)
+	if err != nil {
+		return nil, false, err
+	}
+	body := whisperMessage{
+		RatchetKey:      s.SenderRatchet.Public,
+		Counter:         s.SenderChain.Index - 1,
+		PreviousCounter: s.PreviousCounter,
+		Ciphertext:      ct,
+	}.marshal()
+	msg = append(body, s.mac(macKey, s.LocalIdentity, s.RemoteIdentity, body)...)
+
+	if s.Pending == nil {
+		return msg, false, nil
+	}
+	return preKeyWhisperMessage{
+		RegistrationID: s.RegistrationID,
+		PreKeyID:       s.Pending.PreKeyID,
+		HasPreKey:      s.Pending.HasPreKey,
+		SignedPreKeyID: s.Pending.SignedPreKeyID,
+		BaseKey:        s.Pending.BaseKey,
+		IdentityKey:    s.LocalIdentity,
+		Message:        msg,
+	}.marshal(), true, nil
+}
+
+// Decrypt decrypts a message sent by the other side of the session.
+// If preKey is true the message is a session setup message for this session
+// (for example, one that was resent because our reply was lost).
+// The session is only modified if decryption succeeds.
+func (s *Session) Decrypt(msg []byte, preKey bool) ([]byte, error) {
+	if preKey {
+		m, err := parsePreKeyWhisperMessage(msg)
+		if err != nil {
+			return nil, err
+		}
+		if m.IdentityKey != s.RemoteIdentity {
+			return nil, ErrNoSession
+		}
+		msg = m.Message
+	}
+	c, err := s.clone()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := c.decryptWhisper(msg)
+	if err != nil {
+		return nil, err
+	}
+	*s = *c
+	return plain, nil
+}
+
+func (s *Session) decryptWhisper(msg []byte) ([]byte, error) {
+	m, body, mac, err := parseWhisperMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var seed []byte
+	idx := -1
+	for i, c := range s.ReceiverChains {
+		if c.RatchetKey == m.RatchetKey {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		idx, err = s.ratchet(m.RatchetKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c := &s.ReceiverChains[idx]
+	switch {
+	case m.Counter < c.Index:
+		for i, k := range s.Skipped {
+			if k.RatchetKey == m.RatchetKey && k.Index == m.Counter {
+				seed = k.Seed
+				s.Skipped = append(s.Skipped[:i], s.Skipped[i+1:]...)
+				break
+			}
+		}
+		if seed == nil {
+			return nil, ErrDuplicate
+		}
+	case m.Counter-c.Index > maxSkip:
+		return nil, ErrTooFarAhead
+	default:
+		for c.Index < m.Counter {
+			k := skippedKey{RatchetKey: c.RatchetKey, Index: c.Index}
+			k.Seed = c.next()
+			s.Skipped = append(s.Skipped, k)
+		}
+		if over := len(s.Skipped) - maxSkip; over > 0 {
+			s.Skipped = s.Skipped[over:]
+		}
+		seed = c.next()
+	}
+
+	cipherKey, macKey, iv := messageKeys(seed)
+	if !hmac.Equal(mac, s.mac(macKey, s.RemoteIdentity, s.LocalIdentity, body)) {
+		return nil, ErrBadMAC
+	}
+	plain, err := decryptCBC(cipherKey, iv, m.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	// The other side has built the session, so we no longer need to send the
+	// information needed to build it.
+	s.Pending = nil
+	return plain, nil
+}
+
+// ratchet performs a DH ratchet step when a new ratchet key is seen and
+// returns the index of the new receiver chain.
+func (s *Session) ratchet(theirs PublicKey) (int, error) {
+	root, recvKey, err := kdfRoot(s.RootKey, theirs, s.SenderRatchet)
+	if err != nil {
+		return 0, err
+	}
+	ours, err := GenerateKeyPair()
+	if err != nil {
+		return 0, err
+	}
+	root, sendKey, err := kdfRoot(root, theirs, ours)
+	if err != nil {
+		return 0, err
+	}
+	s.PreviousCounter = 0
+	if s.SenderChain.Index > 0 {
+		s.PreviousCounter = s.SenderChain.Index - 1
+	}
+	s.RootKey = root
+	s.SenderRatchet = ours
+	s.SenderChain = chain{RatchetKey: ours.Public, Key: sendKey}
+	s.ReceiverChains = append(s.ReceiverChains, chain{RatchetKey: theirs, Key: recvKey})
+	if over := len(s.ReceiverChains) - maxReceiverChains; over > 0 {
+		s.ReceiverChains = s.ReceiverChains[over:]
+	}
+	return len(s.ReceiverChains) - 1, nil
+}
+
+func (s *Session) mac(key []byte, sender, receiver PublicKey, body []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(sender.Serialize())
+	h.Write(receiver.Serialize())
+	h.Write(body)
+	return h.Sum(nil)[:macLen]
+}
+
+func (s *Session) clone() (*Session, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	c := &Session{}
+	return c, json.Unmarshal(b, c)
+}
+
+func deriveInitial(secrets [][]byte) (root, chainKey []byte) {
+	master := bytes.Repeat([]byte{0xff}, 32)
+	for _, s := range secrets {
+		master = append(master, s...)
+	}
+	out := hkdfBytes(master, nil, "WhisperText", 64)
+	return out[:32], out[32:]
+}
+
+func kdfRoot(rootKey []byte, theirs PublicKey, ours KeyPair) (root, chainKey []byte, err error) {
+	secret, err := dh(ours.Private, theirs)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := hkdfBytes(secret, rootKey, "WhisperRatchet", 64)
+	return out[:32], out[32:], nil
+}
+
+func messageKeys(seed []byte) (cipherKey, macKey, iv []byte) {
+	out := hkdfBytes(seed, nil, "WhisperMessageKeys", 80)
+	return out[:32], out[32:64], out[64:]
+}
+
+func hkdfBytes(secret, salt []byte, info string, n int) []byte {
+	if salt == nil {
+		salt = make([]byte, 32)
+	}
+	out := make([]byte, n)
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), out)
+	if err != nil {
+		// HKDF can only fail if too much output is requested.
+		panic(err)
+	}
+	return out
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func encryptCBC(key, iv, plain []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	buf := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(buf, buf)
+	return buf, nil
+}
+
+var errPadding = errors.New("omemo: invalid padding")
+
+func decryptCBC(key, iv, ct []byte) ([]byte, error) {
+	if len(ct) == 0 || len(ct)%aes.BlockSize != 0 {
+		return nil, errPadding
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, len(ct))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(buf, ct)
+	pad := int(buf[len(buf)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errPadding
+	}
+	return buf[:len(buf)-pad], nil
+}
@@ -0,0 +1,28 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+// Trust is the trust placed in the identity key of a device.
+type Trust int
+
+// A list of trust levels.
+// Devices that have not been verified are undecided and messages are still
+// encrypted for them, only devices that are explicitly untrusted are skipped.
+const (
+	TrustUndecided Trust = iota
+	Trusted
+	Untrusted
+)
+
+// Device is a remote device and the trust placed in it.
+type Device struct {
+	ID uint32
+	// Identity is the identity key of the device, or the zero value if we have
+	// not yet fetched its bundle.
+	Identity PublicKey
+	Trust    Trust
+	// Active is false if the device was removed from the device list.
+	Active bool
+}
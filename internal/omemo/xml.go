@@ -0,0 +1,274 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package omemo
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"strconv"
+
+	"mellium.im/xmlstream"
+)
+
+// Namespaces and PEP nodes used by OMEMO.
+const (
+	NS          = "eu.siacs.conversations.axolotl"
+	NodeDevices = NS + ".devicelist"
+	// NodeBundles is the prefix of the node used to publish each device's
+	// bundle, the device ID is appended to it.
+	NodeBundles = NS + ".bundles:"
+)
+
+// BundleNode returns the PEP node where the bundle for device id is published.
+func BundleNode(id uint32) string {
+	return NodeBundles + strconv.FormatUint(uint64(id), 10)
+}
+
+func b64(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func unb64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(s)
+}
+
+func wrapChars(s string, start xml.StartElement) xml.TokenReader {
+	return xmlstream.Wrap(xmlstream.Token(xml.CharData(s)), start)
+}
+
+func attrID(name string, id uint32) xml.Attr {
+	return xml.Attr{Name: xml.Name{Local: name}, Value: strconv.FormatUint(uint64(id), 10)}
+}
+
+// DeviceList is the list of device IDs published by an account.
+type DeviceList []uint32
+
+// TokenReader implements xmlstream.Marshaler.
+func (l DeviceList) TokenReader() xml.TokenReader {
+	var inner []xml.TokenReader
+	for _, id := range l {
+		inner = append(inner, xmlstream.Wrap(nil, xml.StartElement{
+			Name: xml.Name{Local: "device"},
+			Attr: []xml.Attr{attrID("id", id)},
+		}))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "list"}},
+	)
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (l *DeviceList) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var list struct {
+		Devices []struct {
+			ID uint32 `xml:"id,attr"`
+		} `xml:"device"`
+	}
+	err := d.DecodeElement(&list, &start)
+	if err != nil {
+		return err
+	}
+	*l = (*l)[:0]
+	for _, dev := range list.Devices {
+		*l = append(*l, dev.ID)
+	}
+	return nil
+}
+
+// BundlePreKey is a public one time prekey published in a bundle.
+type BundlePreKey struct {
+	ID  uint32
+	Key PublicKey
+}
+
+// Bundle is the public key material published by a device so that others can
+// start sessions with it.
+type Bundle struct {
+	SignedPreKeyID        uint32
+	SignedPreKey          PublicKey
+	SignedPreKeySignature []byte
+	IdentityKey           PublicKey
+	PreKeys               []BundlePreKey
+}
+
+// NewBundle returns the bundle to publish for our own device.
+func NewBundle(id Identity, spk SignedPreKey, preKeys []PreKey) Bundle {
+	b := Bundle{
+		SignedPreKeyID:        spk.ID,
+		SignedPreKey:          spk.KeyPair.Public,
+		SignedPreKeySignature: spk.Signature,
+		IdentityKey:           id.KeyPair().Public,
+	}
+	for _, pk := range preKeys {
+		b.PreKeys = append(b.PreKeys, BundlePreKey{ID: pk.ID, Key: pk.KeyPair.Public})
+	}
+	return b
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (b Bundle) TokenReader() xml.TokenReader {
+	var preKeys []xml.TokenReader
+	for _, pk := range b.PreKeys {
+		preKeys = append(preKeys, wrapChars(b64(pk.Key.Serialize()), xml.StartElement{
+			Name: xml.Name{Local: "preKeyPublic"},
+			Attr: []xml.Attr{attrID("preKeyId", pk.ID)},
+		}))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(
+			wrapChars(b64(b.SignedPreKey.Serialize()), xml.StartElement{
+				Name: xml.Name{Local: "signedPreKeyPublic"},
+				Attr: []xml.Attr{attrID("signedPreKeyId", b.SignedPreKeyID)},
+			}),
+			wrapChars(b64(b.SignedPreKeySignature), xml.StartElement{Name: xml.Name{Local: "signedPreKeySignature"}}),
+			wrapChars(b64(b.IdentityKey.Serialize()), xml.StartElement{Name: xml.Name{Local: "identityKey"}}),
+			xmlstream.Wrap(
+				xmlstream.MultiReader(preKeys...),
+				xml.StartElement{Name: xml.Name{Local: "prekeys"}},
+			),
+		),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "bundle"}},
+	)
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (b *Bundle) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var raw struct {
+		SPK struct {
+			ID  uint32 `xml:"signedPreKeyId,attr"`
+			Key string `xml:",chardata"`
+		} `xml:"signedPreKeyPublic"`
+		Sig      string `xml:"signedPreKeySignature"`
+		Identity string `xml:"identityKey"`
+		PreKeys  []struct {
+			ID  uint32 `xml:"preKeyId,attr"`
+			Key string `xml:",chardata"`
+		} `xml:"prekeys>preKeyPublic"`
+	}
+	err := d.DecodeElement(&raw, &start)
+	if err != nil {
+		return err
+	}
+	parseKey := func(s string) (PublicKey, error) {
+		data, err := unb64(s)
+		if err != nil {
+			return PublicKey{}, err
+		}
+		return ParsePublicKey(data)
+	}
+	b.SignedPreKeyID = raw.SPK.ID
+	b.SignedPreKey, err = parseKey(raw.SPK.Key)
+	if err != nil {
+		return err
+	}
+	b.SignedPreKeySignature, err = unb64(raw.Sig)
+	if err != nil {
+		return err
+	}
+	b.IdentityKey, err = parseKey(raw.Identity)
+	if err != nil {
+		return err
+	}
+	b.PreKeys = b.PreKeys[:0]
+	for _, pk := range raw.PreKeys {
+		key, err := parseKey(pk.Key)
+		if err != nil {
+			// Skip bad prekeys, there are usually plenty of others.
+			continue
+		}
+		b.PreKeys = append(b.PreKeys, BundlePreKey{ID: pk.ID, Key: key})
+	}
+	return nil
+}
+
+// Key is the payload key encrypted for a single recipient device.
+type Key struct {
+	RID    uint32
+	PreKey bool
+	Data   []byte
+}
+
+// Encrypted is an encrypted message element.
+type Encrypted struct {
+	SID     uint32
+	Keys    []Key
+	IV      []byte
+	Payload []byte
+}
+
+// TokenReader implements xmlstream.Marshaler.
+func (e Encrypted) TokenReader() xml.TokenReader {
+	var header []xml.TokenReader
+	for _, k := range e.Keys {
+		attrs := []xml.Attr{attrID("rid", k.RID)}
+		if k.PreKey {
+			attrs = append(attrs, xml.Attr{Name: xml.Name{Local: "prekey"}, Value: "true"})
+		}
+		header = append(header, wrapChars(b64(k.Data), xml.StartElement{
+			Name: xml.Name{Local: "key"},
+			Attr: attrs,
+		}))
+	}
+	header = append(header, wrapChars(b64(e.IV), xml.StartElement{Name: xml.Name{Local: "iv"}}))
+	inner := []xml.TokenReader{
+		xmlstream.Wrap(
+			xmlstream.MultiReader(header...),
+			xml.StartElement{
+				Name: xml.Name{Local: "header"},
+				Attr: []xml.Attr{attrID("sid", e.SID)},
+			},
+		),
+	}
+	if e.Payload != nil {
+		inner = append(inner, wrapChars(b64(e.Payload), xml.StartElement{Name: xml.Name{Local: "payload"}}))
+	}
+	return xmlstream.Wrap(
+		xmlstream.MultiReader(inner...),
+		xml.StartElement{Name: xml.Name{Space: NS, Local: "encrypted"}},
+	)
+}
+
+// UnmarshalXML implements xml.Unmarshaler.
+func (e *Encrypted) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var raw struct {
+		Header struct {
+			SID  uint32 `xml:"sid,attr"`
+			Keys []struct {
+				RID    uint32 `xml:"rid,attr"`
+				PreKey string `xml:"prekey,attr"`
+				Data   string `xml:",chardata"`
+			} `xml:"key"`
+			IV string `xml:"iv"`
+		} `xml:"header"`
+		Payload *string `xml:"payload"`
+	}
+	err := d.DecodeElement(&raw, &start)
+	if err != nil {
+		return err
+	}
+	e.SID = raw.Header.SID
+	e.IV, err = unb64(raw.Header.IV)
+	if err != nil {
+		return err
+	}
+	e.Keys = e.Keys[:0]
+	for _, k := range raw.Header.Keys {
+		data, err := unb64(k.Data)
+		if err != nil {
+			return err
+		}
+		e.Keys = append(e.Keys, Key{
+			RID:    k.RID,
+			PreKey: k.PreKey == "true" || k.PreKey == "1",
+			Data:   data,
+		})
+	}
+	e.Payload = nil
+	if raw.Payload != nil {
+		e.Payload, err = unb64(*raw.Payload)
+	}
+	return err
+}
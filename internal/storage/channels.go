@@ -0,0 +1,116 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/localerr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+type channelQueries struct {
+	mergeReflection *sql.Stmt
+	channelAfterID  *sql.Stmt
+	channelBeforeID *sql.Stmt
+}
+
+func prepareChannels(ctx context.Context, db *sql.DB, wrapDB *DB) error {
+	var err error
+	wrapDB.mergeReflection, err = db.PrepareContext(ctx, `
+UPDATE messages
+	SET archiveID=IFNULL(archiveID, (
+			-- The message may already have been stored from the archive.
+			SELECT $3 WHERE NOT EXISTS (SELECT 1 FROM messages WHERE archiveID=$3))),
+		nick=IFNULL(NULLIF($4, ''), nick)
+	WHERE rosterJID=$1 AND originID=$2 AND sent=TRUE AND stanzaType='groupchat'`)
+	if err != nil {
+		return err
+	}
+	wrapDB.channelAfterID, err = db.PrepareContext(ctx, `
+SELECT archiveID
+	FROM messages
+	WHERE rosterJID=$1 AND stanzaType='groupchat' AND archiveID IS NOT NULL
+	ORDER BY delay DESC, id DESC
+	LIMIT 1`)
+	if err != nil {
+		return err
+	}
+	wrapDB.channelBeforeID, err = db.PrepareContext(ctx, `
+SELECT archiveID
+	FROM messages
+	WHERE rosterJID=$1 AND stanzaType='groupchat' AND archiveID IS NOT NULL
+	ORDER BY delay ASC, id ASC
+	LIMIT 1`)
+	return err
+}
+
+// MergeReflection reports whether msg is a channel sending a message that we
+// sent back to us.
+// If it is, the nickname and the stanza ID assigned by the channel are added to
+// the message we stored when sending it and msg should not be inserted again.
+func (db *DB) MergeReflection(ctx context.Context, msg event.ChatMessage) (bool, error) {
+	if msg.Sent || msg.Type != stanza.GroupChatMessage {
+		return false, nil
+	}
+	originID := msg.OriginID.ID
+	if originID == "" {
+		originID = msg.ID
+	}
+	if originID == "" {
+		return false, nil
+	}
+	room := msg.From.Bare()
+	var archiveID *string
+	for _, sid := range msg.SID {
+		if sid.By.Equal(room) {
+			archiveID = &sid.ID
+			break
+		}
+	}
+	var merged bool
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.Stmt(db.mergeReflection).ExecContext(ctx, room.String(), originID, archiveID, msg.From.Resourcepart())
+		if err != nil {
+			return localerr.Wrap(db.p, "error merging reflected message %q: %v", originID, err)
+		}
+		n, err := res.RowsAffected()
+		merged = n > 0
+		return err
+	})
+	return merged, err
+}
+
+// ChannelAfterID returns the most recent stanza ID assigned by the channel to
+// any of the messages we have stored for it.
+// If there are none, the empty string is returned.
+func (db *DB) ChannelAfterID(ctx context.Context, room jid.JID) (string, error) {
+	return db.channelID(ctx, db.channelAfterID, room)
+}
+
+// ChannelBeforeID returns the oldest stanza ID assigned by the channel to any
+// of the messages we have stored for it.
+// If there are none, the empty string is returned.
+func (db *DB) ChannelBeforeID(ctx context.Context, room jid.JID) (string, error) {
+	return db.channelID(ctx, db.channelBeforeID, room)
+}
+
+func (db *DB) channelID(ctx context.Context, stmt *sql.Stmt, room jid.JID) (string, error) {
+	var id string
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.Stmt(stmt).QueryRowContext(ctx, room.Bare().String()).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return "", localerr.Wrap(db.p, "error getting the stored history of %s: %v", room.Bare(), err)
+	}
+	return id, nil
+}
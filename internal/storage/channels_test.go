@@ -0,0 +1,186 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/storage/storagetest"
+	"mellium.im/xmpp/jid"
+)
+
+var (
+	nurseNick = jid.MustParse("capulets@muc.example.com/nurse")
+	romeoNick = jid.MustParse("capulets@muc.example.com/romeo")
+)
+
+type channelLine struct {
+	ID        string
+	Nick      string
+	ArchiveID string
+}
+
+func channelLines(iter storage.MessageIter) channelLine {
+	return channelLine{ID: iter.Message().ID, Nick: iter.Message().From.Resourcepart(), ArchiveID: iter.ArchiveID()}
+}
+
+// reflection returns our message with the given ID as it is sent back to us by
+// the channel.
+func reflection(id, archiveID string) event.ChatMessage {
+	m := withOrigin(groupMsg(id, romeoNick, self, "My dear?"), id)
+	if archiveID != "" {
+		m = withSID(m, archiveID, room)
+	}
+	return m
+}
+
+var reflectionTestCases = [...]struct {
+	msg    event.ChatMessage
+	merged bool
+	expect []channelLine
+}{
+	// The channel sends our own message back to us with its stanza ID and our
+	// nickname, which are added to the message we already have.
+	0: {
+		msg:    reflection("2", "b"),
+		merged: true,
+		expect: []channelLine{{ID: "1", Nick: "nurse", ArchiveID: "a"}, {ID: "2", Nick: "romeo", ArchiveID: "b"}},
+	},
+	// A stanza ID assigned by someone other than the channel is ignored.
+	1: {
+		msg:    withSID(reflection("2", ""), "b", romeoNick),
+		merged: true,
+		expect: []channelLine{{ID: "1", Nick: "nurse", ArchiveID: "a"}, {ID: "2", Nick: "romeo"}},
+	},
+	// A stanza ID that is already used is not assigned twice.
+	2: {
+		msg:    reflection("2", "a"),
+		merged: true,
+		expect: []channelLine{{ID: "1", Nick: "nurse", ArchiveID: "a"}, {ID: "2", Nick: "romeo"}},
+	},
+	// Messages from someone else, or that we never sent, are not merged.
+	3: {
+		msg:    withSID(groupMsg("3", nurseNick, self, "What o'clock to-morrow?"), "c", room),
+		expect: []channelLine{{ID: "1", Nick: "nurse", ArchiveID: "a"}, {ID: "2"}},
+	},
+	4: {
+		msg:    reflection("unknown", "c"),
+		expect: []channelLine{{ID: "1", Nick: "nurse", ArchiveID: "a"}, {ID: "2"}},
+	},
+	5: {
+		msg:    reflection("", ""),
+		expect: []channelLine{{ID: "1", Nick: "nurse", ArchiveID: "a"}, {ID: "2"}},
+	},
+	// Only messages in channels are reflected.
+	6: {
+		msg:    withOrigin(msg("2", juliet, self, "My dear?"), "2"),
+		expect: []channelLine{{ID: "1", Nick: "nurse", ArchiveID: "a"}, {ID: "2"}},
+	},
+}
+
+func TestMergeReflection(t *testing.T) {
+	for i, tc := range reflectionTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			db := storagetest.InsertMsgs(t, self,
+				withSID(groupMsg("1", nurseNick, self, "Romeo!"), "a", room),
+				withOrigin(groupMsg("2", self, room, "My dear?"), "2"),
+			)
+			merged, err := db.MergeReflection(context.Background(), tc.msg)
+			if err != nil {
+				t.Fatalf("error merging reflection: %v", err)
+			}
+			if merged != tc.merged {
+				t.Errorf("wrong merge result: want=%t, got=%t", tc.merged, merged)
+			}
+			if got := history(t, db, room, channelLines); !slices.Equal(got, tc.expect) {
+				t.Errorf("wrong history: want=%v, got=%v", tc.expect, got)
+			}
+		})
+	}
+}
+
+var channelIDTestCases = [...]struct {
+	msgs   []event.ChatMessage
+	with   jid.JID
+	before string
+	after  string
+}{
+	0: {with: room},
+	1: {
+		msgs: []event.ChatMessage{
+			withSID(groupMsg("1", nurseNick, self, "Romeo!"), "a", room),
+			// Messages without a stanza ID are skipped.
+			groupMsg("2", nurseNick, self, "Romeo!"),
+			withSID(groupMsg("3", nurseNick, self, "Romeo!"), "c", room),
+		},
+		with:   room,
+		before: "a",
+		after:  "c",
+	},
+	// Messages are ordered by when they were sent, not when they were stored.
+	2: {
+		msgs: []event.ChatMessage{
+			withDelay(withSID(groupMsg("1", nurseNick, self, "Romeo!"), "b", room), storagetest.Day(2)),
+			withDelay(withSID(groupMsg("2", nurseNick, self, "Romeo!"), "a", room), storagetest.Day(1)),
+			withDelay(withSID(groupMsg("3", nurseNick, self, "Romeo!"), "c", room), storagetest.Day(3)),
+		},
+		with:   room,
+		before: "a",
+		after:  "c",
+	},
+	// Stanza IDs in chats are assigned by our own archive, not a channel.
+	3: {
+		msgs: []event.ChatMessage{withSID(msg("1", juliet, self, "Romeo!"), "a", self)},
+		with: juliet,
+	},
+	4: {
+		msgs: []event.ChatMessage{withSID(groupMsg("1", nurseNick, self, "Romeo!"), "a", room)},
+		with: jid.MustParse("montagues@muc.example.com"),
+	},
+}
+
+func TestChannelIDs(t *testing.T) {
+	for i, tc := range channelIDTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ctx := context.Background()
+			db := storagetest.InsertMsgs(t, self, tc.msgs...)
+			before, err := db.ChannelBeforeID(ctx, tc.with)
+			if err != nil {
+				t.Fatalf("error getting first archive ID: %v", err)
+			}
+			if before != tc.before {
+				t.Errorf("wrong first archive ID: want=%q, got=%q", tc.before, before)
+			}
+			after, err := db.ChannelAfterID(ctx, tc.with)
+			if err != nil {
+				t.Fatalf("error getting last archive ID: %v", err)
+			}
+			if after != tc.after {
+				t.Errorf("wrong last archive ID: want=%q, got=%q", tc.after, after)
+			}
+		})
+	}
+}
+
+func TestChannelsCanceled(t *testing.T) {
+	db := storagetest.InsertMsgs(t, self, withOrigin(groupMsg("1", self, room, "My dear?"), "1"))
+	if _, err := db.MergeReflection(canceled(), reflection("1", "a")); err == nil {
+		t.Errorf("expected error merging reflection")
+	}
+	if _, err := db.ChannelBeforeID(canceled(), room); err == nil {
+		t.Errorf("expected error getting first archive ID")
+	}
+	if _, err := db.ChannelAfterID(canceled(), room); err == nil {
+		t.Errorf("expected error getting last archive ID")
+	}
+	if got := history(t, db, room, channelLines); !slices.Equal(got, []channelLine{{ID: "1"}}) {
+		t.Errorf("reflection was merged: %v", got)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"mellium.im/communique/internal/localerr"
+	"mellium.im/xmpp/jid"
+)
+
+// Conversation is an entry in the conversations list.
+type Conversation struct {
+	JID    jid.JID
+	Name   string
+	Room   bool
+	Pinned bool
+	// Unread is the number of messages received since the conversation was last
+	// read and FirstUnread is the ID of the oldest of them.
+	Unread       int
+	FirstUnread  string
+	LastActivity time.Time
+}
+
+type conversationQueries struct {
+	upsertConv  *sql.Stmt
+	pinConv     *sql.Stmt
+	closeConv   *sql.Stmt
+	setUnread   *sql.Stmt
+	selectConvs *sql.Stmt
+}
+
+func prepareConversations(ctx context.Context, db *sql.DB, wrapDB *DB) error {
+	var err error
+	wrapDB.upsertConv, err = db.PrepareContext(ctx, `
+INSERT INTO conversations (rosterJID, name, room, unread, firstUnread, lastActivity)
+	VALUES ($1, $2, $3, IIF($4, 1, 0), IIF($4, $5, NULL), $6)
+	ON CONFLICT (rosterJID) DO UPDATE SET
+		name=IIF(excluded.name='', name, excluded.name),
+		room=room OR excluded.room,
+		unread=unread+excluded.unread,
+		firstUnread=IIF(unread=0, excluded.firstUnread, firstUnread),
+		lastActivity=MAX(lastActivity, excluded.lastActivity)`)
+	if err != nil {
+		return err
+	}
+	wrapDB.pinConv, err = db.PrepareContext(ctx, `
+INSERT INTO conversations (rosterJID, pinned)
+	-- Unpinning a conversation that isn't open does not open it.
+	SELECT $1, $2 WHERE $2 OR EXISTS (SELECT 1 FROM conversations WHERE rosterJID=$1)
+	ON CONFLICT (rosterJID) DO UPDATE SET pinned=excluded.pinned`)
+	if err != nil {
+		return err
+	}
+	wrapDB.closeConv, err = db.PrepareContext(ctx, `
+DELETE FROM conversations WHERE rosterJID=$1`)
+	if err != nil {
+		return err
+	}
+	wrapDB.setUnread, err = db.PrepareContext(ctx, `
+UPDATE conversations SET unread=$2, firstUnread=NULLIF($3, '')
+	WHERE rosterJID=$1`)
+	if err != nil {
+		return err
+	}
+	wrapDB.selectConvs, err = db.PrepareContext(ctx, `
+SELECT rosterJID, name, room, pinned, unread, IFNULL(firstUnread, ''), lastActivity
+	FROM conversations
+	ORDER BY pinned DESC, lastActivity DESC`)
+	return err
+}
+
+// UpsertConversation adds a conversation to the conversations list or updates
+// its name and last activity.
+// If unread is true the conversation has a new unread message and c.FirstUnread
+// is its ID.
+// The pinned state and unread count of c are ignored.
+func (db *DB) UpsertConversation(ctx context.Context, c Conversation, unread bool) error {
+	var lastActivity int64
+	if !c.LastActivity.IsZero() {
+		lastActivity = c.LastActivity.Unix()
+	}
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.upsertConv).ExecContext(ctx, c.JID.Bare().String(), c.Name, c.Room, unread, c.FirstUnread, lastActivity)
+		return err
+	})
+}
+
+// PinConversation pins the conversation with j to the top of the conversations
+// list or unpins it.
+func (db *DB) PinConversation(ctx context.Context, j jid.JID, pinned bool) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.pinConv).ExecContext(ctx, j.Bare().String(), pinned)
+		return err
+	})
+}
+
+// CloseConversation removes the conversation with j from the conversations
+// list.
+// The message history is not affected.
+func (db *DB) CloseConversation(ctx context.Context, j jid.JID) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.closeConv).ExecContext(ctx, j.Bare().String())
+		return err
+	})
+}
+
+// Conversations returns the conversations list with pinned conversations first
+// and the rest ordered by their last activity, newest first.
+func (db *DB) Conversations(ctx context.Context) ([]Conversation, error) {
+	var results []Conversation
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.Stmt(db.selectConvs).QueryContext(ctx)
+		if err != nil {
+			return localerr.Wrap(db.p, "error getting conversations: %v", err)
+		}
+		for rows.Next() {
+			var cur Conversation
+			var jStr string
+			var lastActivity int64
+			err = rows.Scan(&jStr, &cur.Name, &cur.Room, &cur.Pinned, &cur.Unread, &cur.FirstUnread, &lastActivity)
+			if err != nil {
+				return localerr.Wrap(db.p, "error scanning conversations: %v", err)
+			}
+			unsafeJID, err := jid.ParseUnsafe(jStr)
+			if err != nil {
+				return localerr.Wrap(db.p, "failed to parse conversation JID: %v", err)
+			}
+			cur.JID = unsafeJID.JID
+			if lastActivity != 0 {
+				cur.LastActivity = time.Unix(lastActivity, 0)
+			}
+			results = append(results, cur)
+		}
+		if err = rows.Err(); err != nil {
+			return localerr.Wrap(db.p, "error iterating over conversations: %v", err)
+		}
+		return nil
+	})
+	return results, err
+}
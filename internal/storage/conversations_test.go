@@ -0,0 +1,196 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/storage/storagetest"
+	"mellium.im/xmpp/jid"
+)
+
+type convLine struct {
+	JID         string
+	Name        string
+	Unread      int
+	FirstUnread string
+	Pinned      bool
+}
+
+func conversations(t *testing.T, db *storage.DB) []convLine {
+	t.Helper()
+	convs, err := db.Conversations(context.Background())
+	if err != nil {
+		t.Fatalf("error getting conversations: %v", err)
+	}
+	var got []convLine
+	for _, c := range convs {
+		got = append(got, convLine{JID: c.JID.String(), Name: c.Name, Unread: c.Unread, FirstUnread: c.FirstUnread, Pinned: c.Pinned})
+	}
+	return got
+}
+
+// convOp changes the conversations list.
+type convOp func(context.Context, *storage.DB) error
+
+func upsert(j jid.JID, name, firstUnread string, lastActivity time.Time) convOp {
+	return func(ctx context.Context, db *storage.DB) error {
+		return db.UpsertConversation(ctx, storage.Conversation{
+			JID:          j,
+			Name:         name,
+			FirstUnread:  firstUnread,
+			LastActivity: lastActivity,
+		}, firstUnread != "")
+	}
+}
+
+func pin(j jid.JID, pinned bool) convOp {
+	return func(ctx context.Context, db *storage.DB) error {
+		return db.PinConversation(ctx, j, pinned)
+	}
+}
+
+func closeConv(j jid.JID) convOp {
+	return func(ctx context.Context, db *storage.DB) error {
+		return db.CloseConversation(ctx, j)
+	}
+}
+
+func markDisplayed(j jid.JID, id string) convOp {
+	return func(ctx context.Context, db *storage.DB) error {
+		return db.MarkDisplayed(ctx, j, id)
+	}
+}
+
+func markRead(j jid.JID) convOp {
+	return func(ctx context.Context, db *storage.DB) error {
+		_, err := db.MarkRead(ctx, j)
+		return err
+	}
+}
+
+var conversationTestCases = [...]struct {
+	ops    []convOp
+	expect []convLine
+}{
+	0: {},
+	1: {
+		ops: []convOp{
+			upsert(juliet, "", "1", storagetest.Day(1)),
+			upsert(juliet, "", "2", storagetest.Day(2)),
+			upsert(nurse, "", "3", storagetest.Day(3)),
+			upsert(room, "Capulets", "", storagetest.Day(0)),
+			pin(room, true),
+		},
+		expect: []convLine{
+			{JID: room.String(), Name: "Capulets", Pinned: true},
+			{JID: nurse.String(), Unread: 1, FirstUnread: "3"},
+			{JID: juliet.String(), Unread: 2, FirstUnread: "1"},
+		},
+	},
+	2: {
+		ops: []convOp{
+			upsert(juliet, "", "1", storagetest.Day(1)),
+			upsert(juliet, "", "2", storagetest.Day(2)),
+			upsert(nurse, "", "3", storagetest.Day(3)),
+			upsert(room, "Capulets", "", storagetest.Day(0)),
+			pin(room, true),
+			markDisplayed(juliet, "1"),
+			markRead(nurse),
+			closeConv(room),
+		},
+		expect: []convLine{
+			{JID: nurse.String()},
+			{JID: juliet.String(), Unread: 1, FirstUnread: "2"},
+		},
+	},
+	3: {
+		// Older activity and an empty name don't replace what we know, wherever
+		// the time was recorded.
+		ops: []convOp{
+			upsert(juliet, "Juliet", "", time.Date(2020, 1, 2, 1, 0, 0, 0, berlin)),
+			upsert(nurse, "", "", storagetest.Day(1)),
+			upsert(juliet, "", "", time.Date(2020, 1, 1, 18, 0, 0, 0, newYork)),
+		},
+		expect: []convLine{
+			{JID: juliet.String(), Name: "Juliet"},
+			{JID: nurse.String()},
+		},
+	},
+	4: {
+		// Full JIDs are stored as their bare JID.
+		ops: []convOp{
+			upsert(tybalt, "", "", storagetest.Day(1)),
+			upsert(room, "Capulets", "", storagetest.Day(1)),
+		},
+		expect: []convLine{{JID: room.String(), Name: "Capulets"}},
+	},
+	5: {
+		// Closing, unpinning, or reading a conversation that isn't open doesn't
+		// open it, but pinning does.
+		ops: []convOp{
+			closeConv(juliet),
+			pin(nurse, false),
+			markRead(juliet),
+			markDisplayed(juliet, "1"),
+			pin(room, true),
+		},
+		expect: []convLine{{JID: room.String(), Pinned: true}},
+	},
+	6: {
+		// Reopening a closed conversation starts over.
+		ops: []convOp{
+			upsert(juliet, "", "1", storagetest.Day(1)),
+			pin(juliet, true),
+			closeConv(juliet),
+			upsert(juliet, "", "2", storagetest.Day(2)),
+		},
+		expect: []convLine{{JID: juliet.String(), Unread: 1, FirstUnread: "2"}},
+	},
+}
+
+func TestConversations(t *testing.T) {
+	for i, tc := range conversationTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			db := storagetest.InsertMsgs(t, self,
+				msg("1", juliet, self, "Wherefore art thou Romeo?"),
+				msg("2", juliet, self, "Deny thy father"),
+				msg("3", nurse, self, "Madam!"),
+			)
+			for i, op := range tc.ops {
+				if err := op(context.Background(), db); err != nil {
+					t.Fatalf("error in operation %d: %v", i, err)
+				}
+			}
+			if got := conversations(t, db); !slices.Equal(got, tc.expect) {
+				t.Errorf("wrong conversations: want=%v, got=%v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestConversationsCanceled(t *testing.T) {
+	db := storagetest.OpenDB(t)
+	for i, op := range []convOp{
+		upsert(juliet, "", "1", storagetest.Day(1)),
+		pin(juliet, true),
+		closeConv(juliet),
+	} {
+		if err := op(canceled(), db); err == nil {
+			t.Errorf("expected error in operation %d", i)
+		}
+	}
+	if _, err := db.Conversations(canceled()); err == nil {
+		t.Errorf("expected error getting conversations")
+	}
+	if got := conversations(t, db); len(got) != 0 {
+		t.Errorf("expected no conversations, got=%v", got)
+	}
+}
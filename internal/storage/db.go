@@ -45,7 +45,9 @@ type DB struct {
 	selectRoster      *sql.Stmt
 	insertMsg         *sql.Stmt
 	markRecvd         *sql.Stmt
+	markFailed        *sql.Stmt
 	queryMsg          *sql.Stmt
+	lastSent          *sql.Stmt
 	afterID           *sql.Stmt
 	beforeID          *sql.Stmt
 	insertCaps        *sql.Stmt
@@ -60,8 +62,17 @@ type DB struct {
 	insertIdentJID    *sql.Stmt
 	insertFeature     *sql.Stmt
 	insertFeatureJID  *sql.Stmt
-	p                 *message.Printer
-	debug             *log.Logger
+	omemoQueries
+	searchQueries
+	retractQueries
+	markerQueries
+	conversationQueries
+	exportQueries
+	importQueries
+	outboxQueries
+	channelQueries
+	p     *message.Printer
+	debug *log.Logger
 }
 
 // OpenDB attempts to open the database at dbFile.
@@ -202,9 +213,15 @@ SELECT jid,name,subs FROM rosterJIDs`)
 
 	wrapDB.insertMsg, err = db.PrepareContext(ctx, `
 INSERT INTO messages
-	(sent, toAttr, fromAttr, idAttr, body, stanzaType, originID, delay, rosterJID, archiveID)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, IFNULL(NULLIF($8, 0), CAST(strftime('%s', 'now') AS INTEGER)), $9, $10)
-	ON CONFLICT (originID, fromAttr) DO UPDATE SET archiveID=$10
+	(sent, toAttr, fromAttr, idAttr, body, stanzaType, originID, delay, rosterJID, archiveID, replaceID, markable, nick, delayed)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, IFNULL(NULLIF($8, 0), CAST(strftime('%s', 'now') AS INTEGER)), $9, $10,
+		-- If this corrects a correction, point at the original message instead.
+		-- Occupants of a channel share its address (see latestCorrection).
+		IFNULL((SELECT o.replaceID FROM messages AS o
+			WHERE o.rosterJID=$9 AND o.fromAttr=$3 AND ($1 OR IFNULL(o.nick, '')=$13)
+				AND $11 IN (o.idAttr, o.originID)
+			LIMIT 1), $11), $12, NULLIF($13, ''), $14)
+	ON CONFLICT (originID, fromAttr) DO UPDATE SET archiveID=IFNULL($10, archiveID)
 	ON CONFLICT (archiveID) DO NOTHING
 	RETURNING id`)
 	if err != nil {
@@ -217,12 +234,36 @@ UPDATE messages SET received=TRUE WHERE sent=TRUE AND (idAttr=$1 OR originID=$1)
 		return nil, err
 	}
 
+	wrapDB.markFailed, err = db.PrepareContext(ctx, `
+UPDATE messages SET failed=TRUE WHERE sent=TRUE AND (idAttr=$1 OR originID=$1)`)
+	if err != nil {
+		return nil, err
+	}
+
 	wrapDB.queryMsg, err = db.PrepareContext(ctx, `
-SELECT sent, toAttr, fromAttr, idAttr, body, stanzaType
-	FROM messages
-	WHERE rosterJID=$1
-		AND stanzaType=COALESCE(NULLIF($2, ''), stanzaType)
-	ORDER BY delay ASC`)
+SELECT `+historyColumns+`
+	FROM messages AS m
+		-- Show the most recent correction in place of the original message.
+		`+latestCorrection+`
+	WHERE m.rosterJID=$1
+		AND m.stanzaType=COALESCE(NULLIF($2, ''), m.stanzaType)
+		AND `+skipCorrections+`
+	ORDER BY m.delay ASC`)
+	if err != nil {
+		return nil, err
+	}
+	wrapDB.lastSent, err = db.PrepareContext(ctx, `
+SELECT m.idAttr, IFNULL((
+	SELECT body FROM messages AS c
+		WHERE c.rosterJID=m.rosterJID AND c.fromAttr=m.fromAttr AND (m.sent OR IFNULL(c.nick, '')=IFNULL(m.nick, ''))
+			AND c.replaceID IN (m.idAttr, m.originID)
+		ORDER BY c.delay DESC, c.id DESC
+		LIMIT 1), m.body)
+	FROM messages AS m
+	WHERE m.rosterJID=$1 AND m.sent=TRUE AND m.replaceID IS NULL AND m.retracted=FALSE
+		AND m.idAttr<>'' AND IFNULL(m.body, '')<>''
+	ORDER BY m.delay DESC, m.id DESC
+	LIMIT 1`)
 	if err != nil {
 		return nil, err
 	}
@@ -238,7 +279,7 @@ SELECT j.jid, m.archiveID, MAX(m.delay)
 		return nil, err
 	}
 	wrapDB.beforeID, err = db.PrepareContext(ctx, `
-SELECT archiveID, MIN(delay) AS mindelay
+SELECT IFNULL(archiveID, ''), MIN(delay) AS mindelay
 	FROM messages
 	WHERE rosterJID=$1
 	GROUP BY delay
@@ -349,6 +390,42 @@ INSERT INTO discoFeatureJID (jid, feat)
 	if err != nil {
 		return nil, err
 	}
+	err = prepareOMEMO(ctx, db, wrapDB)
+	if err != nil {
+		return nil, err
+	}
+	err = prepareSearch(ctx, db, wrapDB)
+	if err != nil {
+		return nil, err
+	}
+	err = prepareRetract(ctx, db, wrapDB)
+	if err != nil {
+		return nil, err
+	}
+	err = prepareMarkers(ctx, db, wrapDB)
+	if err != nil {
+		return nil, err
+	}
+	err = prepareConversations(ctx, db, wrapDB)
+	if err != nil {
+		return nil, err
+	}
+	err = prepareExport(ctx, db, wrapDB)
+	if err != nil {
+		return nil, err
+	}
+	err = prepareImport(ctx, db, wrapDB)
+	if err != nil {
+		return nil, err
+	}
+	err = prepareOutbox(ctx, db, wrapDB)
+	if err != nil {
+		return nil, err
+	}
+	err = prepareChannels(ctx, db, wrapDB)
+	if err != nil {
+		return nil, err
+	}
 	return wrapDB, nil
 }
 
@@ -395,6 +472,14 @@ func (db *DB) MarkReceived(ctx context.Context, e event.Receipt) error {
 	})
 }
 
+// MarkFailed marks a message that we sent as having bounced with an error.
+func (db *DB) MarkFailed(ctx context.Context, id string) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.markFailed).ExecContext(ctx, id)
+		return err
+	})
+}
+
 // InsertMsg adds a message to the database.
 func (db *DB) InsertMsg(ctx context.Context, respectDelay bool, msg event.ChatMessage, addr jid.JID) error {
 	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
@@ -426,15 +511,26 @@ func (db *DB) InsertMsg(ctx context.Context, respectDelay bool, msg event.ChatMe
 		}
 
 		var domainSID *string
+		by := archivedBy(msg, rosterJID, addr)
 		for _, sid := range msg.SID {
-			if sid.By.String() == addr.Bare().String() {
+			if sid.By.String() == by {
 				domainSID = &sid.ID
 				break
 			}
 		}
 
+		var replaceID *string
+		if msg.Replace.ID != "" {
+			replaceID = &msg.Replace.ID
+		}
+
+		var nick string
+		if msg.Type == stanza.GroupChatMessage {
+			nick = msg.From.Resourcepart()
+		}
+
 		var msgRID uint64
-		err := tx.Stmt(db.insertMsg).QueryRowContext(ctx, msg.Sent, msg.To.Bare().String(), msg.From.Bare().String(), msg.ID, msg.Body, msg.Type, originID, delay, rosterJID, domainSID).Scan(&msgRID)
+		err := tx.Stmt(db.insertMsg).QueryRowContext(ctx, msg.Sent, msg.To.Bare().String(), msg.From.Bare().String(), msg.ID, msg.Body, msg.Type, originID, delay, rosterJID, domainSID, replaceID, msg.Markable != nil, nick, msg.Delayed).Scan(&msgRID)
 		switch err {
 		case sql.ErrNoRows:
 			return nil
@@ -572,13 +668,99 @@ type MessageIter struct {
 	*Iter
 }
 
+type historyRow struct {
+	event.ChatMessage
+	rowID     int64
+	received  bool
+	failed    bool
+	queued    bool
+	archiveID string
+}
+
+// The following fragments are shared by the queries that return a
+// MessageIter.
+// They expect the messages table to be aliased as m.
+//
+// Every occupant of a channel shares the channel's address, so a correction
+// must also come from the same nickname as the message it corrects.
+// Our own messages are stored under our own address and only learn their
+// nickname once the channel reflects them, so their nickname is not compared.
+const (
+	historyColumns = `m.id, m.sent, m.toAttr, m.fromAttr, m.idAttr, IFNULL(c.body, m.body), m.stanzaType, c.id NOT NULL,
+		m.retracted, IFNULL(m.retractedBy, ''), IFNULL(m.retractReason, ''), m.delay, m.received, IFNULL(m.nick, ''),
+		IFNULL(m.archiveID, ''), m.delayed, m.failed,
+		EXISTS (SELECT 1 FROM outbox AS q WHERE q.originID=m.originID AND m.sent=TRUE)`
+	latestCorrection = `LEFT JOIN messages AS c ON c.id=(
+			SELECT l.id FROM messages AS l
+				WHERE l.rosterJID=m.rosterJID AND l.fromAttr=m.fromAttr AND (m.sent OR IFNULL(l.nick, '')=IFNULL(m.nick, ''))
+					AND l.replaceID IN (m.idAttr, m.originID)
+				ORDER BY l.delay DESC, l.id DESC
+				LIMIT 1)`
+	// Skip corrections unless we don't have the message they correct.
+	skipCorrections = `(m.replaceID IS NULL OR NOT EXISTS (
+			SELECT 1 FROM messages AS o
+				WHERE o.rosterJID=m.rosterJID AND o.fromAttr=m.fromAttr AND (m.sent OR IFNULL(o.nick, '')=IFNULL(m.nick, ''))
+					AND o.replaceID IS NULL AND m.replaceID IN (o.idAttr, o.originID)))`
+)
+
 // Result returns the most recent result read from the iter.
 func (iter MessageIter) Message() event.ChatMessage {
 	cur := iter.Iter.Current()
 	if cur == nil {
 		return event.ChatMessage{}
 	}
-	return cur.(event.ChatMessage)
+	return cur.(historyRow).ChatMessage
+}
+
+// RowID returns the database ID of the most recent result read from the iter.
+// It can be compared against SearchResult.RowID.
+func (iter MessageIter) RowID() int64 {
+	cur := iter.Iter.Current()
+	if cur == nil {
+		return 0
+	}
+	return cur.(historyRow).rowID
+}
+
+// Received reports whether a delivery receipt was received for the most recent
+// result read from the iter.
+// It is always false for messages that we did not send.
+func (iter MessageIter) Received() bool {
+	cur := iter.Iter.Current()
+	if cur == nil {
+		return false
+	}
+	return cur.(historyRow).received
+}
+
+// Failed reports whether the most recent result read from the iter was a
+// message that we sent and that bounced with an error.
+func (iter MessageIter) Failed() bool {
+	cur := iter.Iter.Current()
+	if cur == nil {
+		return false
+	}
+	return cur.(historyRow).failed
+}
+
+// Queued reports whether the most recent result read from the iter is a
+// message that we sent and that is still waiting in the outbox.
+func (iter MessageIter) Queued() bool {
+	cur := iter.Iter.Current()
+	if cur == nil {
+		return false
+	}
+	return cur.(historyRow).queued
+}
+
+// ArchiveID returns the ID assigned to the most recent result read from the
+// iter by the archive that it was fetched from, if any.
+func (iter MessageIter) ArchiveID() string {
+	cur := iter.Iter.Current()
+	if cur == nil {
+		return ""
+	}
+	return cur.(historyRow).archiveID
 }
 
 // QueryHistory returns all rows to or from the given JID.
@@ -597,30 +779,65 @@ func (db *DB) QueryHistory(ctx context.Context, j string, typ stanza.MessageType
 			cancel: cancel,
 			err:    err,
 			rows:   rows,
-			f: func(rows *sql.Rows) (interface{}, error) {
-				cur := event.ChatMessage{}
-				var to, from, typ string
-				err := rows.Scan(&cur.Sent, &to, &from, &cur.ID, &cur.Body, &typ)
-				if err != nil {
-					return cur, err
-				}
-				cur.Type = stanza.MessageType(typ)
-				unsafeTo, err := jid.ParseUnsafe(to)
-				if err != nil {
-					return cur, err
-				}
-				cur.To = unsafeTo.JID
-				unsafeFrom, err := jid.ParseUnsafe(from)
-				if err != nil {
-					return cur, err
-				}
-				cur.From = unsafeFrom.JID
-				return cur, nil
-			},
+			f:      scanHistoryRow,
 		},
 	}
 }
 
+// scanHistoryRow scans a row selected with historyColumns.
+func scanHistoryRow(rows *sql.Rows) (interface{}, error) {
+	cur := historyRow{}
+	var to, from, typ, retractedBy, reason, nick string
+	var delay int64
+	err := rows.Scan(&cur.rowID, &cur.Sent, &to, &from, &cur.ID, &cur.Body, &typ, &cur.Edited, &cur.Retracted, &retractedBy, &reason, &delay, &cur.received, &nick, &cur.archiveID, &cur.Delayed, &cur.failed, &cur.queued)
+	if err != nil {
+		return cur, err
+	}
+	if cur.Retracted {
+		cur.Retract = &event.Retract{Reason: reason}
+		if retractedBy != "" {
+			unsafeBy, err := jid.ParseUnsafe(retractedBy)
+			if err != nil {
+				return cur, err
+			}
+			cur.Retract.Moderated = &event.Moderated{By: unsafeBy.JID}
+		}
+	}
+	cur.Type = stanza.MessageType(typ)
+	cur.Delay.Time = time.Unix(delay, 0)
+	unsafeTo, err := jid.ParseUnsafe(to)
+	if err != nil {
+		return cur, err
+	}
+	cur.To = unsafeTo.JID
+	unsafeFrom, err := jid.ParseUnsafe(from)
+	if err != nil {
+		return cur, err
+	}
+	cur.From = unsafeFrom.JID
+	if nick != "" {
+		// Messages in channels are from the occupant, not the channel.
+		cur.From, err = cur.From.WithResource(nick)
+		if err != nil {
+			return cur, err
+		}
+	}
+	return cur, nil
+}
+
+// LastSent returns the ID and the current text of the last message that we
+// sent to the given JID.
+// If no message has been sent, the ID is empty.
+func (db *DB) LastSent(ctx context.Context, j jid.JID) (id, body string, err error) {
+	err = execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		return tx.Stmt(db.lastSent).QueryRowContext(ctx, j.Bare().String()).Scan(&id, &body)
+	})
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return id, body, err
+}
+
 // AfterIDRes is returned from an AfterID query.
 type AfterIDResult struct {
 	Addr  jid.JID
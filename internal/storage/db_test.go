@@ -0,0 +1,393 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/storage/storagetest"
+	"mellium.im/xmpp/delay"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	self     = jid.MustParse("me@example.net")
+	juliet   = jid.MustParse("juliet@example.com")
+	nurse    = jid.MustParse("nurse@example.com")
+	room     = jid.MustParse("capulets@muc.example.com")
+	tybalt   = jid.MustParse("capulets@muc.example.com/tybalt")
+	mercutio = jid.MustParse("capulets@muc.example.com/mercutio")
+)
+
+// canceled returns a context that is already canceled, which makes every
+// query fail.
+func canceled() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+// history returns f applied to each message in the conversation with j,
+// oldest first.
+func history[T any](t *testing.T, db *storage.DB, j jid.JID, f func(storage.MessageIter) T) []T {
+	t.Helper()
+	var got []T
+	iter := db.QueryHistory(context.Background(), j.String(), "")
+	for iter.Next() {
+		got = append(got, f(iter))
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error querying history: %v", err)
+	}
+	return got
+}
+
+// msg returns a chat message from one address to another.
+// Messages from self are marked as sent.
+func msg(id string, from, to jid.JID, body string) event.ChatMessage {
+	return event.ChatMessage{
+		Message: stanza.Message{ID: id, From: from, To: to, Type: stanza.ChatMessage},
+		Body:    body,
+		Sent:    from.Equal(self),
+	}
+}
+
+// groupMsg is like msg but returns a message in a channel.
+func groupMsg(id string, from, to jid.JID, body string) event.ChatMessage {
+	m := msg(id, from, to, body)
+	m.Type = stanza.GroupChatMessage
+	return m
+}
+
+type bodyLine struct {
+	ID     string
+	Body   string
+	Edited bool
+}
+
+func bodyLines(iter storage.MessageIter) bodyLine {
+	cur := iter.Message()
+	return bodyLine{ID: cur.ID, Body: cur.Body, Edited: cur.Edited}
+}
+
+var correctionTestCases = [...]struct {
+	with     jid.JID
+	msgs     []event.ChatMessage
+	expect   []bodyLine
+	lastID   string
+	lastBody string
+}{
+	0: {with: juliet},
+	1: {
+		with: juliet,
+		msgs: []event.ChatMessage{
+			msg("1", juliet, self, "Wherefore art thou Romoe?"),
+			msg("2", self, juliet, "Call me but lvoe"),
+			// A correction that points at an earlier correction.
+			withReplace(msg("3", juliet, self, "Wherefore art thou Romeo"), "1"),
+			withReplace(msg("4", juliet, self, "Wherefore art thou Romeo?"), "3"),
+			withReplace(msg("5", self, juliet, "Call me but love"), "2"),
+			// Someone other than the original sender can't correct a message.
+			withReplace(msg("6", self, juliet, "Spoofed"), "1"),
+		},
+		expect: []bodyLine{
+			{ID: "1", Body: "Wherefore art thou Romeo?", Edited: true},
+			{ID: "2", Body: "Call me but love", Edited: true},
+			{ID: "6", Body: "Spoofed"},
+		},
+		lastID:   "2",
+		lastBody: "Call me but love",
+	},
+	2: {
+		with: juliet,
+		// A correction of a message that we don't have is shown on its own.
+		msgs: []event.ChatMessage{
+			withReplace(msg("2", juliet, self, "Deny thy father"), "1"),
+		},
+		expect: []bodyLine{{ID: "2", Body: "Deny thy father"}},
+	},
+	3: {
+		with: juliet,
+		// The newest correction wins, even if it arrives first.
+		msgs: []event.ChatMessage{
+			msg("1", self, juliet, "Good nihgt"),
+			withDelay(withReplace(msg("3", self, juliet, "Good night!"), "1"), storagetest.Day(5)),
+			withDelay(withReplace(msg("2", self, juliet, "Good night"), "1"), storagetest.Day(4)),
+		},
+		expect:   []bodyLine{{ID: "1", Body: "Good night!", Edited: true}},
+		lastID:   "1",
+		lastBody: "Good night!",
+	},
+	4: {
+		with: room,
+		// Every occupant of a channel shares its address, so only the occupant that
+		// sent a message can correct it.
+		msgs: []event.ChatMessage{
+			groupMsg("1", tybalt, self, "What, drawn, and talk of peace!"),
+			withReplace(groupMsg("2", mercutio, self, "I hate the word"), "1"),
+			withReplace(groupMsg("3", tybalt, self, "What, drawn, and talk of peace?"), "1"),
+			groupMsg("4", self, room, "I do but keep the pease"),
+			withReplace(groupMsg("5", self, room, "I do but keep the peace"), "4"),
+		},
+		expect: []bodyLine{
+			{ID: "1", Body: "What, drawn, and talk of peace?", Edited: true},
+			{ID: "2", Body: "I hate the word"},
+			{ID: "4", Body: "I do but keep the peace", Edited: true},
+		},
+		lastID:   "4",
+		lastBody: "I do but keep the peace",
+	},
+}
+
+func withReplace(msg event.ChatMessage, id string) event.ChatMessage {
+	msg.Replace = event.Replace{ID: id}
+	return msg
+}
+
+func withDelay(msg event.ChatMessage, t time.Time) event.ChatMessage {
+	msg.Delay = delay.Delay{Time: t}
+	return msg
+}
+
+func TestCorrections(t *testing.T) {
+	for i, tc := range correctionTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			db := storagetest.InsertMsgs(t, self, tc.msgs...)
+			if got := history(t, db, tc.with, bodyLines); !slices.Equal(got, tc.expect) {
+				t.Errorf("wrong history: want=%v, got=%v", tc.expect, got)
+			}
+			id, body, err := db.LastSent(context.Background(), tc.with)
+			if err != nil {
+				t.Fatalf("error getting last sent message: %v", err)
+			}
+			if id != tc.lastID || body != tc.lastBody {
+				t.Errorf("wrong last sent message: want=%s %q, got=%s %q", tc.lastID, tc.lastBody, id, body)
+			}
+		})
+	}
+}
+
+var duplicateTestCases = [...]struct {
+	msgs   []event.ChatMessage
+	expect []bodyLine
+}{
+	0: {
+		// The same message from the same sender is only stored once.
+		msgs: []event.ChatMessage{
+			msg("1", juliet, self, "Wherefore art thou Romeo?"),
+			msg("1", juliet, self, "Wherefore art thou Romeo?"),
+		},
+		expect: []bodyLine{{ID: "1", Body: "Wherefore art thou Romeo?"}},
+	},
+	1: {
+		// IDs are only unique per sender.
+		msgs: []event.ChatMessage{
+			msg("1", juliet, self, "Wherefore art thou Romeo?"),
+			msg("1", self, juliet, "Call me but love"),
+		},
+		expect: []bodyLine{
+			{ID: "1", Body: "Wherefore art thou Romeo?"},
+			{ID: "1", Body: "Call me but love"},
+		},
+	},
+	2: {
+		// The origin ID takes precedence over the stanza ID.
+		msgs: []event.ChatMessage{
+			withOrigin(msg("1", juliet, self, "Wherefore art thou Romeo?"), "a"),
+			withOrigin(msg("2", juliet, self, "Wherefore art thou Romeo?"), "a"),
+			withOrigin(msg("1", juliet, self, "Deny thy father"), "b"),
+		},
+		expect: []bodyLine{
+			{ID: "1", Body: "Wherefore art thou Romeo?"},
+			{ID: "1", Body: "Deny thy father"},
+		},
+	},
+	3: {
+		// Messages without any ID can't be de-duplicated.
+		msgs: []event.ChatMessage{
+			msg("", juliet, self, "Romeo!"),
+			msg("", juliet, self, "Romeo!"),
+		},
+		expect: []bodyLine{{Body: "Romeo!"}, {Body: "Romeo!"}},
+	},
+	4: {
+		// A message fetched from the archive again is only stored once, even if it
+		// has no ID of its own.
+		msgs: []event.ChatMessage{
+			withSID(msg("", juliet, self, "Romeo!"), "a", self),
+			withSID(msg("", juliet, self, "Romeo!"), "a", self),
+		},
+		expect: []bodyLine{{Body: "Romeo!"}},
+	},
+}
+
+func withOrigin(msg event.ChatMessage, id string) event.ChatMessage {
+	msg.OriginID = stanza.OriginID{ID: id}
+	return msg
+}
+
+func withSID(msg event.ChatMessage, id string, by jid.JID) event.ChatMessage {
+	msg.SID = append(msg.SID, stanza.ID{ID: id, By: by})
+	return msg
+}
+
+func TestDuplicates(t *testing.T) {
+	for i, tc := range duplicateTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			db := storagetest.InsertMsgs(t, self, tc.msgs...)
+			if got := history(t, db, juliet, bodyLines); !slices.Equal(got, tc.expect) {
+				t.Errorf("wrong history: want=%v, got=%v", tc.expect, got)
+			}
+		})
+	}
+}
+
+var delayTestCases = [...]struct {
+	respectDelay bool
+	delay        time.Time
+	delayed      bool
+	stored       bool
+}{
+	0: {respectDelay: true, delay: time.Date(2020, 10, 12, 8, 30, 0, 0, time.UTC), stored: true},
+	// The time is the same instant in every time zone.
+	1: {respectDelay: true, delay: time.Date(2020, 10, 12, 23, 30, 0, 0, time.FixedZone("UTC-10", -10*60*60)), stored: true},
+	2: {respectDelay: true, delay: time.Date(2020, 10, 13, 0, 30, 0, 0, time.FixedZone("UTC+14", 14*60*60)), stored: true},
+	// An untrusted delay is ignored and the message is stored at the time it
+	// was received but still marked as having been delayed.
+	3: {delay: time.Date(2020, 10, 12, 8, 30, 0, 0, time.UTC), delayed: true},
+	// Without a delay the message is stored at the time it was received.
+	4: {respectDelay: true},
+}
+
+func TestDelay(t *testing.T) {
+	for i, tc := range delayTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			db := storagetest.OpenDB(t)
+			m := msg("1", juliet, self, "Wherefore art thou Romeo?")
+			m.Delay = delay.Delay{Time: tc.delay}
+			m.Delayed = tc.delayed
+			before := time.Now().Add(-time.Second)
+			err := db.InsertMsg(context.Background(), tc.respectDelay, m, self)
+			if err != nil {
+				t.Fatalf("error inserting message: %v", err)
+			}
+			got := history(t, db, juliet, storage.MessageIter.Message)
+			if len(got) != 1 {
+				t.Fatalf("wrong number of messages: want=1, got=%d", len(got))
+			}
+			stored := got[0].Delay.Time
+			switch {
+			case tc.stored && !stored.Equal(tc.delay):
+				t.Errorf("wrong time: want=%v, got=%v", tc.delay, stored)
+			case !tc.stored && stored.Before(before):
+				t.Errorf("message should be stored at the time it arrived, got=%v", stored)
+			}
+			if got[0].Delayed != tc.delayed {
+				t.Errorf("wrong delayed flag: want=%t, got=%t", tc.delayed, got[0].Delayed)
+			}
+		})
+	}
+}
+
+type deliveryLine struct {
+	ID       string
+	Received bool
+	Failed   bool
+}
+
+var deliveryTestCases = [...]struct {
+	received []string
+	failed   []string
+	expect   []deliveryLine
+}{
+	0: {expect: []deliveryLine{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}}},
+	1: {
+		received: []string{"2"},
+		failed:   []string{"3"},
+		expect:   []deliveryLine{{ID: "1"}, {ID: "2", Received: true}, {ID: "3", Failed: true}, {ID: "4"}},
+	},
+	// Receipts and errors for messages we don't have are ignored.
+	2: {
+		received: []string{"unknown", ""},
+		failed:   []string{"unknown", ""},
+		expect:   []deliveryLine{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}},
+	},
+	// Receipts and errors for messages that we received are ignored.
+	3: {
+		received: []string{"4"},
+		failed:   []string{"4"},
+		expect:   []deliveryLine{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}},
+	},
+}
+
+func TestDelivery(t *testing.T) {
+	for i, tc := range deliveryTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ctx := context.Background()
+			db := storagetest.InsertMsgs(t, self,
+				withOrigin(msg("1", self, juliet, "Good night, good night!"), "1"),
+				withOrigin(msg("2", self, juliet, "Parting is such sweet sorrow"), "2"),
+				withOrigin(msg("3", self, juliet, "That I shall say good night"), "3"),
+				msg("4", juliet, self, "Till it be morrow"),
+			)
+			for _, id := range tc.received {
+				if err := db.MarkReceived(ctx, event.Receipt(id)); err != nil {
+					t.Fatalf("error marking %q as received: %v", id, err)
+				}
+			}
+			for _, id := range tc.failed {
+				if err := db.MarkFailed(ctx, id); err != nil {
+					t.Fatalf("error marking %q as failed: %v", id, err)
+				}
+			}
+			got := history(t, db, juliet, func(iter storage.MessageIter) deliveryLine {
+				return deliveryLine{ID: iter.Message().ID, Received: iter.Received(), Failed: iter.Failed()}
+			})
+			if !slices.Equal(got, tc.expect) {
+				t.Errorf("wrong delivery states: want=%v, got=%v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	db := storagetest.OpenDB(t)
+	ctx := canceled()
+	if err := db.InsertMsg(ctx, true, msg("1", juliet, self, "Romeo!"), self); err == nil {
+		t.Errorf("expected error inserting message")
+	}
+	iter := db.QueryHistory(ctx, juliet.String(), "")
+	if iter.Next() {
+		t.Errorf("expected no history")
+	}
+	if iter.Err() == nil {
+		t.Errorf("expected error querying history")
+	}
+	if _, _, err := db.LastSent(ctx, juliet); err == nil {
+		t.Errorf("expected error getting last sent message")
+	}
+	if err := db.MarkReceived(ctx, event.Receipt("1")); err == nil {
+		t.Errorf("expected error marking message as received")
+	}
+	if err := db.MarkFailed(ctx, "1"); err == nil {
+		t.Errorf("expected error marking message as failed")
+	}
+	// The database is still usable afterwards.
+	if err := db.InsertMsg(context.Background(), true, msg("1", juliet, self, "Romeo!"), self); err != nil {
+		t.Errorf("error inserting message after canceled queries: %v", err)
+	}
+}
+
+func withType(msg event.ChatMessage, typ stanza.MessageType) event.ChatMessage {
+	msg.Type = typ
+	return msg
+}
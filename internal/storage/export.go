@@ -0,0 +1,74 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"mellium.im/xmpp/jid"
+)
+
+// ExportQuery selects the messages to export.
+// The zero value of each field matches all messages.
+type ExportQuery struct {
+	With  jid.JID
+	Start time.Time
+	End   time.Time
+}
+
+type exportQueries struct {
+	exportMsg *sql.Stmt
+}
+
+func prepareExport(ctx context.Context, db *sql.DB, wrapDB *DB) error {
+	var err error
+	wrapDB.exportMsg, err = db.PrepareContext(ctx, `
+SELECT `+historyColumns+`
+	FROM messages AS m
+		-- Show the most recent correction in place of the original message.
+		`+latestCorrection+`
+	WHERE ($1='' OR m.rosterJID=$1)
+		AND ($2=0 OR m.delay>=$2)
+		AND ($3=0 OR m.delay<=$3)
+		AND `+skipCorrections+`
+	ORDER BY m.rosterJID ASC, m.delay ASC, m.id ASC`)
+	return err
+}
+
+// Export returns the messages matching the query grouped by conversation and
+// oldest first.
+// Corrections are applied in the same way as QueryHistory.
+// Any errors encountered while querying are deferred until the iter is used.
+func (db *DB) Export(ctx context.Context, q ExportQuery) MessageIter {
+	db.txM.Lock()
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-ctx.Done()
+		defer db.txM.Unlock()
+	}()
+
+	var start, end int64
+	if !q.Start.IsZero() {
+		start = q.Start.Unix()
+	}
+	if !q.End.IsZero() {
+		end = q.End.Unix()
+	}
+	var with string
+	if !q.With.Equal(jid.JID{}) {
+		with = q.With.Bare().String()
+	}
+	rows, err := db.exportMsg.QueryContext(ctx, with, start, end)
+	return MessageIter{
+		Iter: &Iter{
+			cancel: cancel,
+			err:    err,
+			rows:   rows,
+			f:      scanHistoryRow,
+		},
+	}
+}
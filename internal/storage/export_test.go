@@ -0,0 +1,104 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/storage/storagetest"
+	"mellium.im/xmpp/jid"
+)
+
+var exportTestCases = [...]struct {
+	q      storage.ExportQuery
+	expect []string
+}{
+	// Conversations are grouped together and the newest correction replaces the
+	// message it corrects.
+	0: {expect: []string{"5", "1", "3", "6", "2 edited"}},
+	1: {q: storage.ExportQuery{With: juliet}, expect: []string{"1", "3", "6"}},
+	2: {q: storage.ExportQuery{With: tybalt}, expect: []string{"5"}},
+	3: {q: storage.ExportQuery{Start: storagetest.Day(2), End: storagetest.Day(3)}, expect: []string{"3", "2 edited"}},
+	// The bounds are inclusive and compared as instants, whatever their time
+	// zone.
+	4: {q: storage.ExportQuery{Start: time.Date(2020, 1, 5, 1, 0, 0, 0, berlin)}, expect: []string{"5", "6"}},
+	5: {q: storage.ExportQuery{Start: time.Date(2020, 1, 5, 1, 0, 1, 0, berlin)}, expect: []string{"6"}},
+	6: {q: storage.ExportQuery{End: time.Date(2020, 1, 1, 19, 0, 0, 0, newYork)}, expect: []string{"1", "2 edited"}},
+	7: {q: storage.ExportQuery{End: time.Date(2020, 1, 1, 18, 59, 59, 0, newYork)}, expect: []string{"1"}},
+	// Nothing matches.
+	8: {q: storage.ExportQuery{With: jid.MustParse("tybalt@example.com")}},
+	9: {q: storage.ExportQuery{Start: storagetest.Day(4), End: storagetest.Day(3)}},
+}
+
+func TestExport(t *testing.T) {
+	db := storagetest.InsertMsgs(t, self,
+		msg("1", juliet, self, "Wherefore art thou Romeo?"),
+		msg("2", nurse, self, "Madam!"),
+		msg("3", self, juliet, "Call me but love"),
+		withReplace(msg("4", nurse, self, "Madam, madam!"), "2"),
+		groupMsg("5", tybalt, self, "Draw!"),
+		msg("6", juliet, self, "Deny thy father"),
+	)
+	for i, tc := range exportTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var got []string
+			iter := db.Export(context.Background(), tc.q)
+			for iter.Next() {
+				cur := iter.Message()
+				if cur.Edited {
+					cur.ID += " edited"
+				}
+				got = append(got, cur.ID)
+			}
+			if err := iter.Err(); err != nil {
+				t.Fatalf("error exporting: %v", err)
+			}
+			if !slices.Equal(got, tc.expect) {
+				t.Errorf("wrong messages: want=%v, got=%v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestMessageCount(t *testing.T) {
+	ctx := context.Background()
+	db := storagetest.OpenDB(t)
+	n, err := db.MessageCount(ctx)
+	if err != nil || n != 0 {
+		t.Errorf("wrong count for an empty database: want=0, got=%d (err: %v)", n, err)
+	}
+	db = storagetest.InsertMsgs(t, self,
+		msg("1", juliet, self, "Wherefore art thou Romeo?"),
+		// Duplicates are not stored, so they aren't counted.
+		msg("1", juliet, self, "Wherefore art thou Romeo?"),
+		groupMsg("2", tybalt, self, "Draw!"),
+	)
+	n, err = db.MessageCount(ctx)
+	if err != nil || n != 2 {
+		t.Errorf("wrong count: want=2, got=%d (err: %v)", n, err)
+	}
+}
+
+func TestExportCanceled(t *testing.T) {
+	db := storagetest.InsertMsgs(t, self, msg("1", juliet, self, "Wherefore art thou Romeo?"))
+	iter := db.Export(canceled(), storage.ExportQuery{})
+	if iter.Next() {
+		t.Errorf("expected no messages")
+	}
+	if iter.Err() == nil {
+		t.Errorf("expected error exporting")
+	}
+	if err := iter.Close(); err != nil {
+		t.Errorf("error closing iter: %v", err)
+	}
+	if _, err := db.MessageCount(canceled()); err == nil {
+		t.Errorf("expected error counting messages")
+	}
+}
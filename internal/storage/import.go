@@ -0,0 +1,32 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+)
+
+type importQueries struct {
+	countMsg *sql.Stmt
+}
+
+func prepareImport(ctx context.Context, db *sql.DB, wrapDB *DB) error {
+	var err error
+	wrapDB.countMsg, err = db.PrepareContext(ctx, `SELECT COUNT(*) FROM messages`)
+	return err
+}
+
+// MessageCount returns the number of messages stored in the database.
+// Messages that were skipped by InsertMsg because they were already stored are
+// not counted, so comparing the count before and after inserting messages
+// gives the number of new messages.
+func (db *DB) MessageCount(ctx context.Context) (int, error) {
+	var n int
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		return tx.Stmt(db.countMsg).QueryRowContext(ctx).Scan(&n)
+	})
+	return n, err
+}
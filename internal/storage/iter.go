@@ -76,5 +76,9 @@ func (i *Iter) Close() error {
 		return nil
 	}
 	i.cancel()
+	if i.rows == nil {
+		// The query failed, so there is nothing to close.
+		return nil
+	}
 	return i.rows.Close()
 }
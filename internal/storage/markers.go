@@ -0,0 +1,168 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"mellium.im/communique/internal/localerr"
+	"mellium.im/xmpp/jid"
+)
+
+// afterDisplayed is the part of a query that limits the messages m to those
+// after the last displayed message of their conversation.
+// If nothing was ever displayed in a conversation, all of its messages are
+// after it.
+const afterDisplayed = `
+	LEFT JOIN displayed AS d ON d.rosterJID=m.rosterJID
+	LEFT JOIN messages AS p ON p.id=(
+		SELECT id FROM messages
+			WHERE rosterJID=d.rosterJID AND d.msgID IN (idAttr, originID)
+			ORDER BY delay DESC, id DESC
+			LIMIT 1)
+	WHERE (d.rosterJID IS NULL OR (m.delay, m.id) > (p.delay, p.id))`
+
+// Unread is the number of unread messages in a conversation.
+type Unread struct {
+	JID jid.JID
+	// FirstUnread is the ID of the oldest unread message.
+	FirstUnread string
+	Count       int
+}
+
+type markerQueries struct {
+	markDisplayed *sql.Stmt
+	markRead      *sql.Stmt
+	lastMarkable  *sql.Stmt
+	selectUnread  *sql.Stmt
+}
+
+func prepareMarkers(ctx context.Context, db *sql.DB, wrapDB *DB) error {
+	var err error
+	wrapDB.markDisplayed, err = db.PrepareContext(ctx, `
+INSERT INTO displayed (rosterJID, msgID)
+	-- Without the message we can't tell which messages are before it.
+	SELECT $1, $2 WHERE EXISTS (
+		SELECT 1 FROM messages WHERE rosterJID=$1 AND $2 IN (idAttr, originID))
+	ON CONFLICT (rosterJID) DO UPDATE SET msgID=excluded.msgID
+		-- Markers may arrive out of order, so never move back to an older message.
+		WHERE NOT EXISTS (
+			SELECT 1 FROM messages AS o, messages AS n
+				WHERE o.rosterJID=$1 AND displayed.msgID IN (o.idAttr, o.originID)
+					AND n.rosterJID=$1 AND $2 IN (n.idAttr, n.originID)
+					AND (n.delay, n.id) < (o.delay, o.id))`)
+	if err != nil {
+		return err
+	}
+	wrapDB.markRead, err = db.PrepareContext(ctx, `
+INSERT INTO displayed (rosterJID, msgID)
+	SELECT rosterJID, IFNULL(NULLIF(idAttr, ''), originID) FROM messages
+		WHERE rosterJID=$1 AND IFNULL(NULLIF(idAttr, ''), originID) IS NOT NULL
+		ORDER BY delay DESC, id DESC
+		LIMIT 1
+	ON CONFLICT (rosterJID) DO UPDATE SET msgID=excluded.msgID`)
+	if err != nil {
+		return err
+	}
+	wrapDB.lastMarkable, err = db.PrepareContext(ctx, `
+SELECT m.idAttr
+	FROM messages AS m`+afterDisplayed+`
+		AND m.rosterJID=$1 AND m.sent=FALSE AND m.markable=TRUE AND m.idAttr<>''
+	ORDER BY m.delay DESC, m.id DESC
+	LIMIT 1`)
+	if err != nil {
+		return err
+	}
+	wrapDB.selectUnread, err = db.PrepareContext(ctx, `
+SELECT m.rosterJID, m.idAttr, MIN(m.delay), COUNT(*)
+	FROM messages AS m`+afterDisplayed+`
+		AND ($1='' OR m.rosterJID=$1) AND m.rosterJID IS NOT NULL
+		AND m.sent=FALSE AND m.stanzaType<>'groupchat'
+		AND m.retracted=FALSE AND m.replaceID IS NULL AND IFNULL(m.body, '')<>''
+	GROUP BY m.rosterJID`)
+	return err
+}
+
+// MarkDisplayed records that the message with the given ID in the conversation
+// with j was displayed by one of our clients.
+// Earlier messages are considered read as well and the unread count of the
+// conversation is updated to match.
+// Markers for messages that are not stored are ignored.
+func (db *DB) MarkDisplayed(ctx context.Context, j jid.JID, id string) error {
+	bare := j.Bare().String()
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.markDisplayed).ExecContext(ctx, bare, id)
+		if err != nil {
+			return err
+		}
+		var jStr, firstUnread string
+		var delay int64
+		var count int
+		err = tx.Stmt(db.selectUnread).QueryRowContext(ctx, bare).Scan(&jStr, &firstUnread, &delay, &count)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		_, err = tx.Stmt(db.setUnread).ExecContext(ctx, bare, count, firstUnread)
+		return err
+	})
+}
+
+// MarkRead records that every message in the conversation with j was
+// displayed and resets its unread count.
+// It returns the ID of the newest message that was unread until now and that
+// asked for chat markers, or an empty string if there was none.
+func (db *DB) MarkRead(ctx context.Context, j jid.JID) (markable string, err error) {
+	err = execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.Stmt(db.lastMarkable).QueryRowContext(ctx, j.Bare().String()).Scan(&markable)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		_, err = tx.Stmt(db.markRead).ExecContext(ctx, j.Bare().String())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Stmt(db.setUnread).ExecContext(ctx, j.Bare().String(), 0, "")
+		return err
+	})
+	return markable, err
+}
+
+// Unread returns the conversations with j that have unread messages.
+// If j is the zero value, all conversations with unread messages are returned.
+// Messages in channels are not counted.
+func (db *DB) Unread(ctx context.Context, j jid.JID) ([]Unread, error) {
+	var results []Unread
+	var with string
+	if !j.Equal(jid.JID{}) {
+		with = j.Bare().String()
+	}
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.Stmt(db.selectUnread).QueryContext(ctx, with)
+		if err != nil {
+			return localerr.Wrap(db.p, "error getting unread messages: %v", err)
+		}
+		for rows.Next() {
+			var cur Unread
+			var jStr string
+			var delay int64
+			err = rows.Scan(&jStr, &cur.FirstUnread, &delay, &cur.Count)
+			if err != nil {
+				return localerr.Wrap(db.p, "error scanning unread messages: %v", err)
+			}
+			unsafeJID, err := jid.ParseUnsafe(jStr)
+			if err != nil {
+				return localerr.Wrap(db.p, "failed to parse conversation JID: %v", err)
+			}
+			cur.JID = unsafeJID.JID
+			results = append(results, cur)
+		}
+		if err = rows.Err(); err != nil {
+			return localerr.Wrap(db.p, "error iterating over unread messages: %v", err)
+		}
+		return nil
+	})
+	return results, err
+}
@@ -0,0 +1,191 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/storage/storagetest"
+	"mellium.im/xmpp/jid"
+)
+
+func markable(m event.ChatMessage) event.ChatMessage {
+	m.Markable = &event.Markable{}
+	return m
+}
+
+func unread(t *testing.T, db *storage.DB, j jid.JID) []storage.Unread {
+	t.Helper()
+	u, err := db.Unread(context.Background(), j)
+	if err != nil {
+		t.Fatalf("error getting unread messages: %v", err)
+	}
+	slices.SortFunc(u, func(a, b storage.Unread) int {
+		return strings.Compare(a.JID.String(), b.JID.String())
+	})
+	return u
+}
+
+func unreadEqual(a, b storage.Unread) bool {
+	return a.JID.Equal(b.JID) && a.FirstUnread == b.FirstUnread && a.Count == b.Count
+}
+
+// displayed marks a message as displayed, or the whole conversation as read if
+// the ID is empty.
+type displayed struct {
+	with jid.JID
+	id   string
+}
+
+var markerTestCases = [...]struct {
+	displayed []displayed
+	with      jid.JID
+	markable  string
+	expect    []storage.Unread
+}{
+	0: {
+		with:     juliet,
+		markable: "3",
+		expect: []storage.Unread{
+			{JID: juliet, FirstUnread: "1", Count: 3},
+			{JID: nurse, FirstUnread: "4", Count: 1},
+		},
+	},
+	1: {
+		// Another client displayed the second message, and then a marker for the
+		// first message arrives late.
+		displayed: []displayed{{juliet, "2"}, {juliet, "1"}},
+		with:      juliet,
+		markable:  "3",
+		expect: []storage.Unread{
+			{JID: juliet, FirstUnread: "3", Count: 1},
+			{JID: nurse, FirstUnread: "4", Count: 1},
+		},
+	},
+	2: {
+		// After reading a conversation there is nothing left to mark.
+		displayed: []displayed{{with: juliet}},
+		with:      juliet,
+		expect:    []storage.Unread{{JID: nurse, FirstUnread: "4", Count: 1}},
+	},
+	3: {
+		// Markers for messages we don't have, or from another conversation, don't
+		// change anything.
+		displayed: []displayed{{juliet, "unknown"}, {juliet, "4"}, {nurse, "1"}},
+		with:      juliet,
+		markable:  "3",
+		expect: []storage.Unread{
+			{JID: juliet, FirstUnread: "1", Count: 3},
+			{JID: nurse, FirstUnread: "4", Count: 1},
+		},
+	},
+	4: {
+		// The nurse did not ask for markers and reading a conversation without
+		// messages does nothing.
+		displayed: []displayed{{with: tybalt}},
+		with:      nurse,
+		expect: []storage.Unread{
+			{JID: juliet, FirstUnread: "1", Count: 3},
+			{JID: nurse, FirstUnread: "4", Count: 1},
+		},
+	},
+	5: {
+		// Everything was displayed by another client.
+		displayed: []displayed{{juliet, "3"}, {nurse, "4"}},
+		with:      juliet,
+	},
+}
+
+func TestMarkers(t *testing.T) {
+	for i, tc := range markerTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ctx := context.Background()
+			db := storagetest.InsertMsgs(t, self,
+				markable(msg("1", juliet, self, "Wherefore art thou Romeo?")),
+				markable(msg("2", juliet, self, "Deny thy father")),
+				markable(msg("3", juliet, self, "And refuse thy name")),
+				msg("4", nurse, self, "Madam!"),
+				// Messages in channels are never unread.
+				markable(groupMsg("5", tybalt, self, "Draw!")),
+			)
+			for _, d := range tc.displayed {
+				if d.id != "" {
+					if err := db.MarkDisplayed(ctx, d.with, d.id); err != nil {
+						t.Fatalf("error marking %s as displayed: %v", d.id, err)
+					}
+					continue
+				}
+				if _, err := db.MarkRead(ctx, d.with); err != nil {
+					t.Fatalf("error marking %s as read: %v", d.with, err)
+				}
+			}
+			if u := unread(t, db, jid.JID{}); !slices.EqualFunc(u, tc.expect, unreadEqual) {
+				t.Errorf("wrong unread messages: want=%v, got=%v", tc.expect, u)
+			}
+
+			id, err := db.MarkRead(ctx, tc.with)
+			if err != nil {
+				t.Fatalf("error marking conversation as read: %v", err)
+			}
+			if id != tc.markable {
+				t.Errorf("wrong message to send marker for: want=%q, got=%q", tc.markable, id)
+			}
+			if u := unread(t, db, tc.with); len(u) != 0 {
+				t.Errorf("expected no unread messages after reading, got=%v", u)
+			}
+			id, err = db.MarkRead(ctx, tc.with)
+			if err != nil {
+				t.Fatalf("error marking conversation as read again: %v", err)
+			}
+			if id != "" {
+				t.Errorf("expected no marker to be sent twice, got=%q", id)
+			}
+		})
+	}
+}
+
+func TestUnreadSkipped(t *testing.T) {
+	db := storagetest.InsertMsgs(t, self,
+		msg("1", juliet, self, "Wherefore art thou Romoe?"),
+		// Corrections and retracted messages are not counted, and neither are
+		// markers and other messages without a body.
+		withReplace(msg("2", juliet, self, "Wherefore art thou Romeo?"), "1"),
+		msg("4", juliet, self, "Deny thy father"),
+		msg("5", juliet, self, ""),
+		msg("6", self, juliet, "Call me but love"),
+	)
+	err := db.Retract(context.Background(), retraction(juliet, self, "4"), self)
+	if err != nil {
+		t.Fatalf("error retracting message: %v", err)
+	}
+	expect := []storage.Unread{{JID: juliet, FirstUnread: "1", Count: 1}}
+	if u := unread(t, db, juliet); !slices.EqualFunc(u, expect, unreadEqual) {
+		t.Errorf("wrong unread messages: want=%v, got=%v", expect, u)
+	}
+}
+
+func TestMarkersCanceled(t *testing.T) {
+	db := storagetest.InsertMsgs(t, self, markable(msg("1", juliet, self, "Wherefore art thou Romeo?")))
+	if err := db.MarkDisplayed(canceled(), juliet, "1"); err == nil {
+		t.Errorf("expected error marking message as displayed")
+	}
+	if _, err := db.MarkRead(canceled(), juliet); err == nil {
+		t.Errorf("expected error marking conversation as read")
+	}
+	if _, err := db.Unread(canceled(), jid.JID{}); err == nil {
+		t.Errorf("expected error getting unread messages")
+	}
+	// Nothing was marked.
+	expect := []storage.Unread{{JID: juliet, FirstUnread: "1", Count: 1}}
+	if u := unread(t, db, jid.JID{}); !slices.EqualFunc(u, expect, unreadEqual) {
+		t.Errorf("wrong unread messages: want=%v, got=%v", expect, u)
+	}
+}
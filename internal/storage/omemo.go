@@ -0,0 +1,380 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"mellium.im/communique/internal/omemo"
+	"mellium.im/xmpp/jid"
+)
+
+type omemoQueries struct {
+	getOMEMOIdent     *sql.Stmt
+	setOMEMOIdent     *sql.Stmt
+	getPreKeys        *sql.Stmt
+	getPreKey         *sql.Stmt
+	insertPreKey      *sql.Stmt
+	delPreKey         *sql.Stmt
+	getDevices        *sql.Stmt
+	deactivateDevices *sql.Stmt
+	upsertDevice      *sql.Stmt
+	setDeviceIdent    *sql.Stmt
+	setTrust          *sql.Stmt
+	getSession        *sql.Stmt
+	setSession        *sql.Stmt
+	getDeviceOwners   *sql.Stmt
+	getOMEMOEnabled   *sql.Stmt
+	setOMEMOEnabled   *sql.Stmt
+}
+
+func prepareOMEMO(ctx context.Context, db *sql.DB, wrapDB *DB) error {
+	var err error
+	wrapDB.getOMEMOIdent, err = db.PrepareContext(ctx, `
+SELECT deviceID, seed, spkID, spkPriv, spkSig FROM omemoIdentity WHERE id=0`)
+	if err != nil {
+		return err
+	}
+	wrapDB.setOMEMOIdent, err = db.PrepareContext(ctx, `
+INSERT INTO omemoIdentity (id, deviceID, seed, spkID, spkPriv, spkSig)
+	VALUES (FALSE, $1, $2, $3, $4, $5)
+	ON CONFLICT(id) DO UPDATE SET deviceID=$1, seed=$2, spkID=$3, spkPriv=$4, spkSig=$5`)
+	if err != nil {
+		return err
+	}
+	wrapDB.getPreKeys, err = db.PrepareContext(ctx, `
+SELECT id, priv FROM omemoPreKeys ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	wrapDB.getPreKey, err = db.PrepareContext(ctx, `
+SELECT priv FROM omemoPreKeys WHERE id=$1`)
+	if err != nil {
+		return err
+	}
+	wrapDB.insertPreKey, err = db.PrepareContext(ctx, `
+INSERT INTO omemoPreKeys (id, priv)
+	VALUES ($1, $2)
+	ON CONFLICT(id) DO UPDATE SET priv=$2`)
+	if err != nil {
+		return err
+	}
+	wrapDB.delPreKey, err = db.PrepareContext(ctx, `
+DELETE FROM omemoPreKeys WHERE id=$1`)
+	if err != nil {
+		return err
+	}
+	wrapDB.getDevices, err = db.PrepareContext(ctx, `
+SELECT deviceID, identity, trust, active FROM omemoDevices
+	WHERE jid=$1
+	ORDER BY deviceID`)
+	if err != nil {
+		return err
+	}
+	wrapDB.deactivateDevices, err = db.PrepareContext(ctx, `
+UPDATE omemoDevices SET active=FALSE WHERE jid=$1`)
+	if err != nil {
+		return err
+	}
+	wrapDB.upsertDevice, err = db.PrepareContext(ctx, `
+INSERT INTO omemoDevices (jid, deviceID, active)
+	VALUES ($1, $2, TRUE)
+	ON CONFLICT(jid, deviceID) DO UPDATE SET active=TRUE`)
+	if err != nil {
+		return err
+	}
+	// If the identity key of a device changes, any trust decision and session
+	// built with the old key no longer apply.
+	wrapDB.setDeviceIdent, err = db.PrepareContext(ctx, `
+INSERT INTO omemoDevices (jid, deviceID, identity)
+	VALUES ($1, $2, $3)
+	ON CONFLICT(jid, deviceID) DO UPDATE SET
+		trust=CASE WHEN identity=$3 THEN trust ELSE 0 END,
+		session=CASE WHEN identity=$3 OR identity IS NULL THEN session ELSE NULL END,
+		identity=$3`)
+	if err != nil {
+		return err
+	}
+	wrapDB.setTrust, err = db.PrepareContext(ctx, `
+UPDATE omemoDevices SET trust=$3 WHERE jid=$1 AND deviceID=$2`)
+	if err != nil {
+		return err
+	}
+	wrapDB.getSession, err = db.PrepareContext(ctx, `
+SELECT session FROM omemoDevices WHERE jid=$1 AND deviceID=$2`)
+	if err != nil {
+		return err
+	}
+	wrapDB.setSession, err = db.PrepareContext(ctx, `
+INSERT INTO omemoDevices (jid, deviceID, session)
+	VALUES ($1, $2, $3)
+	ON CONFLICT(jid, deviceID) DO UPDATE SET session=$3`)
+	if err != nil {
+		return err
+	}
+	wrapDB.getDeviceOwners, err = db.PrepareContext(ctx, `
+SELECT jid FROM omemoDevices WHERE deviceID=$1`)
+	if err != nil {
+		return err
+	}
+	wrapDB.getOMEMOEnabled, err = db.PrepareContext(ctx, `
+SELECT enabled FROM omemoEnabled WHERE jid=$1`)
+	if err != nil {
+		return err
+	}
+	wrapDB.setOMEMOEnabled, err = db.PrepareContext(ctx, `
+INSERT INTO omemoEnabled (jid, enabled)
+	VALUES ($1, $2)
+	ON CONFLICT(jid) DO UPDATE SET enabled=$2`)
+	return err
+}
+
+// OMEMOIdentity returns our own OMEMO identity and signed prekey.
+// If no identity has been created yet, ok is false.
+func (db *DB) OMEMOIdentity(ctx context.Context) (id omemo.Identity, spk omemo.SignedPreKey, ok bool, err error) {
+	err = execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		var spkPriv []byte
+		err := tx.Stmt(db.getOMEMOIdent).QueryRowContext(ctx).Scan(&id.DeviceID, &id.Seed, &spk.ID, &spkPriv, &spk.Signature)
+		if err != nil {
+			return err
+		}
+		spk.KeyPair, err = omemo.NewKeyPair(spkPriv)
+		return err
+	})
+	switch err {
+	case sql.ErrNoRows:
+		return id, spk, false, nil
+	case nil:
+		return id, spk, true, nil
+	}
+	return id, spk, false, err
+}
+
+// SetOMEMOIdentity stores our own OMEMO identity and signed prekey.
+func (db *DB) SetOMEMOIdentity(ctx context.Context, id omemo.Identity, spk omemo.SignedPreKey) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.setOMEMOIdent).ExecContext(ctx, id.DeviceID, id.Seed, spk.ID, spk.KeyPair.Private[:], spk.Signature)
+		return err
+	})
+}
+
+// OMEMOPreKeys returns all unused one time prekeys.
+func (db *DB) OMEMOPreKeys(ctx context.Context) ([]omemo.PreKey, error) {
+	var keys []omemo.PreKey
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.Stmt(db.getPreKeys).QueryContext(ctx)
+		if err != nil {
+			return err
+		}
+		/* #nosec */
+		defer rows.Close()
+		for rows.Next() {
+			var pk omemo.PreKey
+			var priv []byte
+			err = rows.Scan(&pk.ID, &priv)
+			if err != nil {
+				return err
+			}
+			pk.KeyPair, err = omemo.NewKeyPair(priv)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, pk)
+		}
+		return rows.Err()
+	})
+	return keys, err
+}
+
+// OMEMOPreKey returns the one time prekey with the given ID, or nil if it does
+// not exist (for example, because it was already used).
+func (db *DB) OMEMOPreKey(ctx context.Context, id uint32) (*omemo.PreKey, error) {
+	var priv []byte
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		return tx.Stmt(db.getPreKey).QueryRowContext(ctx, id).Scan(&priv)
+	})
+	switch err {
+	case sql.ErrNoRows:
+		return nil, nil
+	case nil:
+	default:
+		return nil, err
+	}
+	kp, err := omemo.NewKeyPair(priv)
+	if err != nil {
+		return nil, err
+	}
+	return &omemo.PreKey{ID: id, KeyPair: kp}, nil
+}
+
+// AddOMEMOPreKeys stores newly generated one time prekeys.
+func (db *DB) AddOMEMOPreKeys(ctx context.Context, keys []omemo.PreKey) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		stmt := tx.Stmt(db.insertPreKey)
+		for _, pk := range keys {
+			_, err := stmt.ExecContext(ctx, pk.ID, pk.KeyPair.Private[:])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveOMEMOPreKey removes a one time prekey after it has been used.
+func (db *DB) RemoveOMEMOPreKey(ctx context.Context, id uint32) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.delPreKey).ExecContext(ctx, id)
+		return err
+	})
+}
+
+// OMEMODevices returns all known devices belonging to j, including inactive
+// ones.
+func (db *DB) OMEMODevices(ctx context.Context, j jid.JID) ([]omemo.Device, error) {
+	var devices []omemo.Device
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.Stmt(db.getDevices).QueryContext(ctx, j.Bare().String())
+		if err != nil {
+			return err
+		}
+		/* #nosec */
+		defer rows.Close()
+		for rows.Next() {
+			var dev omemo.Device
+			var ident []byte
+			err = rows.Scan(&dev.ID, &ident, &dev.Trust, &dev.Active)
+			if err != nil {
+				return err
+			}
+			if ident != nil {
+				dev.Identity, err = omemo.ParsePublicKey(ident)
+				if err != nil {
+					return err
+				}
+			}
+			devices = append(devices, dev)
+		}
+		return rows.Err()
+	})
+	return devices, err
+}
+
+// SetOMEMODevices replaces the list of active devices belonging to j.
+// Devices that are no longer in the list are kept so that their trust is
+// remembered, but are marked as inactive.
+func (db *DB) SetOMEMODevices(ctx context.Context, j jid.JID, list omemo.DeviceList) error {
+	bare := j.Bare().String()
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.deactivateDevices).ExecContext(ctx, bare)
+		if err != nil {
+			return err
+		}
+		stmt := tx.Stmt(db.upsertDevice)
+		for _, id := range list {
+			_, err = stmt.ExecContext(ctx, bare, id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetOMEMODeviceIdentity records the identity key of a device.
+// If it differs from the previously recorded key, the trust in the device is
+// reset and any existing session is removed.
+func (db *DB) SetOMEMODeviceIdentity(ctx context.Context, j jid.JID, id uint32, key omemo.PublicKey) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.setDeviceIdent).ExecContext(ctx, j.Bare().String(), id, key.Serialize())
+		return err
+	})
+}
+
+// SetOMEMOTrust sets the trust placed in a device.
+func (db *DB) SetOMEMOTrust(ctx context.Context, j jid.JID, id uint32, trust omemo.Trust) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.setTrust).ExecContext(ctx, j.Bare().String(), id, trust)
+		return err
+	})
+}
+
+// OMEMOSession returns the session with a device, or nil if none exists.
+func (db *DB) OMEMOSession(ctx context.Context, j jid.JID, id uint32) (*omemo.Session, error) {
+	var raw *string
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		return tx.Stmt(db.getSession).QueryRowContext(ctx, j.Bare().String(), id).Scan(&raw)
+	})
+	switch {
+	case err == sql.ErrNoRows || (err == nil && raw == nil):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	s := &omemo.Session{}
+	return s, json.Unmarshal([]byte(*raw), s)
+}
+
+// SetOMEMOSession stores the session with a device.
+func (db *DB) SetOMEMOSession(ctx context.Context, j jid.JID, id uint32, s *omemo.Session) error {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.setSession).ExecContext(ctx, j.Bare().String(), id, string(raw))
+		return err
+	})
+}
+
+// OMEMODeviceOwners returns the addresses that have published a device with
+// the given ID.
+func (db *DB) OMEMODeviceOwners(ctx context.Context, id uint32) ([]jid.JID, error) {
+	var owners []jid.JID
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.Stmt(db.getDeviceOwners).QueryContext(ctx, id)
+		if err != nil {
+			return err
+		}
+		/* #nosec */
+		defer rows.Close()
+		for rows.Next() {
+			var s string
+			err = rows.Scan(&s)
+			if err != nil {
+				return err
+			}
+			j, err := jid.ParseUnsafe(s)
+			if err != nil {
+				return err
+			}
+			owners = append(owners, j.JID)
+		}
+		return rows.Err()
+	})
+	return owners, err
+}
+
+// OMEMOEnabled reports whether messages to j should be encrypted.
+func (db *DB) OMEMOEnabled(ctx context.Context, j jid.JID) (bool, error) {
+	var enabled bool
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		return tx.Stmt(db.getOMEMOEnabled).QueryRowContext(ctx, j.Bare().String()).Scan(&enabled)
+	})
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return enabled, err
+}
+
+// SetOMEMOEnabled sets whether messages to j should be encrypted.
+func (db *DB) SetOMEMOEnabled(ctx context.Context, j jid.JID, enabled bool) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.setOMEMOEnabled).ExecContext(ctx, j.Bare().String(), enabled)
+		return err
+	})
+}
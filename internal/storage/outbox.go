@@ -0,0 +1,147 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/localerr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+type outboxQueries struct {
+	queueMsg   *sql.Stmt
+	nextQueued *sql.Stmt
+	dequeueMsg *sql.Stmt
+	selectOut  *sql.Stmt
+}
+
+func prepareOutbox(ctx context.Context, db *sql.DB, wrapDB *DB) error {
+	var err error
+	wrapDB.queueMsg, err = db.PrepareContext(ctx, `
+INSERT INTO outbox (originID, toAttr, stanzaType, body, replaceID)
+	VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+	ON CONFLICT (originID) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+	wrapDB.nextQueued, err = db.PrepareContext(ctx, `
+SELECT `+outboxColumns+`
+	FROM outbox
+	ORDER BY id ASC
+	LIMIT 1`)
+	if err != nil {
+		return err
+	}
+	wrapDB.dequeueMsg, err = db.PrepareContext(ctx, `
+DELETE FROM outbox WHERE originID=$1`)
+	if err != nil {
+		return err
+	}
+	wrapDB.selectOut, err = db.PrepareContext(ctx, `
+SELECT `+outboxColumns+`
+	FROM outbox
+	ORDER BY id ASC`)
+	return err
+}
+
+const outboxColumns = `originID, toAttr, stanzaType, body, IFNULL(replaceID, ''), queued`
+
+// QueuedMessage is a message in the outbox.
+type QueuedMessage struct {
+	event.ChatMessage
+	Queued time.Time
+}
+
+// QueueMsg adds a message that we are sending to the end of the outbox.
+// The message must have an origin ID which is used to remove it from the outbox
+// again once it has been sent.
+func (db *DB) QueueMsg(ctx context.Context, msg event.ChatMessage) error {
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.queueMsg).ExecContext(ctx, msg.OriginID.ID, msg.To.String(), string(msg.Type), msg.Body, msg.Replace.ID)
+		return err
+	})
+}
+
+// NextQueued returns the oldest message in the outbox.
+// If the outbox is empty, ok is false.
+func (db *DB) NextQueued(ctx context.Context) (msg event.ChatMessage, ok bool, err error) {
+	err = execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		q, err := scanQueued(tx.Stmt(db.nextQueued).QueryRowContext(ctx))
+		switch err {
+		case sql.ErrNoRows:
+			return nil
+		case nil:
+			msg, ok = q.ChatMessage, true
+			return nil
+		}
+		return localerr.Wrap(db.p, "error getting the next message in the outbox: %v", err)
+	})
+	return msg, ok, err
+}
+
+// Dequeue removes the message with the given origin ID from the outbox and
+// reports whether it was still there.
+func (db *DB) Dequeue(ctx context.Context, originID string) (bool, error) {
+	var removed bool
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.Stmt(db.dequeueMsg).ExecContext(ctx, originID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		removed = n > 0
+		return err
+	})
+	return removed, err
+}
+
+// Outbox returns every message in the outbox, oldest first.
+func (db *DB) Outbox(ctx context.Context) ([]QueuedMessage, error) {
+	var results []QueuedMessage
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.Stmt(db.selectOut).QueryContext(ctx)
+		if err != nil {
+			return localerr.Wrap(db.p, "error getting the outbox: %v", err)
+		}
+		for rows.Next() {
+			cur, err := scanQueued(rows)
+			if err != nil {
+				return localerr.Wrap(db.p, "error scanning the outbox: %v", err)
+			}
+			results = append(results, cur)
+		}
+		if err = rows.Err(); err != nil {
+			return localerr.Wrap(db.p, "error iterating over the outbox: %v", err)
+		}
+		return nil
+	})
+	return results, err
+}
+
+// scanQueued scans a row selected with outboxColumns.
+func scanQueued(row interface{ Scan(...any) error }) (QueuedMessage, error) {
+	var cur QueuedMessage
+	var to, typ string
+	var queued int64
+	err := row.Scan(&cur.OriginID.ID, &to, &typ, &cur.Body, &cur.Replace.ID, &queued)
+	if err != nil {
+		return cur, err
+	}
+	unsafeTo, err := jid.ParseUnsafe(to)
+	if err != nil {
+		return cur, err
+	}
+	cur.To = unsafeTo.JID
+	cur.ID = cur.OriginID.ID
+	cur.Type = stanza.MessageType(typ)
+	cur.Sent = true
+	cur.Queued = time.Unix(queued, 0)
+	return cur, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/storage/storagetest"
+)
+
+func outgoing(id string) event.ChatMessage {
+	return withOrigin(msg(id, self, juliet, "Parting is such sweet sorrow "+id), id)
+}
+
+// drain sends every message in the outbox and returns their origin IDs in the
+// order they were sent.
+func drain(t *testing.T, db *storage.DB) []string {
+	t.Helper()
+	ctx := context.Background()
+	var sent []string
+	for {
+		m, ok, err := db.NextQueued(ctx)
+		if err != nil {
+			t.Fatalf("error getting next queued message: %v", err)
+		}
+		if !ok {
+			return sent
+		}
+		if !m.To.Equal(juliet) || m.OriginID.ID != m.ID || m.Body == "" || !m.Sent {
+			t.Errorf("queued message was not restored: %+v", m)
+		}
+		sent = append(sent, m.OriginID.ID)
+		removed, err := db.Dequeue(ctx, m.OriginID.ID)
+		if err != nil || !removed {
+			t.Fatalf("error dequeueing message: removed=%t, err=%v", removed, err)
+		}
+	}
+}
+
+var outboxTestCases = [...]struct {
+	queue   []string
+	dequeue []string
+	removed []bool
+	expect  []string
+}{
+	0: {},
+	1: {queue: []string{"1", "2", "3"}, expect: []string{"1", "2", "3"}},
+	// Cancelling a message leaves the rest in place.
+	2: {
+		queue:   []string{"1", "2", "3"},
+		dequeue: []string{"2"},
+		removed: []bool{true},
+		expect:  []string{"1", "3"},
+	},
+	// A message is only queued once and keeps its place.
+	3: {queue: []string{"1", "2", "1"}, expect: []string{"1", "2"}},
+	// Dequeueing a message that isn't queued does nothing.
+	4: {
+		queue:   []string{"1"},
+		dequeue: []string{"2", "", "1", "1"},
+		removed: []bool{false, false, true, false},
+	},
+}
+
+func TestOutbox(t *testing.T) {
+	for i, tc := range outboxTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			ctx := context.Background()
+			db := storagetest.OpenDB(t)
+			for _, id := range tc.queue {
+				if err := db.QueueMsg(ctx, outgoing(id)); err != nil {
+					t.Fatalf("error queueing message %s: %v", id, err)
+				}
+			}
+			var removed []bool
+			for _, id := range tc.dequeue {
+				ok, err := db.Dequeue(ctx, id)
+				if err != nil {
+					t.Fatalf("error dequeueing message %q: %v", id, err)
+				}
+				removed = append(removed, ok)
+			}
+			if !slices.Equal(removed, tc.removed) {
+				t.Errorf("wrong messages removed: want=%v, got=%v", tc.removed, removed)
+			}
+			out, err := db.Outbox(ctx)
+			if err != nil {
+				t.Fatalf("error listing outbox: %v", err)
+			}
+			var listed []string
+			for _, m := range out {
+				listed = append(listed, m.OriginID.ID)
+			}
+			if !slices.Equal(listed, tc.expect) {
+				t.Errorf("wrong outbox: want=%v, got=%v", tc.expect, listed)
+			}
+			if sent := drain(t, db); !slices.Equal(sent, tc.expect) {
+				t.Errorf("wrong order: want=%v, got=%v", tc.expect, sent)
+			}
+		})
+	}
+}
+
+func TestOutboxHistory(t *testing.T) {
+	ctx := context.Background()
+	db := storagetest.InsertMsgs(t, self, outgoing("1"), outgoing("2"), msg("3", juliet, self, "Good night"))
+	if err := db.QueueMsg(ctx, outgoing("2")); err != nil {
+		t.Fatalf("error queueing message: %v", err)
+	}
+	// Messages that were received never show up as queued, even if their ID
+	// matches a queued message.
+	if err := db.QueueMsg(ctx, outgoing("3")); err != nil {
+		t.Fatalf("error queueing message: %v", err)
+	}
+	queued := func(iter storage.MessageIter) bool {
+		return iter.Queued()
+	}
+	if got, want := history(t, db, juliet, queued), []bool{false, true, false}; !slices.Equal(got, want) {
+		t.Errorf("wrong queued state in history: want=%v, got=%v", want, got)
+	}
+	drain(t, db)
+	if got, want := history(t, db, juliet, queued), []bool{false, false, false}; !slices.Equal(got, want) {
+		t.Errorf("wrong queued state after sending: want=%v, got=%v", want, got)
+	}
+}
+
+func TestOutboxCanceled(t *testing.T) {
+	db := storagetest.OpenDB(t)
+	if err := db.QueueMsg(canceled(), outgoing("1")); err == nil {
+		t.Errorf("expected error queueing message")
+	}
+	if _, _, err := db.NextQueued(canceled()); err == nil {
+		t.Errorf("expected error getting next queued message")
+	}
+	if _, err := db.Dequeue(canceled(), "1"); err == nil {
+		t.Errorf("expected error dequeueing message")
+	}
+	if _, err := db.Outbox(canceled()); err == nil {
+		t.Errorf("expected error listing outbox")
+	}
+	if sent := drain(t, db); len(sent) != 0 {
+		t.Errorf("expected empty outbox, got=%v", sent)
+	}
+}
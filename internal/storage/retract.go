@@ -0,0 +1,144 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/localerr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// RetractableMessage is a message that can still be retracted.
+type RetractableMessage struct {
+	// ID is the origin ID of a message that we sent, or the stanza ID assigned
+	// by the channel if the message is to be moderated.
+	ID    string
+	Body  string
+	Delay time.Time
+}
+
+type retractQueries struct {
+	retractMsg  *sql.Stmt
+	retractable *sql.Stmt
+}
+
+func prepareRetract(ctx context.Context, db *sql.DB, wrapDB *DB) error {
+	var err error
+	wrapDB.retractMsg, err = db.PrepareContext(ctx, `
+WITH target AS (
+	-- If a correction is retracted, the original message and all of its
+	-- corrections are retracted with it.
+	SELECT fromAttr, IFNULL(replaceID, idAttr) AS key FROM messages
+		WHERE rosterJID=$1
+			AND (($2<>'' AND fromAttr=$2 AND $3 IN (idAttr, originID) AND ($6='' OR nick=$6))
+				OR ($2='' AND archiveID=$3))
+	UNION
+	SELECT fromAttr, IFNULL(replaceID, originID) FROM messages
+		WHERE rosterJID=$1
+			AND (($2<>'' AND fromAttr=$2 AND $3 IN (idAttr, originID) AND ($6='' OR nick=$6))
+				OR ($2='' AND archiveID=$3))
+)
+UPDATE messages SET body='', retracted=TRUE, retractedBy=NULLIF($4, ''), retractReason=NULLIF($5, '')
+	WHERE rosterJID=$1
+		AND fromAttr IN (SELECT fromAttr FROM target)
+		AND (idAttr IN (SELECT key FROM target)
+			OR originID IN (SELECT key FROM target)
+			OR replaceID IN (SELECT key FROM target))`)
+	if err != nil {
+		return err
+	}
+	wrapDB.retractable, err = db.PrepareContext(ctx, `
+SELECT IIF($2, m.archiveID, m.originID), IFNULL((
+	SELECT body FROM messages AS c
+		WHERE c.rosterJID=m.rosterJID AND c.fromAttr=m.fromAttr AND (m.sent OR IFNULL(c.nick, '')=IFNULL(m.nick, ''))
+			AND c.replaceID IN (m.idAttr, m.originID)
+		ORDER BY c.delay DESC, c.id DESC
+		LIMIT 1), m.body), m.delay
+	FROM messages AS m
+	WHERE m.rosterJID=$1 AND m.retracted=FALSE AND m.replaceID IS NULL
+		AND IFNULL(m.body, '')<>''
+		AND IIF($2, m.archiveID IS NOT NULL AND m.stanzaType='groupchat', m.sent=TRUE AND m.originID IS NOT NULL)
+	ORDER BY m.delay DESC, m.id DESC
+	LIMIT $3`)
+	return err
+}
+
+// Retract replaces the message that msg retracts, and any corrections of it,
+// with a tombstone.
+// Messages retracted by their sender are found using their origin ID, and
+// moderated messages using the stanza ID assigned by the channel.
+func (db *DB) Retract(ctx context.Context, msg event.ChatMessage, addr jid.JID) error {
+	if msg.Retract == nil {
+		return nil
+	}
+	if msg.From.Equal(jid.JID{}) {
+		msg.From = addr
+	}
+	rosterJID := msg.From.Bare().String()
+	if msg.Sent {
+		rosterJID = msg.To.Bare().String()
+	}
+	fromAttr := msg.From.Bare().String()
+	// Every message in a channel is from the channel, so the occupant that
+	// retracts a message must also have sent it.
+	var nick string
+	if msg.Type == stanza.GroupChatMessage && !msg.Sent {
+		nick = msg.From.Resourcepart()
+	}
+	var by string
+	if msg.Retract.Moderated != nil {
+		fromAttr = ""
+		by = msg.Retract.Moderated.By.String()
+	}
+	return execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.Stmt(db.retractMsg).ExecContext(ctx, rosterJID, fromAttr, msg.Retract.ID, by, msg.Retract.Reason, nick)
+		return err
+	})
+}
+
+// Retractable returns the most recent messages in a conversation that can be
+// retracted, newest first.
+// If moderate is true the messages sent by anyone in the channel j are
+// returned, otherwise only the messages that we sent.
+func (db *DB) Retractable(ctx context.Context, j jid.JID, moderate bool, limit int) ([]RetractableMessage, error) {
+	var results []RetractableMessage
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.Stmt(db.retractable).QueryContext(ctx, j.Bare().String(), moderate, limit)
+		if err != nil {
+			return localerr.Wrap(db.p, "error getting retractable messages: %v", err)
+		}
+		for rows.Next() {
+			var msg RetractableMessage
+			var delay int64
+			err = rows.Scan(&msg.ID, &msg.Body, &delay)
+			if err != nil {
+				return localerr.Wrap(db.p, "error scanning retractable messages: %v", err)
+			}
+			msg.Delay = time.Unix(delay, 0)
+			results = append(results, msg)
+		}
+		if err = rows.Err(); err != nil {
+			return localerr.Wrap(db.p, "error iterating over retractable messages: %v", err)
+		}
+		return nil
+	})
+	return results, err
+}
+
+// archivedBy returns the address of the archive that assigns the stanza IDs we
+// store for a message.
+// Messages in channels are archived by the channel, which also uses the ID to
+// moderate them, and all others by our own account.
+func archivedBy(msg event.ChatMessage, rosterJID string, addr jid.JID) string {
+	if msg.Type == stanza.GroupChatMessage {
+		return rosterJID
+	}
+	return addr.Bare().String()
+}
@@ -0,0 +1,269 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/storage/storagetest"
+	"mellium.im/xmpp/jid"
+)
+
+var escalus = jid.MustParse("capulets@muc.example.com/escalus")
+
+type retractLine struct {
+	ID        string
+	Body      string
+	Retracted bool
+	Reason    string
+	By        string
+}
+
+func retractLines(iter storage.MessageIter) retractLine {
+	cur := iter.Message()
+	l := retractLine{ID: cur.ID, Body: cur.Body, Retracted: cur.Retracted}
+	if cur.Retract != nil {
+		l.Reason = cur.Retract.Reason
+		if cur.Retract.Moderated != nil {
+			l.By = cur.Retract.Moderated.By.String()
+		}
+	}
+	return l
+}
+
+func retraction(from, to jid.JID, id string) event.ChatMessage {
+	m := msg("", from, to, "")
+	m.Retract = &event.Retract{ID: id}
+	return m
+}
+
+func groupRetraction(from jid.JID, id string) event.ChatMessage {
+	m := groupMsg("", from, self, "")
+	m.Retract = &event.Retract{ID: id}
+	return m
+}
+
+func moderation(id, reason string) event.ChatMessage {
+	m := groupMsg("", room, self, "")
+	m.Retract = &event.Retract{
+		ID:        id,
+		Moderated: &event.Moderated{By: escalus},
+		Reason:    reason,
+	}
+	return m
+}
+
+var retractTestCases = [...]struct {
+	retract []event.ChatMessage
+	with    jid.JID
+	expect  []retractLine
+}{
+	0: {
+		with: juliet,
+		expect: []retractLine{
+			{ID: "1", Body: "Wherefore art thou Romeo?"},
+			{ID: "3", Body: "Call me but love"},
+			{ID: "4", Body: "Deny thy father"},
+		},
+	},
+	1: {
+		// Retracting a corrected message also removes the correction.
+		retract: []event.ChatMessage{retraction(juliet, self, "1")},
+		with:    juliet,
+		expect: []retractLine{
+			{ID: "1", Retracted: true},
+			{ID: "3", Body: "Call me but love"},
+			{ID: "4", Body: "Deny thy father"},
+		},
+	},
+	2: {
+		// So does retracting the correction.
+		retract: []event.ChatMessage{retraction(juliet, self, "2")},
+		with:    juliet,
+		expect: []retractLine{
+			{ID: "1", Retracted: true},
+			{ID: "3", Body: "Call me but love"},
+			{ID: "4", Body: "Deny thy father"},
+		},
+	},
+	3: {
+		retract: []event.ChatMessage{retraction(self, juliet, "3")},
+		with:    juliet,
+		expect: []retractLine{
+			{ID: "1", Body: "Wherefore art thou Romeo?"},
+			{ID: "3", Retracted: true},
+			{ID: "4", Body: "Deny thy father"},
+		},
+	},
+	4: {
+		retract: []event.ChatMessage{
+			// Someone other than the original sender can't retract a message.
+			retraction(juliet, self, "3"),
+			// Retracting a message we don't have does nothing.
+			retraction(juliet, self, "unknown"),
+			retraction(juliet, self, ""),
+			// Neither does a message without a retraction.
+			msg("", juliet, self, ""),
+			// Moderation only applies to channels.
+			moderation("1", "Spam"),
+		},
+		with: juliet,
+		expect: []retractLine{
+			{ID: "1", Body: "Wherefore art thou Romeo?"},
+			{ID: "3", Body: "Call me but love"},
+			{ID: "4", Body: "Deny thy father"},
+		},
+	},
+	5: {
+		retract: []event.ChatMessage{moderation("a", "Violence")},
+		with:    room,
+		expect: []retractLine{
+			{ID: "5", Retracted: true, Reason: "Violence", By: escalus.String()},
+			{ID: "6", Body: "Peace?"},
+		},
+	},
+	6: {
+		retract: []event.ChatMessage{
+			// Moderation uses the stanza ID, not the ID chosen by the sender.
+			moderation("5", "Violence"),
+			// Occupants can't retract messages of others.
+			groupRetraction(jid.MustParse("capulets@muc.example.com/benvolio"), "5"),
+		},
+		with: room,
+		expect: []retractLine{
+			{ID: "5", Body: "Draw!"},
+			{ID: "6", Body: "Peace?"},
+		},
+	},
+	7: {
+		retract: []event.ChatMessage{groupRetraction(tybalt, "6")},
+		with:    room,
+		expect: []retractLine{
+			{ID: "5", Body: "Draw!"},
+			{ID: "6", Retracted: true},
+		},
+	},
+}
+
+func TestRetract(t *testing.T) {
+	for i, tc := range retractTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			db := storagetest.InsertMsgs(t, self,
+				msg("1", juliet, self, "Wherefore art thou Romoe?"),
+				withReplace(msg("2", juliet, self, "Wherefore art thou Romeo?"), "1"),
+				withOrigin(msg("3", self, juliet, "Call me but love"), "3"),
+				msg("4", juliet, self, "Deny thy father"),
+				withSID(groupMsg("5", tybalt, self, "Draw!"), "a", room),
+				withSID(groupMsg("6", tybalt, self, "Peace?"), "b", room),
+			)
+			for i, m := range tc.retract {
+				if err := db.Retract(context.Background(), m, self); err != nil {
+					t.Fatalf("error retracting message %d: %v", i, err)
+				}
+			}
+			if got := history(t, db, tc.with, retractLines); !slices.Equal(got, tc.expect) {
+				t.Errorf("wrong history: want=%v, got=%v", tc.expect, got)
+			}
+		})
+	}
+}
+
+var retractableTestCases = [...]struct {
+	with     jid.JID
+	moderate bool
+	limit    int
+	expect   []storage.RetractableMessage
+}{
+	0: {
+		with:  juliet,
+		limit: 10,
+		expect: []storage.RetractableMessage{
+			{ID: "7", Body: "Good night", Delay: storagetest.Day(7)},
+			{ID: "3", Body: "Call me but love", Delay: storagetest.Day(3)},
+		},
+	},
+	1: {
+		with:     room,
+		moderate: true,
+		limit:    10,
+		expect: []storage.RetractableMessage{
+			{ID: "b", Body: "Peace?", Delay: storagetest.Day(6)},
+			{ID: "a", Body: "Draw!", Delay: storagetest.Day(5)},
+		},
+	},
+	2: {
+		with:   juliet,
+		limit:  1,
+		expect: []storage.RetractableMessage{{ID: "7", Body: "Good night", Delay: storagetest.Day(7)}},
+	},
+	// Without moderating, only our own messages in a channel can be retracted.
+	3: {
+		with:   room,
+		limit:  10,
+		expect: []storage.RetractableMessage{{ID: "8", Body: "I do bite my thumb", Delay: storagetest.Day(8)}},
+	},
+	// There is nothing to moderate in a chat and nothing at all with strangers.
+	4: {with: juliet, moderate: true, limit: 10},
+	5: {with: nurse, limit: 10},
+	6: {with: juliet, limit: 0},
+}
+
+func TestRetractable(t *testing.T) {
+	db := storagetest.InsertMsgs(t, self,
+		msg("1", juliet, self, "Wherefore art thou Romeo?"),
+		msg("2", juliet, self, "Deny thy father"),
+		withOrigin(msg("3", self, juliet, "Call me but lvoe"), "3"),
+		// The newest correction is shown instead of the original text.
+		withReplace(msg("4", self, juliet, "Call me but love"), "3"),
+		withSID(groupMsg("5", tybalt, self, "Draw!"), "a", room),
+		withSID(groupMsg("6", tybalt, self, "Peace?"), "b", room),
+		withOrigin(msg("7", self, juliet, "Good night"), "7"),
+		withOrigin(groupMsg("8", self, room, "I do bite my thumb"), "8"),
+		// Only the occupant that sent a message can correct it.
+		withReplace(groupMsg("9", mercutio, self, "I hate the word"), "6"),
+	)
+	for i, tc := range retractableTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			got, err := db.Retractable(context.Background(), tc.with, tc.moderate, tc.limit)
+			if err != nil {
+				t.Fatalf("error listing retractable messages: %v", err)
+			}
+			if !slices.EqualFunc(got, tc.expect, retractableEqual) {
+				t.Errorf("wrong messages: want=%v, got=%v", tc.expect, got)
+			}
+		})
+	}
+
+	// Retracted messages can't be retracted again.
+	if err := db.Retract(context.Background(), retraction(self, juliet, "7"), self); err != nil {
+		t.Fatalf("error retracting message: %v", err)
+	}
+	got, err := db.Retractable(context.Background(), juliet, false, 10)
+	if err != nil {
+		t.Fatalf("error listing retractable messages: %v", err)
+	}
+	if want := retractableTestCases[0].expect[1:]; !slices.EqualFunc(got, want, retractableEqual) {
+		t.Errorf("wrong messages after retracting: want=%v, got=%v", want, got)
+	}
+}
+
+func retractableEqual(a, b storage.RetractableMessage) bool {
+	return a.ID == b.ID && a.Body == b.Body && a.Delay.Equal(b.Delay)
+}
+
+func TestRetractCanceled(t *testing.T) {
+	db := storagetest.InsertMsgs(t, self, withOrigin(msg("1", self, juliet, "Call me but love"), "1"))
+	if err := db.Retract(canceled(), retraction(self, juliet, "1"), self); err == nil {
+		t.Errorf("expected error retracting message")
+	}
+	if _, err := db.Retractable(canceled(), juliet, false, 10); err == nil {
+		t.Errorf("expected error listing retractable messages")
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2024 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	_ "embed"
+)
+
+//go:embed schema.sql
+var schema string
+
+// Schema is the collection of migrations for upgrading the database.
+// It automatically checks the expected schema version of the application and
+// orders itself to upgrade or downgrade the database to the correct version.
+// The offset is the version,
+func Schema() Migrations {
+	return Migrations{
+		{
+			Version: 1,
+			Up:      schema,
+			Down: `
+			PRAGMA writable_schema = 1;
+			delete from sqlite_master where type in ('view', 'table', 'index', 'trigger');
+			PRAGMA writable_schema = 0;`,
+		},
+		{
+			Version: 2,
+			Up: `
+CREATE TABLE IF NOT EXISTS omemoIdentity (
+	id       BOOLEAN PRIMARY KEY DEFAULT FALSE CHECK (id = FALSE),
+	deviceID INTEGER NOT NULL,
+	seed     BLOB    NOT NULL,
+	spkID    INTEGER NOT NULL,
+	spkPriv  BLOB    NOT NULL,
+	spkSig   BLOB    NOT NULL
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS omemoPreKeys (
+	id   INTEGER PRIMARY KEY NOT NULL,
+	priv BLOB                NOT NULL
+);
+
+-- Devices belonging to other accounts (and our own other devices).
+-- Trust is reset to undecided whenever the identity key of a device changes.
+CREATE TABLE IF NOT EXISTS omemoDevices (
+	jid      TEXT    NOT NULL,
+	deviceID INTEGER NOT NULL,
+	identity BLOB,
+	trust    INTEGER NOT NULL DEFAULT 0, -- 0: undecided, 1: trusted, 2: untrusted
+	active   BOOLEAN NOT NULL DEFAULT TRUE,
+	session  TEXT,
+
+	PRIMARY KEY (jid, deviceID)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS omemoEnabled (
+	jid     TEXT    PRIMARY KEY NOT NULL,
+	enabled BOOLEAN NOT NULL
+) WITHOUT ROWID;`,
+			Down: `
+DROP TABLE IF EXISTS omemoEnabled;
+DROP TABLE IF EXISTS omemoDevices;
+DROP TABLE IF EXISTS omemoPreKeys;
+DROP TABLE IF EXISTS omemoIdentity;`,
+		},
+		{
+			Version: 3,
+			Up: `
+-- Full text index over message bodies.
+-- The index does not store its own copy of the text and is kept up to date with
+-- the messages table by the triggers below.
+CREATE VIRTUAL TABLE IF NOT EXISTS messagesFTS USING fts5 (
+	body,
+	content='messages',
+	content_rowid='id'
+);
+
+CREATE TRIGGER IF NOT EXISTS messagesFTSInsert AFTER INSERT ON messages BEGIN
+	INSERT INTO messagesFTS (rowid, body) VALUES (new.id, new.body);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messagesFTSDelete AFTER DELETE ON messages BEGIN
+	INSERT INTO messagesFTS (messagesFTS, rowid, body) VALUES ('delete', old.id, old.body);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messagesFTSUpdate AFTER UPDATE OF body ON messages BEGIN
+	INSERT INTO messagesFTS (messagesFTS, rowid, body) VALUES ('delete', old.id, old.body);
+	INSERT INTO messagesFTS (rowid, body) VALUES (new.id, new.body);
+END;
+
+-- Index any messages that were stored before the index existed.
+INSERT INTO messagesFTS (messagesFTS) VALUES ('rebuild');`,
+			Down: `
+DROP TRIGGER IF EXISTS messagesFTSUpdate;
+DROP TRIGGER IF EXISTS messagesFTSDelete;
+DROP TRIGGER IF EXISTS messagesFTSInsert;
+DROP TABLE IF EXISTS messagesFTS;`,
+		},
+		{
+			Version: 4,
+			Up: `
+-- The ID of the message that this message corrects (XEP-0308).
+-- Chains of corrections are resolved when they are stored, so this always
+-- points to the original message.
+ALTER TABLE messages ADD COLUMN replaceID TEXT;
+CREATE INDEX IF NOT EXISTS messagesReplaceID ON messages (rosterJID, replaceID);`,
+			Down: `
+DROP INDEX IF EXISTS messagesReplaceID;
+ALTER TABLE messages DROP COLUMN replaceID;`,
+		},
+		{
+			Version: 5,
+			Up: `
+-- Retracted messages (XEP-0424, XEP-0425) keep their row as a tombstone but
+-- lose their body.
+-- If the message was removed by a channel moderator retractedBy is the address
+-- of the moderator.
+ALTER TABLE messages ADD COLUMN retracted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE messages ADD COLUMN retractedBy TEXT;
+ALTER TABLE messages ADD COLUMN retractReason TEXT;`,
+			Down: `
+ALTER TABLE messages DROP COLUMN retractReason;
+ALTER TABLE messages DROP COLUMN retractedBy;
+ALTER TABLE messages DROP COLUMN retracted;`,
+		},
+		{
+			Version: 6,
+			Up: `
+-- Whether the sender asked for chat markers (XEP-0333).
+ALTER TABLE messages ADD COLUMN markable BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- The ID of the last message that was displayed in each conversation, either by
+-- us or by one of our other clients.
+-- Received messages after it are unread.
+CREATE TABLE IF NOT EXISTS displayed (
+	rosterJID TEXT PRIMARY KEY NOT NULL,
+	msgID     TEXT NOT NULL
+) WITHOUT ROWID;
+
+-- Consider everything that was stored before we kept track of it as read.
+INSERT INTO displayed (rosterJID, msgID)
+	SELECT rosterJID, IFNULL(NULLIF(idAttr, ''), originID) FROM (
+		SELECT rosterJID, idAttr, originID, MAX(delay) FROM messages
+			WHERE rosterJID IS NOT NULL AND IFNULL(NULLIF(idAttr, ''), originID) IS NOT NULL
+			GROUP BY rosterJID)
+	WHERE true
+	ON CONFLICT DO NOTHING;`,
+			Down: `
+DROP TABLE IF EXISTS displayed;
+ALTER TABLE messages DROP COLUMN markable;`,
+		},
+		{
+			Version: 7,
+			Up: `
+-- The conversations shown in the conversations list.
+CREATE TABLE IF NOT EXISTS conversations (
+	rosterJID    TEXT    PRIMARY KEY NOT NULL,
+	name         TEXT    NOT NULL DEFAULT '',
+	room         BOOLEAN NOT NULL DEFAULT FALSE,
+	pinned       BOOLEAN NOT NULL DEFAULT FALSE,
+	unread       INTEGER NOT NULL DEFAULT 0,
+	firstUnread  TEXT,
+	lastActivity INTEGER NOT NULL DEFAULT 0
+) WITHOUT ROWID;
+
+-- Start with the conversations that have unread messages.
+INSERT INTO conversations (rosterJID, unread, firstUnread, lastActivity)
+	SELECT rosterJID, n, idAttr, (
+		SELECT MAX(delay) FROM messages WHERE rosterJID=u.rosterJID) FROM (
+		SELECT m.rosterJID, m.idAttr, MIN(m.delay), COUNT(*) AS n
+			FROM messages AS m
+			LEFT JOIN displayed AS d ON d.rosterJID=m.rosterJID
+			LEFT JOIN messages AS p ON p.id=(
+				SELECT id FROM messages
+					WHERE rosterJID=d.rosterJID AND d.msgID IN (idAttr, originID)
+					ORDER BY delay DESC, id DESC
+					LIMIT 1)
+			WHERE (d.rosterJID IS NULL OR (m.delay, m.id) > (p.delay, p.id))
+				AND m.rosterJID IS NOT NULL AND m.sent=FALSE AND m.stanzaType<>'groupchat'
+				AND m.retracted=FALSE AND m.replaceID IS NULL AND IFNULL(m.body, '')<>''
+			GROUP BY m.rosterJID) AS u
+	WHERE true
+	ON CONFLICT DO NOTHING;`,
+			Down: `
+DROP TABLE IF EXISTS conversations;`,
+		},
+		{
+			Version: 8,
+			Up: `
+-- The nickname of the occupant that sent a message to a channel.
+-- The from attribute is stored as a bare address, which is the channel itself.
+ALTER TABLE messages ADD COLUMN nick TEXT;`,
+			Down: `
+ALTER TABLE messages DROP COLUMN nick;`,
+		},
+		{
+			Version: 9,
+			Up: `
+-- Whether the message carried a delay when it was received live, for example
+-- because it was stored by the server while we were offline.
+ALTER TABLE messages ADD COLUMN delayed BOOLEAN NOT NULL DEFAULT FALSE;`,
+			Down: `
+ALTER TABLE messages DROP COLUMN delayed;`,
+		},
+		{
+			Version: 10,
+			Up: `
+-- Whether a message that we sent bounced with an error.
+ALTER TABLE messages ADD COLUMN failed BOOLEAN NOT NULL DEFAULT FALSE;`,
+			Down: `
+ALTER TABLE messages DROP COLUMN failed;`,
+		},
+		{
+			Version: 11,
+			Up: `
+-- Messages that we sent and that are waiting to be sent to the server, in the
+-- order they were written.
+-- They are also stored in the messages table so that they show up in the
+-- history.
+CREATE TABLE IF NOT EXISTS outbox (
+	id         INTEGER PRIMARY KEY NOT NULL,
+	originID   TEXT    NOT NULL UNIQUE,
+	toAttr     TEXT    NOT NULL,
+	stanzaType TEXT    NOT NULL DEFAULT "chat",
+	body       TEXT    NOT NULL,
+	replaceID  TEXT,
+	queued     INTEGER NOT NULL DEFAULT (CAST(strftime('%s', 'now') AS INTEGER))
+);`,
+			Down: `
+DROP TABLE IF EXISTS outbox;`,
+		},
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/localerr"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+// Direction limits a search to messages that were sent or received.
+type Direction uint8
+
+// A list of possible directions.
+const (
+	AnyDirection Direction = iota
+	Sent
+	Received
+)
+
+// SearchQuery is a full text search over the stored message history.
+// The zero value of each field other than Text matches all messages.
+type SearchQuery struct {
+	// Text contains the words to search for.
+	// Every word must appear in a message for it to match.
+	Text      string
+	With      jid.JID
+	Direction Direction
+	Start     time.Time
+	End       time.Time
+	Type      stanza.MessageType
+	Limit     int
+}
+
+// SearchResult is a single message that matched a search.
+type SearchResult struct {
+	event.ChatMessage
+
+	// RowID identifies the message in the database.
+	RowID int64
+	// With is the bare JID of the conversation the message belongs to.
+	With jid.JID
+}
+
+// SearchIter is an iterator that can return concrete search results.
+type SearchIter struct {
+	*Iter
+}
+
+// Result returns the most recent result read from the iter.
+func (iter SearchIter) Result() SearchResult {
+	cur := iter.Iter.Current()
+	if cur == nil {
+		return SearchResult{}
+	}
+	return cur.(SearchResult)
+}
+
+type searchQueries struct {
+	searchMsg    *sql.Stmt
+	archiveRowID *sql.Stmt
+}
+
+func prepareSearch(ctx context.Context, db *sql.DB, wrapDB *DB) error {
+	var err error
+	wrapDB.searchMsg, err = db.PrepareContext(ctx, `
+SELECT m.id, m.sent, m.toAttr, m.fromAttr, m.idAttr, m.body, m.stanzaType, m.delay, IFNULL(m.rosterJID, '')
+	FROM messagesFTS AS f
+		INNER JOIN messages AS m ON m.id=f.rowid
+	WHERE messagesFTS MATCH $1
+		AND ($2='' OR m.rosterJID=$2)
+		AND ($3=0 OR m.sent=($3=1))
+		AND ($4=0 OR m.delay>=$4)
+		AND ($5=0 OR m.delay<=$5)
+		AND m.stanzaType=COALESCE(NULLIF($6, ''), m.stanzaType)
+	ORDER BY m.delay DESC
+	LIMIT $7`)
+	if err != nil {
+		return err
+	}
+	wrapDB.archiveRowID, err = db.PrepareContext(ctx, `
+SELECT id FROM messages WHERE archiveID=$1`)
+	return err
+}
+
+// ArchiveRowID returns the row ID of the message that was given the stanza ID
+// by the archive, for example to show a message found by an archive search
+// next to local search results.
+// If no such message is stored, 0 is returned.
+func (db *DB) ArchiveRowID(ctx context.Context, archiveID string) (int64, error) {
+	var id int64
+	err := execTx(ctx, db, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.Stmt(db.archiveRowID).QueryRowContext(ctx, archiveID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return 0, localerr.Wrap(db.p, "error looking up archived message %q: %v", archiveID, err)
+	}
+	return id, nil
+}
+
+// ftsQuery turns user input into an FTS5 query that matches messages
+// containing every word.
+// Each word is quoted so that characters with a special meaning in the FTS5
+// query syntax are matched literally.
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// Search returns messages matching the query, newest first.
+// Any errors encountered while querying are deferred until the iter is used.
+func (db *DB) Search(ctx context.Context, q SearchQuery) SearchIter {
+	db.txM.Lock()
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-ctx.Done()
+		defer db.txM.Unlock()
+	}()
+
+	var start, end int64
+	if !q.Start.IsZero() {
+		start = q.Start.Unix()
+	}
+	if !q.End.IsZero() {
+		end = q.End.Unix()
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	var with string
+	if !q.With.Equal(jid.JID{}) {
+		with = q.With.Bare().String()
+	}
+	query := ftsQuery(q.Text)
+	if query == "" {
+		// An empty FTS5 query is a syntax error, but without any words there is
+		// nothing to find.
+		cancel()
+		return SearchIter{Iter: &Iter{cancel: cancel, err: sql.ErrNoRows}}
+	}
+	rows, err := db.searchMsg.QueryContext(ctx, query, with, int(q.Direction), start, end, string(q.Type), limit)
+	return SearchIter{
+		Iter: &Iter{
+			cancel: cancel,
+			err:    err,
+			rows:   rows,
+			f: func(rows *sql.Rows) (interface{}, error) {
+				cur := SearchResult{}
+				var to, from, typ, with string
+				var body sql.NullString
+				var delay int64
+				err := rows.Scan(&cur.RowID, &cur.Sent, &to, &from, &cur.ID, &body, &typ, &delay, &with)
+				if err != nil {
+					return cur, err
+				}
+				cur.Body = body.String
+				cur.Type = stanza.MessageType(typ)
+				cur.Delay.Time = time.Unix(delay, 0)
+				unsafeTo, err := jid.ParseUnsafe(to)
+				if err != nil {
+					return cur, err
+				}
+				cur.To = unsafeTo.JID
+				unsafeFrom, err := jid.ParseUnsafe(from)
+				if err != nil {
+					return cur, err
+				}
+				cur.From = unsafeFrom.JID
+				cur.With = cur.To.Bare()
+				if !cur.Sent {
+					cur.With = cur.From.Bare()
+				}
+				if with != "" {
+					unsafeWith, err := jid.ParseUnsafe(with)
+					if err != nil {
+						return cur, err
+					}
+					cur.With = unsafeWith.JID
+				}
+				return cur, nil
+			},
+		},
+	}
+}
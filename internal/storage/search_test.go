@@ -0,0 +1,145 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package storage_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/storage/storagetest"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+var (
+	// Midnight UTC on the 2nd of January is still the 1st in New York and
+	// already 1 o'clock in Berlin.
+	newYork = time.FixedZone("UTC-5", -5*60*60)
+	berlin  = time.FixedZone("UTC+1", 60*60)
+)
+
+var searchTestCases = [...]struct {
+	q      storage.SearchQuery
+	expect []string
+}{
+	0: {q: storage.SearchQuery{Text: "romeo"}, expect: []string{"4", "3", "1"}},
+	1: {q: storage.SearchQuery{Text: "art romeo", With: juliet}, expect: []string{"4", "1"}},
+	2: {q: storage.SearchQuery{Text: "love", Direction: storage.Received}},
+	3: {q: storage.SearchQuery{Text: "love", Direction: storage.Sent}, expect: []string{"2"}},
+	4: {q: storage.SearchQuery{Text: "thou", Type: stanza.ChatMessage}, expect: []string{"3", "1"}},
+	5: {q: storage.SearchQuery{Text: "thou", Start: storagetest.Day(2), End: storagetest.Day(3)}, expect: []string{"3"}},
+	6: {q: storage.SearchQuery{Text: `"where"`}, expect: []string{"3"}},
+	7: {q: storage.SearchQuery{Text: "thou", Limit: 1}, expect: []string{"4"}},
+	// The bounds are inclusive and compared as instants, whatever their time
+	// zone.
+	8:  {q: storage.SearchQuery{Text: "love", Start: time.Date(2020, 1, 1, 19, 0, 0, 0, newYork)}, expect: []string{"2"}},
+	9:  {q: storage.SearchQuery{Text: "love", Start: time.Date(2020, 1, 2, 1, 0, 1, 0, berlin)}},
+	10: {q: storage.SearchQuery{Text: "love", End: time.Date(2020, 1, 2, 1, 0, 0, 0, berlin)}, expect: []string{"2"}},
+	11: {q: storage.SearchQuery{Text: "love", End: time.Date(2020, 1, 1, 18, 59, 59, 0, newYork)}},
+	// Nothing matches.
+	12: {q: storage.SearchQuery{Text: "montague capulet"}},
+	13: {q: storage.SearchQuery{Text: "romeo", With: jid.MustParse("tybalt@example.com")}},
+	14: {q: storage.SearchQuery{Text: "romeo", Start: storagetest.Day(5)}},
+	15: {q: storage.SearchQuery{Text: "romeo", Start: storagetest.Day(3), End: storagetest.Day(2)}},
+	// Query syntax is matched literally instead of being an error.
+	16: {q: storage.SearchQuery{Text: `romeo OR "`}},
+	17: {q: storage.SearchQuery{Text: "NOT* (thou)"}, expect: []string{"4"}},
+	// Without any words there is nothing to search for.
+	18: {q: storage.SearchQuery{Text: " "}},
+}
+
+func TestSearch(t *testing.T) {
+	db := storagetest.InsertMsgs(t, self,
+		msg("1", juliet, self, "Wherefore art thou Romeo?"),
+		msg("2", self, juliet, "Call me but love, and I'll be new baptized"),
+		msg("3", nurse, self, "Romeo, \"where\" art thou?"),
+		withType(msg("4", juliet, self, "Art thou not Romeo, and a Montague?"), stanza.NormalMessage),
+	)
+	for i, tc := range searchTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var ids []string
+			iter := db.Search(context.Background(), tc.q)
+			for iter.Next() {
+				ids = append(ids, iter.Result().ID)
+			}
+			if err := iter.Err(); err != nil {
+				t.Fatalf("error searching: %v", err)
+			}
+			if !slices.Equal(ids, tc.expect) {
+				t.Errorf("wrong results: want=%v, got=%v", tc.expect, ids)
+			}
+		})
+	}
+}
+
+func TestSearchWith(t *testing.T) {
+	db := storagetest.InsertMsgs(t, self,
+		msg("1", juliet, self, "Wherefore art thou Romeo?"),
+		msg("2", self, juliet, "Call me but love"),
+		groupMsg("3", tybalt, self, "Romeo, thou art a villain"),
+	)
+	iter := db.Search(context.Background(), storage.SearchQuery{Text: "romeo"})
+	var got []string
+	for iter.Next() {
+		r := iter.Result()
+		got = append(got, r.With.String())
+		if r.RowID == 0 {
+			t.Errorf("missing row ID for %s", r.ID)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	// Messages in channels belong to the channel, not the occupant.
+	if want := []string{room.String(), juliet.String()}; !slices.Equal(got, want) {
+		t.Errorf("wrong conversations: want=%v, got=%v", want, got)
+	}
+}
+
+func TestArchiveRowID(t *testing.T) {
+	ctx := context.Background()
+	db := storagetest.InsertMsgs(t, self,
+		withSID(msg("1", juliet, self, "Wherefore art thou Romeo?"), "a", self),
+		// Stanza IDs assigned by anyone other than our archive are not stored.
+		withSID(msg("2", juliet, self, "Deny thy father"), "b", juliet),
+	)
+	for i, tc := range [...]struct {
+		id     string
+		expect bool
+	}{
+		0: {id: "a", expect: true},
+		1: {id: "b"},
+		2: {id: "unknown"},
+		3: {id: ""},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			rowID, err := db.ArchiveRowID(ctx, tc.id)
+			if err != nil {
+				t.Fatalf("error looking up archive ID: %v", err)
+			}
+			if found := rowID != 0; found != tc.expect {
+				t.Errorf("wrong result for %q: want found=%t, got row %d", tc.id, tc.expect, rowID)
+			}
+		})
+	}
+}
+
+func TestSearchCanceled(t *testing.T) {
+	db := storagetest.InsertMsgs(t, self, msg("1", juliet, self, "Wherefore art thou Romeo?"))
+	iter := db.Search(canceled(), storage.SearchQuery{Text: "romeo"})
+	if iter.Next() {
+		t.Errorf("expected no results")
+	}
+	if iter.Err() == nil {
+		t.Errorf("expected error searching")
+	}
+	if _, err := db.ArchiveRowID(canceled(), "a"); err == nil {
+		t.Errorf("expected error looking up archive ID")
+	}
+}
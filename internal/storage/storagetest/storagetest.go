@@ -0,0 +1,60 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package storagetest provides message databases for use in tests.
+package storagetest
+
+import (
+	"context"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/text/message"
+
+	"mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/xmpp/delay"
+	"mellium.im/xmpp/jid"
+)
+
+// Day returns midnight UTC on the given day of January 2020.
+func Day(d int) time.Time {
+	return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+// OpenDB opens a new, empty database with the current schema.
+// The database is closed when the test ends.
+func OpenDB(t testing.TB) *storage.DB {
+	t.Helper()
+	p := message.NewPrinter(message.MatchLanguage("en"))
+	db, err := storage.OpenDB(context.Background(), "communiqué", "test", filepath.Join(t.TempDir(), "test.db"), storage.Schema(), p, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+// InsertMsgs opens a new database and inserts msgs into it as if they had been
+// received by self from a trusted source.
+// Messages without a delay are stored on Day(i+1) so that they are ordered.
+func InsertMsgs(t testing.TB, self jid.JID, msgs ...event.ChatMessage) *storage.DB {
+	t.Helper()
+	db := OpenDB(t)
+	for i, msg := range msgs {
+		if msg.Delay.Time.IsZero() {
+			msg.Delay = delay.Delay{Time: Day(i + 1)}
+		}
+		err := db.InsertMsg(context.Background(), true, msg, self)
+		if err != nil {
+			t.Fatalf("error inserting message %d: %v", i, err)
+		}
+	}
+	return db
+}
@@ -0,0 +1,185 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/rivo/tview"
+)
+
+// Account creates a UI for another account that shares the screen with ui.
+// Each account has its own sidebar and event handler, and only the sidebar of
+// the active account is shown at any given time.
+// Options that affect the entire screen (such as the file picker or the
+// notification command) apply to every account.
+func (ui *UI) Account(opts ...Option) *UI {
+	acct := &UI{
+		screen:     ui.screen,
+		channels:   newChannels(),
+		handler:    func(interface{}) {},
+		passPrompt: make(chan string),
+	}
+	acct.sidebar = newSidebar(ui.p, acct, ui.statusSelect)
+	acct.sidebar.conversations.updated = ui.mergedView.refresh
+	acct.sidebar.SetWidth(ui.sidebarWidth)
+	for _, o := range opts {
+		o(acct)
+	}
+	acct.addAccount()
+	return acct
+}
+
+// addAccount adds the pages belonging to ui to the screen.
+func (ui *UI) addAccount() {
+	ui.accountsM.Lock()
+	first := len(ui.accounts) == 0
+	ui.accounts = append(ui.accounts, ui)
+	ui.accountsM.Unlock()
+	ui.mergedView.refresh()
+
+	ui.sidebars.AddPage(ui.addr, ui.sidebar, true, first)
+	pageName := getPasswordPageName + ui.addr
+	ui.pages.AddPage(pageName, passwordModal(ui.p, ui.addr, func(getPasswordPage *tview.Form) {
+		ui.passPrompt <- getPasswordPage.GetFormItem(0).(*tview.InputField).GetText()
+		ui.pages.HidePage(pageName)
+	}), true, false)
+	ui.drawAccountBar()
+}
+
+// activeUI returns the UI of the account whose sidebar is currently shown.
+func (s *screen) activeUI() *UI {
+	s.accountsM.Lock()
+	defer s.accountsM.Unlock()
+	return s.accounts[s.active]
+}
+
+// switchAccount shows the sidebar of the account n places after the active
+// account (or before it if n is negative).
+func (s *screen) switchAccount(n int) {
+	s.accountsM.Lock()
+	l := len(s.accounts)
+	if l < 2 {
+		s.accountsM.Unlock()
+		return
+	}
+	prev := s.accounts[s.active]
+	s.accountsM.Unlock()
+
+	// Close any chat belonging to the old account before switching.
+	prev.SelectRoster()
+
+	s.accountsM.Lock()
+	s.active = ((s.active+n)%l + l) % l
+	next := s.accounts[s.active]
+	s.merged = false
+	s.accountsM.Unlock()
+
+	s.sidebars.SwitchToPage(next.addr)
+	s.app.SetFocus(next.sidebar)
+	s.drawAccountBar()
+}
+
+// setActive makes ui the active account without changing which sidebar is
+// shown.
+// It is used to open conversations from the merged view.
+func (s *screen) setActive(ui *UI) {
+	s.accountsM.Lock()
+	idx := slices.Index(s.accounts, ui)
+	if idx < 0 || idx == s.active {
+		s.accountsM.Unlock()
+		return
+	}
+	prev := s.accounts[s.active]
+	s.accountsM.Unlock()
+
+	// Close any chat belonging to the old account before switching.
+	prev.SelectRoster()
+
+	s.accountsM.Lock()
+	s.active = idx
+	s.accountsM.Unlock()
+	s.drawAccountBar()
+}
+
+// showMerged switches between showing the conversations of every account in a
+// single list and showing the sidebar of the active account.
+func (s *screen) showMerged(show bool) {
+	s.accountsM.Lock()
+	if len(s.accounts) < 2 {
+		s.accountsM.Unlock()
+		return
+	}
+	s.merged = show
+	active := s.accounts[s.active]
+	s.accountsM.Unlock()
+
+	if show {
+		s.sidebars.SwitchToPage(mergedPageName)
+		s.app.SetFocus(s.mergedView)
+		return
+	}
+	s.sidebars.SwitchToPage(active.addr)
+	s.app.SetFocus(active.sidebar)
+}
+
+// shownSidebar returns the sidebar that is currently shown, either the merged view
+// or the sidebar of the active account.
+func (s *screen) shownSidebar() tview.Primitive {
+	s.accountsM.Lock()
+	defer s.accountsM.Unlock()
+	if s.merged {
+		return s.mergedView
+	}
+	return s.accounts[s.active].sidebar
+}
+
+// setAccountStatus records the status of the account for display in the status
+// bar.
+func (ui *UI) setAccountStatus(status string) {
+	ui.accountsM.Lock()
+	ui.status = status
+	ui.accountsM.Unlock()
+	ui.drawAccountBar()
+}
+
+// drawAccountBar shows the status of every account next to the status bar if
+// more than one account is configured.
+func (s *screen) drawAccountBar() {
+	s.accountsM.Lock()
+	defer s.accountsM.Unlock()
+	if len(s.accounts) < 2 {
+		return
+	}
+	var buf strings.Builder
+	for i, acct := range s.accounts {
+		if i > 0 {
+			buf.WriteString("  ")
+		}
+		icon := "◯"
+		switch acct.status {
+		case statusOnline:
+			icon = "●"
+		case statusBusy:
+			icon = "◐"
+		case statusAway:
+			icon = "◓"
+		}
+		buf.WriteString(icon)
+		buf.WriteString(" ")
+		if i == s.active {
+			buf.WriteString("[::b]" + tview.Escape(acct.addr) + "[::-]")
+		} else {
+			buf.WriteString(tview.Escape(acct.addr))
+		}
+	}
+	text := buf.String()
+	s.accountBar.SetText(text)
+	// Size the account bar to fit, leaving the rest of the line for the status
+	// bar.
+	width := tview.TaggedStringWidth(text) + 2
+	s.bottom.ResizeItem(s.accountBar, width, 0)
+}
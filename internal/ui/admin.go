@@ -0,0 +1,280 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"slices"
+
+	"github.com/rivo/tview"
+	"golang.org/x/text/message"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+)
+
+const (
+	channelAdminPageName = "channel_admin"
+	affiliationsPageName = "affiliations"
+)
+
+// adminAction is a change to the role or the affiliation of an occupant that
+// can be picked in the channel administration dialog.
+type adminAction struct {
+	label       string
+	setRole     bool
+	role        muc.Role
+	affiliation muc.Affiliation
+}
+
+// adminActions returns the changes that someone with the given role and
+// affiliation is allowed to make to other occupants of a channel.
+func adminActions(p *message.Printer, role muc.Role, a muc.Affiliation) []adminAction {
+	var actions []adminAction
+	if role == muc.RoleModerator {
+		actions = append(actions,
+			adminAction{label: p.Sprintf("Kick"), setRole: true, role: muc.RoleNone},
+			adminAction{label: p.Sprintf("Grant voice"), setRole: true, role: muc.RoleParticipant},
+			adminAction{label: p.Sprintf("Revoke voice"), setRole: true, role: muc.RoleVisitor},
+		)
+	}
+	if a == muc.AffiliationAdmin || a == muc.AffiliationOwner {
+		actions = append(actions,
+			adminAction{label: p.Sprintf("Grant moderator"), setRole: true, role: muc.RoleModerator},
+			adminAction{label: p.Sprintf("Revoke moderator"), setRole: true, role: muc.RoleParticipant},
+			adminAction{label: p.Sprintf("Ban"), affiliation: muc.AffiliationOutcast},
+			adminAction{label: p.Sprintf("Make member"), affiliation: muc.AffiliationMember},
+			adminAction{label: p.Sprintf("Remove affiliation"), affiliation: muc.AffiliationNone},
+		)
+	}
+	if a == muc.AffiliationOwner {
+		actions = append(actions,
+			adminAction{label: p.Sprintf("Make admin"), affiliation: muc.AffiliationAdmin},
+			adminAction{label: p.Sprintf("Make owner"), affiliation: muc.AffiliationOwner},
+		)
+	}
+	return actions
+}
+
+// adminLists returns the affiliations whose lists someone with the affiliation
+// a is allowed to see and change.
+func adminLists(a muc.Affiliation) []muc.Affiliation {
+	switch a {
+	case muc.AffiliationOwner:
+		return []muc.Affiliation{muc.AffiliationOutcast, muc.AffiliationMember, muc.AffiliationAdmin, muc.AffiliationOwner}
+	case muc.AffiliationAdmin:
+		return []muc.Affiliation{muc.AffiliationOutcast, muc.AffiliationMember}
+	}
+	return nil
+}
+
+// listName returns the name of the list of everyone with the affiliation a.
+func listName(p *message.Printer, a muc.Affiliation) string {
+	switch a {
+	case muc.AffiliationOutcast:
+		return p.Sprintf("Banned")
+	case muc.AffiliationMember:
+		return p.Sprintf("Members")
+	case muc.AffiliationAdmin:
+		return p.Sprintf("Admins")
+	case muc.AffiliationOwner:
+		return p.Sprintf("Owners")
+	}
+	return a.String()
+}
+
+// loadChannelAdmin asks for the occupants of the selected channel so that they
+// can be moderated.
+func (cv *ConversationView) loadChannelAdmin() {
+	c, ok := cv.ui.activeUI().sidebar.conversations.GetSelected()
+	if !ok || !c.Room {
+		return
+	}
+	cv.ui.activeUI().handler(event.LoadChannelAdmin(c.JID.Bare()))
+}
+
+// ShowChannelAdmin shows a dialog for changing the role or affiliation of the
+// occupants of room.
+// Only the changes allowed by our own role and affiliation are offered.
+func (ui *UI) ShowChannelAdmin(room jid.JID, role muc.Role, a muc.Affiliation, occupants []muc.Item) {
+	p := ui.Printer()
+	onEsc := func() {
+		ui.pages.HidePage(channelAdminPageName)
+		ui.pages.RemovePage(channelAdminPageName)
+	}
+
+	actions := adminActions(p, role, a)
+	lists := adminLists(a)
+	applyButton := p.Sprintf("Apply")
+	listsButton := p.Sprintf("Lists")
+	cancelButton := p.Sprintf("Cancel")
+	buttons := []string{cancelButton}
+	mod := NewModal().SetText(p.Sprintf("Administer %s", room))
+	switch {
+	case len(actions) == 0 && len(lists) == 0:
+		mod.SetText(p.Sprintf("Administer %s", room) + "\n\n" + p.Sprintf("You are not a moderator of this channel."))
+	case len(occupants) == 0:
+		actions = nil
+	default:
+		buttons = append(buttons, applyButton)
+	}
+	if len(lists) > 0 {
+		buttons = append(buttons, listsButton)
+	}
+	mod.AddButtons(buttons)
+
+	var selectedOccupant, selectedAction int
+	modForm := mod.Form()
+	reasonInput := tview.NewInputField().SetLabel(p.Sprintf("Reason"))
+	if len(actions) > 0 {
+		nicks := make([]string, 0, len(occupants))
+		for _, occupant := range occupants {
+			nicks = append(nicks, tview.Escape(occupant.Nick))
+		}
+		labels := make([]string, 0, len(actions))
+		for _, action := range actions {
+			labels = append(labels, action.label)
+		}
+		modForm.AddDropDown(p.Sprintf("Occupant"), nicks, 0, func(_ string, idx int) {
+			selectedOccupant = idx
+		})
+		modForm.AddDropDown(p.Sprintf("Action"), labels, 0, func(_ string, idx int) {
+			selectedAction = idx
+		})
+		modForm.AddFormItem(reasonInput)
+	}
+
+	mod.SetBackgroundColor(tview.Styles.PrimitiveBackgroundColor).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			onEsc()
+			switch buttonLabel {
+			case applyButton:
+				occupant := occupants[selectedOccupant]
+				action := actions[selectedAction]
+				if action.setRole {
+					ui.handler(event.SetRole{
+						Room:   room,
+						Nick:   occupant.Nick,
+						Role:   action.role,
+						Reason: reasonInput.GetText(),
+					})
+					return
+				}
+				ui.handler(event.SetAffiliation{
+					Room:        room,
+					JID:         occupant.JID,
+					Nick:        occupant.Nick,
+					Affiliation: action.affiliation,
+					Reason:      reasonInput.GetText(),
+				})
+			case listsButton:
+				ui.handler(event.LoadAffiliations{
+					Room:        room,
+					Affiliation: lists[0],
+				})
+			}
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+
+	ui.pages.AddPage(channelAdminPageName, mod, true, false)
+	ui.pages.ShowPage(channelAdminPageName)
+	ui.pages.SendToFront(channelAdminPageName)
+	ui.app.SetFocus(ui.pages)
+}
+
+// ShowAffiliations shows everyone in items, who have the affiliation list with
+// room, and lets the user add or remove addresses.
+// Our own affiliation a decides which other lists can be picked.
+func (ui *UI) ShowAffiliations(room jid.JID, a, list muc.Affiliation, items []muc.Item) {
+	p := ui.Printer()
+	onEsc := func() {
+		ui.pages.HidePage(affiliationsPageName)
+		ui.pages.RemovePage(affiliationsPageName)
+	}
+
+	lists := adminLists(a)
+	listIdx := slices.Index(lists, list)
+	if listIdx < 0 {
+		return
+	}
+	addButton := p.Sprintf("Add")
+	removeButton := p.Sprintf("Remove")
+	cancelButton := p.Sprintf("Cancel")
+	mod := NewModal().SetText(p.Sprintf("Administer %s", room))
+	buttons := []string{cancelButton, addButton}
+	if len(items) > 0 {
+		buttons = append(buttons, removeButton)
+	}
+	mod.AddButtons(buttons)
+
+	listNames := make([]string, 0, len(lists))
+	for _, l := range lists {
+		listNames = append(listNames, listName(p, l))
+	}
+	var selected int
+	addrs := make([]string, 0, len(items))
+	for _, item := range items {
+		addrs = append(addrs, tview.Escape(item.JID.String()))
+	}
+	var inputJID jid.JID
+	modForm := mod.Form()
+	modForm.AddDropDown(p.Sprintf("List"), listNames, listIdx, func(_ string, idx int) {
+		if idx == listIdx {
+			return
+		}
+		onEsc()
+		ui.handler(event.LoadAffiliations{
+			Room:        room,
+			Affiliation: lists[idx],
+		})
+	})
+	if len(items) > 0 {
+		modForm.AddDropDown(p.Sprintf("Address"), addrs, 0, func(_ string, idx int) {
+			selected = idx
+		})
+	} else {
+		modForm.AddTextView("", p.Sprintf("Nobody is on this list."), 0, 1, false, false)
+	}
+	modForm.AddFormItem(jidInput(p, &inputJID, true, nil, nil))
+	reasonInput := tview.NewInputField().SetLabel(p.Sprintf("Reason"))
+	modForm.AddFormItem(reasonInput)
+
+	mod.SetBackgroundColor(tview.Styles.PrimitiveBackgroundColor).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			switch buttonLabel {
+			case addButton:
+				if inputJID.Equal(jid.JID{}) {
+					return
+				}
+				onEsc()
+				ui.handler(event.SetAffiliation{
+					Room:        room,
+					JID:         inputJID.Bare(),
+					Affiliation: list,
+					Reason:      reasonInput.GetText(),
+					ShowList:    true,
+					List:        list,
+				})
+			case removeButton:
+				onEsc()
+				ui.handler(event.SetAffiliation{
+					Room:        room,
+					JID:         items[selected].JID,
+					Affiliation: muc.AffiliationNone,
+					Reason:      reasonInput.GetText(),
+					ShowList:    true,
+					List:        list,
+				})
+			default:
+				onEsc()
+			}
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+
+	ui.pages.AddPage(affiliationsPageName, mod, true, false)
+	ui.pages.ShowPage(affiliationsPageName)
+	ui.pages.SendToFront(affiliationsPageName)
+	ui.app.SetFocus(ui.pages)
+}
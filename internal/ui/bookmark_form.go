@@ -0,0 +1,179 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/rivo/tview"
+
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/jid"
+)
+
+const bookmarkPageName = "bookmark"
+
+// ShowAddBookmark asks the user for a new JID and the properties of the
+// bookmark.
+func (ui *UI) ShowAddBookmark() {
+	// Autocomplete rooms that are joined in the chats list but that we don't have
+	// bookmarks for and autocomplete domains of existing bookmarks.
+	l := len(ui.sidebar.bookmarks.items) + len(ui.sidebar.conversations.items)
+	autocomplete := make([]jid.JID, 0, l)
+	for _, item := range ui.sidebar.bookmarks.items {
+		autocomplete = append(autocomplete, item.JID.Domain())
+	}
+	for _, item := range ui.sidebar.conversations.items {
+		if !item.Room {
+			continue
+		}
+		bare := item.JID.Bare()
+		if _, ok := ui.sidebar.bookmarks.items[bare.String()]; ok {
+			continue
+		}
+		autocomplete = append(autocomplete, bare)
+	}
+	ui.showBookmarkForm(bookmarks.Channel{}, false, autocomplete)
+}
+
+// ShowEditBookmark lets the user change the selected bookmark.
+func (ui *UI) ShowEditBookmark() {
+	item, ok := ui.sidebar.bookmarks.GetSelected()
+	if !ok {
+		return
+	}
+	ui.showBookmarkForm(item.Channel, true, nil)
+}
+
+// showBookmarkForm shows a form for the properties of a bookmark.
+// If edit is false the user is also asked for the address of the channel.
+func (ui *UI) showBookmarkForm(ch bookmarks.Channel, edit bool, autocomplete []jid.JID) {
+	p := ui.Printer()
+	onEsc := func() {
+		ui.pages.HidePage(bookmarkPageName)
+		ui.pages.RemovePage(bookmarkPageName)
+	}
+
+	cancelButton := p.Sprintf("Cancel")
+	saveButton := p.Sprintf("Join")
+	title := p.Sprintf("Join Channel")
+	if edit {
+		saveButton = p.Sprintf("Save")
+		title = p.Sprintf("Edit Bookmark")
+	}
+	mod := NewModal().SetText(title)
+	modForm := mod.Form()
+
+	var addrInput *tview.InputField
+	if edit {
+		mod.SetText(title + "\n\n" + tview.Escape(ch.JID.Bare().String()))
+	} else {
+		var inputJID jid.JID
+		addrInput = jidInput(p, &inputJID, false, autocomplete, nil)
+		addrInput.SetLabel(p.Sprintf("Address"))
+		modForm.AddFormItem(addrInput)
+	}
+	nameInput := tview.NewInputField().
+		SetLabel(p.Sprintf("Name")).
+		SetText(ch.Name)
+	modForm.AddFormItem(nameInput)
+	nickLabel := p.Sprintf("Nick")
+	nickInput := tview.NewInputField().
+		SetLabel(nickLabel).
+		SetText(ch.Nick)
+	nickInput.SetChangedFunc(func(string) {
+		nickInput.SetLabel(nickLabel)
+	})
+	modForm.AddFormItem(nickInput)
+	passInput := tview.NewInputField().
+		SetLabel(p.Sprintf("Password")).
+		SetText(ch.Password).
+		SetMaskCharacter('*')
+	modForm.AddFormItem(passInput)
+	modForm.AddCheckbox(p.Sprintf("Join automatically"), ch.Autojoin, func(checked bool) {
+		ch.Autojoin = checked
+	})
+	extLabel := p.Sprintf("Extensions")
+	extInput := tview.NewTextArea().
+		SetLabel(extLabel).
+		SetText(string(ch.Extensions), false)
+	extInput.SetChangedFunc(func() {
+		extInput.SetLabel(extLabel)
+	})
+	modForm.AddFormItem(extInput)
+
+	mod.SetBackgroundColor(tview.Styles.PrimitiveBackgroundColor).
+		AddButtons([]string{cancelButton, saveButton}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			if buttonLabel != saveButton {
+				onEsc()
+				return
+			}
+			// Keep the form open with the invalid fields marked so that nothing that
+			// was typed in is lost.
+			valid := true
+			if !edit {
+				j, err := jid.Parse(addrInput.GetText())
+				if err != nil || j.Localpart() == "" {
+					addrInput.SetLabel("❌")
+					valid = false
+				}
+				ch.JID = j
+			}
+			ch.Nick = strings.TrimSpace(nickInput.GetText())
+			if ch.Nick != "" {
+				if _, err := ch.JID.WithResource(ch.Nick); err != nil {
+					nickInput.SetLabel("❌")
+					valid = false
+				}
+			}
+			ext := strings.TrimSpace(extInput.GetText())
+			if !wellFormed(ext) {
+				extInput.SetLabel("❌")
+				valid = false
+			}
+			if !valid {
+				return
+			}
+			onEsc()
+
+			ch.JID = ch.JID.Bare()
+			ch.Name = strings.TrimSpace(nameInput.GetText())
+			ch.Password = passInput.GetText()
+			ch.Extensions = nil
+			if ext != "" {
+				ch.Extensions = []byte(ext)
+			}
+			ui.UpdateBookmarks(ch)
+			if _, ok := ui.sidebar.conversations.GetItem(ch.JID.String()); ch.Autojoin && !ok {
+				ui.Autojoin(ch)
+			}
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+
+	ui.pages.RemovePage(bookmarkPageName)
+	ui.pages.AddPage(bookmarkPageName, mod, true, false)
+	ui.pages.ShowPage(bookmarkPageName)
+	ui.pages.SendToFront(bookmarkPageName)
+	ui.app.SetFocus(ui.pages)
+}
+
+// wellFormed reports whether s is a (possibly empty) sequence of well-formed
+// XML elements.
+func wellFormed(s string) bool {
+	d := xml.NewDecoder(strings.NewReader(s))
+	for {
+		_, err := d.Token()
+		switch err {
+		case nil:
+		case io.EOF:
+			return true
+		default:
+			return false
+		}
+	}
+}
@@ -5,24 +5,64 @@
 package ui
 
 import (
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 
+	"mellium.im/communique/internal/chatstate"
 	"mellium.im/communique/internal/ui/event"
 	"mellium.im/filechooser"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/roster"
 	"mellium.im/xmpp/stanza"
 )
 
 // UnreadRegion is a tview region tag that will draw an unread marker.
 const UnreadRegion = "unreadMarker"
 
+// SearchRegion is a tview region tag that surrounds the message that was
+// selected from the search results.
+const SearchRegion = "searchMatch"
+
 // ConversationView is a wrapper around TextView that adds other functionality
 // important for displaying chats.
 type ConversationView struct {
 	*tview.Flex
 	TextView   *tview.TextView
+	body       *tview.Flex
 	inputPages *tview.Pages
+	inputField *tview.InputField
 	ui         *UI
+	encrypted  bool
+	editing    string
+	keys       keyState
+
+	// Our chat state in the conversation that we last typed in.
+	stateM       sync.Mutex
+	state        chatstate.State
+	stateJID     jid.JID
+	stateHandler func(interface{})
+	stateTimer   *time.Timer
+
+	// Contacts that are currently typing.
+	typingM sync.Mutex
+	typing  map[string]struct{}
+
+	// The day of the last timestamp that was written to the history, used to
+	// draw a separator when the day changes.
+	dayM    sync.Mutex
+	lastDay time.Time
+
+	// The occupants of the selected channel and the last version of them that
+	// was shown.
+	occupantList  *tview.TextView
+	showOccupants bool
+	shownUI       *UI
+	shownRoom     string
+	shownVersion  uint64
 }
 
 const (
@@ -41,8 +81,11 @@ func NewConversationView(ui *UI) *ConversationView {
 			SetRegions(true).
 			ScrollToEnd().
 			Highlight(UnreadRegion),
-		inputPages: tview.NewPages(),
-		ui:         ui,
+		body:         tview.NewFlex(),
+		inputPages:   tview.NewPages(),
+		ui:           ui,
+		typing:       make(map[string]struct{}),
+		occupantList: tview.NewTextView().SetDynamicColors(true),
 	}
 	filePicker := filechooser.NewPathInputField()
 	filePicker.SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor)
@@ -60,10 +103,18 @@ func NewConversationView(ui *UI) *ConversationView {
 	input := tview.NewInputField()
 	input.SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor)
 	input.SetBorder(true)
+	input.SetChangedFunc(func(text string) {
+		cv.setHint("")
+		cv.typed(text)
+	})
 	cv.inputPages.AddPage(pageFilePicker, filePicker, true, false)
 	cv.inputPages.AddPage(pageInput, input, true, true)
+	cv.inputField = input
+	cv.occupantList.SetBorder(true).SetTitle(p.Sprintf("Occupants"))
+	cv.body.AddItem(unreadTextView{TextView: cv.TextView}, 0, 1, false)
+	cv.body.AddItem(cv.occupantList, 0, 0, false)
 	cv.Flex.SetBorder(false)
-	cv.Flex.AddItem(unreadTextView{TextView: cv.TextView}, 0, 100, false)
+	cv.Flex.AddItem(cv.body, 0, 100, false)
 	cv.Flex.AddItem(cv.inputPages, 3, 1, true)
 	cv.TextView.SetChangedFunc(func() {
 		ui.app.Draw()
@@ -71,6 +122,43 @@ func NewConversationView(ui *UI) *ConversationView {
 	return &cv
 }
 
+// SetEncrypted sets whether the conversation is end-to-end encrypted and
+// updates the title to match.
+func (cv *ConversationView) SetEncrypted(v bool) {
+	cv.encrypted = v
+	cv.updateTitle()
+}
+
+// toggleEncryption turns end-to-end encryption on or off for the selected
+// conversation.
+func (cv *ConversationView) toggleEncryption() {
+	c, ok := cv.ui.activeUI().sidebar.conversations.GetSelected()
+	if !ok {
+		return
+	}
+	cv.SetEncrypted(!cv.encrypted)
+	cv.ui.activeUI().handler(event.SetEncryption{
+		JID:     c.JID.Bare(),
+		Enabled: cv.encrypted,
+	})
+}
+
+// EditMessage loads a previously sent message into the input field so that a
+// correction can be sent.
+func (cv *ConversationView) EditMessage(id, body string) {
+	p := cv.ui.Printer()
+	cv.editing = id
+	cv.inputField.SetLabel(p.Sprintf("Edit") + ": ")
+	cv.inputField.SetText(body)
+}
+
+// cancelEdit stops correcting a message and clears the input field.
+func (cv *ConversationView) cancelEdit() {
+	cv.editing = ""
+	cv.inputField.SetLabel("")
+	cv.inputField.SetText("")
+}
+
 // ShowFilePicker shows the file picker field.
 func (cv *ConversationView) ShowFilePicker() {
 	cv.inputPages.SwitchToPage(pageFilePicker)
@@ -88,12 +176,9 @@ func checkScroll(cv *ConversationView, f func()) {
 	f()
 	newRow, _ := cv.TextView.GetScrollOffset()
 	if newRow == 0 && oldRow != newRow {
-		item, ok := cv.ui.sidebar.GetSelected()
-		if ok {
-			// TODO: work with other types?
-			if rosterItem, ok := item.(*RosterItem); ok {
-				cv.ui.handler(event.PullToRefreshChat(rosterItem.Item))
-			}
+		active := cv.ui.activeUI()
+		if j := active.GetRosterJID(); !j.Equal(jid.JID{}) {
+			active.handler(event.PullToRefreshChat(roster.Item{JID: j.Bare()}))
 		}
 	}
 }
@@ -148,36 +233,39 @@ func (cv *ConversationView) InputHandler() func(event *tcell.EventKey, setFocus
 			return
 		}
 
+		action, _, res := cv.ui.keys.feed(keysChat, &cv.keys, ev)
+		switch {
+		case res == keyPending:
+			return
+		case res == keyMatched && cv.runAction(action, pageName, setFocus):
+			return
+		}
+
 		switch ev.Key() {
 		case tcell.KeyUp, tcell.KeyDown, tcell.KeyRight, tcell.KeyLeft, tcell.KeyPgUp, tcell.KeyPgDn:
 			cv.TextView.InputHandler()(ev, setFocus)
 		case tcell.KeyTAB, tcell.KeyBacktab:
+			if ev.Key() == tcell.KeyTAB && cv.inputPages.HasFocus() && pageName == pageInput && isSlashCommand(cv.inputField.GetText()) {
+				cv.completeSlashCommand()
+				return
+			}
 			if cv.inputPages.HasFocus() {
 				setFocus(cv.TextView)
 			} else {
 				setFocus(cv.inputPages)
 			}
 		case tcell.KeyESC:
-			cv.ui.SelectRoster()
+			if cv.editing != "" {
+				cv.cancelEdit()
+				return
+			}
+			cv.leave()
+			cv.ui.activeUI().SelectRoster()
 		case tcell.KeyEnter:
 			if !cv.inputPages.HasFocus() {
 				break
 			}
 			sendMsg(cv, ev, setFocus)
-		case tcell.KeyCtrlU:
-			if cv.ui.FilePickerConfigured() {
-				p := cv.ui.Printer()
-				files, err := cv.ui.FilePicker()
-				if err != nil {
-					cv.ui.logger.Print(p.Sprintf("error while picking files: %v", err))
-					return
-				}
-				cv.uploadFiles(files)
-				return
-			}
-			// If an external file picker isn't configured, launch the built-in one.
-			cv.ShowFilePicker()
-			setFocus(cv.inputPages)
 		default:
 			// Pass anything else to the input handler.
 			if cv.inputPages.HasFocus() {
@@ -191,36 +279,100 @@ func (cv *ConversationView) InputHandler() func(event *tcell.EventKey, setFocus
 	}
 }
 
+// runAction performs a bound action and reports whether it applied.
+// If it did not, the key is handled as if it were not bound.
+func (cv *ConversationView) runAction(action, pageName string, setFocus func(p tview.Primitive)) bool {
+	switch action {
+	case actionEditLast:
+		// Only edit the last message if the input field is empty so that the key
+		// can still be used to scroll or move around.
+		if !cv.inputPages.HasFocus() || pageName != pageInput || cv.inputField.GetText() != "" {
+			return false
+		}
+		c, ok := cv.ui.activeUI().sidebar.conversations.GetSelected()
+		if ok {
+			cv.ui.activeUI().handler(event.EditLastMessage(c.JID.Bare()))
+		}
+	case actionEncryption:
+		cv.toggleEncryption()
+	case actionInvite:
+		cv.showSendInvite()
+	case actionOccupants:
+		cv.toggleOccupants()
+	case actionRetract:
+		cv.loadRetractable()
+	case actionAdmin:
+		cv.loadChannelAdmin()
+	case actionUpload:
+		if cv.ui.FilePickerConfigured() {
+			p := cv.ui.Printer()
+			files, err := cv.ui.FilePicker()
+			if err != nil {
+				cv.ui.logger.Print(p.Sprintf("error while picking files: %v", err))
+				return true
+			}
+			cv.uploadFiles(files)
+			return true
+		}
+		// If an external file picker isn't configured, launch the built-in one.
+		cv.ShowFilePicker()
+		setFocus(cv.inputPages)
+	default:
+		return false
+	}
+	return true
+}
+
 func sendMsg(cv *ConversationView, ev *tcell.EventKey, setFocus func(p tview.Primitive)) {
 	_, prim := cv.inputPages.GetFrontPage()
 	body := prim.(*tview.InputField).GetText()
 	if body == "" {
 		return
 	}
-	c, ok := cv.ui.sidebar.conversations.GetSelected()
+	c, ok := cv.ui.activeUI().sidebar.conversations.GetSelected()
 	if !ok {
 		return
 	}
+	if cv.editing == "" && isSlashCommand(body) {
+		err := cv.runSlashCommand(c, body)
+		if err != nil {
+			cv.setHint(err.Error())
+			return
+		}
+		cv.inputField.SetText("")
+		return
+	}
+	// A doubled slash sends a message that starts with a slash.
+	if strings.HasPrefix(body, "//") {
+		body = body[1:]
+	}
+	cv.sendBody(c, body)
+}
+
+// sendBody sends a message to the conversation c and clears the input.
+func (cv *ConversationView) sendBody(c Conversation, body string) {
 	typ := stanza.ChatMessage
 	to := c.JID
 	if c.Room {
 		typ = stanza.GroupChatMessage
 		to = to.Bare()
 	}
-	cv.ui.handler(event.ChatMessage{
+	cv.ui.activeUI().handler(event.ChatMessage{
 		Message: stanza.Message{
 			To:   to,
 			Type: typ,
 		},
-		Body: body,
+		Body:    body,
+		Replace: cv.editing,
 	})
-	prim.(*tview.InputField).SetText("")
+	cv.sent()
+	cv.cancelEdit()
 }
 
 func (cv *ConversationView) uploadFiles(files []string) {
 	p := cv.ui.Printer()
 
-	rcpt, ok := cv.ui.sidebar.conversations.GetSelected()
+	rcpt, ok := cv.ui.activeUI().sidebar.conversations.GetSelected()
 	if !ok {
 		cv.ui.logger.Print(p.Sprintf("failed to get the recipient"))
 		return
@@ -232,7 +384,7 @@ func (cv *ConversationView) uploadFiles(files []string) {
 		to = to.Bare()
 	}
 	for _, file := range files {
-		cv.ui.handler(event.UploadFile{
+		cv.ui.activeUI().handler(event.UploadFile{
 			Message: event.ChatMessage{
 				Message: stanza.Message{
 					To:   to,
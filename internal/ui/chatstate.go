@@ -0,0 +1,143 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"mellium.im/communique/internal/chatstate"
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/jid"
+)
+
+const (
+	// pausedTimeout is how long after the last change to the input field we stop
+	// telling the contact that we are typing.
+	pausedTimeout = 5 * time.Second
+	// inactiveTimeout is how long after pausing we tell the contact that we are
+	// no longer paying attention to the conversation.
+	inactiveTimeout = 2 * time.Minute
+)
+
+// typed is called whenever the text in the input field changes.
+func (cv *ConversationView) typed(text string) {
+	ui := cv.ui.activeUI()
+	c, ok := ui.sidebar.conversations.GetSelected()
+	// Commands are not messages, so typing one doesn't count as composing.
+	if !ok || c.Room || isSlashCommand(text) {
+		return
+	}
+	j := c.JID.Bare()
+	if text == "" {
+		// Clearing the input only matters if we were typing to the same contact.
+		cv.stateM.Lock()
+		same := j.Equal(cv.stateJID)
+		cv.stateM.Unlock()
+		if same {
+			cv.setChatState(ui.handler, j, chatstate.Active)
+		}
+		return
+	}
+	cv.setChatState(ui.handler, j, chatstate.Composing)
+}
+
+// sent resets our chat state after a message is sent.
+// Messages always carry the active state so there is no need to send it again.
+func (cv *ConversationView) sent() {
+	cv.stateM.Lock()
+	defer cv.stateM.Unlock()
+	if cv.stateTimer != nil {
+		cv.stateTimer.Stop()
+		cv.stateTimer = nil
+	}
+	cv.state = chatstate.Active
+}
+
+// leave tells the contact that we stopped paying attention to the
+// conversation if we were in the middle of typing a message.
+func (cv *ConversationView) leave() {
+	cv.stateM.Lock()
+	h, j, state := cv.stateHandler, cv.stateJID, cv.state
+	cv.stateM.Unlock()
+	if state == chatstate.Composing || state == chatstate.Paused {
+		cv.setChatState(h, j, chatstate.Inactive)
+	}
+}
+
+// setChatState changes our chat state in the conversation with j and starts a
+// timer to move to the next state if we stop typing.
+func (cv *ConversationView) setChatState(h func(interface{}), j jid.JID, state chatstate.State) {
+	cv.stateM.Lock()
+	defer cv.stateM.Unlock()
+
+	if cv.stateTimer != nil {
+		cv.stateTimer.Stop()
+		cv.stateTimer = nil
+	}
+	switch state {
+	case chatstate.Composing:
+		cv.stateTimer = time.AfterFunc(pausedTimeout, func() {
+			cv.setChatState(h, j, chatstate.Paused)
+		})
+	case chatstate.Paused:
+		cv.stateTimer = time.AfterFunc(inactiveTimeout, func() {
+			cv.setChatState(h, j, chatstate.Inactive)
+		})
+	}
+
+	if j.Equal(cv.stateJID) && state == cv.state {
+		return
+	}
+	// If we switched conversations in the middle of typing, don't leave the last
+	// one thinking that we are still typing.
+	if !j.Equal(cv.stateJID) && (cv.state == chatstate.Composing || cv.state == chatstate.Paused) {
+		cv.stateHandler(event.ChatState{JID: cv.stateJID, State: chatstate.Inactive})
+	}
+	cv.state, cv.stateJID, cv.stateHandler = state, j, h
+	h(event.ChatState{JID: j, State: state})
+}
+
+// setTyping records whether the contact j is typing and updates the title if
+// the conversation with them is open.
+func (cv *ConversationView) setTyping(j jid.JID, typing bool) {
+	cv.typingM.Lock()
+	if typing {
+		cv.typing[j.Bare().String()] = struct{}{}
+	} else {
+		delete(cv.typing, j.Bare().String())
+	}
+	cv.typingM.Unlock()
+	cv.updateTitle()
+}
+
+// updateTitle sets the title to show whether the conversation is encrypted,
+// whether the contact is typing, and the subject of channels.
+func (cv *ConversationView) updateTitle() {
+	p := cv.ui.Printer()
+	title := p.Sprintf("Conversation")
+	if cv.encrypted {
+		title += " 🔒"
+	}
+	ui := cv.ui.activeUI()
+	j := ui.GetRosterJID().Bare()
+	cv.typingM.Lock()
+	_, typing := cv.typing[j.String()]
+	cv.typingM.Unlock()
+	if typing {
+		title += " — " + p.Sprintf("typing…")
+	}
+	if subject, _, _ := strings.Cut(ui.subject(j), "\n"); subject != "" {
+		title += " — " + tview.Escape(subject)
+	}
+	cv.TextView.SetTitle(title)
+}
+
+// SetTyping shows or hides the indicator that the contact j is typing.
+func (ui *UI) SetTyping(j jid.JID, typing bool) {
+	ui.history.setTyping(j, typing)
+}
@@ -5,7 +5,7 @@
 package ui
 
 import (
-	"strings"
+	"slices"
 	"sync"
 
 	"github.com/gdamore/tcell/v2"
@@ -21,8 +21,12 @@ type Conversation struct {
 	Name        string
 	idx         int
 	firstUnread string
+	unread      int
 	presences   []presence
 	Room        bool
+	// Pinned conversations are kept at the top of the list.
+	Pinned   bool
+	selected func()
 }
 
 // FirstUnread returns the ID of the first unread message.
@@ -43,6 +47,9 @@ type Conversations struct {
 	flex     *tview.Flex
 	changed  func(int, string, string, rune)
 	p        *message.Printer
+	// updated is called with the lock held whenever an item is added, removed,
+	// or changed.
+	updated func()
 }
 
 // newConversations creates a new widget with the provided options.
@@ -71,22 +78,72 @@ func (c Conversations) Delete(bareJID string) {
 }
 
 func (c Conversations) deleteItem(bareJID string) {
-	var ok bool
 	item, ok := c.items[bareJID]
 	if !ok {
 		return
 	}
-	oldIdx := item.idx
-	c.list.RemoveItem(oldIdx)
+	c.list.RemoveItem(item.idx)
 	delete(c.items, bareJID)
-	for bareJID, item = range c.items {
-		found := c.list.FindItems(item.Name, bareJID, true, false)
-		if len(found) == 0 {
+	c.reindex()
+	c.update()
+}
+
+// reindex updates the index of every item after items were removed or moved.
+// The secondary text of every item in the list is its bare JID.
+func (c Conversations) reindex() {
+	for i := 0; i < c.list.GetItemCount(); i++ {
+		_, bare := c.list.GetItemText(i)
+		item, ok := c.items[bare]
+		if !ok {
 			continue
 		}
-		item.idx = found[0]
-		c.items[bareJID] = item
+		item.idx = i
+		c.items[bare] = item
+	}
+}
+
+// update calls the updated callback if one is set.
+func (c Conversations) update() {
+	if c.updated != nil {
+		c.updated()
+	}
+}
+
+// ordered returns the items in the order that they are shown in the list.
+func (c Conversations) ordered() []Conversation {
+	c.itemLock.Lock()
+	defer c.itemLock.Unlock()
+	items := make([]Conversation, 0, len(c.items))
+	for _, item := range c.items {
+		items = append(items, item)
 	}
+	slices.SortFunc(items, func(a, b Conversation) int {
+		return a.idx - b.idx
+	})
+	return items
+}
+
+// pinnedLen returns the number of pinned items other than the item for bareJID.
+func (c Conversations) pinnedLen(bareJID string) int {
+	var n int
+	for j, item := range c.items {
+		if item.Pinned && j != bareJID {
+			n++
+		}
+	}
+	return n
+}
+
+// text returns the text shown in the list for item.
+func (c Conversations) text(item Conversation) string {
+	text := tview.Escape(item.Name)
+	if item.Pinned {
+		text = "📌 " + text
+	}
+	if item.unread > 0 {
+		text = highlightTag + text + c.p.Sprintf(" (%d)", item.unread)
+	}
+	return text
 }
 
 // Upsert inserts or updates an item in the list.
@@ -102,16 +159,48 @@ func (c Conversations) Upsert(item Conversation, action func(Conversation)) int
 	existing, ok := c.items[bare]
 	if ok {
 		// Update the existing roster item.
-		c.list.SetItemText(existing.idx, item.Name, bare)
 		item.idx = existing.idx
 		item.firstUnread = existing.firstUnread
+		item.unread = existing.unread
+		item.Pinned = existing.Pinned
+		item.selected = existing.selected
+		c.list.SetItemText(existing.idx, c.text(item), bare)
 		c.items[bare] = item
+		c.update()
 		return item.idx
 	}
-	c.list.AddItem(item.Name, bare, 0, func() { action(item) })
-	item.idx = c.list.GetItemCount() - 1
+	item.selected = func() { action(item) }
+	idx := -1
+	if item.Pinned {
+		idx = c.pinnedLen(bare)
+	}
+	c.list.InsertItem(idx, c.text(item), bare, 0, item.selected)
 	c.items[bare] = item
-	return item.idx
+	c.reindex()
+	c.update()
+	return c.items[bare].idx
+}
+
+// Pin moves the item for the given JID to the top of the list or back below
+// the pinned items.
+func (c Conversations) Pin(j string, pinned bool) {
+	c.itemLock.Lock()
+	defer c.itemLock.Unlock()
+
+	item, ok := c.items[j]
+	if !ok || item.Pinned == pinned {
+		return
+	}
+	selected := c.list.GetCurrentItem() == item.idx
+	item.Pinned = pinned
+	c.items[j] = item
+	c.list.RemoveItem(item.idx)
+	c.list.InsertItem(c.pinnedLen(j), c.text(item), j, 0, item.selected)
+	c.reindex()
+	if selected {
+		c.list.SetCurrentItem(c.items[j].idx)
+	}
+	c.update()
 }
 
 // Draw implements tview.Primitive foc Conversations.
@@ -233,8 +322,9 @@ func (c Conversations) GetItem(j string) (Conversation, bool) {
 	return item, ok
 }
 
-// MarkUnread sets the given jid to bold and sets the first message seen after
-// the unread marker (unless the unread marker is already set).
+// MarkUnread sets the given jid to bold, increments its unread count, and sets
+// the first message seen after the unread marker (unless the unread marker is
+// already set).
 func (c Conversations) MarkUnread(j, msgID string) bool {
 	c.itemLock.Lock()
 	defer c.itemLock.Unlock()
@@ -248,32 +338,35 @@ func (c Conversations) MarkUnread(j, msgID string) bool {
 	// it's already set don't change it.
 	if item.firstUnread == "" {
 		item.firstUnread = msgID
-		c.items[j] = item
-	}
-
-	primary, secondary := c.list.GetItemText(item.idx)
-	// If it already has the highlighted prefix, do nothing.
-	if strings.HasPrefix(primary, highlightTag) {
-		return true
 	}
-	c.list.SetItemText(item.idx, highlightTag+tview.Escape(primary), secondary)
+	item.unread++
+	c.items[j] = item
+	c.list.SetItemText(item.idx, c.text(item), j)
+	c.update()
 	return true
 }
 
-// MarkRead sets the given jid back to the normal font.
-func (c Conversations) MarkRead(j string) {
+// SetUnread sets the number of unread messages for the given jid and the first
+// message seen after the unread marker.
+func (c Conversations) SetUnread(j, msgID string, n int) bool {
 	c.itemLock.Lock()
 	defer c.itemLock.Unlock()
 
 	item, ok := c.items[j]
 	if !ok {
-		return
+		return false
 	}
-	item.firstUnread = ""
+	item.firstUnread = msgID
+	item.unread = n
 	c.items[j] = item
+	c.list.SetItemText(item.idx, c.text(item), j)
+	c.update()
+	return true
+}
 
-	primary, secondary := c.list.GetItemText(item.idx)
-	c.list.SetItemText(item.idx, strings.TrimPrefix(primary, highlightTag), secondary)
+// MarkRead sets the given jid back to the normal font.
+func (c Conversations) MarkRead(j string) {
+	c.SetUnread(j, "", 0)
 }
 
 // Unread returns whether the roster item is currently marked as having unread
@@ -288,8 +381,7 @@ func (c Conversations) Unread(j string) bool {
 		// If it doesn't exist, it's not unread.
 		return false
 	}
-	primary, _ := c.list.GetItemText(item.idx)
-	return strings.HasPrefix(primary, highlightTag)
+	return item.unread > 0
 }
 
 // Len returns the length of the roster.
@@ -6,9 +6,14 @@
 package event // import "mellium.im/communique/internal/ui/event"
 
 import (
+	"time"
+
+	"mellium.im/communique/internal/chatstate"
+	"mellium.im/communique/internal/omemo"
 	"mellium.im/xmpp/bookmarks"
 	"mellium.im/xmpp/commands"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
 	"mellium.im/xmpp/roster"
 	"mellium.im/xmpp/stanza"
 )
@@ -50,12 +55,132 @@ type (
 	// DeleteBookmark is sent when a bookmark has been removed.
 	DeleteBookmark bookmarks.Channel
 
+	// CloseConversation is sent when a conversation is removed from the
+	// conversations list.
+	CloseConversation jid.JID
+
+	// PinConversation is sent when a conversation is pinned to the top of the
+	// conversations list or unpinned.
+	PinConversation struct {
+		JID    jid.JID
+		Pinned bool
+	}
+
 	// ChatMessage is sent when messages of type "chat" or "normal" are received
 	// or sent.
 	ChatMessage struct {
 		stanza.Message
 
 		Body string `xml:"body,omitempty"`
+		// Replace is the ID of an earlier message that this message corrects.
+		Replace string `xml:"-"`
+	}
+
+	// EditLastMessage is sent when the user wants to correct the last message
+	// they sent to a contact or channel.
+	EditLastMessage jid.JID
+
+	// ChatState is sent when the user starts or stops typing a message to a
+	// contact.
+	ChatState struct {
+		JID   jid.JID
+		State chatstate.State
+	}
+
+	// LoadRetractable is sent when the user wants to pick a message to retract
+	// from a conversation.
+	// In channels where the user is a moderator this means moderating messages
+	// sent by anyone.
+	LoadRetractable struct {
+		JID  jid.JID
+		Room bool
+	}
+
+	// Retract is sent when the user retracts a message that they sent, or
+	// moderates a message in a channel.
+	// ID is the origin ID of our own message, or the stanza ID assigned by the
+	// channel to a moderated message.
+	Retract struct {
+		JID      jid.JID
+		ID       string
+		Room     bool
+		Moderate bool
+		Reason   string
+	}
+
+	// CreateChannel is sent when the user wants to create a new channel.
+	CreateChannel jid.JID
+
+	// ConfigureChannel is sent when the user wants to change the configuration
+	// of a channel they own.
+	ConfigureChannel jid.JID
+
+	// SetNick is sent when the user changes their nickname in a channel.
+	SetNick struct {
+		Room jid.JID
+		Nick string
+	}
+
+	// SetSubject is sent when the user changes the subject of a channel.
+	SetSubject struct {
+		Room    jid.JID
+		Subject string
+	}
+
+	// LeaveChannel is sent when the user leaves a channel.
+	LeaveChannel struct {
+		Room   jid.JID
+		Reason string
+	}
+
+	// SendInvite is sent when the user invites someone to join a channel.
+	SendInvite struct {
+		Room   jid.JID
+		JID    jid.JID
+		Reason string
+	}
+
+	// DeclineInvite is sent when the user declines an invitation that was sent
+	// through a channel.
+	DeclineInvite struct {
+		Room jid.JID
+		From jid.JID
+	}
+
+	// LoadChannelAdmin is sent when the user wants to change the role or
+	// affiliation of an occupant of a channel.
+	LoadChannelAdmin jid.JID
+
+	// SetRole is sent when the user changes the role of an occupant of a
+	// channel.
+	// Setting the role to RoleNone kicks them out of the channel.
+	SetRole struct {
+		Room   jid.JID
+		Nick   string
+		Role   muc.Role
+		Reason string
+	}
+
+	// SetAffiliation is sent when the user changes the affiliation of someone
+	// with a channel.
+	// If JID is the zero value, the occupant using Nick is changed.
+	SetAffiliation struct {
+		Room        jid.JID
+		JID         jid.JID
+		Nick        string
+		Affiliation muc.Affiliation
+		Reason      string
+		// ShowList is true if the list of everyone with the affiliation List should
+		// be shown again after the change.
+		ShowList bool
+		List     muc.Affiliation
+	}
+
+	// LoadAffiliations is sent when the user wants to see everyone with an
+	// affiliation, for example the ban list of a channel.
+	LoadAffiliations struct {
+		Room        jid.JID
+		Affiliation muc.Affiliation
 	}
 
 	// OpenChat is sent when a roster item is selected.
@@ -74,6 +199,73 @@ type (
 	// the history or when we simply scroll to the top of the history.
 	PullToRefreshChat roster.Item
 
+	// SetEncryption is sent when end-to-end encryption is turned on or off for
+	// a conversation.
+	SetEncryption struct {
+		JID     jid.JID
+		Enabled bool
+	}
+
+	// LoadFingerprints is sent when the fingerprint trust dialog for a contact or
+	// channel should be shown.
+	LoadFingerprints struct {
+		JID  jid.JID
+		Room bool
+	}
+
+	// SetTrust is sent when the user changes the trust placed in the identity
+	// key of a device.
+	SetTrust struct {
+		JID    jid.JID
+		Device uint32
+		Trust  omemo.Trust
+	}
+
+	// SearchHistory is sent when the user searches the stored message history.
+	// Fields other than Text that are left empty do not limit the search.
+	SearchHistory struct {
+		Text string
+		With jid.JID
+		// OnlySent and OnlyReceived limit the search to messages we sent or
+		// messages we received.
+		OnlySent     bool
+		OnlyReceived bool
+		Start        time.Time
+		End          time.Time
+		Type         stanza.MessageType
+		// Archive also searches the server side message archive and stores any
+		// messages found there before the local history is searched.
+		Archive bool
+	}
+
+	// ExportHistory is sent when the user exports the stored message history to
+	// a file.
+	// Fields other than Format and Path that are left empty do not limit the
+	// messages that are exported.
+	ExportHistory struct {
+		With   jid.JID
+		Start  time.Time
+		End    time.Time
+		Format string
+		Path   string
+	}
+
+	// JumpToMessage is sent when a search result is selected and the
+	// conversation should be opened at the message.
+	JumpToMessage struct {
+		JID   jid.JID
+		RowID int64
+	}
+
+	// LoadOutbox is sent when the user wants to see the messages that are
+	// waiting to be sent.
+	LoadOutbox struct{}
+
+	// CancelQueued is sent when the user decides not to send a message that is
+	// waiting in the outbox.
+	// It is the origin ID of the message.
+	CancelQueued string
+
 	// UploadFile is sent to instruct the client to perform HTTP upload.
 	UploadFile struct {
 		Path    string
@@ -0,0 +1,139 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/jid"
+)
+
+const exportPageName = "export"
+
+// exportFormat is one of the formats that history can be exported to.
+type exportFormat struct {
+	name  string
+	label string
+	ext   string
+}
+
+// exportPath returns the default file to export the history of the
+// conversation with addr to.
+func exportPath(addr string, f exportFormat) string {
+	name := "history"
+	if addr != "" {
+		name = strings.ReplaceAll(addr, string(filepath.Separator), "_")
+	}
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, name+f.ext)
+}
+
+// ShowExport shows a form for exporting the message history to a file.
+// If a conversation is selected in the sidebar, the export is limited to it by
+// default.
+func (ui *UI) ShowExport() {
+	p := ui.Printer()
+	var (
+		exportButton = p.Sprintf("Export")
+		cancelButton = p.Sprintf("Cancel")
+	)
+	onEsc := func() {
+		ui.pages.HidePage(exportPageName)
+		ui.pages.RemovePage(exportPageName)
+	}
+	formats := []exportFormat{
+		{name: "text", label: p.Sprintf("Plain text"), ext: ".txt"},
+		{name: "jsonl", label: p.Sprintf("JSON Lines"), ext: ".jsonl"},
+		{name: "html", label: p.Sprintf("HTML"), ext: ".html"},
+		{name: "xml", label: p.Sprintf("XML (XEP-0227)"), ext: ".xml"},
+	}
+
+	autocomplete := make([]jid.JID, 0, len(ui.sidebar.conversations.items))
+	for _, item := range ui.sidebar.conversations.items {
+		autocomplete = append(autocomplete, item.JID.Bare())
+	}
+
+	var (
+		ev       event.ExportHistory
+		inputJID jid.JID
+		format   exportFormat
+		addr     string
+		// edited is set once the user changes the path so that we stop
+		// suggesting a file name.
+		edited bool
+	)
+	format = formats[0]
+	if selected := ui.GetRosterJID(); !selected.Equal(jid.JID{}) {
+		addr = selected.Bare().String()
+	}
+
+	mod := NewModal().SetText(p.Sprintf("Export History"))
+	modForm := mod.Form()
+	pathInput := tview.NewInputField().SetLabel(p.Sprintf("File"))
+	suggest := func() {
+		if !edited {
+			pathInput.SetText(exportPath(addr, format))
+		}
+	}
+	addrInput := jidInput(p, &inputJID, true, autocomplete, func(text string) {
+		addr = text
+		suggest()
+	})
+	addrInput.SetLabel(p.Sprintf("Address"))
+	addrInput.SetText(addr)
+	modForm.AddFormItem(addrInput)
+	labels := make([]string, 0, len(formats))
+	for _, f := range formats {
+		labels = append(labels, f.label)
+	}
+	modForm.AddDropDown(p.Sprintf("Format"), labels, 0, func(_ string, idx int) {
+		if idx < 0 {
+			return
+		}
+		format = formats[idx]
+		suggest()
+	})
+	modForm.AddFormItem(dateInput(p.Sprintf("From"), &ev.Start))
+	modForm.AddFormItem(dateInput(p.Sprintf("Until"), &ev.End))
+	suggest()
+	pathInput.SetChangedFunc(func(text string) {
+		edited = text != exportPath(addr, format)
+	})
+	modForm.AddFormItem(pathInput)
+
+	mod.SetBackgroundColor(tview.Styles.PrimitiveBackgroundColor).
+		AddButtons([]string{cancelButton, exportButton}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			onEsc()
+			ev.Path = strings.TrimSpace(pathInput.GetText())
+			if buttonLabel != exportButton || ev.Path == "" {
+				return
+			}
+			if addrInput.GetText() != "" {
+				ev.With = inputJID.Bare()
+			}
+			ev.Format = format.name
+			if !ev.End.IsZero() {
+				// Include the entire last day.
+				ev.End = ev.End.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			ui.handler(ev)
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+
+	ui.pages.AddPage(exportPageName, mod, true, false)
+	ui.pages.ShowPage(exportPageName)
+	ui.pages.SendToFront(exportPageName)
+	ui.app.SetFocus(ui.pages)
+}
@@ -0,0 +1,132 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"github.com/rivo/tview"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/jid"
+)
+
+const (
+	invitePageName     = "invite"
+	sendInvitePageName = "send_invite"
+)
+
+// ShowInvite asks the user whether they want to join room after being invited
+// by from.
+// If mediated is true the invitation was sent through the channel and
+// declining it tells from that we will not be joining.
+func (ui *UI) ShowInvite(room, from jid.JID, password, reason string, mediated bool) {
+	p := ui.Printer()
+	pageName := invitePageName + ":" + room.Bare().String()
+	onEsc := func() {
+		ui.pages.HidePage(pageName)
+		ui.pages.RemovePage(pageName)
+	}
+
+	joinButton := p.Sprintf("Join")
+	declineButton := p.Sprintf("Decline")
+	text := p.Sprintf("%s invited you to join %s.", tview.Escape(from.String()), tview.Escape(room.String()))
+	if reason != "" {
+		text += "\n\n" + tview.Escape(reason)
+	}
+	mod := NewModal().
+		SetText(text).
+		AddButtons([]string{declineButton, joinButton})
+	var bookmark bool
+	mod.Form().AddCheckbox(p.Sprintf("Bookmark"), false, func(checked bool) {
+		bookmark = checked
+	})
+	mod.SetBackgroundColor(tview.Styles.PrimitiveBackgroundColor).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			onEsc()
+			switch buttonLabel {
+			case joinButton:
+				item := bookmarks.Channel{
+					JID:      room.Bare(),
+					Password: password,
+				}
+				if bookmark {
+					ui.UpdateBookmarks(item)
+				}
+				ui.JoinChannel(item)
+			case declineButton:
+				if mediated {
+					ui.handler(event.DeclineInvite{
+						Room: room.Bare(),
+						From: from,
+					})
+				}
+			}
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+
+	ui.pages.AddPage(pageName, mod, true, false)
+	ui.pages.ShowPage(pageName)
+	ui.pages.SendToFront(pageName)
+	ui.app.SetFocus(ui.pages)
+	ui.Redraw()
+}
+
+// showSendInvite asks who to invite to the selected channel.
+func (cv *ConversationView) showSendInvite() {
+	c, ok := cv.ui.activeUI().sidebar.conversations.GetSelected()
+	if !ok || !c.Room {
+		return
+	}
+	cv.ui.activeUI().ShowSendInvite(c.JID.Bare())
+}
+
+// ShowSendInvite asks the user who they want to invite to join room.
+func (ui *UI) ShowSendInvite(room jid.JID) {
+	p := ui.Printer()
+	onEsc := func() {
+		ui.pages.HidePage(sendInvitePageName)
+		ui.pages.RemovePage(sendInvitePageName)
+	}
+
+	// Autocomplete contacts from the roster since those are the people we're most
+	// likely to invite.
+	ui.sidebar.roster.itemLock.Lock()
+	autocomplete := make([]jid.JID, 0, len(ui.sidebar.roster.items))
+	for _, item := range ui.sidebar.roster.items {
+		autocomplete = append(autocomplete, item.JID.Bare())
+	}
+	ui.sidebar.roster.itemLock.Unlock()
+
+	inviteButton := p.Sprintf("Invite")
+	cancelButton := p.Sprintf("Cancel")
+	mod := NewModal().
+		SetText(p.Sprintf("Invite someone to %s", room)).
+		AddButtons([]string{cancelButton, inviteButton})
+	var inputJID jid.JID
+	reasonInput := tview.NewInputField().SetLabel(p.Sprintf("Reason"))
+	mod.Form().
+		AddFormItem(jidInput(p, &inputJID, true, autocomplete, nil)).
+		AddFormItem(reasonInput)
+	mod.SetBackgroundColor(tview.Styles.PrimitiveBackgroundColor).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			if buttonLabel == inviteButton {
+				if inputJID.Equal(jid.JID{}) {
+					return
+				}
+				ui.handler(event.SendInvite{
+					Room:   room,
+					JID:    inputJID.Bare(),
+					Reason: reasonInput.GetText(),
+				})
+			}
+			onEsc()
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+
+	ui.pages.AddPage(sendInvitePageName, mod, true, false)
+	ui.pages.ShowPage(sendInvitePageName)
+	ui.pages.SendToFront(sendInvitePageName)
+	ui.app.SetFocus(ui.pages)
+}
@@ -0,0 +1,661 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"golang.org/x/text/message"
+
+	"mellium.im/communique/internal/localerr"
+)
+
+// Actions that can be bound to key sequences.
+// The names are used in the [keys] section of the config file.
+const (
+	actionQuit          = "quit"
+	actionHelp          = "help"
+	actionManual        = "manual"
+	actionTop           = "top"
+	actionBottom        = "bottom"
+	actionDown          = "down"
+	actionUp            = "up"
+	actionSearchForward = "search_forward"
+	actionSearchBack    = "search_backward"
+	actionSearchNext    = "search_next"
+	actionSearchPrev    = "search_prev"
+	actionSearchHistory = "search_history"
+	actionExport        = "export"
+	actionOutbox        = "outbox"
+	actionNextTab       = "next_tab"
+	actionPrevTab       = "prev_tab"
+	actionNextAccount   = "next_account"
+	actionPrevAccount   = "prev_account"
+	actionMergeAccounts = "merge_accounts"
+	actionStartChat     = "start_chat"
+	actionCreateChannel = "create_channel"
+	actionEditBookmark  = "edit_bookmark"
+	actionOpen          = "open"
+	actionInfo          = "info"
+	actionNextUnread    = "next_unread"
+	actionPrevUnread    = "prev_unread"
+	actionDelete        = "delete"
+	actionPin           = "pin"
+	actionCommand       = "command"
+	actionStatus        = "status"
+	actionEditLast      = "edit_last"
+	actionEncryption    = "toggle_encryption"
+	actionInvite        = "invite"
+	actionOccupants     = "toggle_occupants"
+	actionRetract       = "retract"
+	actionAdmin         = "channel_admin"
+	actionUpload        = "upload"
+)
+
+// keyContext is the part of the UI that a key binding applies to.
+// Bindings in different contexts may use the same keys.
+type keyContext int
+
+const (
+	// keysGlobal bindings work everywhere and must be a single key that can't be
+	// typed into an input field.
+	keysGlobal keyContext = iota
+	// keysSidebar bindings are used when the sidebar is focused.
+	keysSidebar
+	// keysChat bindings are used when a conversation is focused.
+	// Since text is typed into the conversation, they can't start with a
+	// printable character.
+	keysChat
+)
+
+// keyAction is an action that can be bound to key sequences.
+type keyAction struct {
+	name    string
+	context keyContext
+	section string
+	descr   string
+	def     []string
+	// count is true if the action can be prefixed with a number, eg. "10j".
+	count bool
+	// fixed are keys that also perform the action but that can't be changed.
+	// They are written the way they are shown in the help text, and can't be
+	// bound to other actions in the same context.
+	fixed []string
+}
+
+// keyActions is the list of actions in the order they are listed in the help.
+var keyActions = []keyAction{
+	{name: actionQuit, context: keysSidebar, section: "Global", descr: "quit or close", def: []string{"q"}, fixed: []string{"Esc"}},
+	{name: actionHelp, context: keysSidebar, section: "Global", descr: "quick help", def: []string{"K"}},
+	{name: actionManual, context: keysGlobal, section: "Global", descr: "manual", def: []string{"<F1>", "<Help>"}},
+
+	{name: actionTop, context: keysSidebar, section: "Navigation", descr: "scroll to top", def: []string{"gg"}, fixed: []string{"Home"}},
+	{name: actionBottom, context: keysSidebar, section: "Navigation", descr: "scroll to bottom", def: []string{"G"}, fixed: []string{"End"}},
+	{name: actionDown, context: keysSidebar, section: "Navigation", descr: "move down", def: []string{"j"}, fixed: []string{"↓"}, count: true},
+	{name: actionUp, context: keysSidebar, section: "Navigation", descr: "move up", def: []string{"k"}, fixed: []string{"↑"}, count: true},
+	{name: actionSearchForward, context: keysSidebar, section: "Navigation", descr: "search forward", def: []string{"/"}},
+	{name: actionSearchBack, context: keysSidebar, section: "Navigation", descr: "search backward", def: []string{"?"}},
+	{name: actionSearchNext, context: keysSidebar, section: "Navigation", descr: "next search result", def: []string{"n"}},
+	{name: actionSearchPrev, context: keysSidebar, section: "Navigation", descr: "previous search result", def: []string{"N"}},
+	{name: actionSearchHistory, context: keysSidebar, section: "Navigation", descr: "search message history", def: []string{"f"}},
+	{name: actionExport, context: keysSidebar, section: "Navigation", descr: "export message history", def: []string{"x"}},
+	{name: actionOutbox, context: keysSidebar, section: "Navigation", descr: "show messages waiting to be sent", def: []string{"go"}},
+	{name: actionNextTab, context: keysSidebar, section: "Navigation", descr: "next sidebar tab", def: []string{"gt"}},
+	{name: actionPrevTab, context: keysSidebar, section: "Navigation", descr: "previous sidebar tab", def: []string{"gT"}},
+	{name: actionNextAccount, context: keysSidebar, section: "Navigation", descr: "next account", def: []string{"ga"}},
+	{name: actionPrevAccount, context: keysSidebar, section: "Navigation", descr: "previous account", def: []string{"gA"}},
+	{name: actionMergeAccounts, context: keysSidebar, section: "Navigation", descr: "show conversations of all accounts together", def: []string{"gm"}},
+
+	{name: actionStartChat, context: keysSidebar, section: "Roster", descr: "start chat", def: []string{"c"}},
+	{name: actionCreateChannel, context: keysSidebar, section: "Roster", descr: "create channel", def: []string{"C"}},
+	{name: actionEditBookmark, context: keysSidebar, section: "Roster", descr: "edit bookmark", def: []string{"e"}},
+	{name: actionOpen, context: keysSidebar, section: "Roster", descr: "open chat", def: []string{"i"}, fixed: []string{"Enter"}},
+	{name: actionInfo, context: keysSidebar, section: "Roster", descr: "more info", def: []string{"I"}},
+	{name: actionNextUnread, context: keysSidebar, section: "Roster", descr: "open next unread", def: []string{"o"}},
+	{name: actionPrevUnread, context: keysSidebar, section: "Roster", descr: "open previous unread", def: []string{"O"}},
+	{name: actionDelete, context: keysSidebar, section: "Roster", descr: "remove contact or close conversation", def: []string{"dd"}},
+	{name: actionPin, context: keysSidebar, section: "Roster", descr: "pin or unpin conversation", def: []string{"p"}},
+	{name: actionCommand, context: keysSidebar, section: "Roster", descr: "execute command", def: []string{"!"}},
+	{name: actionStatus, context: keysSidebar, section: "Roster", descr: "change status", def: []string{"s"}},
+
+	{name: actionEditLast, context: keysChat, section: "Chat", descr: "edit last message", def: []string{"<Up>"}},
+	{name: actionEncryption, context: keysChat, section: "Chat", descr: "toggle encryption", def: []string{"<C-e>"}},
+	{name: actionInvite, context: keysChat, section: "Chat", descr: "invite someone to a channel", def: []string{"<C-n>"}},
+	{name: actionOccupants, context: keysChat, section: "Chat", descr: "show or hide channel occupants", def: []string{"<C-o>"}},
+	{name: actionRetract, context: keysChat, section: "Chat", descr: "retract or moderate a message", def: []string{"<C-r>"}},
+	{name: actionAdmin, context: keysChat, section: "Chat", descr: "kick, ban, or change roles and affiliations in a channel", def: []string{"<C-t>"}},
+	{name: actionUpload, context: keysChat, section: "Chat", descr: "upload file(s)", def: []string{"<C-u>"}},
+}
+
+// fixedHelp is help for keys that can't be changed, by section.
+var fixedHelp = map[string][]string{
+	"Navigation": {
+		"Tab, Shift+Tab: focus to next/prev",
+		"h, ←: move left",
+		"l, →: move right",
+		"PageUp, PageDown: move up/down one page",
+	},
+	"Chat": {
+		"Enter: send message",
+		"Esc: cancel editing or close chat",
+		"/help: list commands",
+	},
+}
+
+func findKeyAction(name string) (keyAction, bool) {
+	for _, a := range keyActions {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return keyAction{}, false
+}
+
+// key is a single key press.
+type key struct {
+	k   tcell.Key
+	r   rune
+	mod tcell.ModMask
+}
+
+// eventKey normalizes a key event so that it can be compared with parsed keys.
+func eventKey(ev *tcell.EventKey) key {
+	k := key{k: ev.Key(), mod: ev.Modifiers()}
+	switch {
+	case k.k == tcell.KeyRune:
+		k.r = ev.Rune()
+		// Shift is already reflected in the rune.
+		k.mod &^= tcell.ModShift
+	case k.k <= tcell.KeyUS || k.k == tcell.KeyDEL:
+		// Control characters are always typed with Ctrl.
+		k.mod &^= tcell.ModCtrl
+	}
+	return k
+}
+
+// plain reports whether k types a character into an input field.
+func (k key) plain() bool {
+	return k.k == tcell.KeyRune && k.mod&(tcell.ModAlt|tcell.ModCtrl|tcell.ModMeta) == 0
+}
+
+// keyLabels are the names shown in the help for keys that tcell does not name
+// or that are easier to recognize as a symbol.
+var keyLabels = map[tcell.Key]string{
+	tcell.KeyUp:    "↑",
+	tcell.KeyDown:  "↓",
+	tcell.KeyLeft:  "←",
+	tcell.KeyRight: "→",
+	tcell.KeyHelp:  "Help",
+}
+
+func (k key) String() string {
+	var mods string
+	if k.mod&tcell.ModCtrl != 0 {
+		mods += "Ctrl+"
+	}
+	if k.mod&(tcell.ModAlt|tcell.ModMeta) != 0 {
+		mods += "Alt+"
+	}
+	if k.mod&tcell.ModShift != 0 {
+		mods += "Shift+"
+	}
+	switch {
+	case k.k == tcell.KeyRune && k.r == ' ':
+		return mods + "Space"
+	case k.k == tcell.KeyRune:
+		return mods + string(k.r)
+	case k.k >= tcell.KeyCtrlA && k.k <= tcell.KeyCtrlZ && k.k != tcell.KeyTab && k.k != tcell.KeyEnter && k.k != tcell.KeyBackspace:
+		return mods + "Ctrl+" + string(rune('a'+k.k-tcell.KeyCtrlA))
+	case k.k == tcell.KeyBacktab:
+		return mods + "Shift+Tab"
+	}
+	if label, ok := keyLabels[k.k]; ok {
+		return mods + label
+	}
+	if name, ok := tcell.KeyNames[k.k]; ok {
+		return mods + strings.ReplaceAll(name, "Ctrl-", "Ctrl+")
+	}
+	return mods + "?"
+}
+
+// keySeq is a sequence of keys that must be pressed one after another.
+type keySeq []key
+
+func (s keySeq) String() string {
+	plain := true
+	for _, k := range s {
+		plain = plain && k.plain() && k.r != ' '
+	}
+	if plain {
+		var b strings.Builder
+		for _, k := range s {
+			b.WriteRune(k.r)
+		}
+		return b.String()
+	}
+	names := make([]string, 0, len(s))
+	for _, k := range s {
+		names = append(names, k.String())
+	}
+	return strings.Join(names, " ")
+}
+
+func (s keySeq) hasPrefix(prefix keySeq) bool {
+	if len(prefix) > len(s) {
+		return false
+	}
+	for i, k := range prefix {
+		if s[i] != k {
+			return false
+		}
+	}
+	return true
+}
+
+// keyNames maps the lower case names of special keys to the keys.
+var keyNames = func() map[string]tcell.Key {
+	names := map[string]tcell.Key{
+		"esc":      tcell.KeyEsc,
+		"escape":   tcell.KeyEsc,
+		"cr":       tcell.KeyEnter,
+		"return":   tcell.KeyEnter,
+		"bs":       tcell.KeyBackspace2,
+		"del":      tcell.KeyDelete,
+		"pageup":   tcell.KeyPgUp,
+		"pagedown": tcell.KeyPgDn,
+		"ins":      tcell.KeyInsert,
+		"help":     tcell.KeyHelp,
+	}
+	for k, name := range tcell.KeyNames {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "ctrl-") {
+			continue
+		}
+		names[name] = k
+	}
+	// Backspace2 is what most terminals send when backspace is pressed.
+	names["backspace"] = tcell.KeyBackspace2
+	return names
+}()
+
+// parseKeySeq parses a key sequence.
+// Printable characters stand for themselves and special keys are written in
+// angle brackets, optionally with the modifiers C- (Ctrl), M- or A- (Alt), and
+// S- (Shift). For example "gg", "<C-x>u", "<Up>", "<M-j>", or "<S-Tab>".
+// Use "<lt>" for a literal "<" and "<Space>" for a space.
+func parseKeySeq(p *message.Printer, s string) (keySeq, error) {
+	var seq keySeq
+	for s != "" {
+		r, size := utf8.DecodeRuneInString(s)
+		if r != '<' {
+			if unicode.IsControl(r) {
+				return nil, errors.New(p.Sprintf("invalid key %q, write special keys in angle brackets", r))
+			}
+			seq = append(seq, key{k: tcell.KeyRune, r: r})
+			s = s[size:]
+			continue
+		}
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			return nil, errors.New(p.Sprintf("missing > in key %q", s))
+		}
+		k, err := parseSpecialKey(p, s[1:end])
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, k)
+		s = s[end+1:]
+	}
+	if len(seq) == 0 {
+		return nil, errors.New(p.Sprintf("empty key sequence"))
+	}
+	return seq, nil
+}
+
+func parseSpecialKey(p *message.Printer, name string) (key, error) {
+	var k key
+	// Strip modifiers. A single "-" is a key, not a modifier.
+	for len(name) > 2 && name[1] == '-' {
+		switch unicode.ToLower(rune(name[0])) {
+		case 'c':
+			k.mod |= tcell.ModCtrl
+		case 'm', 'a':
+			k.mod |= tcell.ModAlt
+		case 's':
+			k.mod |= tcell.ModShift
+		default:
+			return k, errors.New(p.Sprintf("unknown modifier %q in key <%s>", name[:2], name))
+		}
+		name = name[2:]
+	}
+
+	if r, size := utf8.DecodeRuneInString(name); size == len(name) && size > 0 {
+		k.k = tcell.KeyRune
+		k.r = r
+		if k.mod&tcell.ModShift != 0 {
+			k.r = unicode.ToUpper(r)
+			k.mod &^= tcell.ModShift
+		}
+		if k.mod&tcell.ModCtrl != 0 {
+			// Ctrl and a letter is sent as a control character.
+			lower := unicode.ToLower(k.r)
+			if lower < 'a' || lower > 'z' {
+				return k, errors.New(p.Sprintf("Ctrl can only be used with letters, got <%s>", name))
+			}
+			k.k = tcell.KeyCtrlA + tcell.Key(lower-'a')
+			k.r = 0
+			k.mod &^= tcell.ModCtrl
+		}
+		return k, nil
+	}
+
+	switch lower := strings.ToLower(name); lower {
+	case "space":
+		k.k, k.r = tcell.KeyRune, ' '
+	case "lt":
+		k.k, k.r = tcell.KeyRune, '<'
+	default:
+		special, ok := keyNames[lower]
+		if !ok {
+			return k, errors.New(p.Sprintf("unknown key <%s>", name))
+		}
+		k.k = special
+	}
+	if k.k == tcell.KeyTab && k.mod&tcell.ModShift != 0 {
+		k.k = tcell.KeyBacktab
+		k.mod &^= tcell.ModShift
+	}
+	return k, nil
+}
+
+// keymap maps actions to the key sequences that trigger them.
+type keymap struct {
+	m        sync.Mutex
+	bindings map[string][]keySeq
+}
+
+// defaultKeymap returns a keymap with the default bindings.
+func defaultKeymap() *keymap {
+	km := &keymap{bindings: make(map[string][]keySeq)}
+	for _, a := range keyActions {
+		for _, s := range a.def {
+			seq, err := parseKeySeq(message.NewPrinter(message.MatchLanguage("en")), s)
+			if err != nil {
+				panic(err)
+			}
+			km.bindings[a.name] = append(km.bindings[a.name], seq)
+		}
+	}
+	return km
+}
+
+// DefaultKeys returns the default key bindings in the format used by the Keys
+// option.
+func DefaultKeys() map[string][]string {
+	keys := make(map[string][]string, len(keyActions))
+	for _, a := range keyActions {
+		keys[a.name] = a.def
+	}
+	return keys
+}
+
+// parseBinding parses the key sequences bound to the action name.
+func parseBinding(p *message.Printer, name string, seqs []string) (keyAction, []keySeq, error) {
+	a, ok := findKeyAction(name)
+	if !ok {
+		return a, nil, errors.New(p.Sprintf("unknown action %q", name))
+	}
+	parsed := make([]keySeq, 0, len(seqs))
+	for _, s := range seqs {
+		seq, err := parseKeySeq(p, s)
+		if err != nil {
+			return a, nil, localerr.Wrap(p, "invalid key sequence %q for %s: %v", s, name, err)
+		}
+		switch a.context {
+		case keysGlobal:
+			if len(seq) > 1 || seq[0].plain() {
+				return a, nil, errors.New(p.Sprintf("%s must be bound to a single key that is not a printable character, got %q", name, s))
+			}
+		case keysChat:
+			if seq[0].plain() {
+				return a, nil, errors.New(p.Sprintf("%s can't start with a printable character, got %q", name, s))
+			}
+		}
+		if other, ok := fixedTo(a.context, seq); ok && other != name {
+			return a, nil, errors.New(p.Sprintf("%q always performs %s and can't be bound to %s", s, other, name))
+		}
+		parsed = append(parsed, seq)
+	}
+	return a, parsed, nil
+}
+
+// fixedTo returns the action in the context that seq is a fixed key for.
+func fixedTo(ctx keyContext, seq keySeq) (string, bool) {
+	if len(seq) != 1 || seq[0].plain() {
+		return "", false
+	}
+	name := seq.String()
+	for _, a := range keyActions {
+		if a.context == ctx && slices.Contains(a.fixed, name) {
+			return a.name, true
+		}
+	}
+	return "", false
+}
+
+// boundTo returns the action in bindings that seq is bound to in the context.
+func boundTo(bindings map[string][]keySeq, ctx keyContext, seq keySeq) (string, bool) {
+	for _, a := range keyActions {
+		if a.context != ctx {
+			continue
+		}
+		for _, s := range bindings[a.name] {
+			if len(s) == len(seq) && s.hasPrefix(seq) {
+				return a.name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// set replaces the key sequences of the actions in bindings.
+// Sequences that are set are removed from the default bindings of other
+// actions in the same context so that they are not ambiguous.
+// Invalid bindings, and sequences that are set for more than one action, are
+// skipped and returned as errors.
+func (km *keymap) set(p *message.Printer, bindings map[string][]string) []error {
+	names := make([]string, 0, len(bindings))
+	for name := range bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	parsed := make(map[string][]keySeq, len(bindings))
+	for _, name := range names {
+		a, seqs, err := parseBinding(p, name, bindings[name])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		kept := seqs[:0]
+		for _, seq := range seqs {
+			if other, ok := boundTo(parsed, a.context, seq); ok {
+				errs = append(errs, errors.New(p.Sprintf("%q is bound to both %s and %s", seq.String(), other, name)))
+				continue
+			}
+			kept = append(kept, seq)
+		}
+		parsed[name] = kept
+	}
+
+	km.m.Lock()
+	defer km.m.Unlock()
+	for _, a := range keyActions {
+		if seqs, ok := parsed[a.name]; ok {
+			km.bindings[a.name] = seqs
+			continue
+		}
+		kept := km.bindings[a.name][:0]
+		for _, seq := range km.bindings[a.name] {
+			if _, taken := boundTo(parsed, a.context, seq); !taken {
+				kept = append(kept, seq)
+			}
+		}
+		km.bindings[a.name] = kept
+	}
+	return errs
+}
+
+// keyResult is the result of feeding a key to the keymap.
+type keyResult int
+
+const (
+	// keyNone means that the key is not bound and should be handled normally.
+	keyNone keyResult = iota
+	// keyPending means that the key is part of a longer sequence or a count.
+	keyPending
+	// keyMatched means that a sequence bound to an action was completed.
+	keyMatched
+)
+
+// keyState tracks the keys that have been pressed so far in a sequence.
+type keyState struct {
+	pending keySeq
+	count   int
+}
+
+func (st *keyState) reset() {
+	st.pending = st.pending[:0]
+	st.count = 0
+}
+
+// feed adds the key press ev to the sequence in st and reports whether it
+// completes a binding in the context.
+// If an action was matched, the count typed before it is returned (or 0 if
+// there was none).
+// A sequence that is bound to an action is matched as soon as it is typed, even
+// if a longer sequence starts with it.
+func (km *keymap) feed(ctx keyContext, st *keyState, ev *tcell.EventKey) (string, int, keyResult) {
+	k := eventKey(ev)
+	if ctx == keysChat && len(st.pending) == 0 && k.plain() {
+		return "", 0, keyNone
+	}
+
+	km.m.Lock()
+	defer km.m.Unlock()
+
+	if len(st.pending) == 0 && k.plain() && unicode.IsDigit(k.r) && (st.count > 0 || k.r != '0') && km.countsIn(ctx) {
+		st.count = st.count*10 + int(k.r-'0')
+		return "", 0, keyPending
+	}
+
+	st.pending = append(st.pending, k)
+	var prefix bool
+	for _, a := range keyActions {
+		if a.context != ctx {
+			continue
+		}
+		for _, seq := range km.bindings[a.name] {
+			if !seq.hasPrefix(st.pending) {
+				continue
+			}
+			if len(seq) == len(st.pending) {
+				count := st.count
+				st.reset()
+				if !a.count {
+					count = 0
+				}
+				return a.name, count, keyMatched
+			}
+			prefix = true
+		}
+	}
+	if prefix {
+		return "", 0, keyPending
+	}
+	st.reset()
+	return "", 0, keyNone
+}
+
+// countsIn reports whether any action in the context accepts a count.
+func (km *keymap) countsIn(ctx keyContext) bool {
+	for _, a := range keyActions {
+		if a.context == ctx && a.count && len(km.bindings[a.name]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// help returns the help text for the active key bindings.
+func (km *keymap) help() string {
+	km.m.Lock()
+	defer km.m.Unlock()
+
+	var b strings.Builder
+	var section string
+	var counts []string
+	flush := func() {
+		for _, line := range counts {
+			b.WriteString(line)
+		}
+		counts = counts[:0]
+		for _, line := range fixedHelp[section] {
+			b.WriteString(tview.Escape(line))
+			b.WriteByte('\n')
+		}
+	}
+	for _, a := range keyActions {
+		if a.section != section {
+			if section != "" {
+				flush()
+				b.WriteByte('\n')
+			}
+			section = a.section
+			b.WriteString("[::b]" + section + "[::-]\n\n")
+		}
+		names := make([]string, 0, len(km.bindings[a.name])+len(a.fixed))
+		for _, seq := range km.bindings[a.name] {
+			names = append(names, seq.String())
+		}
+		for _, name := range a.fixed {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		b.WriteString(tview.Escape(strings.Join(names, ", ") + ": " + a.descr))
+		b.WriteByte('\n')
+		if a.count && len(km.bindings[a.name]) > 0 {
+			counts = append(counts, tview.Escape("<n>"+km.bindings[a.name][0].String()+": "+a.descr+" <n> lines")+"\n")
+		}
+	}
+	flush()
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Keys returns an option that changes the key bindings.
+// Bindings maps the names of actions to key sequences, any action that is not
+// present keeps its default bindings and an empty list removes all bindings
+// for the action.
+// Invalid bindings are logged and ignored.
+func Keys(bindings map[string][]string) Option {
+	return func(ui *UI) {
+		for _, err := range ui.keys.set(ui.p, bindings) {
+			ui.logger.Print(ui.p.Sprintf("error in key bindings: %v", err))
+		}
+	}
+}
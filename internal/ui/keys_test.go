@@ -0,0 +1,339 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+var testPrinter = message.NewPrinter(language.English)
+
+func plainKey(c rune) key {
+	return key{k: tcell.KeyRune, r: c}
+}
+
+var parseKeySeqTestCases = [...]struct {
+	in   string
+	want keySeq
+	str  string
+	err  bool
+}{
+	0:  {in: "gg", want: keySeq{plainKey('g'), plainKey('g')}, str: "gg"},
+	1:  {in: "<C-x>u", want: keySeq{{k: tcell.KeyCtrlX}, plainKey('u')}, str: "Ctrl+x u"},
+	2:  {in: "<Up>", want: keySeq{{k: tcell.KeyUp}}, str: "↑"},
+	3:  {in: "<up>", want: keySeq{{k: tcell.KeyUp}}, str: "↑"},
+	4:  {in: "<M-j>", want: keySeq{{k: tcell.KeyRune, r: 'j', mod: tcell.ModAlt}}, str: "Alt+j"},
+	5:  {in: "<A-j>", want: keySeq{{k: tcell.KeyRune, r: 'j', mod: tcell.ModAlt}}, str: "Alt+j"},
+	6:  {in: "<S-Tab>", want: keySeq{{k: tcell.KeyBacktab}}, str: "Shift+Tab"},
+	7:  {in: "<S-a>", want: keySeq{plainKey('A')}, str: "A"},
+	8:  {in: "<C-E>", want: keySeq{{k: tcell.KeyCtrlE}}, str: "Ctrl+e"},
+	9:  {in: "<lt>", want: keySeq{plainKey('<')}, str: "<"},
+	10: {in: "<Space>", want: keySeq{plainKey(' ')}, str: "Space"},
+	11: {in: "<F1>", want: keySeq{{k: tcell.KeyF1}}, str: "F1"},
+	12: {in: "<CR>", want: keySeq{{k: tcell.KeyEnter}}, str: "Enter"},
+	13: {in: "<Esc>", want: keySeq{{k: tcell.KeyEsc}}, str: "Esc"},
+	14: {in: "<Home>", want: keySeq{{k: tcell.KeyHome}}, str: "Home"},
+	15: {in: "<C-M-x>", want: keySeq{{k: tcell.KeyCtrlX, mod: tcell.ModAlt}}, str: "Alt+Ctrl+x"},
+	16: {in: "<->", want: keySeq{plainKey('-')}, str: "-"},
+	17: {in: "é", want: keySeq{plainKey('é')}, str: "é"},
+	18: {in: "", err: true},
+	19: {in: "<Up", err: true},
+	20: {in: "<>", err: true},
+	21: {in: "<X-a>", err: true},
+	22: {in: "<C-1>", err: true},
+	23: {in: "<Nope>", err: true},
+	24: {in: "\x01", err: true},
+	25: {in: "g<", err: true},
+}
+
+func TestParseKeySeq(t *testing.T) {
+	for i, tc := range parseKeySeqTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			seq, err := parseKeySeq(testPrinter, tc.in)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected error, got %v", seq)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(seq, tc.want) {
+				t.Errorf("wrong key sequence: want=%v, got=%v", tc.want, seq)
+			}
+			if s := seq.String(); s != tc.str {
+				t.Errorf("wrong string: want=%q, got=%q", tc.str, s)
+			}
+		})
+	}
+}
+
+var parseBindingTestCases = [...]struct {
+	name string
+	seqs []string
+	err  string
+}{
+	0: {name: actionDown, seqs: []string{"j", "<C-n>", "<Down>"}},
+	1: {name: actionManual, seqs: []string{"<F2>"}},
+	2: {name: actionUpload, seqs: []string{"<C-x>u"}},
+	3: {name: actionQuit, seqs: []string{"<Esc>"}},
+	4: {name: "nope", seqs: []string{"x"}, err: "unknown action"},
+	5: {name: actionDown, seqs: []string{"j", "<Up"}, err: "invalid key sequence"},
+	6: {name: actionManual, seqs: []string{"m"}, err: "single key"},
+	7: {name: actionManual, seqs: []string{"<F1><F2>"}, err: "single key"},
+	8: {name: actionUpload, seqs: []string{"u<C-x>"}, err: "printable character"},
+	// Keys that always perform another action can't be rebound.
+	9:  {name: actionHelp, seqs: []string{"<Esc>"}, err: "always performs quit"},
+	10: {name: actionTop, seqs: []string{"<Down>"}, err: "always performs down"},
+	11: {name: actionInfo, seqs: []string{"<CR>"}, err: "always performs open"},
+}
+
+func TestParseBinding(t *testing.T) {
+	for i, tc := range parseBindingTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a, seqs, err := parseBinding(testPrinter, tc.name, tc.seqs)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("wrong error: want=%q, got=%v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if a.name != tc.name {
+				t.Errorf("wrong action: want=%s, got=%s", tc.name, a.name)
+			}
+			if len(seqs) != len(tc.seqs) {
+				t.Errorf("wrong number of sequences: want=%d, got=%d", len(tc.seqs), len(seqs))
+			}
+		})
+	}
+}
+
+// TestDefaultKeys checks that the default bindings in each context are not
+// ambiguous and do not use keys that are fixed to another action.
+func TestDefaultKeys(t *testing.T) {
+	km := defaultKeymap()
+	type bound struct {
+		action string
+		seq    keySeq
+	}
+	byContext := make(map[keyContext][]bound)
+	for _, a := range keyActions {
+		_, _, err := parseBinding(testPrinter, a.name, a.def)
+		if err != nil {
+			t.Errorf("invalid default binding for %s: %v", a.name, err)
+		}
+		for _, seq := range km.bindings[a.name] {
+			byContext[a.context] = append(byContext[a.context], bound{action: a.name, seq: seq})
+		}
+	}
+	for _, bindings := range byContext {
+		for i, b := range bindings {
+			for _, other := range bindings[i+1:] {
+				if b.seq.hasPrefix(other.seq) || other.seq.hasPrefix(b.seq) {
+					t.Errorf("%s (%s) and %s (%s) are ambiguous", b.seq, b.action, other.seq, other.action)
+				}
+			}
+		}
+	}
+}
+
+var setTestCases = [...]struct {
+	bindings map[string][]string
+	errs     int
+	want     map[string][]string
+}{
+	0: {
+		want: map[string][]string{actionDown: {"j"}, actionUp: {"k"}},
+	},
+	1: {
+		// Taking a default key from another action removes it there.
+		bindings: map[string][]string{actionUp: {"j"}},
+		want:     map[string][]string{actionDown: nil, actionUp: {"j"}},
+	},
+	2: {
+		// Swapping keys.
+		bindings: map[string][]string{actionUp: {"j"}, actionDown: {"k"}},
+		want:     map[string][]string{actionDown: {"k"}, actionUp: {"j"}},
+	},
+	3: {
+		// The same key for two actions keeps it for the first one by name.
+		bindings: map[string][]string{actionUp: {"x", "k"}, actionDown: {"x"}},
+		errs:     1,
+		want:     map[string][]string{actionDown: {"x"}, actionUp: {"k"}, actionExport: nil},
+	},
+	4: {
+		// Keys in different contexts don't conflict.
+		bindings: map[string][]string{actionTop: {"<C-e>"}},
+		want:     map[string][]string{actionTop: {"Ctrl+e"}, actionEncryption: {"Ctrl+e"}},
+	},
+	5: {
+		// An empty list removes all bindings.
+		bindings: map[string][]string{actionManual: {}},
+		want:     map[string][]string{actionManual: nil},
+	},
+	6: {
+		// Invalid bindings are reported and the defaults are kept.
+		bindings: map[string][]string{"nope": {"x"}, actionUp: {"<Up"}, actionHelp: {"<Esc>"}},
+		errs:     3,
+		want:     map[string][]string{actionUp: {"k"}, actionHelp: {"K"}, actionExport: {"x"}},
+	},
+}
+
+func TestSet(t *testing.T) {
+	for i, tc := range setTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			km := defaultKeymap()
+			errs := km.set(testPrinter, tc.bindings)
+			if len(errs) != tc.errs {
+				t.Errorf("wrong number of errors: want=%d, got=%v", tc.errs, errs)
+			}
+			for name, want := range tc.want {
+				var got []string
+				for _, seq := range km.bindings[name] {
+					got = append(got, seq.String())
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("wrong bindings for %s: want=%q, got=%q", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func runeKey(c rune) *tcell.EventKey {
+	return tcell.NewEventKey(tcell.KeyRune, c, tcell.ModNone)
+}
+
+type fed struct {
+	action string
+	count  int
+	res    keyResult
+}
+
+var feedTestCases = [...]struct {
+	ctx      keyContext
+	bindings map[string][]string
+	keys     []*tcell.EventKey
+	want     []fed
+}{
+	0: {
+		ctx:  keysSidebar,
+		keys: []*tcell.EventKey{runeKey('j')},
+		want: []fed{{action: actionDown, res: keyMatched}},
+	},
+	1: {
+		ctx:  keysSidebar,
+		keys: []*tcell.EventKey{runeKey('g'), runeKey('g')},
+		want: []fed{{res: keyPending}, {action: actionTop, res: keyMatched}},
+	},
+	2: {
+		// An unbound chord resets the state.
+		ctx:  keysSidebar,
+		keys: []*tcell.EventKey{runeKey('g'), runeKey('z'), runeKey('j')},
+		want: []fed{{res: keyPending}, {res: keyNone}, {action: actionDown, res: keyMatched}},
+	},
+	3: {
+		ctx:  keysSidebar,
+		keys: []*tcell.EventKey{runeKey('1'), runeKey('0'), runeKey('j')},
+		want: []fed{{res: keyPending}, {res: keyPending}, {action: actionDown, count: 10, res: keyMatched}},
+	},
+	4: {
+		// Counts are dropped for actions that don't take one.
+		ctx:  keysSidebar,
+		keys: []*tcell.EventKey{runeKey('3'), runeKey('g'), runeKey('g')},
+		want: []fed{{res: keyPending}, {res: keyPending}, {action: actionTop, res: keyMatched}},
+	},
+	5: {
+		// A count can't start with zero.
+		ctx:  keysSidebar,
+		keys: []*tcell.EventKey{runeKey('0')},
+		want: []fed{{res: keyNone}},
+	},
+	6: {
+		// Digits in the middle of a chord are not a count.
+		ctx:  keysSidebar,
+		keys: []*tcell.EventKey{runeKey('g'), runeKey('5')},
+		want: []fed{{res: keyPending}, {res: keyNone}},
+	},
+	7: {
+		// The count is reset after a match.
+		ctx:  keysSidebar,
+		keys: []*tcell.EventKey{runeKey('2'), runeKey('k'), runeKey('k')},
+		want: []fed{{res: keyPending}, {action: actionUp, count: 2, res: keyMatched}, {action: actionUp, res: keyMatched}},
+	},
+	8: {
+		// Without any bindings that take a count, digits are not counts.
+		ctx:      keysSidebar,
+		bindings: map[string][]string{actionUp: {}, actionDown: {}},
+		keys:     []*tcell.EventKey{runeKey('2')},
+		want:     []fed{{res: keyNone}},
+	},
+	9: {
+		// A shorter sequence is matched before a longer one that starts with it.
+		ctx:      keysSidebar,
+		bindings: map[string][]string{actionInfo: {"g"}},
+		keys:     []*tcell.EventKey{runeKey('g'), runeKey('g')},
+		want:     []fed{{action: actionInfo, res: keyMatched}, {action: actionInfo, res: keyMatched}},
+	},
+	10: {
+		// Plain keys are typed in a chat.
+		ctx:  keysChat,
+		keys: []*tcell.EventKey{runeKey('j'), runeKey('1')},
+		want: []fed{{res: keyNone}, {res: keyNone}},
+	},
+	11: {
+		ctx:  keysChat,
+		keys: []*tcell.EventKey{tcell.NewEventKey(tcell.KeyCtrlE, 0, tcell.ModCtrl), tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone)},
+		want: []fed{{action: actionEncryption, res: keyMatched}, {action: actionEditLast, res: keyMatched}},
+	},
+	12: {
+		// Chords in a chat may continue with printable characters.
+		ctx:      keysChat,
+		bindings: map[string][]string{actionUpload: {"<C-x>u"}},
+		keys:     []*tcell.EventKey{tcell.NewEventKey(tcell.KeyCtrlX, 0, tcell.ModCtrl), runeKey('u')},
+		want:     []fed{{res: keyPending}, {action: actionUpload, res: keyMatched}},
+	},
+	13: {
+		// Shift is part of the rune.
+		ctx:  keysSidebar,
+		keys: []*tcell.EventKey{tcell.NewEventKey(tcell.KeyRune, 'G', tcell.ModShift)},
+		want: []fed{{action: actionBottom, res: keyMatched}},
+	},
+	14: {
+		// Sidebar bindings don't apply in other contexts.
+		ctx:  keysGlobal,
+		keys: []*tcell.EventKey{runeKey('q'), tcell.NewEventKey(tcell.KeyF1, 0, tcell.ModNone)},
+		want: []fed{{res: keyNone}, {action: actionManual, res: keyMatched}},
+	},
+}
+
+func TestFeed(t *testing.T) {
+	for i, tc := range feedTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			km := defaultKeymap()
+			if errs := km.set(testPrinter, tc.bindings); len(errs) > 0 {
+				t.Fatalf("error setting bindings: %v", errs)
+			}
+			var st keyState
+			for j, ev := range tc.keys {
+				action, count, res := km.feed(tc.ctx, &st, ev)
+				if got := (fed{action: action, count: count, res: res}); got != tc.want[j] {
+					t.Errorf("wrong result for key %d: want=%+v, got=%+v", j, tc.want[j], got)
+				}
+			}
+		})
+	}
+}
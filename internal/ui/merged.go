@@ -0,0 +1,190 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"golang.org/x/text/message"
+)
+
+const mergedPageName = "merged"
+
+// mergedItem is a conversation in the merged list and the account it belongs
+// to.
+type mergedItem struct {
+	acct *UI
+	bare string
+}
+
+// Merged is a tview.Primitive that shows the conversations of every account in
+// a single list.
+// Pinned conversations from all accounts are shown first, and the account that
+// each conversation belongs to is shown below it.
+type Merged struct {
+	*tview.Flex
+	list    *tview.List
+	s       *screen
+	keys    keyState
+	eventsM sync.Mutex
+	items   []mergedItem
+	// stale is set when the conversations of any account change so that the
+	// list is rebuilt the next time it is drawn.
+	stale atomic.Bool
+}
+
+func newMerged(p *message.Printer, s *screen) *Merged {
+	m := &Merged{
+		list: tview.NewList(),
+		s:    s,
+	}
+	m.list.SetTitle(p.Sprintf("All accounts"))
+	m.list.SetChangedFunc(func(idx int, main, secondary string, _ rune) {
+		s.statusBar.SetText(p.Sprintf("Chat: %q (%s)", main, secondary))
+	})
+	m.Flex = tview.NewFlex().SetDirection(tview.FlexRow)
+	m.Flex.SetBorder(true).
+		SetBorderPadding(0, 0, 1, 0).
+		SetTitle(m.list.GetTitle())
+	m.Flex.AddItem(m.list, 0, 1, true)
+	m.stale.Store(true)
+	return m
+}
+
+// refresh marks the list as out of date.
+// It is safe to call from any goroutine, including with the lock of an
+// accounts conversations held.
+func (m *Merged) refresh() {
+	m.stale.Store(true)
+}
+
+// rebuild fills the list with the conversations of every account, keeping the
+// current selection if it is still in the list.
+func (m *Merged) rebuild() {
+	var cur mergedItem
+	if idx := m.list.GetCurrentItem(); idx >= 0 && idx < len(m.items) {
+		cur = m.items[idx]
+	}
+
+	m.s.accountsM.Lock()
+	accounts := append([]*UI(nil), m.s.accounts...)
+	m.s.accountsM.Unlock()
+
+	type entry struct {
+		acct *UI
+		conv Conversation
+		text string
+	}
+	var pinned, rest []entry
+	for _, acct := range accounts {
+		convs := acct.sidebar.conversations
+		for _, c := range convs.ordered() {
+			e := entry{acct: acct, conv: c, text: convs.text(c)}
+			if c.Pinned {
+				pinned = append(pinned, e)
+			} else {
+				rest = append(rest, e)
+			}
+		}
+	}
+
+	m.list.Clear()
+	m.items = m.items[:0]
+	selected := -1
+	for _, e := range append(pinned, rest...) {
+		item := mergedItem{acct: e.acct, bare: e.conv.JID.Bare().String()}
+		if item == cur {
+			selected = len(m.items)
+		}
+		m.items = append(m.items, item)
+		m.list.AddItem(e.text, tview.Escape(e.acct.addr), 0, func() {
+			m.open(item)
+		})
+	}
+	if selected >= 0 {
+		m.list.SetCurrentItem(selected)
+	}
+}
+
+// open makes the account that the conversation belongs to active and opens the
+// conversation.
+func (m *Merged) open(item mergedItem) {
+	convs := item.acct.sidebar.conversations
+	c, ok := convs.GetItem(item.bare)
+	if !ok || c.selected == nil {
+		return
+	}
+	m.s.setActive(item.acct)
+	convs.list.SetCurrentItem(c.idx)
+	c.selected()
+}
+
+// Draw implements tview.Primitive for Merged.
+func (m *Merged) Draw(screen tcell.Screen) {
+	if m.stale.Swap(false) {
+		m.rebuild()
+	}
+	m.Flex.Draw(screen)
+}
+
+// InputHandler implements tview.Primitive for Merged.
+func (m *Merged) InputHandler() func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
+	return m.WrapInputHandler(func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
+		if event == nil {
+			return
+		}
+
+		m.eventsM.Lock()
+		defer m.eventsM.Unlock()
+		if event.Key() == tcell.KeyESC {
+			m.keys.reset()
+			return
+		}
+		action, count, res := m.s.keys.feed(keysSidebar, &m.keys, event)
+		switch res {
+		case keyPending:
+			return
+		case keyNone:
+			m.list.InputHandler()(event, setFocus)
+			return
+		}
+
+		if count == 0 {
+			count = 1
+		}
+		switch action {
+		case actionOpen:
+			m.list.InputHandler()(tcell.NewEventKey(tcell.KeyCR, 0, tcell.ModNone), setFocus)
+		case actionUp:
+			m.list.SetCurrentItem(max(m.list.GetCurrentItem()-count, 0))
+		case actionDown:
+			m.list.SetCurrentItem(min(m.list.GetCurrentItem()+count, m.list.GetItemCount()-1))
+		case actionTop:
+			m.list.SetCurrentItem(0)
+		case actionBottom:
+			m.list.SetCurrentItem(m.list.GetItemCount() - 1)
+		case actionMergeAccounts:
+			m.s.showMerged(false)
+		case actionNextAccount:
+			m.s.switchAccount(1)
+		case actionPrevAccount:
+			m.s.switchAccount(-1)
+		case actionStatus:
+			m.s.statusSelect()
+		case actionQuit:
+			m.s.activeUI().ShowQuitPrompt()
+		case actionHelp:
+			m.s.activeUI().ShowHelpPrompt()
+		}
+	})
+}
+
+// PasteHandler implements tview.Primitive.
+func (*Merged) PasteHandler() func(string, func(tview.Primitive)) {
+	return nil
+}
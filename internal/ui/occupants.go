@@ -0,0 +1,158 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"golang.org/x/text/message"
+
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
+)
+
+// occupantsWidth is the width of the occupant list when it is shown.
+const occupantsWidth = 24
+
+// channels holds the occupants and subjects of the channels an account has
+// joined.
+type channels struct {
+	sync.Mutex
+	occupants map[string][]muc.Item
+	subjects  map[string]string
+	// version changes whenever the occupants change so that the list knows when
+	// it has to be redrawn.
+	version uint64
+}
+
+func newChannels() *channels {
+	return &channels{
+		occupants: make(map[string][]muc.Item),
+		subjects:  make(map[string]string),
+	}
+}
+
+// SetOccupants replaces the list of everyone in the channel room.
+func (ui *UI) SetOccupants(room jid.JID, occupants []muc.Item) {
+	ui.channels.Lock()
+	defer ui.channels.Unlock()
+	if len(occupants) == 0 {
+		delete(ui.channels.occupants, room.Bare().String())
+	} else {
+		ui.channels.occupants[room.Bare().String()] = occupants
+	}
+	ui.channels.version++
+}
+
+// SetSubject sets the subject of the channel room and shows it in the title if
+// the channel is open.
+func (ui *UI) SetSubject(room jid.JID, subject string) {
+	ui.channels.Lock()
+	ui.channels.subjects[room.Bare().String()] = subject
+	ui.channels.Unlock()
+	ui.history.updateTitle()
+}
+
+// subject returns the subject of the channel room.
+func (ui *UI) subject(room jid.JID) string {
+	ui.channels.Lock()
+	defer ui.channels.Unlock()
+	return ui.channels.subjects[room.Bare().String()]
+}
+
+// toggleOccupants shows or hides the occupant list.
+// It is only ever shown for channels.
+func (cv *ConversationView) toggleOccupants() {
+	cv.showOccupants = !cv.showOccupants
+	cv.shownUI = nil
+}
+
+// Draw implements tview.Primitive for ConversationView.
+func (cv *ConversationView) Draw(screen tcell.Screen) {
+	cv.syncOccupants()
+	cv.Flex.Draw(screen)
+}
+
+// syncOccupants shows the occupants of the selected channel if the occupant
+// list is turned on.
+func (cv *ConversationView) syncOccupants() {
+	ui := cv.ui.activeUI()
+	c, ok := ui.sidebar.conversations.GetSelected()
+	if !ok || !c.Room || !cv.showOccupants {
+		cv.body.ResizeItem(cv.occupantList, 0, 0)
+		return
+	}
+	cv.body.ResizeItem(cv.occupantList, occupantsWidth, 0)
+
+	room := c.JID.Bare().String()
+	ui.channels.Lock()
+	defer ui.channels.Unlock()
+	if ui == cv.shownUI && room == cv.shownRoom && ui.channels.version == cv.shownVersion {
+		return
+	}
+	cv.shownUI, cv.shownRoom, cv.shownVersion = ui, room, ui.channels.version
+	occupants := ui.channels.occupants[room]
+	cv.occupantList.SetTitle(cv.ui.Printer().Sprintf("Occupants (%d)", len(occupants)))
+	cv.occupantList.SetText(formatOccupants(cv.ui.Printer(), occupants))
+}
+
+// formatOccupants lists the occupants of a channel grouped by their role.
+func formatOccupants(p *message.Printer, occupants []muc.Item) string {
+	var buf strings.Builder
+	for _, role := range []muc.Role{muc.RoleModerator, muc.RoleParticipant, muc.RoleVisitor} {
+		var n int
+		for _, occupant := range occupants {
+			if occupant.Role == role {
+				n++
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("[::b]")
+		switch role {
+		case muc.RoleModerator:
+			buf.WriteString(p.Sprintf("Moderators (%d)", n))
+		case muc.RoleParticipant:
+			buf.WriteString(p.Sprintf("Participants (%d)", n))
+		case muc.RoleVisitor:
+			buf.WriteString(p.Sprintf("Visitors (%d)", n))
+		}
+		buf.WriteString("[::-]\n")
+		for _, occupant := range occupants {
+			if occupant.Role != role {
+				continue
+			}
+			buf.WriteString(tview.Escape(occupant.Nick))
+			if a := affiliation(p, occupant.Affiliation); a != "" {
+				buf.WriteString(" [::d]")
+				buf.WriteString(a)
+				buf.WriteString("[::-]")
+			}
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String()
+}
+
+// affiliation returns the name of a long lived affiliation with a channel, or
+// an empty string if there is none.
+func affiliation(p *message.Printer, a muc.Affiliation) string {
+	switch a {
+	case muc.AffiliationOwner:
+		return p.Sprintf("owner")
+	case muc.AffiliationAdmin:
+		return p.Sprintf("admin")
+	case muc.AffiliationMember:
+		return p.Sprintf("member")
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/jid"
+)
+
+const outboxPageName = "outbox"
+
+// QueuedMessage is a message that is waiting in the outbox.
+type QueuedMessage struct {
+	// ID is the origin ID of the message.
+	ID     string
+	To     jid.JID
+	Body   string
+	Queued time.Time
+}
+
+// loadOutbox asks for the messages that are waiting to be sent.
+func (ui *UI) loadOutbox() {
+	ui.handler(event.LoadOutbox{})
+}
+
+// ShowOutbox shows the messages that are waiting to be sent, oldest first, and
+// lets the user pick one to cancel.
+func (ui *UI) ShowOutbox(msgs []QueuedMessage) {
+	p := ui.Printer()
+	onEsc := func() {
+		ui.pages.HidePage(outboxPageName)
+		ui.pages.RemovePage(outboxPageName)
+	}
+
+	title := p.Sprintf("Outbox")
+	closeButton := p.Sprintf("Close")
+	cancelButton := p.Sprintf("Don't send")
+	mod := NewModal().SetText(title)
+	if len(msgs) == 0 {
+		mod.SetText(title + "\n\n" + p.Sprintf("There are no messages waiting to be sent."))
+		mod.AddButtons([]string{closeButton})
+	} else {
+		mod.SetText(title + "\n\n" + p.Sprintf("These messages will be sent in order once you are online."))
+		mod.AddButtons([]string{closeButton, cancelButton})
+	}
+
+	var selected int
+	if len(msgs) > 0 {
+		opts := make([]string, 0, len(msgs))
+		for _, msg := range msgs {
+			body, _, _ := strings.Cut(msg.Body, "\n")
+			opts = append(opts, tview.Escape(msg.Queued.Local().Format(time.DateTime)+" → "+msg.To.Bare().String()+": "+body))
+		}
+		mod.Form().AddDropDown(p.Sprintf("Message"), opts, 0, func(_ string, idx int) {
+			selected = idx
+		})
+	}
+
+	mod.SetBackgroundColor(tview.Styles.PrimitiveBackgroundColor).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			onEsc()
+			if buttonLabel != cancelButton {
+				return
+			}
+			ui.handler(event.CancelQueued(msgs[selected].ID))
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+
+	ui.pages.RemovePage(outboxPageName)
+	ui.pages.AddPage(outboxPageName, mod, true, false)
+	ui.pages.ShowPage(outboxPageName)
+	ui.pages.SendToFront(outboxPageName)
+	ui.app.SetFocus(ui.pages)
+}
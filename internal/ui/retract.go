@@ -0,0 +1,103 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/jid"
+)
+
+const retractPageName = "retract"
+
+// RetractableMessage is a message that can be picked in the retraction dialog.
+type RetractableMessage struct {
+	ID   string
+	Body string
+	Time time.Time
+}
+
+// loadRetractable asks for the messages that can be retracted from the selected
+// conversation.
+func (cv *ConversationView) loadRetractable() {
+	c, ok := cv.ui.activeUI().sidebar.conversations.GetSelected()
+	if !ok {
+		return
+	}
+	cv.ui.activeUI().handler(event.LoadRetractable{
+		JID:  c.JID.Bare(),
+		Room: c.Room,
+	})
+}
+
+// ShowRetract shows a dialog for picking one of msgs to retract from the
+// conversation with j.
+// Room is true if j is a channel.
+// If moderate is true, the messages belong to other occupants of the channel j
+// and a reason for removing them may be given.
+func (ui *UI) ShowRetract(j jid.JID, room, moderate bool, msgs []RetractableMessage) {
+	p := ui.Printer()
+	onEsc := func() {
+		ui.pages.HidePage(retractPageName)
+		ui.pages.RemovePage(retractPageName)
+	}
+
+	title := p.Sprintf("Retract a message sent to %s", j)
+	retractButton := p.Sprintf("Retract")
+	if moderate {
+		title = p.Sprintf("Moderate a message in %s", j)
+		retractButton = p.Sprintf("Remove")
+	}
+	cancelButton := p.Sprintf("Cancel")
+	mod := NewModal().SetText(title)
+	if len(msgs) == 0 {
+		mod.SetText(title + "\n\n" + p.Sprintf("No messages found."))
+		mod.AddButtons([]string{cancelButton})
+	} else {
+		mod.AddButtons([]string{cancelButton, retractButton})
+	}
+
+	var selected int
+	opts := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		body, _, _ := strings.Cut(msg.Body, "\n")
+		opts = append(opts, tview.Escape(msg.Time.Local().Format(time.DateTime)+" "+body))
+	}
+	modForm := mod.Form()
+	reasonInput := tview.NewInputField().SetLabel(p.Sprintf("Reason"))
+	if len(msgs) > 0 {
+		modForm.AddDropDown(p.Sprintf("Message"), opts, 0, func(_ string, idx int) {
+			selected = idx
+		})
+		if moderate {
+			modForm.AddFormItem(reasonInput)
+		}
+	}
+
+	mod.SetBackgroundColor(tview.Styles.PrimitiveBackgroundColor).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			onEsc()
+			if buttonLabel != retractButton {
+				return
+			}
+			ui.handler(event.Retract{
+				JID:      j,
+				ID:       msgs[selected].ID,
+				Room:     room,
+				Moderate: moderate,
+				Reason:   reasonInput.GetText(),
+			})
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+
+	ui.pages.AddPage(retractPageName, mod, true, false)
+	ui.pages.ShowPage(retractPageName)
+	ui.pages.SendToFront(retractPageName)
+	ui.app.SetFocus(ui.pages)
+}
@@ -0,0 +1,210 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+const (
+	searchPageName        = "search"
+	searchResultsPageName = "search_results"
+	searchDateLayout      = "2006-01-02"
+)
+
+// SearchResult is a message that matched a history search.
+type SearchResult struct {
+	// JID is the bare JID of the conversation the message belongs to.
+	JID   jid.JID
+	Room  bool
+	RowID int64
+	Time  time.Time
+	Sent  bool
+	Body  string
+}
+
+// dateInput returns a field that asks for a date and validates it.
+// An empty field is valid and leaves the date unset.
+func dateInput(label string, t *time.Time) *tview.InputField {
+	input := tview.NewInputField()
+	input.SetLabel(label)
+	input.SetPlaceholder(searchDateLayout)
+	input.SetChangedFunc(func(text string) {
+		if text == "" {
+			*t = time.Time{}
+			input.SetLabel(label)
+			return
+		}
+		parsed, err := time.ParseInLocation(searchDateLayout, text, time.Local)
+		if err != nil {
+			input.SetLabel("❌")
+			return
+		}
+		*t = parsed
+		input.SetLabel(label)
+	})
+	return input
+}
+
+// ShowSearch shows a form for searching the message history.
+// If a conversation is selected in the sidebar, the search is limited to it by
+// default.
+func (ui *UI) ShowSearch() {
+	p := ui.Printer()
+	var (
+		searchButton = p.Sprintf("Search")
+		cancelButton = p.Sprintf("Cancel")
+	)
+	onEsc := func() {
+		ui.pages.HidePage(searchPageName)
+		ui.pages.RemovePage(searchPageName)
+	}
+
+	autocomplete := make([]jid.JID, 0, len(ui.sidebar.conversations.items))
+	for _, item := range ui.sidebar.conversations.items {
+		autocomplete = append(autocomplete, item.JID.Bare())
+	}
+
+	var (
+		ev        event.SearchHistory
+		inputJID  jid.JID
+		direction int
+		typ       int
+	)
+	mod := NewModal().SetText(p.Sprintf("Search History"))
+	modForm := mod.Form()
+	textInput := tview.NewInputField().SetLabel(p.Sprintf("Text"))
+	modForm.AddFormItem(textInput)
+	addrInput := jidInput(p, &inputJID, true, autocomplete, nil)
+	addrInput.SetLabel(p.Sprintf("Address"))
+	if selected := ui.GetRosterJID(); !selected.Equal(jid.JID{}) {
+		addrInput.SetText(selected.Bare().String())
+	}
+	modForm.AddFormItem(addrInput)
+	modForm.AddDropDown(p.Sprintf("Direction"), []string{
+		p.Sprintf("Any"),
+		p.Sprintf("Sent"),
+		p.Sprintf("Received"),
+	}, 0, func(_ string, idx int) {
+		direction = idx
+	})
+	types := []stanza.MessageType{"", stanza.ChatMessage, stanza.GroupChatMessage, stanza.NormalMessage}
+	modForm.AddDropDown(p.Sprintf("Type"), []string{
+		p.Sprintf("Any"),
+		p.Sprintf("Chat"),
+		p.Sprintf("Channel"),
+		p.Sprintf("Normal"),
+	}, 0, func(_ string, idx int) {
+		typ = idx
+	})
+	modForm.AddFormItem(dateInput(p.Sprintf("From"), &ev.Start))
+	modForm.AddFormItem(dateInput(p.Sprintf("Until"), &ev.End))
+	modForm.AddCheckbox(p.Sprintf("Search server archive"), false, func(checked bool) {
+		ev.Archive = checked
+	})
+
+	mod.SetBackgroundColor(tview.Styles.PrimitiveBackgroundColor).
+		AddButtons([]string{cancelButton, searchButton}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			onEsc()
+			ev.Text = textInput.GetText()
+			if buttonLabel != searchButton || strings.TrimSpace(ev.Text) == "" {
+				return
+			}
+			if addrInput.GetText() != "" {
+				ev.With = inputJID.Bare()
+			}
+			ev.OnlySent = direction == 1
+			ev.OnlyReceived = direction == 2
+			ev.Type = types[typ]
+			if !ev.End.IsZero() {
+				// Include the entire last day.
+				ev.End = ev.End.AddDate(0, 0, 1).Add(-time.Second)
+			}
+			ui.handler(ev)
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+
+	ui.pages.AddPage(searchPageName, mod, true, false)
+	ui.pages.ShowPage(searchPageName)
+	ui.pages.SendToFront(searchPageName)
+	ui.app.SetFocus(ui.pages)
+}
+
+// ShowSearchResults shows the messages that matched a search in place of the
+// conversation.
+// Selecting a result opens the conversation that it belongs to and scrolls to
+// the message.
+func (ui *UI) ShowSearchResults(text string, results []SearchResult) {
+	p := ui.Printer()
+	list := tview.NewList()
+	list.SetBorder(true).
+		SetTitle(p.Sprintf("Search results for %q", text))
+	if len(results) == 0 {
+		list.AddItem(p.Sprintf("No messages found."), "", 0, nil)
+	}
+	for _, r := range results {
+		arrow := "←"
+		if r.Sent {
+			arrow = "→"
+		}
+		body, _, _ := strings.Cut(r.Body, "\n")
+		list.AddItem(
+			tview.Escape(body),
+			tview.Escape(r.Time.Local().Format(time.DateTime)+" "+arrow+" "+r.JID.String()),
+			0,
+			func() { ui.jumpToMessage(r) },
+		)
+	}
+	list.SetDoneFunc(func() {
+		ui.buffers.RemovePage(searchResultsPageName)
+		ui.SelectRoster()
+	})
+
+	ui.buffers.RemovePage(searchResultsPageName)
+	ui.buffers.AddAndSwitchToPage(searchResultsPageName, list, true)
+	ui.app.SetFocus(list)
+}
+
+// jumpToMessage opens the conversation that a search result belongs to.
+func (ui *UI) jumpToMessage(r SearchResult) {
+	c := Conversation{
+		JID:  r.JID,
+		Name: r.JID.Localpart(),
+		Room: r.Room,
+	}
+	if item, ok := ui.sidebar.bookmarks.GetItem(r.JID.String()); ok && item.Name != "" {
+		c.Name = item.Name
+	} else if item, ok := ui.sidebar.roster.GetItem(r.JID.String()); ok && item.Name != "" {
+		c.Name = item.Name
+	}
+	idx := ui.sidebar.conversations.Upsert(c, func(c Conversation) {
+		ui.buffers.SwitchToPage(chatPageName)
+		ui.chatsOpen.Set(true)
+		ui.handler(event.OpenChat{
+			JID:  c.JID,
+			Name: c.Name,
+		})
+		ui.app.SetFocus(ui.buffers)
+	})
+	ui.sidebar.conversations.list.SetCurrentItem(idx)
+	ui.sidebar.dropDown.SetCurrentOption(0)
+
+	ui.buffers.RemovePage(searchResultsPageName)
+	ui.buffers.SwitchToPage(chatPageName)
+	ui.chatsOpen.Set(true)
+	ui.handler(event.JumpToMessage{
+		JID:   r.JID,
+		RowID: r.RowID,
+	})
+	ui.app.SetFocus(ui.buffers)
+}
@@ -5,15 +5,16 @@
 package ui
 
 import (
-	"bytes"
-	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	"golang.org/x/text/message"
 
+	"mellium.im/communique/internal/ui/event"
 	"mellium.im/xmpp/jid"
 )
 
@@ -32,9 +33,10 @@ type Sidebar struct {
 	bookmarks     *Bookmarks
 	conversations *Conversations
 	ui            *UI
-	events        *bytes.Buffer
+	keys          keyState
 	eventsM       *sync.Mutex
 	statusButton  *tview.Button
+	countdown     *countdown
 	p             *message.Printer
 	statusSelect  func()
 }
@@ -46,9 +48,9 @@ func newSidebar(p *message.Printer, ui *UI, statusSelect func()) *Sidebar {
 		dropDown:     tview.NewDropDown().SetFieldBackgroundColor(tview.Styles.PrimitiveBackgroundColor),
 		search:       tview.NewInputField(),
 		ui:           ui,
-		events:       &bytes.Buffer{},
 		eventsM:      &sync.Mutex{},
 		statusButton: tview.NewButton("").SetSelectedFunc(statusSelect),
+		countdown:    &countdown{},
 		statusSelect: statusSelect,
 		p:            p,
 	}
@@ -76,7 +78,9 @@ func newSidebar(p *message.Printer, ui *UI, statusSelect func()) *Sidebar {
 			ui.statusBar.SetText(p.Sprintf("Status: %s", main))
 			return
 		}
-		main = strings.TrimPrefix(main, highlightTag)
+		if item, ok := r.conversations.GetItem(secondary); ok {
+			main = item.Name
+		}
 		ui.statusBar.SetText(p.Sprintf("Chat: %q (%s)", main, secondary))
 	})
 	r.pages.AddAndSwitchToPage(r.conversations.list.GetTitle(), r.conversations, true)
@@ -114,7 +118,7 @@ func newSidebar(p *message.Printer, ui *UI, statusSelect func()) *Sidebar {
 			}
 			r.eventsM.Lock()
 			defer r.eventsM.Unlock()
-			r.events.Reset()
+			r.keys.reset()
 			r.searching = false
 			r.search.SetText("")
 			r.Flex.RemoveItem(r.search)
@@ -131,110 +135,108 @@ func (s *Sidebar) InputHandler() func(event *tcell.EventKey, setFocus func(p tvi
 		if event == nil {
 			return
 		}
-		switch event.Key() {
-		case tcell.KeyESC:
-			s.events.Reset()
-			return
-		case tcell.KeyRune:
-		default:
-			return
-		}
 
 		s.eventsM.Lock()
 		defer s.eventsM.Unlock()
-		/* #nosec */
-		s.events.WriteRune(event.Rune())
+		if event.Key() == tcell.KeyESC {
+			s.keys.reset()
+			return
+		}
+		action, count, res := s.ui.keys.feed(keysSidebar, &s.keys, event)
+		switch res {
+		case keyPending:
+			return
+		case keyNone:
+			if event.Key() != tcell.KeyRune {
+				return
+			}
+			_, item := s.pages.GetFrontPage()
+			if item != nil {
+				item.InputHandler()(event, setFocus)
+			}
+			return
+		}
 
-		switch event.Rune() {
-		case '!':
-			s.events.Reset()
+		switch action {
+		case actionCommand:
 			s.ui.PickResource(func(j jid.JID, ok bool) {
 				if ok {
 					s.ui.ShowLoadCmd(j)
 				}
 			})
-			return
-		case 'i':
-			s.events.Reset()
+		case actionOpen:
 			_, item := s.pages.GetFrontPage()
 			if item != nil {
 				item.InputHandler()(tcell.NewEventKey(tcell.KeyCR, 0, tcell.ModNone), nil)
 			}
-			return
-		case 'I':
+		case actionInfo:
 			s.ui.ShowRosterInfo()
-			return
-		case 'o':
+		case actionNextUnread:
 			s.openNextUnread()
-		case 'O':
+		case actionPrevUnread:
 			s.openPrevUnread()
-		case 'k':
-			s.navigateUp()
-			return
-		case 'j':
-			s.navigateDown()
-			return
-		case 'G':
+		case actionUp:
+			s.navigateUp(count)
+		case actionDown:
+			s.navigateDown(count)
+		case actionBottom:
 			roster := s.getFrontList()
 			if roster == nil {
 				return
 			}
 			roster.SetCurrentItem(roster.GetItemCount() - 1)
-		case 'g':
+		case actionTop:
 			roster := s.getFrontList()
 			if roster == nil {
 				return
 			}
-			if s.events.String() != "gg" {
-				return
-			}
-			s.events.Reset()
 			roster.SetCurrentItem(0)
-		case 't':
-			if s.events.String() != "gt" {
-				return
-			}
+		case actionNextTab:
 			i, _ := s.dropDown.GetCurrentOption()
 			s.dropDown.SetCurrentOption((i + 1) % s.dropDown.GetOptionCount())
-		case 'T':
-			if s.events.String() != "gT" {
-				return
-			}
+		case actionPrevTab:
 			i, _ := s.dropDown.GetCurrentOption()
 			l := s.dropDown.GetOptionCount()
 			s.dropDown.SetCurrentOption(((i - 1) + l) % l)
-		case 'd':
-			if s.events.String() != "dd" {
-				return
-			}
+		case actionNextAccount:
+			s.ui.switchAccount(1)
+		case actionPrevAccount:
+			s.ui.switchAccount(-1)
+		case actionMergeAccounts:
+			s.ui.showMerged(true)
+		case actionDelete:
 			s.deleteItem()
-		case 's':
+		case actionStatus:
 			s.statusSelect()
-		case '1', '2', '3', '4', '5', '6', '7', '8', '9', '0':
-			// Don't reset events, after a number we may provide an action such as
-			// '10j'.
-			return
-		case '/':
+		case actionPin:
+			s.togglePin()
+		case actionSearchForward:
 			s.searching = true
 			s.searchDir = SearchDown
 			s.Flex.AddItem(s.search, 1, 0, true)
-		case '?':
+		case actionSearchBack:
 			s.searching = true
 			s.searchDir = SearchUp
 			s.Flex.AddItem(s.search, 1, 0, true)
-		case 'n':
+		case actionSearchNext:
 			if s.lastSearch != "" {
 				s.Search(s.lastSearch, s.searchDir)
 			}
-		case 'N':
+		case actionSearchPrev:
 			if s.lastSearch != "" {
 				s.Search(s.lastSearch, !s.searchDir)
 			}
-		case 'q':
+		case actionSearchHistory:
+			s.ui.ShowSearch()
+		case actionExport:
+			s.ui.ShowExport()
+		case actionOutbox:
+			s.ui.loadOutbox()
+		case actionQuit:
 			s.ui.ShowQuitPrompt()
-		case 'K':
+		case actionHelp:
 			s.ui.ShowHelpPrompt()
-		case 'c':
+		case actionStartChat:
 			name, _ := s.pages.GetFrontPage()
 			switch name {
 			case s.roster.list.GetTitle():
@@ -242,14 +244,17 @@ func (s *Sidebar) InputHandler() func(event *tcell.EventKey, setFocus func(p tvi
 			case s.bookmarks.list.GetTitle():
 				s.ui.ShowAddBookmark()
 			}
-		default:
-			_, item := s.pages.GetFrontPage()
-			if item != nil {
-				item.InputHandler()(event, setFocus)
+		case actionCreateChannel:
+			name, _ := s.pages.GetFrontPage()
+			if name == s.bookmarks.list.GetTitle() {
+				s.ui.ShowCreateChannel()
+			}
+		case actionEditBookmark:
+			name, _ := s.pages.GetFrontPage()
+			if name == s.bookmarks.list.GetTitle() {
+				s.ui.ShowEditBookmark()
 			}
 		}
-
-		s.events.Reset()
 	})
 }
 
@@ -269,71 +274,53 @@ func (s *Sidebar) deleteItem() {
 			break
 		}
 		i.Delete(c.JID.String())
+		s.ui.handler(event.CloseConversation(c.JID.Bare()))
 	}
 }
 
-func (s *Sidebar) navigateDown() {
-	roster := s.getFrontList()
-	if roster == nil {
+// togglePin pins the selected conversation to the top of the conversations list
+// or unpins it.
+func (s *Sidebar) togglePin() {
+	name, _ := s.pages.GetFrontPage()
+	if name != s.conversations.list.GetTitle() {
 		return
 	}
-	if s.events.Len() > 1 {
-		n, err := strconv.Atoi(s.events.String()[0 : s.events.Len()-1])
-		if err == nil {
-			n = roster.GetCurrentItem() + n
-			if m := roster.GetItemCount() - 1; n > m {
-				n = m
-			}
-			if n < 0 {
-				n = 0
-			}
-			roster.SetCurrentItem(n)
-
-			s.events.Reset()
-			return
-		}
-	}
-
-	s.events.Reset()
-	cur := roster.GetCurrentItem()
-	if cur >= roster.GetItemCount()-1 {
+	c, ok := s.conversations.GetSelected()
+	if !ok {
 		return
 	}
-	roster.SetCurrentItem(cur + 1)
+	s.conversations.Pin(c.JID.String(), !c.Pinned)
+	s.ui.handler(event.PinConversation{
+		JID:    c.JID.Bare(),
+		Pinned: !c.Pinned,
+	})
 }
 
-func (s *Sidebar) navigateUp() {
+// navigateDown moves the selection down n items, or one if n is zero.
+func (s *Sidebar) navigateDown(n int) {
 	roster := s.getFrontList()
 	if roster == nil {
 		return
 	}
-	if s.events.Len() > 1 {
-		n, err := strconv.Atoi(s.events.String()[0 : s.events.Len()-1])
-		if err == nil {
-			n = roster.GetCurrentItem() - n
-			if m := roster.GetItemCount() - 1; n > m {
-				n = m
-			}
-			if n < 0 {
-				n = 0
-			}
-			roster.SetCurrentItem(n)
-
-			s.events.Reset()
-			return
-		}
+	if n == 0 {
+		n = 1
 	}
+	roster.SetCurrentItem(min(roster.GetCurrentItem()+n, roster.GetItemCount()-1))
+}
 
-	s.events.Reset()
-	cur := roster.GetCurrentItem()
-	if cur <= 0 {
+// navigateUp moves the selection up n items, or one if n is zero.
+func (s *Sidebar) navigateUp(n int) {
+	roster := s.getFrontList()
+	if roster == nil {
 		return
 	}
-	roster.SetCurrentItem(cur - 1)
+	if n == 0 {
+		n = 1
+	}
+	roster.SetCurrentItem(max(roster.GetCurrentItem()-n, 0))
 }
 
 func (s *Sidebar) openPrevUnread() {
-	s.events.Reset()
 	roster := s.getFrontList()
 	if roster == nil {
 		return
@@ -361,7 +348,6 @@ func (s *Sidebar) openPrevUnread() {
 }
 
 func (s *Sidebar) openNextUnread() {
-	s.events.Reset()
 	roster := s.getFrontList()
 	if roster == nil {
 		return
@@ -400,6 +386,17 @@ func (s *Sidebar) MarkUnread(j, msgID string) bool {
 	return r || c
 }
 
+// SetUnread sets the number of unread messages for the given jid and the first
+// message seen after the unread marker in whatever views it appears in.
+func (s *Sidebar) SetUnread(j, msgID string, n int) bool {
+	if n == 0 {
+		s.roster.MarkRead(j)
+	} else {
+		s.roster.MarkUnread(j, msgID)
+	}
+	return s.conversations.SetUnread(j, msgID, n)
+}
+
 // Search looks forward in the roster trying to find items that match s.
 // It is case insensitive and looks in the primary or secondary texts.
 // If a match is found after the current selection, we jump to the match,
@@ -503,6 +500,33 @@ func (s Sidebar) Busy() {
 	s.setStatus("red", s.p.Sprintf("Busy"))
 }
 
+// Reconnecting sets the state of the roster to show that the connection was
+// lost and counts down until the next attempt to reconnect in d.
+func (s Sidebar) Reconnecting(d time.Duration) {
+	deadline := time.Now().Add(d)
+	update := func() bool {
+		left := max(time.Until(deadline).Round(time.Second), 0)
+		s.drawStatus("yellow", s.p.Sprintf("reconnecting in %ds", int(left/time.Second)))
+		return left > 0
+	}
+	stop := s.countdown.start(update)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			if !s.countdown.tick(stop, update) {
+				return
+			}
+			s.ui.redraw()
+		}
+	}()
+}
+
 // UpsertPresence updates an existing roster item or bookmark with a newly seen
 // resource or presence change.
 // If the item is not in any roster, false is returned.
@@ -513,12 +537,67 @@ func (s Sidebar) UpsertPresence(j jid.JID, status string) bool {
 }
 
 func (s Sidebar) setStatus(color, name string) {
+	s.countdown.stop(func() {
+		s.drawStatus(color, "")
+	})
+}
+
+// drawStatus draws a line in the status color, optionally with a label in the
+// middle.
+func (s Sidebar) drawStatus(color, label string) {
 	var width int
 	if s.Width > 4 {
 		width = s.Width - 4
 	}
+	if label != "" {
+		label = " " + label + " "
+	}
+	side := max(width-utf8.RuneCountInString(label), 0)
 	s.statusButton.SetStyle(tcell.StyleDefault.
 		Background(tcell.ColorDefault).
 		Foreground(tcell.GetColor(color)))
-	s.statusButton.SetLabel(strings.Repeat("─", width))
+	s.statusButton.SetLabel(strings.Repeat("─", side/2) + label + strings.Repeat("─", side-side/2))
+}
+
+// countdown tracks the timer shown in the status line while waiting to
+// reconnect.
+type countdown struct {
+	m    sync.Mutex
+	done chan struct{}
+}
+
+// start stops any running countdown, calls f, and returns a channel that is
+// closed when the new countdown is stopped.
+func (c *countdown) start(f func() bool) <-chan struct{} {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.done != nil {
+		close(c.done)
+	}
+	c.done = make(chan struct{})
+	f()
+	return c.done
+}
+
+// tick calls f if the countdown identified by done is still running.
+func (c *countdown) tick(done <-chan struct{}, f func() bool) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	select {
+	case <-done:
+		return false
+	default:
+	}
+	return f()
+}
+
+// stop stops any running countdown and then calls f.
+func (c *countdown) stop(f func()) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.done != nil {
+		close(c.done)
+		c.done = nil
+	}
+	f()
 }
@@ -0,0 +1,370 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/rivo/tview"
+	"golang.org/x/text/message"
+
+	"mellium.im/communique/internal/ui/event"
+	"mellium.im/xmpp/bookmarks"
+	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/stanza"
+)
+
+const slashHelpPageName = "slash_help"
+
+// slashCommand is an IRC style command that can be typed into the message input
+// of a conversation, for example "/nick newnick".
+type slashCommand struct {
+	name  string
+	usage string
+	help  string
+	// room is true if the command only makes sense in channels.
+	room bool
+	// complete returns the possible values of the first argument, if any.
+	complete func(cv *ConversationView, c Conversation) []string
+	run      func(cv *ConversationView, c Conversation, args string) error
+}
+
+// slashCommands returns every command that can be typed into the message input
+// sorted by name.
+// New commands only need to be added here to show up in completion and in the
+// output of /help.
+func slashCommands(p *message.Printer) []slashCommand {
+	return []slashCommand{{
+		name: "clear",
+		help: p.Sprintf("clear the conversation window"),
+		run: func(cv *ConversationView, _ Conversation, _ string) error {
+			cv.TextView.Clear()
+			return nil
+		},
+	}, {
+		name: "help",
+		help: p.Sprintf("show this list of commands"),
+		run: func(cv *ConversationView, _ Conversation, _ string) error {
+			cv.ui.showSlashHelp()
+			return nil
+		},
+	}, {
+		name:     "invite",
+		usage:    p.Sprintf("<address> [reason]"),
+		help:     p.Sprintf("invite someone to the channel"),
+		room:     true,
+		complete: completeRoster,
+		run: func(cv *ConversationView, c Conversation, args string) error {
+			addr, reason := splitArg(args)
+			j, err := jid.Parse(addr)
+			if err != nil {
+				return err
+			}
+			cv.ui.activeUI().handler(event.SendInvite{
+				Room:   c.JID.Bare(),
+				JID:    j.Bare(),
+				Reason: reason,
+			})
+			return nil
+		},
+	}, {
+		name:     "join",
+		usage:    p.Sprintf("<address>"),
+		help:     p.Sprintf("join a channel"),
+		complete: completeBookmarks,
+		run: func(cv *ConversationView, _ Conversation, args string) error {
+			j, err := jid.Parse(args)
+			if err != nil {
+				return err
+			}
+			cv.ui.activeUI().JoinChannel(bookmarks.Channel{JID: j.Bare()})
+			return nil
+		},
+	}, {
+		name:  "me",
+		usage: p.Sprintf("<action>"),
+		help:  p.Sprintf("send an action, for example \"/me waves\""),
+		run: func(cv *ConversationView, c Conversation, args string) error {
+			// Actions are sent with the command in place (XEP-0245).
+			cv.sendBody(c, "/me "+args)
+			return nil
+		},
+	}, {
+		name:     "msg",
+		usage:    p.Sprintf("<address or nickname> <message>"),
+		help:     p.Sprintf("send a private message"),
+		complete: completeRecipient,
+		run: func(cv *ConversationView, c Conversation, args string) error {
+			to, body := splitArg(args)
+			if body == "" {
+				return errors.New(p.Sprintf("no message given"))
+			}
+			var j jid.JID
+			var err error
+			if c.Room && !strings.Contains(to, "@") {
+				j, err = c.JID.Bare().WithResource(to)
+			} else {
+				j, err = jid.Parse(to)
+			}
+			if err != nil {
+				return err
+			}
+			cv.ui.activeUI().handler(event.ChatMessage{
+				Message: stanza.Message{
+					To:   j,
+					Type: stanza.ChatMessage,
+				},
+				Body: body,
+			})
+			return nil
+		},
+	}, {
+		name:  "nick",
+		usage: p.Sprintf("<nickname>"),
+		help:  p.Sprintf("change your nickname in the channel"),
+		room:  true,
+		run: func(cv *ConversationView, c Conversation, args string) error {
+			cv.ui.activeUI().handler(event.SetNick{
+				Room: c.JID.Bare(),
+				Nick: args,
+			})
+			return nil
+		},
+	}, {
+		name:  "part",
+		usage: p.Sprintf("[reason]"),
+		help:  p.Sprintf("leave the channel"),
+		room:  true,
+		run: func(cv *ConversationView, c Conversation, args string) error {
+			ui := cv.ui.activeUI()
+			ui.handler(event.LeaveChannel{
+				Room:   c.JID.Bare(),
+				Reason: args,
+			})
+			ui.sidebar.conversations.Delete(c.JID.String())
+			ui.handler(event.CloseConversation(c.JID.Bare()))
+			cv.leave()
+			ui.SelectRoster()
+			return nil
+		},
+	}, {
+		name:  "status",
+		usage: "online|away|busy|offline",
+		help:  p.Sprintf("change your status"),
+		complete: func(*ConversationView, Conversation) []string {
+			return []string{"online", "away", "busy", "offline"}
+		},
+		run: func(cv *ConversationView, _ Conversation, args string) error {
+			handler := cv.ui.activeUI().handler
+			switch args {
+			case "online":
+				handler(event.StatusOnline{})
+			case "away":
+				handler(event.StatusAway{})
+			case "busy":
+				handler(event.StatusBusy{})
+			case "offline":
+				handler(event.StatusOffline{})
+			default:
+				return errors.New(p.Sprintf("unknown status %q", args))
+			}
+			return nil
+		},
+	}, {
+		name:  "topic",
+		usage: p.Sprintf("[subject]"),
+		help:  p.Sprintf("show or change the subject of the channel"),
+		room:  true,
+		run: func(cv *ConversationView, c Conversation, args string) error {
+			if args == "" {
+				cv.setHint(cv.ui.activeUI().subject(c.JID))
+				return nil
+			}
+			cv.ui.activeUI().handler(event.SetSubject{
+				Room:    c.JID.Bare(),
+				Subject: args,
+			})
+			return nil
+		},
+	}}
+}
+
+// isSlashCommand reports whether text should be run as a command instead of
+// being sent as a message.
+// Messages that really do start with a slash can be sent by doubling it.
+func isSlashCommand(text string) bool {
+	return strings.HasPrefix(text, "/") && !strings.HasPrefix(text, "//")
+}
+
+// splitArg splits the first word off of args.
+func splitArg(args string) (arg, rest string) {
+	arg, rest, _ = strings.Cut(strings.TrimSpace(args), " ")
+	return arg, strings.TrimSpace(rest)
+}
+
+// runSlashCommand parses and runs a command typed into the message input of the
+// conversation c.
+func (cv *ConversationView) runSlashCommand(c Conversation, text string) error {
+	p := cv.ui.Printer()
+	name, args := splitArg(strings.TrimPrefix(text, "/"))
+	for _, cmd := range slashCommands(p) {
+		if cmd.name != name {
+			continue
+		}
+		if cmd.room && !c.Room {
+			return errors.New(p.Sprintf("/%s only works in channels", name))
+		}
+		if args == "" && strings.HasPrefix(cmd.usage, "<") {
+			return errors.New(p.Sprintf("usage: /%s %s", name, cmd.usage))
+		}
+		return cmd.run(cv, c, args)
+	}
+	return errors.New(p.Sprintf("unknown command /%s, see /help", name))
+}
+
+// completeSlashCommand completes the command name or its first argument in the
+// message input.
+// If there is more than one candidate the longest common prefix is filled in
+// and the candidates are shown above the input.
+func (cv *ConversationView) completeSlashCommand() {
+	text := cv.inputField.GetText()
+	if !isSlashCommand(text) {
+		return
+	}
+	c, _ := cv.ui.activeUI().sidebar.conversations.GetSelected()
+	cmds := slashCommands(cv.ui.Printer())
+
+	var prefix, word string
+	var candidates []string
+	name, arg, hasArg := strings.Cut(strings.TrimPrefix(text, "/"), " ")
+	if !hasArg {
+		prefix, word = "/", name
+		for _, cmd := range cmds {
+			if !cmd.room || c.Room {
+				candidates = append(candidates, cmd.name)
+			}
+		}
+	} else {
+		if strings.Contains(arg, " ") {
+			return
+		}
+		prefix, word = "/"+name+" ", arg
+		for _, cmd := range cmds {
+			if cmd.name == name && cmd.complete != nil {
+				candidates = cmd.complete(cv, c)
+			}
+		}
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			matches = append(matches, candidate)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return
+	case 1:
+		cv.inputField.SetText(prefix + matches[0] + " ")
+		return
+	}
+	sort.Strings(matches)
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	cv.inputField.SetText(prefix + common)
+	cv.setHint(strings.Join(matches, " "))
+}
+
+// setHint shows a short message, such as completion candidates or an error,
+// above the message input until the input changes.
+func (cv *ConversationView) setHint(hint string) {
+	if hint == cv.inputField.GetTitle() {
+		return
+	}
+	cv.inputField.SetTitle(tview.Escape(hint))
+}
+
+// completeRoster returns the addresses of our contacts.
+func completeRoster(cv *ConversationView, _ Conversation) []string {
+	r := cv.ui.activeUI().sidebar.roster
+	r.itemLock.Lock()
+	defer r.itemLock.Unlock()
+	addrs := make([]string, 0, len(r.items))
+	for _, item := range r.items {
+		addrs = append(addrs, item.JID.Bare().String())
+	}
+	return addrs
+}
+
+// completeBookmarks returns the addresses of our bookmarked channels.
+func completeBookmarks(cv *ConversationView, _ Conversation) []string {
+	b := cv.ui.activeUI().sidebar.bookmarks
+	b.itemLock.Lock()
+	defer b.itemLock.Unlock()
+	addrs := make([]string, 0, len(b.items))
+	for _, item := range b.items {
+		addrs = append(addrs, item.JID.Bare().String())
+	}
+	return addrs
+}
+
+// completeRecipient returns the nicknames of everyone in the channel c, or our
+// contacts if c is not a channel.
+func completeRecipient(cv *ConversationView, c Conversation) []string {
+	if !c.Room {
+		return completeRoster(cv, c)
+	}
+	ui := cv.ui.activeUI()
+	ui.channels.Lock()
+	defer ui.channels.Unlock()
+	occupants := ui.channels.occupants[c.JID.Bare().String()]
+	nicks := make([]string, 0, len(occupants))
+	for _, occupant := range occupants {
+		nicks = append(nicks, occupant.Nick)
+	}
+	return nicks
+}
+
+// showSlashHelp lists the commands that can be typed into the message input.
+func (ui *UI) showSlashHelp() {
+	p := ui.Printer()
+	onEsc := func() {
+		ui.pages.HidePage(slashHelpPageName)
+		ui.pages.RemovePage(slashHelpPageName)
+	}
+	var buf strings.Builder
+	buf.WriteString("[::b]")
+	buf.WriteString(p.Sprintf("Commands"))
+	buf.WriteString("[::-]\n")
+	for _, cmd := range slashCommands(p) {
+		buf.WriteString("\n/")
+		buf.WriteString(cmd.name)
+		if cmd.usage != "" {
+			buf.WriteString(" ")
+			buf.WriteString(tview.Escape(cmd.usage))
+		}
+		buf.WriteString(": ")
+		buf.WriteString(cmd.help)
+	}
+	buf.WriteString("\n\n")
+	buf.WriteString(p.Sprintf("Use Tab to complete commands. Start a message with // to send it with a single leading /."))
+	mod := NewModal().
+		SetText(buf.String()).
+		SetDoneFunc(func(int, string) {
+			onEsc()
+		})
+	mod.SetInputCapture(modalClose(onEsc))
+	ui.pages.AddPage(slashHelpPageName, mod, true, false)
+	ui.pages.ShowPage(slashHelpPageName)
+	ui.pages.SendToFront(slashHelpPageName)
+	ui.app.SetFocus(ui.pages)
+}
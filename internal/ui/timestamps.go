@@ -0,0 +1,106 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/rivo/tview"
+	"golang.org/x/text/message"
+)
+
+// DefaultTimeFormat is the layout used to show the time of messages if no other
+// layout is configured.
+const DefaultTimeFormat = "15:04"
+
+// TimeFormat returns an option that sets the layout (see the time package) used
+// to show the time at which messages were sent in the chat history.
+// If layout is empty DefaultTimeFormat is used.
+func TimeFormat(layout string) Option {
+	return func(ui *UI) {
+		if layout == "" {
+			layout = DefaultTimeFormat
+		}
+		ui.timeFormat = layout
+	}
+}
+
+// Timestamp returns t formatted in the local time zone to be written to the
+// chat history.
+// If t is on a different day than the last timestamp that was written to the
+// history, the result is prefixed with a line separating the two days.
+// Because of this, the result must always be written to the history.
+func (ui *UI) Timestamp(t time.Time) string {
+	t = t.Local()
+	h := ui.history
+	h.dayM.Lock()
+	defer h.dayM.Unlock()
+
+	stamp := tview.Escape(t.Format(ui.timeFormat))
+	y, m, d := t.Date()
+	lastY, lastM, lastD := h.lastDay.Date()
+	if !h.lastDay.IsZero() && y == lastY && m == lastM && d == lastD {
+		return stamp
+	}
+	h.lastDay = t
+	return "[::d]── " + tview.Escape(dayName(ui.p, t)) + " ──[::-]\n" + stamp
+}
+
+// dayName returns the date of t for the line separating days in the history.
+// The year is only included if it is not the current year.
+func dayName(p *message.Printer, t time.Time) string {
+	// Numbers are formatted by strconv so that the printer does not add digit
+	// grouping to the year.
+	weekday := weekdayName(p, t.Weekday())
+	day := strconv.Itoa(t.Day())
+	month := monthName(p, t.Month())
+	if t.Year() != time.Now().Year() {
+		year := strconv.Itoa(t.Year())
+		return p.Sprintf("%s %s %s %s", weekday, day, month, year)
+	}
+	return p.Sprintf("%s %s %s", weekday, day, month)
+}
+
+// weekdayName returns the translated name of d.
+// The time package only knows the English names.
+func weekdayName(p *message.Printer, d time.Weekday) string {
+	return [...]string{
+		time.Sunday:    p.Sprintf("Sunday"),
+		time.Monday:    p.Sprintf("Monday"),
+		time.Tuesday:   p.Sprintf("Tuesday"),
+		time.Wednesday: p.Sprintf("Wednesday"),
+		time.Thursday:  p.Sprintf("Thursday"),
+		time.Friday:    p.Sprintf("Friday"),
+		time.Saturday:  p.Sprintf("Saturday"),
+	}[d]
+}
+
+// monthName returns the translated name of m.
+func monthName(p *message.Printer, m time.Month) string {
+	return [...]string{
+		time.January:   p.Sprintf("January"),
+		time.February:  p.Sprintf("February"),
+		time.March:     p.Sprintf("March"),
+		time.April:     p.Sprintf("April"),
+		time.May:       p.Sprintf("May"),
+		time.June:      p.Sprintf("June"),
+		time.July:      p.Sprintf("July"),
+		time.August:    p.Sprintf("August"),
+		time.September: p.Sprintf("September"),
+		time.October:   p.Sprintf("October"),
+		time.November:  p.Sprintf("November"),
+		time.December:  p.Sprintf("December"),
+	}[m]
+}
+
+// ClearHistory removes everything from the chat history.
+func (ui *UI) ClearHistory() {
+	h := ui.history
+	h.dayM.Lock()
+	h.lastDay = time.Time{}
+	h.dayM.Unlock()
+	h.TextView.SetText("")
+}
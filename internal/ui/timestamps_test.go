@@ -0,0 +1,58 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package ui
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+func germanPrinter(t *testing.T) *message.Printer {
+	t.Helper()
+	b := catalog.NewBuilder(catalog.Fallback(language.English))
+	for key, msg := range map[string]string{
+		"%s %s %s":    "%[1]s, %[2]s. %[3]s",
+		"%s %s %s %s": "%[1]s, %[2]s. %[3]s %[4]s",
+		"Saturday":    "Samstag",
+		"Tuesday":     "Dienstag",
+		"March":       "März",
+		"December":    "Dezember",
+	} {
+		err := b.SetString(language.German, key, msg)
+		if err != nil {
+			t.Fatalf("error building catalog: %v", err)
+		}
+	}
+	return message.NewPrinter(language.German, message.Catalog(b))
+}
+
+func TestDayName(t *testing.T) {
+	thisYear := time.Date(time.Now().Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	en := message.NewPrinter(language.English)
+	de := germanPrinter(t)
+	for i, tc := range [...]struct {
+		p    *message.Printer
+		t    time.Time
+		want string
+	}{
+		0: {p: en, t: time.Date(2024, time.December, 31, 23, 59, 0, 0, time.UTC), want: "Tuesday 31 December 2024"},
+		1: {p: en, t: time.Date(1999, time.March, 6, 0, 0, 0, 0, time.UTC), want: "Saturday 6 March 1999"},
+		2: {p: de, t: time.Date(2024, time.December, 31, 23, 59, 0, 0, time.UTC), want: "Dienstag, 31. Dezember 2024"},
+		3: {p: de, t: time.Date(1999, time.March, 6, 0, 0, 0, 0, time.UTC), want: "Samstag, 6. März 1999"},
+		// The current year is left out.
+		4: {p: en, t: thisYear, want: thisYear.Weekday().String() + " 1 January"},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if got := dayName(tc.p, tc.t); got != tc.want {
+				t.Errorf("wrong day: want=%q, got=%q", tc.want, got)
+			}
+		})
+	}
+}
@@ -18,12 +18,14 @@ import (
 	"sync"
 	"text/tabwriter"
 	"text/template"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	"golang.org/x/text/message"
 
 	"mellium.im/communique/internal/localerr"
+	"mellium.im/communique/internal/omemo"
 	"mellium.im/communique/internal/ui/event"
 	"mellium.im/xmpp/bookmarks"
 	"mellium.im/xmpp/commands"
@@ -69,20 +71,32 @@ func (b *syncBool) Get() bool {
 }
 
 // UI is a widget that combines other widgets to make the main UI.
+// Each account has its own UI with its own sidebar, but all accounts share the
+// same screen (see Account).
 type UI struct {
+	*screen
+	sidebar    *Sidebar
+	channels   *channels
+	handler    func(interface{})
+	addr       string
+	status     string
+	passPrompt chan string
+}
+
+// screen contains the widgets and settings shared by every account.
+type screen struct {
 	app          *tview.Application
 	flex         *tview.Flex
+	bottom       *tview.Flex
 	pages        *tview.Pages
 	buffers      *tview.Pages
+	sidebars     *tview.Pages
 	history      *ConversationView
 	statusBar    *tview.TextView
-	sidebar      *Sidebar
+	accountBar   *tview.TextView
 	sidebarWidth int
 	logWriter    *tview.TextView
-	handler      func(interface{})
 	redraw       func() *tview.Application
-	addr         string
-	passPrompt   chan string
 	chatsOpen    *syncBool
 	cmdPane      *commandsPane
 	debug        *log.Logger
@@ -90,6 +104,15 @@ type UI struct {
 	p            *message.Printer
 	filePicker   []string
 	notify       []string
+	keys         *keymap
+	keyState     keyState
+	timeFormat   string
+	statusSelect func()
+	accountsM    sync.Mutex
+	accounts     []*UI
+	active       int
+	merged       bool
+	mergedView   *Merged
 }
 
 // Printer returns the message printer that the UI is using for translations.
@@ -241,30 +264,47 @@ func New(p *message.Printer, logger *log.Logger, opts ...Option) *UI {
 		SetBackgroundColor(tview.Styles.MoreContrastBackgroundColor).
 		SetBorder(false).
 		SetBorderPadding(0, 0, 2, 0)
+	accountBar := tview.NewTextView()
+	accountBar.
+		SetDynamicColors(true).
+		SetTextAlign(tview.AlignRight).
+		SetTextColor(tview.Styles.PrimaryTextColor).
+		SetBackgroundColor(tview.Styles.MoreContrastBackgroundColor).
+		SetBorder(false).
+		SetBorderPadding(0, 0, 0, 2)
 	buffers := tview.NewPages()
 	pages := tview.NewPages()
 
 	ui := &UI{
-		app:          app,
-		sidebarWidth: 25,
-		statusBar:    statusBar,
-		handler:      func(interface{}) {},
-		redraw:       app.Draw,
-		buffers:      buffers,
-		pages:        pages,
-		passPrompt:   make(chan string),
-		chatsOpen:    &syncBool{},
-		debug:        log.New(io.Discard, "", 0),
-		logger:       logger,
-		p:            p,
-	}
-	statusSelect := func() {
-		pages.ShowPage(setStatusPageName)
-		pages.SendToFront(setStatusPageName)
-		app.SetFocus(pages)
+		screen: &screen{
+			app:          app,
+			sidebarWidth: 25,
+			statusBar:    statusBar,
+			accountBar:   accountBar,
+			redraw:       app.Draw,
+			buffers:      buffers,
+			sidebars:     tview.NewPages(),
+			pages:        pages,
+			chatsOpen:    &syncBool{},
+			keys:         defaultKeymap(),
+			timeFormat:   DefaultTimeFormat,
+			debug:        log.New(io.Discard, "", 0),
+			logger:       logger,
+			p:            p,
+			statusSelect: func() {
+				pages.ShowPage(setStatusPageName)
+				pages.SendToFront(setStatusPageName)
+				app.SetFocus(pages)
+			},
+		},
+		channels:   newChannels(),
+		handler:    func(interface{}) {},
+		passPrompt: make(chan string),
 	}
-	sidebarBox := newSidebar(p, ui, statusSelect)
-	ui.sidebar = sidebarBox
+	ui.mergedView = newMerged(p, ui.screen)
+	ui.sidebars.AddPage(mergedPageName, ui.mergedView, true, false)
+	ui.sidebar = newSidebar(p, ui, ui.statusSelect)
+	ui.sidebar.conversations.updated = ui.mergedView.refresh
 	ui.cmdPane = cmdPane()
 	for _, o := range opts {
 		o(ui)
@@ -281,30 +321,29 @@ func New(p *message.Printer, logger *log.Logger, opts ...Option) *UI {
 	ui.logWriter = logs
 
 	setStatusPage := statusModal(p, func(buttonIndex int, buttonLabel string) {
+		handler := ui.activeUI().handler
 		switch buttonIndex {
 		case 0:
-			ui.handler(event.StatusOnline{})
+			handler(event.StatusOnline{})
 		case 1:
-			ui.handler(event.StatusAway{})
+			handler(event.StatusAway{})
 		case 2:
-			ui.handler(event.StatusBusy{})
+			handler(event.StatusBusy{})
 		case 3:
-			ui.handler(event.StatusOffline{})
+			handler(event.StatusOffline{})
 		}
 		ui.pages.HidePage(setStatusPageName)
 	})
 
-	getPasswordPage := passwordModal(p, ui.addr, func(getPasswordPage *tview.Form) {
-		ui.passPrompt <- getPasswordPage.GetFormItem(0).(*tview.InputField).GetText()
-		ui.pages.HidePage(getPasswordPageName)
-	})
-
 	ltrFlex := tview.NewFlex().
-		AddItem(sidebarBox, ui.sidebarWidth, 1, true).
+		AddItem(ui.sidebars, ui.sidebarWidth, 1, true).
 		AddItem(buffers, 0, 1, false)
+	ui.bottom = tview.NewFlex().
+		AddItem(statusBar, 0, 1, false).
+		AddItem(accountBar, 0, 0, false)
 	ui.flex = tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(ltrFlex, 0, 1, true).
-		AddItem(statusBar, 1, 1, false)
+		AddItem(ui.bottom, 1, 1, false)
 
 	ui.pages.AddPage(setStatusPageName, setStatusPage, true, false)
 	ui.pages.AddPage(uiPageName, ui.flex, true, true)
@@ -312,10 +351,11 @@ func New(p *message.Printer, logger *log.Logger, opts ...Option) *UI {
 	ui.pages.AddPage(delRosterPageName, delRosterModal(p, func() {
 		ui.pages.HidePage(delRosterPageName)
 	}, func() {
-		cur := ui.sidebar.roster.list.GetCurrentItem()
-		for _, item := range ui.sidebar.roster.items {
+		active := ui.activeUI()
+		cur := active.sidebar.roster.list.GetCurrentItem()
+		for _, item := range active.sidebar.roster.items {
 			if item.idx == cur {
-				ui.handler(event.DeleteRosterItem(item.Item))
+				active.handler(event.DeleteRosterItem(item.Item))
 				break
 			}
 		}
@@ -323,17 +363,18 @@ func New(p *message.Printer, logger *log.Logger, opts ...Option) *UI {
 	ui.pages.AddPage(delBookmarkPageName, delBookmarkModal(p, func() {
 		ui.pages.HidePage(delBookmarkPageName)
 	}, func() {
-		cur := ui.sidebar.bookmarks.list.GetCurrentItem()
-		for _, item := range ui.sidebar.bookmarks.items {
+		active := ui.activeUI()
+		cur := active.sidebar.bookmarks.list.GetCurrentItem()
+		for _, item := range active.sidebar.bookmarks.items {
 			if item.idx == cur {
-				ui.handler(event.DeleteBookmark(item.Channel))
-				ui.sidebar.bookmarks.Delete(item.Channel.JID.Bare().String())
+				active.handler(event.DeleteBookmark(item.Channel))
+				active.sidebar.bookmarks.Delete(item.Channel.JID.Bare().String())
 				break
 			}
 		}
 	}), true, false)
 
-	ui.pages.AddPage(getPasswordPageName, getPasswordPage, true, false)
+	ui.addAccount()
 
 	return ui
 }
@@ -350,6 +391,12 @@ func (ui *UI) MarkUnread(j, msgID string) bool {
 	return ui.sidebar.MarkUnread(j, msgID)
 }
 
+// SetUnread sets the number of unread messages for the given jid and the first
+// message seen after the unread marker.
+func (ui *UI) SetUnread(j, msgID string, n int) bool {
+	return ui.sidebar.SetUnread(j, msgID, n)
+}
+
 // RosterLen returns the length of the currently visible roster.
 func (ui *UI) RosterLen() int {
 	roster := ui.sidebar.getFrontList()
@@ -399,33 +446,55 @@ func (ui *UI) UpdateConversations(c Conversation) {
 // UpdateBookmarks adds an item to the bookmarks sidebar.
 func (ui *UI) UpdateBookmarks(item bookmarks.Channel) {
 	ui.handler(event.UpdateBookmark(item))
+	bare := item.JID.Bare().String()
 	ui.sidebar.bookmarks.Upsert(item, func() {
-		selected := func(c Conversation) {
-			ui.buffers.SwitchToPage(chatPageName)
-			ui.chatsOpen.Set(true)
-			ui.handler(event.OpenChat(roster.Item{
-				JID:  item.JID,
-				Name: item.Name,
-			}))
-			ui.app.SetFocus(ui.buffers)
+		// Look the bookmark up again in case it was edited after it was added.
+		if cur, ok := ui.sidebar.bookmarks.GetItem(bare); ok {
+			item = cur.Channel
 		}
-		c := Conversation{
-			JID:  item.JID,
-			Name: item.Name,
-			Room: true,
-		}
-		idx := ui.sidebar.conversations.Upsert(c, selected)
-		ui.sidebar.conversations.list.SetCurrentItem(idx)
-		ui.sidebar.dropDown.SetCurrentOption(0)
-		selected(c)
-		ui.app.SetFocus(ui.buffers)
-		ui.handler(event.OpenChannel(item))
+		ui.JoinChannel(item)
+	})
+	ui.redraw()
+}
+
+// Autojoin joins a channel and adds it to the conversations list without
+// switching to it.
+func (ui *UI) Autojoin(item bookmarks.Channel) {
+	ui.UpdateConversations(Conversation{
+		JID:  item.JID,
+		Name: item.Name,
+		Room: true,
+	})
+	ui.handler(event.OpenChannel(item))
+}
+
+// JoinChannel joins a channel and opens it in the conversations list whether
+// or not it is bookmarked.
+func (ui *UI) JoinChannel(item bookmarks.Channel) {
+	selected := func(c Conversation) {
+		ui.buffers.SwitchToPage(chatPageName)
+		ui.chatsOpen.Set(true)
 		ui.handler(event.OpenChat(roster.Item{
 			JID:  item.JID,
 			Name: item.Name,
 		}))
-	})
-	ui.redraw()
+		ui.app.SetFocus(ui.buffers)
+	}
+	c := Conversation{
+		JID:  item.JID,
+		Name: item.Name,
+		Room: true,
+	}
+	idx := ui.sidebar.conversations.Upsert(c, selected)
+	ui.sidebar.conversations.list.SetCurrentItem(idx)
+	ui.sidebar.dropDown.SetCurrentOption(0)
+	selected(c)
+	ui.app.SetFocus(ui.buffers)
+	ui.handler(event.OpenChannel(item))
+	ui.handler(event.OpenChat(roster.Item{
+		JID:  item.JID,
+		Name: item.Name,
+	}))
 }
 
 // Write writes to the logging text view.
@@ -448,24 +517,34 @@ func (ui *UI) Conversations() *Conversations {
 	return ui.sidebar.conversations
 }
 
-// ChatsOpen returns true if the chat pane is open.
+// ChatsOpen returns true if the chat pane is open and showing a chat for this
+// account.
 func (ui *UI) ChatsOpen() bool {
-	return ui.chatsOpen.Get()
+	return ui.chatsOpen.Get() && ui.activeUI() == ui
 }
 
 // Offline sets the state of the roster to show the user as offline.
 func (ui *UI) Offline(j jid.JID, self bool) {
 	if self {
 		ui.sidebar.Offline()
+		ui.setAccountStatus(statusOffline)
 		ui.redraw()
 	}
 	ui.sidebar.UpsertPresence(j, statusOffline)
 }
 
+// Reconnecting sets the state of the roster to show that the connection was
+// lost and that the next attempt to reconnect will happen after d.
+func (ui *UI) Reconnecting(d time.Duration) {
+	ui.sidebar.Reconnecting(d)
+	ui.redraw()
+}
+
 // Online sets the state of the roster to show the user as online.
 func (ui *UI) Online(j jid.JID, self bool) {
 	if self {
 		ui.sidebar.Online()
+		ui.setAccountStatus(statusOnline)
 		ui.redraw()
 	}
 	ui.sidebar.UpsertPresence(j, statusOnline)
@@ -475,6 +554,7 @@ func (ui *UI) Online(j jid.JID, self bool) {
 func (ui *UI) Away(j jid.JID, self bool) {
 	if self {
 		ui.sidebar.Away()
+		ui.setAccountStatus(statusAway)
 		ui.redraw()
 	}
 	ui.sidebar.UpsertPresence(j, statusAway)
@@ -484,6 +564,7 @@ func (ui *UI) Away(j jid.JID, self bool) {
 func (ui *UI) Busy(j jid.JID, self bool) {
 	if self {
 		ui.sidebar.Busy()
+		ui.setAccountStatus(statusBusy)
 		ui.redraw()
 	}
 	ui.sidebar.UpsertPresence(j, statusBusy)
@@ -502,8 +583,9 @@ func (ui *UI) Handle(handler func(interface{})) {
 // ShowPasswordPrompt displays a modal and blocks until the user enters a
 // password and submits it.
 func (ui *UI) ShowPasswordPrompt() string {
-	ui.pages.ShowPage(getPasswordPageName)
-	ui.pages.SendToFront(getPasswordPageName)
+	pageName := getPasswordPageName + ui.addr
+	ui.pages.ShowPage(pageName)
+	ui.pages.SendToFront(pageName)
 	ui.app.SetFocus(ui.pages)
 	return <-ui.passPrompt
 }
@@ -532,37 +614,22 @@ func (ui *UI) ShowQuitPrompt() {
 	ui.app.SetFocus(ui.pages)
 }
 
-// ShowAddBookmark asks the user for a new JID.
-func (ui *UI) ShowAddBookmark() {
+// ShowCreateChannel asks the user for the address of a new channel.
+func (ui *UI) ShowCreateChannel() {
 	const (
-		pageName = "add_bookmark"
+		pageName = "create_channel"
 	)
 	p := ui.Printer()
-	var addButton = p.Sprintf("Join")
-	// Autocomplete rooms that are joined in the chats list but that we don't have
-	// bookmarks for and autocomplete domains of existing bookmarks.
-	l := len(ui.sidebar.bookmarks.items) + len(ui.sidebar.conversations.items)
-	autocomplete := make([]jid.JID, 0, l)
+	createButton := p.Sprintf("Create")
+	// Autocomplete the domains of existing bookmarks since new channels will most
+	// likely be created on the same service.
+	autocomplete := make([]jid.JID, 0, len(ui.sidebar.bookmarks.items))
 	for _, item := range ui.sidebar.bookmarks.items {
 		autocomplete = append(autocomplete, item.JID.Domain())
 	}
-	for _, item := range ui.sidebar.conversations.items {
-		if !item.Room {
-			continue
-		}
-		bare := item.JID.Bare()
-		if _, ok := ui.sidebar.bookmarks.items[bare.String()]; ok {
-			continue
-		}
-		autocomplete = append(autocomplete, bare)
-	}
-	mod := getJID(p, p.Sprintf("Join Channel"), addButton, false, func(j jid.JID, buttonLabel string) {
-		if buttonLabel == addButton {
-			go func() {
-				ui.UpdateBookmarks(bookmarks.Channel{
-					JID: j.Bare(),
-				})
-			}()
+	mod := getJID(p, p.Sprintf("Create Channel"), createButton, true, func(j jid.JID, buttonLabel string) {
+		if buttonLabel == createButton && j.Localpart() != "" {
+			ui.handler(event.CreateChannel(j.Bare()))
 		}
 		ui.pages.HidePage(pageName)
 		ui.pages.RemovePage(pageName)
@@ -842,51 +909,14 @@ func (ui *UI) SetCommands(j jid.JID, c []commands.Command) {
 	ui.app.SetFocus(ui.buffers)
 }
 
-// ShowHelpPrompt shows a list of keyboard shortcuts..
+// ShowHelpPrompt shows a list of the active key bindings.
 func (ui *UI) ShowHelpPrompt() {
 	onEsc := func() {
 		ui.pages.HidePage(helpPageName)
 		ui.pages.RemovePage(helpPageName)
 	}
-	// U+20E3 COMBINING ENCLOSING KEYCAP
 	mod := NewModal().
-		SetText(`[::b]Global[::-]
-
-q, Esc: quit or close
-F1, K: help or quick help
-
-[::b]Navigation[::-]
-
-Tab, Shift+Tab: focus to next/prev
-gg, Home: scroll to top
-G, End: scroll to bottom
-h, ←: move left
-j, ↓: move down
-k, ↑: move up
-l, →: move right
-PageUp, PageDown: move up/down one page
-<n>k: move <n> lines up
-<n>j: move <n> lines down
-/: search forward
-?: search backward
-n: next search result
-N: previous search result
-gt: next sidebar tab
-gT: previous sidebar tab
-
-[::b]Roster[::-]
-
-c: start chat
-i, Enter: open chat
-I: more info
-o, O: open next/prev unread
-dd: remove contact
-!: execute command
-s: change status
-
-[::b]Chat[::-]
-
-Ctrl+u: upload file(s)`).
+		SetText(ui.keys.help()).
 		SetDoneFunc(func(int, string) {
 			onEsc()
 		})
@@ -1126,24 +1156,128 @@ func (ui *UI) ShowRosterInfo() {
 
 	mod.SetText(buf.String()).
 		ClearButtons()
+	const (
+		subscribeBtn    = "Subscribe"
+		fingerprintsBtn = "Fingerprints"
+		configureBtn    = "Configure"
+	)
 	// If we're not subscribed, add a subscribe button.
-	if infoData.Subscription != "to" && infoData.Subscription != "both" {
-		const subscribeBtn = "Subscribe"
-		mod.AddButtons([]string{subscribeBtn}).
-			SetDoneFunc(func(_ int, buttonLabel string) {
-				switch buttonLabel {
-				case subscribeBtn:
-					ui.handler(event.Subscribe(infoData.JID.Bare()))
-				}
-				ui.pages.HidePage(infoPageName)
-			})
+	if !infoData.Room && infoData.Subscription != "to" && infoData.Subscription != "both" {
+		mod.AddButtons([]string{subscribeBtn})
+	}
+	if infoData.Room {
+		mod.AddButtons([]string{configureBtn})
 	}
+	mod.AddButtons([]string{fingerprintsBtn}).
+		SetDoneFunc(func(_ int, buttonLabel string) {
+			ui.pages.HidePage(infoPageName)
+			switch buttonLabel {
+			case subscribeBtn:
+				ui.handler(event.Subscribe(infoData.JID.Bare()))
+			case configureBtn:
+				ui.pages.RemovePage(infoPageName)
+				ui.handler(event.ConfigureChannel(infoData.JID.Bare()))
+			case fingerprintsBtn:
+				ui.pages.RemovePage(infoPageName)
+				ui.handler(event.LoadFingerprints{
+					JID:  infoData.JID.Bare(),
+					Room: infoData.Room,
+				})
+			}
+		})
 	ui.pages.AddPage(infoPageName, mod, true, false)
 	ui.pages.ShowPage(infoPageName)
 	ui.pages.SendToFront(infoPageName)
 	ui.app.SetFocus(ui.pages)
 }
 
+// Fingerprint is the identity key of one OMEMO device belonging to a contact or
+// to a member of a channel.
+type Fingerprint struct {
+	JID         jid.JID
+	Device      uint32
+	Fingerprint string
+	Trust       omemo.Trust
+}
+
+// ShowFingerprints shows the OMEMO fingerprints of the devices belonging to j
+// and lets the user decide whether or not to trust each of them.
+// own is the fingerprint of this device.
+func (ui *UI) ShowFingerprints(j jid.JID, own string, devices []Fingerprint) {
+	const pageName = "fingerprints"
+	p := ui.Printer()
+
+	onEsc := func() {
+		ui.pages.HidePage(pageName)
+		ui.pages.RemovePage(pageName)
+	}
+
+	var buf strings.Builder
+	buf.WriteString(p.Sprintf("Fingerprints for %s", j))
+	buf.WriteString("\n\n")
+	buf.WriteString(p.Sprintf("Own fingerprint: %s", own))
+	if len(devices) == 0 {
+		buf.WriteString("\n\n")
+		buf.WriteString(p.Sprintf("No devices found."))
+	}
+
+	trustOpts := []string{
+		p.Sprintf("Undecided"),
+		p.Sprintf("Trusted"),
+		p.Sprintf("Untrusted"),
+	}
+	trust := make([]omemo.Trust, len(devices))
+	saveButton := p.Sprintf("Save")
+	mod := NewModal().
+		SetText(buf.String()).
+		AddButtons([]string{saveButton})
+	for i, dev := range devices {
+		trust[i] = dev.Trust
+		label := fmt.Sprintf("%s (%d)\n%s", dev.JID, dev.Device, dev.Fingerprint)
+		idx := i
+		mod.Form().AddDropDown(label, trustOpts, int(dev.Trust), func(_ string, optionIndex int) {
+			trust[idx] = omemo.Trust(optionIndex)
+		})
+	}
+	mod.SetDoneFunc(func(_ int, label string) {
+		onEsc()
+		if label != saveButton {
+			return
+		}
+		for i, dev := range devices {
+			if trust[i] == dev.Trust {
+				continue
+			}
+			ui.handler(event.SetTrust{
+				JID:    dev.JID,
+				Device: dev.Device,
+				Trust:  trust[i],
+			})
+		}
+	})
+	mod.SetInputCapture(modalClose(onEsc))
+
+	ui.pages.AddPage(pageName, mod, true, false)
+	ui.pages.ShowPage(pageName)
+	ui.pages.SendToFront(pageName)
+	ui.app.SetFocus(ui.pages)
+}
+
+// SetEncrypted sets whether the open conversation is end-to-end encrypted.
+func (ui *UI) SetEncrypted(v bool) {
+	ui.history.SetEncrypted(v)
+}
+
+// EditMessage loads a message that we sent to j into the input field so that it
+// can be corrected.
+// If the conversation with j is no longer open, nothing happens.
+func (ui *UI) EditMessage(j jid.JID, id, body string) {
+	if !ui.ChatsOpen() || !ui.GetRosterJID().Bare().Equal(j.Bare()) {
+		return
+	}
+	ui.history.EditMessage(id, body)
+}
+
 // SelectRoster moves the input selection back to the roster and shows the logs
 // view.
 func (ui *UI) SelectRoster() {
@@ -1155,7 +1289,7 @@ func (ui *UI) SelectRoster() {
 	}
 	ui.chatsOpen.Set(false)
 	ui.buffers.SwitchToPage(logsPageName)
-	ui.app.SetFocus(ui.sidebar)
+	ui.app.SetFocus(ui.shownSidebar())
 }
 
 // History returns the chat history view.
@@ -1165,6 +1299,27 @@ func (ui *UI) History() *tview.TextView {
 	return ui.history.TextView
 }
 
+// SetRegionText replaces the text of a region that was written to the chat
+// history with a region tag.
+// If the region is not in the history (for example because another
+// conversation is open), nothing happens.
+func (ui *UI) SetRegionText(region, text string) {
+	h := ui.history.TextView
+	start := `["` + region + `"]`
+	buf := h.GetText(false)
+	idx := strings.LastIndex(buf, start)
+	if idx == -1 {
+		return
+	}
+	idx += len(start)
+	end := strings.Index(buf[idx:], `[""]`)
+	if end == -1 {
+		return
+	}
+	h.SetText(buf[:idx] + text + buf[idx+end:])
+	ui.Redraw()
+}
+
 // GetRect returns the size of the UI on the screen (including borders and
 // bounding boxes).
 func (ui *UI) GetRect() (x, y, width, height int) {
@@ -1189,11 +1344,11 @@ func (ui *UI) handleInput(event *tcell.EventKey) *tcell.EventKey {
 		case ui.buffers.HasFocus():
 			name, _ := ui.buffers.GetFrontPage()
 			if !ui.chatsOpen.Get() && name == logsPageName {
-				ui.SelectRoster()
+				ui.activeUI().SelectRoster()
 				return nil
 			}
 			return event
-		case ui.sidebar.HasFocus():
+		case ui.shownSidebar().HasFocus():
 			ui.buffers.SwitchToPage(logsPageName)
 			ui.app.SetFocus(ui.buffers)
 			return nil
@@ -1207,13 +1362,15 @@ func (ui *UI) handleInput(event *tcell.EventKey) *tcell.EventKey {
 			return nil
 		}
 		return event
-	case tcell.KeyF1, tcell.KeyHelp:
-		if name, _ := ui.pages.GetFrontPage(); name == uiPageName {
+	}
+
+	if name, _ := ui.pages.GetFrontPage(); name == uiPageName {
+		action, _, res := ui.keys.feed(keysGlobal, &ui.keyState, event)
+		if res == keyMatched && action == actionManual {
 			ui.ShowManualPage()
 			return nil
 		}
 	}
-
 	return event
 }
 
@@ -30,7 +30,6 @@ import (
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
 
-	"github.com/BurntSushi/toml"
 	"github.com/rivo/tview"
 
 	"mellium.im/cli"
@@ -102,53 +101,14 @@ func main() {
 		Usage: os.Args[0],
 		Flags: flags,
 		Run: func(cmd *cli.Command, args ...string) error {
-			f, fpath, err := configFile(configPath)
+			cfg, accts, err := loadConfig(configPath, defAcct, p, logger)
 			if err != nil {
-				return localerr.Wrap(p, `%v
-
-Try running '%s config' to generate a default config file.`, err, os.Args[0])
-			}
-			cfg := config{}
-			_, err = toml.NewDecoder(f).Decode(&cfg)
-			if err != nil {
-				logger.Print(p.Sprintf("error parsing config file: %v", err))
+				return err
 			}
-			if err = f.Close(); err != nil {
-				logger.Print(p.Sprintf("error closing config file: %v", err))
-			}
-
 			if cfg.Log.Verbose {
 				debug.SetOutput(io.MultiWriter(earlyLogs, os.Stderr))
 			}
 
-			if defAcct != "" {
-				cfg.DefaultAcct = defAcct
-			}
-
-			var acct account
-			for _, a := range cfg.Account {
-				if a.Address == cfg.DefaultAcct {
-					acct = a
-					break
-				}
-			}
-			if acct.Address == "" {
-				return localerr.Wrap(p, "account %q not found in config file", cfg.DefaultAcct)
-			}
-
-			// Open the database
-			dbCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
-			account, err := jid.Parse(acct.Address)
-			if err != nil {
-				return localerr.Wrap(p, "error parsing main account as XMPP address: %v", err)
-			}
-			db, err := storage.OpenDB(dbCtx, appName, account.Bare().String(), acct.DB, Migrations(), p, debug)
-			if err != nil {
-				return localerr.Wrap(p, "error opening database: %v", err)
-			}
-			defer db.Close()
-
 			// Setup the global tview styles. I hate this.
 			var cfgTheme *theme
 			for i := range cfg.Theme {
@@ -179,10 +139,12 @@ Try running '%s config' to generate a default config file.`, err, os.Args[0])
 				p,
 				logger,
 				ui.Debug(debug),
-				ui.Addr(acct.Address),
+				ui.Addr(accts[0].Address),
 				ui.ShowStatus(!cfg.UI.HideStatus),
 				ui.FilePicker(cfg.UI.FilePicker),
 				ui.Notify(cfg.UI.Notify),
+				ui.Keys(cfg.keys(p, logger)),
+				ui.TimeFormat(cfg.UI.TimeFormat),
 				ui.RosterWidth(cfg.UI.Width))
 			uiShutdown = pane.Stop
 
@@ -199,102 +161,51 @@ Go %s %s
 				debug.Print(p.Sprintf("error logging to pane: %v", err))
 			}
 
-			pass := &bytes.Buffer{}
-			if len(acct.PassCmd) > 0 {
-				args := strings.Fields(acct.PassCmd)
-				debug.Print(p.Sprintf("running command: %q", acct.PassCmd))
-				// The config file is considered a safe source since it is never written
-				// except by the user, so consider this use of exec to be safe.
-				/* #nosec */
-				cmd := exec.Command(args[0], args[1:]...)
-				cmd.Stdin = os.Stdin
-				cmd.Stderr = io.MultiWriter(os.Stderr, pane)
-				cmd.Stdout = pass
-				/* #nosec */
-				err := cmd.Run()
-				if err != nil {
-					debug.Print(p.Sprintf("error running password command, falling back to prompt: %v", err))
-				}
+			timeout := cfg.timeout(p, logger)
+
+			var ctl *controlServer
+			if cfg.Control.Enabled {
+				ctl = newControlServer(p, logger, debug)
 			}
-			getPass := func(ctx context.Context) (string, error) {
-				if p := pass.String(); p != "" {
-					return strings.TrimSuffix(p, "\n"), nil
-				}
-				if account.Localpart() == "" {
-					return "", nil
+			for i, acct := range accts {
+				acctPane := pane
+				if i > 0 {
+					acctPane = pane.Account(
+						ui.Addr(acct.Address),
+						ui.ShowStatus(!cfg.UI.HideStatus))
 				}
-				return pane.ShowPasswordPrompt(), nil
-			}
-
-			var j jid.JID
-			if cfg.DefaultAcct == "" {
-				logger.Print(p.Sprintf(`no user address specified, edit %q and add:
-
-	jid="me@example.com"
-
-`, fpath))
-			} else {
-				logger.Print(p.Sprintf("user address: %q", cfg.DefaultAcct))
-				j, err = jid.Parse(cfg.DefaultAcct)
+				closeDB, err := startAccount(acct, acctPane, ctl, timeout, xmlInLog, xmlOutLog, p, logger, debug)
 				if err != nil {
-					logger.Print(p.Sprintf("error parsing user address: %q", err))
+					if i == 0 {
+						return err
+					}
+					logger.Print(p.Sprintf("error starting account %q: %v", acct.Address, err))
+					continue
 				}
+				defer func() {
+					if err := closeDB(); err != nil {
+						debug.Print(p.Sprintf("error closing database for %q: %v", acct.Address, err))
+					}
+				}()
 			}
-			timeout := 30 * time.Second
-			if cfg.Timeout != "" {
-				timeout, err = time.ParseDuration(cfg.Timeout)
-				if err != nil {
-					logger.Print(p.Sprintf("error parsing timeout, defaulting to 30s: %q", err))
+
+			if ctl != nil {
+				sockPath := cfg.Control.Socket
+				if sockPath == "" {
+					sockPath = controlSocketPath()
 				}
-			}
-			// cfg.KeyLog
-			var keylog io.Writer
-			if acct.KeyLog != "" {
-				keylog, err = os.OpenFile(acct.KeyLog, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0400)
+				closeCtl, err := ctl.Listen(sockPath)
 				if err != nil {
-					logger.Print(p.Sprintf("error creating keylog file: %q", err))
+					logger.Print(p.Sprintf("error starting control socket: %v", err))
+				} else {
+					debug.Print(p.Sprintf("listening for control connections on %s", sockPath))
+					defer func() {
+						if err := closeCtl(); err != nil {
+							debug.Print(p.Sprintf("error closing control socket: %v", err))
+						}
+					}()
 				}
 			}
-			dialer := &dial.Dialer{
-				TLSConfig: &tls.Config{
-					ServerName:   j.Domain().String(),
-					KeyLogWriter: keylog,
-					MinVersion:   tls.VersionTLS12,
-				},
-				NoLookup: acct.NoSRV,
-				NoTLS:    acct.NoTLS,
-			}
-			var rosterVer string
-			func() {
-				ctx, cancel := context.WithTimeout(context.Background(), timeout)
-				defer cancel()
-				rosterVer, err = db.RosterVer(ctx)
-				if err != nil {
-					logger.Print(p.Sprintf("error retrieving roster version, falling back to full roster fetch: %v", err))
-				}
-			}()
-			c := client.New(
-				j, logger, debug,
-				client.Timeout(timeout),
-				client.Dialer(dialer),
-				client.NoTLS(acct.NoTLS),
-				client.Tee(logwriter.New(xmlInLog), logwriter.New(xmlOutLog)),
-				client.Password(getPass),
-				client.RosterVer(rosterVer),
-				client.Printer(p),
-			)
-			c.Handler(newClientHandler(c, pane, db, logger, debug))
-			pane.Handle(newUIHandler(acct, pane, db, c, logger, debug))
-
-			go func() {
-				ctx, cancel := context.WithTimeout(context.Background(), 3*timeout)
-				defer cancel()
-				if err := c.Online(ctx); err != nil {
-					logger.Print(p.Sprintf("initial login failed: %v", err))
-					return
-				}
-				debug.Print(p.Sprintf("logged in as: %q", c.LocalAddr()))
-			}()
 
 			go func() {
 				s := <-sigs
@@ -320,6 +231,10 @@ Go %s %s
 	cmds.Commands = []*cli.Command{
 		aboutCmd(os.Stdout, configPath, Version, p, logger),
 		genCfgCmd(p, logger),
+		sendCmd(configPath, defAcct, p, logger, debug),
+		daemonCmd(configPath, defAcct, p, logger, debug),
+		exportCmd(configPath, defAcct, p, logger, debug),
+		importCmd(configPath, defAcct, p, logger, debug),
 		cli.Help(cmds),
 	}
 	helpCmd := cli.Help(cmds)
@@ -338,3 +253,143 @@ Go %s %s
 		logger.Fatalln(err)
 	}
 }
+
+// startAccount opens the database for acct and creates a client for it that
+// uses pane to display the roster, conversations, etc.
+// The client comes online in the background.
+// If ctl is not nil the account is made available to the control socket.
+// The returned function closes the database.
+func startAccount(acct account, pane *ui.UI, ctl *controlServer, timeout time.Duration, xmlInLog, xmlOutLog *log.Logger, p *message.Printer, logger, debug *log.Logger) (func() error, error) {
+	j, err := jid.Parse(acct.Address)
+	if err != nil {
+		return nil, localerr.Wrap(p, "error parsing account as XMPP address: %v", err)
+	}
+	logger.Print(p.Sprintf("user address: %q", acct.Address))
+
+	c, db, err := newAccount(j, acct, pane.ShowPasswordPrompt, io.MultiWriter(os.Stderr, pane), timeout, xmlInLog, xmlOutLog, p, logger, debug)
+	if err != nil {
+		return nil, err
+	}
+	clientHandler := newClientHandler(c, pane, db, logger, debug)
+	uiHandler := newUIHandler(acct, pane, db, c, logger, debug)
+	if ctl != nil {
+		publish := ctl.AddAccount(j, uiHandler, db)
+		c.Handler(func(ev interface{}) {
+			clientHandler(ev)
+			publish(ev)
+		})
+	} else {
+		c.Handler(clientHandler)
+	}
+	pane.Handle(uiHandler)
+	func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		restoreConversations(ctx, pane, db, logger)
+	}()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*timeout)
+		defer cancel()
+		if err := c.Online(ctx); err != nil {
+			logger.Print(p.Sprintf("initial login failed for %q: %v", acct.Address, err))
+			return
+		}
+		debug.Print(p.Sprintf("logged in as: %q", c.LocalAddr()))
+	}()
+
+	return db.Close, nil
+}
+
+// newAccount opens the database for acct and creates a client for it without
+// logging in.
+// If the password command is not set or fails, prompt is used to ask for the
+// password, or the login fails if prompt is nil.
+// Anything the password command writes to standard error is copied to stderr.
+func newAccount(j jid.JID, acct account, prompt func() string, stderr io.Writer, timeout time.Duration, xmlInLog, xmlOutLog *log.Logger, p *message.Printer, logger, debug *log.Logger) (*client.Client, *storage.DB, error) {
+	db, err := openDB(j, acct, p, debug)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pass := &bytes.Buffer{}
+	if len(acct.PassCmd) > 0 {
+		args := strings.Fields(acct.PassCmd)
+		debug.Print(p.Sprintf("running command: %q", acct.PassCmd))
+		// The config file is considered a safe source since it is never written
+		// except by the user, so consider this use of exec to be safe.
+		/* #nosec */
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = stderr
+		cmd.Stdout = pass
+		/* #nosec */
+		err := cmd.Run()
+		if err != nil {
+			debug.Print(p.Sprintf("error running password command, falling back to prompt: %v", err))
+		}
+	}
+	getPass := func(ctx context.Context) (string, error) {
+		if p := pass.String(); p != "" {
+			return strings.TrimSuffix(p, "\n"), nil
+		}
+		if j.Localpart() == "" {
+			return "", nil
+		}
+		if prompt == nil {
+			return "", localerr.Wrap(p, "no password available for %q, set password_eval in the config file", j.Bare())
+		}
+		return prompt(), nil
+	}
+
+	// cfg.KeyLog
+	var keylog io.Writer
+	if acct.KeyLog != "" {
+		keylog, err = os.OpenFile(acct.KeyLog, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0400)
+		if err != nil {
+			logger.Print(p.Sprintf("error creating keylog file: %q", err))
+		}
+	}
+	dialer := &dial.Dialer{
+		TLSConfig: &tls.Config{
+			ServerName:   j.Domain().String(),
+			KeyLogWriter: keylog,
+			MinVersion:   tls.VersionTLS12,
+		},
+		NoLookup: acct.NoSRV,
+		NoTLS:    acct.NoTLS,
+	}
+	var rosterVer string
+	func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		rosterVer, err = db.RosterVer(ctx)
+		if err != nil {
+			logger.Print(p.Sprintf("error retrieving roster version, falling back to full roster fetch: %v", err))
+		}
+	}()
+	c := client.New(
+		j, logger, debug,
+		client.Timeout(timeout),
+		client.Dialer(dialer),
+		client.NoTLS(acct.NoTLS),
+		client.Tee(logwriter.New(xmlInLog), logwriter.New(xmlOutLog)),
+		client.Password(getPass),
+		client.RosterVer(rosterVer),
+		client.Printer(p),
+		client.OMEMO(db),
+		client.Outbox(db),
+	)
+	return c, db, nil
+}
+
+// openDB opens the database for acct, creating it if it does not exist.
+func openDB(j jid.JID, acct account, p *message.Printer, debug *log.Logger) (*storage.DB, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	db, err := storage.OpenDB(ctx, appName, j.Bare().String(), acct.DB, storage.Schema(), p, debug)
+	if err != nil {
+		return nil, localerr.Wrap(p, "error opening database: %v", err)
+	}
+	return db, nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2026 The Mellium Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+
+	"mellium.im/communique/internal/client"
+	clientevent "mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/storage"
+	"mellium.im/communique/internal/ui"
+	"mellium.im/communique/internal/ui/event"
+)
+
+// showOutbox shows the messages that are waiting to be sent.
+func showOutbox(c *client.Client, pane *ui.UI, db *storage.DB, logger *log.Logger) {
+	p := c.Printer()
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout())
+	defer cancel()
+	queued, err := db.Outbox(ctx)
+	if err != nil {
+		logger.Print(p.Sprintf("error loading the outbox: %v", err))
+		return
+	}
+	msgs := make([]ui.QueuedMessage, 0, len(queued))
+	for _, q := range queued {
+		msgs = append(msgs, ui.QueuedMessage{
+			ID:     q.OriginID.ID,
+			To:     q.To,
+			Body:   q.Body,
+			Queued: q.Queued,
+		})
+	}
+	pane.ShowOutbox(msgs)
+	pane.Redraw()
+}
+
+// cancelQueued removes a message from the outbox.
+// It stays in the history but is marked as failed.
+func cancelQueued(e event.CancelQueued, c *client.Client, pane *ui.UI, db *storage.DB, logger *log.Logger) {
+	p := c.Printer()
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout())
+	defer cancel()
+	id := string(e)
+	removed, err := c.Cancel(ctx, id)
+	if err != nil {
+		logger.Print(p.Sprintf("error removing message %q from the outbox: %v", id, err))
+		return
+	}
+	if !removed {
+		logger.Print(p.Sprintf("message %q was already sent", id))
+		return
+	}
+	if err = db.MarkFailed(ctx, id); err != nil {
+		logger.Print(p.Sprintf("error marking message %q as failed: %v", id, err))
+	}
+	setDelivery(pane, id, deliveryFailed)
+}
+
+// outboxSent updates the state of a message that was sent from the outbox or
+// that could not be sent at all.
+func outboxSent(ctx context.Context, e clientevent.OutboxSent, pane *ui.UI, db *storage.DB, logger *log.Logger) {
+	if e.Err == nil {
+		setDelivery(pane, e.ID, deliverySent)
+		return
+	}
+	p := pane.Printer()
+	logger.Print(p.Sprintf("error sending message: %v", e.Err))
+	if err := db.MarkFailed(ctx, e.ID); err != nil {
+		logger.Print(p.Sprintf("error marking message %q as failed: %v", e.ID, err))
+	}
+	setDelivery(pane, e.ID, deliveryFailed)
+}
@@ -7,14 +7,18 @@ package main
 import (
 	"context"
 	"log"
+	"slices"
 	"time"
 
 	/* #nosec */
 	_ "crypto/sha1"
 	_ "crypto/sha256"
 
+	"golang.org/x/text/message"
+
 	"mellium.im/communique/internal/client"
 	clientevent "mellium.im/communique/internal/client/event"
+	"mellium.im/communique/internal/omemo"
 	"mellium.im/communique/internal/storage"
 	"mellium.im/communique/internal/ui"
 	"mellium.im/communique/internal/ui/event"
@@ -24,6 +28,7 @@ import (
 	"mellium.im/xmpp/disco/info"
 	"mellium.im/xmpp/history"
 	"mellium.im/xmpp/jid"
+	"mellium.im/xmpp/muc"
 	"mellium.im/xmpp/roster"
 	"mellium.im/xmpp/stanza"
 	"mellium.im/xmpp/upload"
@@ -179,12 +184,11 @@ func newUIHandler(acct account, pane *ui.UI, db *storage.DB, c *client.Client, l
 		case event.ChatMessage:
 			go sendMessage(c, logger, db, pane, e)
 		case event.OpenChannel:
-			go openChannel(e, c, acct, debug, logger)
+			go openChannel(bookmarks.Channel(e), c, acct, debug, logger)
 		case event.OpenChat:
-			go openChat(e, pane, db, logger)
+			go openChat(e, c, pane, db, logger)
 		case event.CloseChat:
-			history := pane.History()
-			history.SetText("")
+			pane.ClearHistory()
 		case event.Subscribe:
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
@@ -206,6 +210,167 @@ func newUIHandler(acct account, pane *ui.UI, db *storage.DB, c *client.Client, l
 			go pullToRefresh(e, c, pane, db, debug, logger)
 		case event.UploadFile:
 			go uploadFile(c, logger, debug, db, pane, e)
+		case event.EditLastMessage:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+				defer cancel()
+				id, body, err := db.LastSent(ctx, jid.JID(e))
+				if err != nil {
+					logger.Print(p.Sprintf("error loading last message sent to %s: %v", jid.JID(e), err))
+					return
+				}
+				if id == "" {
+					return
+				}
+				pane.EditMessage(jid.JID(e), id, body)
+				pane.Redraw()
+			}()
+		case event.ChatState:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := c.SendChatState(ctx, e.JID, e.State)
+				if err != nil {
+					debug.Print(p.Sprintf("error sending chat state to %s: %v", e.JID, err))
+				}
+			}()
+		case event.LoadOutbox:
+			go showOutbox(c, pane, db, logger)
+		case event.CancelQueued:
+			go cancelQueued(e, c, pane, db, logger)
+		case event.LoadRetractable:
+			go loadRetractable(e, c, pane, db, logger)
+		case event.Retract:
+			go retract(e, c, pane, db, logger)
+		case event.SearchHistory:
+			go searchHistory(e, c, pane, db, logger)
+		case event.JumpToMessage:
+			go jumpToMessage(e, pane, db, logger)
+		case event.ExportHistory:
+			go func() {
+				defer panicHandler()
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+				defer cancel()
+				addr, err := jid.Parse(acct.Address)
+				if err != nil {
+					logger.Print(p.Sprintf("error parsing account as XMPP address: %v", err))
+					return
+				}
+				n, err := exportToFile(ctx, db, e.Path, e.Format, storage.ExportQuery{
+					With:  e.With,
+					Start: e.Start,
+					End:   e.End,
+				}, addr, p)
+				if err != nil {
+					logger.Print(p.Sprintf("error exporting history to %s: %v", e.Path, err))
+					return
+				}
+				logger.Print(p.Sprintf("exported %d messages to %s", n, e.Path))
+			}()
+		case event.SetEncryption:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := db.SetOMEMOEnabled(ctx, e.JID, e.Enabled)
+				if err != nil {
+					logger.Print(p.Sprintf("error saving encryption setting for %s: %v", e.JID, err))
+				}
+			}()
+		case event.CloseConversation:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := db.CloseConversation(ctx, jid.JID(e))
+				if err != nil {
+					logger.Print(p.Sprintf("error closing conversation with %s: %v", jid.JID(e), err))
+				}
+			}()
+		case event.PinConversation:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := db.PinConversation(ctx, e.JID, e.Pinned)
+				if err != nil {
+					logger.Print(p.Sprintf("error pinning conversation with %s: %v", e.JID, err))
+				}
+			}()
+		case event.LoadFingerprints:
+			go showFingerprints(e, c, pane, logger)
+		case event.CreateChannel:
+			go configureChannel(jid.JID(e), true, c, pane, acct, debug, logger)
+		case event.ConfigureChannel:
+			go configureChannel(jid.JID(e), false, c, pane, acct, debug, logger)
+		case event.SetNick:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				err := c.SetNick(ctx, e.Room, e.Nick)
+				if err != nil {
+					logger.Print(p.Sprintf("error changing nickname in %s: %v", e.Room, err))
+				}
+			}()
+		case event.SetSubject:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := c.SetSubject(ctx, e.Room, e.Subject)
+				if err != nil {
+					logger.Print(p.Sprintf("error changing the subject of %s: %v", e.Room, err))
+				}
+			}()
+		case event.LeaveChannel:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				err := c.LeaveMUC(ctx, e.Room, e.Reason)
+				if err != nil {
+					logger.Print(p.Sprintf("error leaving %s: %v", e.Room, err))
+				}
+				pane.SetOccupants(e.Room, nil)
+			}()
+		case event.SendInvite:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := c.Invite(ctx, e.Room, e.JID, e.Reason)
+				if err != nil {
+					logger.Print(p.Sprintf("error inviting %s to %s: %v", e.JID, e.Room, err))
+				}
+			}()
+		case event.DeclineInvite:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := c.DeclineInvite(ctx, e.Room, e.From, "")
+				if err != nil {
+					logger.Print(p.Sprintf("error declining invitation to %s: %v", e.Room, err))
+				}
+			}()
+		case event.LoadChannelAdmin:
+			room := jid.JID(e)
+			pane.ShowChannelAdmin(room, c.Role(room), c.Affiliation(room), c.Occupants(room))
+		case event.SetRole:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				err := c.SetRole(ctx, e.Room, e.Nick, e.Role, e.Reason)
+				if err != nil {
+					logger.Print(p.Sprintf("error changing the role of %s in %s: %v", e.Nick, e.Room, err))
+				}
+			}()
+		case event.SetAffiliation:
+			go setAffiliation(e, c, pane, logger)
+		case event.LoadAffiliations:
+			go showAffiliations(e, c, pane, logger)
+		case event.SetTrust:
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				err := db.SetOMEMOTrust(ctx, e.JID, e.Device, e.Trust)
+				if err != nil {
+					logger.Print(p.Sprintf("error saving trust for device %d of %s: %v", e.Device, e.JID, err))
+				}
+			}()
 		default:
 			debug.Print(p.Sprintf("unrecognized ui event: %T(%[1]q)", e))
 		}
@@ -219,24 +384,45 @@ func sendMessage(c *client.Client, logger *log.Logger, db *storage.DB, ui *ui.UI
 
 	p := c.Printer()
 
-	msg, err := c.SendMessage(ctx, clientevent.ChatMessage{
+	msg := clientevent.ChatMessage{
 		Message: message.Message,
 		Body:    message.Body,
+		Replace: clientevent.Replace{ID: message.Replace},
 		Sent:    true,
-	})
-	if err != nil {
-		logger.Print(p.Sprintf("error sending message: %v", err))
 	}
-	if err = writeMessage(ui, msg, false); err != nil {
+	// Pick the ID now so that the message can be shown as pending while it is
+	// being sent.
+	if msg.ID == "" {
+		msg.ID = client.NewID()
+		msg.OriginID.ID = msg.ID
+	}
+	if err := writeMessage(ui, msg, deliveryPending, false); err != nil {
 		logger.Print(p.Sprintf("error saving sent message to history: %v", err))
 	}
-	if err = db.InsertMsg(ctx, msg.Account, msg, c.LocalAddr()); err != nil {
+	// Store the message before sending it so that a receipt can't arrive before
+	// there is anything to mark as received.
+	if err := db.InsertMsg(ctx, msg.Account, msg, c.LocalAddr()); err != nil {
 		logger.Print(p.Sprintf("error writing message to database: %v", err))
 	}
+	// The outbox sends it as soon as we're online and tells us once it has
+	// (see outboxSent).
+	msg, err := c.Queue(ctx, msg)
+	if err != nil {
+		logger.Print(p.Sprintf("error sending message: %v", err))
+		if err = db.MarkFailed(ctx, msg.ID); err != nil {
+			logger.Print(p.Sprintf("error marking message %q as failed: %v", msg.ID, err))
+		}
+		setDelivery(ui, msg.ID, deliveryFailed)
+	}
+	saveConversation(ctx, ui, db, msg, logger)
+	showEdit(ctx, ui, db, msg, logger)
 	// If we sent the message that wasn't automated (it has a body), assume
 	// we've read everything before it.
 	if message.Body != "" {
 		ui.Roster().MarkRead(message.To.Bare().String())
+		if _, err = db.MarkRead(ctx, message.To); err != nil {
+			logger.Print(p.Sprintf("error marking chat with %s as read: %v", message.To.Bare(), err))
+		}
 	}
 }
 
@@ -265,76 +451,485 @@ func uploadFile(c *client.Client, logger *log.Logger, debug *log.Logger, db *sto
 	sendMessage(c, logger, db, ui, ev.Message)
 }
 
-func openChat(e event.OpenChat, pane *ui.UI, db *storage.DB, logger *log.Logger) {
+// retractableLimit is the number of recent messages offered for retraction.
+const retractableLimit = 20
+
+func loadRetractable(e event.LoadRetractable, c *client.Client, pane *ui.UI, db *storage.DB, logger *log.Logger) {
+	p := c.Printer()
+	// Moderators can remove anyone's messages from a channel, everyone else can
+	// only retract their own.
+	var moderate bool
+	if e.Room {
+		var err error
+		moderate, err = c.Moderator(e.JID)
+		if err != nil {
+			logger.Print(p.Sprintf("error discovering whether %s supports moderation: %v", e.JID, err))
+			return
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	msgs, err := db.Retractable(ctx, e.JID, moderate, retractableLimit)
+	if err != nil {
+		logger.Print(p.Sprintf("error loading messages to retract from %s: %v", e.JID, err))
+		return
+	}
+	retractable := make([]ui.RetractableMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		retractable = append(retractable, ui.RetractableMessage{
+			ID:   msg.ID,
+			Body: msg.Body,
+			Time: msg.Delay,
+		})
+	}
+	pane.ShowRetract(e.JID, e.Room, moderate, retractable)
+	pane.Redraw()
+}
+
+func retract(e event.Retract, c *client.Client, pane *ui.UI, db *storage.DB, logger *log.Logger) {
+	p := c.Printer()
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout())
+	defer cancel()
+	if e.Moderate {
+		// The channel tells all occupants, including us, once the message has been
+		// removed so there is nothing else to do here.
+		err := c.Moderate(ctx, e.JID, e.ID, e.Reason)
+		if err != nil {
+			logger.Print(p.Sprintf("error removing message from %s: %v", e.JID, err))
+		}
+		return
+	}
+	typ := stanza.ChatMessage
+	if e.Room {
+		typ = stanza.GroupChatMessage
+	}
+	err := c.Retract(ctx, e.JID, typ, e.ID)
+	if err != nil {
+		logger.Print(p.Sprintf("error retracting message sent to %s: %v", e.JID, err))
+		return
+	}
+	retractMessage(ctx, pane, db, clientevent.ChatMessage{
+		Message: stanza.Message{To: e.JID, Type: typ},
+		Retract: &clientevent.Retract{ID: e.ID},
+		Sent:    true,
+	}, c.LocalAddr(), logger)
+	pane.Redraw()
+}
+
+func openChat(e event.OpenChat, c *client.Client, pane *ui.UI, db *storage.DB, logger *log.Logger) {
 	var firstUnread string
 	bare := e.JID.Bare().String()
 	item, ok := pane.Roster().GetItem(bare)
 	if ok {
 		firstUnread = item.FirstUnread()
 	}
+	conv, ok := pane.Conversations().GetItem(bare)
+	if ok && firstUnread == "" {
+		firstUnread = conv.FirstUnread()
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
-	if err := loadBuffer(ctx, pane, db, roster.Item(e), firstUnread, logger); err != nil {
+	if ok {
+		err := db.UpsertConversation(ctx, storage.Conversation{
+			JID:          e.JID,
+			Name:         conv.Name,
+			Room:         conv.Room,
+			LastActivity: time.Now(),
+		}, false)
+		if err != nil {
+			p := pane.Printer()
+			logger.Print(p.Sprintf("error saving conversation with %s: %v", bare, err))
+		}
+	}
+	if err := loadBuffer(ctx, pane, db, roster.Item(e), firstUnread, 0, logger); err != nil {
 		p := pane.Printer()
 		logger.Print(p.Sprintf("error loading chat: %v", err))
 		return
 	}
+	encrypted, err := db.OMEMOEnabled(ctx, e.JID.Bare())
+	if err != nil {
+		p := pane.Printer()
+		logger.Print(p.Sprintf("error loading encryption setting for %s: %v", bare, err))
+	}
+	pane.SetEncrypted(encrypted)
 	pane.History().ScrollToEnd()
 	pane.Roster().MarkRead(bare)
 	pane.Conversations().MarkRead(bare)
 	pane.Redraw()
+
+	markable, err := db.MarkRead(ctx, e.JID)
+	if err != nil {
+		p := pane.Printer()
+		logger.Print(p.Sprintf("error marking chat with %s as read: %v", bare, err))
+		return
+	}
+	if markable == "" {
+		return
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), c.Timeout())
+	defer cancel()
+	if err = c.SendDisplayed(ctx, e.JID, markable); err != nil {
+		p := pane.Printer()
+		logger.Print(p.Sprintf("error sending displayed marker to %s: %v", bare, err))
+	}
 }
 
-func openChannel(e event.OpenChannel, c *client.Client, acct account, debug, logger *log.Logger) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// searchHistory searches the stored message history and shows the results.
+// If the server archive is also being searched, the messages that it finds are
+// stored and shown along with the local results, even if the local search
+// would not have matched them.
+func searchHistory(e event.SearchHistory, c *client.Client, pane *ui.UI, db *storage.DB, logger *log.Logger) {
+	const maxResults = 200
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	if acct.Name == "" {
-		acct.Name = c.LocalAddr().Localpart()
+
+	p := pane.Printer()
+	var results []ui.SearchResult
+	found := make(map[int64]struct{})
+	if e.Archive {
+		msgs, err := c.SearchArchive(ctx, client.ArchiveSearch{
+			Text:  e.Text,
+			With:  e.With,
+			Start: e.Start,
+			End:   e.End,
+			Limit: maxResults,
+		})
+		if err != nil {
+			logger.Print(p.Sprintf("error searching server archive: %v", err))
+		}
+		for _, msg := range msgs {
+			result, ok := storeArchiveResult(ctx, e, msg, c.LocalAddr(), db, p, logger)
+			if !ok {
+				continue
+			}
+			if result.RowID != 0 {
+				if _, dup := found[result.RowID]; dup {
+					continue
+				}
+				found[result.RowID] = struct{}{}
+			}
+			results = append(results, result)
+		}
 	}
-	p := c.Printer()
-	j, err := e.JID.WithResource(acct.Name)
-	if err != nil {
-		logger.Print(p.Sprintf("invalid nick %s in config: %v", acct.Name, err))
+
+	q := storage.SearchQuery{
+		Text:  e.Text,
+		With:  e.With,
+		Start: e.Start,
+		End:   e.End,
+		Type:  e.Type,
+		Limit: maxResults,
+	}
+	switch {
+	case e.OnlySent:
+		q.Direction = storage.Sent
+	case e.OnlyReceived:
+		q.Direction = storage.Received
+	}
+	iter := db.Search(ctx, q)
+	for iter.Next() {
+		cur := iter.Result()
+		if _, dup := found[cur.RowID]; dup {
+			continue
+		}
+		results = append(results, ui.SearchResult{
+			JID:   cur.With,
+			Room:  cur.Type == stanza.GroupChatMessage,
+			RowID: cur.RowID,
+			Time:  cur.Delay.Time,
+			Sent:  cur.Sent,
+			Body:  cur.Body,
+		})
+	}
+	if err := iter.Err(); err != nil {
+		logger.Print(p.Sprintf("error searching history: %v", err))
 		return
 	}
-	debug.Print(p.Sprintf("joining room %v…", j))
-	err = c.JoinMUC(ctx, j)
+	slices.SortStableFunc(results, func(a, b ui.SearchResult) int {
+		return b.Time.Compare(a.Time)
+	})
+	if len(results) > maxResults {
+		results = results[:maxResults]
+	}
+	pane.ShowSearchResults(e.Text, results)
+	pane.Redraw()
+}
+
+// storeArchiveResult stores a message found by searching the server archive
+// and returns it as a search result.
+// If the message doesn't match the parts of the search that the archive can't
+// filter on, or it has nothing to show, false is returned.
+func storeArchiveResult(ctx context.Context, e event.SearchHistory, msg clientevent.ChatMessage, addr jid.JID, db *storage.DB, p *message.Printer, logger *log.Logger) (ui.SearchResult, bool) {
+	switch {
+	case msg.Body == "" || msg.Retract != nil:
+		return ui.SearchResult{}, false
+	case e.OnlySent && !msg.Sent, e.OnlyReceived && msg.Sent:
+		return ui.SearchResult{}, false
+	case e.Type != "" && msg.Type != e.Type:
+		return ui.SearchResult{}, false
+	}
+	storeMessage(ctx, db, msg, true, addr, p, logger)
+
+	with := msg.From.Bare()
+	if msg.Sent {
+		with = msg.To.Bare()
+	}
+	result := ui.SearchResult{
+		JID:  with,
+		Room: msg.Type == stanza.GroupChatMessage,
+		Time: msg.Delay.Time,
+		Sent: msg.Sent,
+		Body: msg.Body,
+	}
+	for _, sid := range msg.SID {
+		if !sid.By.Equal(addr.Bare()) {
+			continue
+		}
+		rowID, err := db.ArchiveRowID(ctx, sid.ID)
+		if err != nil {
+			logger.Print(p.Sprintf("error finding stored search result: %v", err))
+		}
+		result.RowID = rowID
+		break
+	}
+	return result, true
+}
+
+// jumpToMessage opens a conversation at a message that was found by a search.
+func jumpToMessage(e event.JumpToMessage, pane *ui.UI, db *storage.DB, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	p := pane.Printer()
+	if err := loadBuffer(ctx, pane, db, roster.Item{JID: e.JID}, "", e.RowID, logger); err != nil {
+		logger.Print(p.Sprintf("error loading chat: %v", err))
+		return
+	}
+	encrypted, err := db.OMEMOEnabled(ctx, e.JID)
 	if err != nil {
-		logger.Print(p.Sprintf("error joining room %s: %v", e.JID, err))
+		logger.Print(p.Sprintf("error loading encryption setting for %s: %v", e.JID, err))
 	}
+	pane.SetEncrypted(encrypted)
+	pane.Redraw()
 }
 
-func pullToRefresh(e event.PullToRefreshChat, c *client.Client, pane *ui.UI, db *storage.DB, debug, logger *log.Logger) {
+// showFingerprints fetches the OMEMO identity keys of a contact, or of everyone
+// in a channel, and shows them to the user.
+func showFingerprints(e event.LoadFingerprints, c *client.Client, pane *ui.UI, logger *log.Logger) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	p := c.Printer()
-	// TODO: if mam:2#extended is supported, use archive ID
-	_, t, err := db.BeforeID(ctx, e.JID)
+	_, own, err := c.OMEMOFingerprint(ctx)
 	if err != nil {
-		logger.Print(p.Sprintf("error fetching earliest message info for %v from database: %v", e, err))
+		logger.Print(p.Sprintf("error loading own fingerprint: %v", err))
 		return
 	}
-	if t.IsZero() {
-		debug.Print(p.Sprintf("no scrollback for %v", e.JID))
+	addrs := []jid.JID{e.JID}
+	if e.Room {
+		addrs = c.OccupantJIDs(e.JID)
+	}
+	var fingerprints []ui.Fingerprint
+	for _, j := range addrs {
+		devices, err := c.FetchOMEMOIdentities(ctx, j)
+		if err != nil {
+			logger.Print(p.Sprintf("error fetching OMEMO devices of %s: %v", j, err))
+			continue
+		}
+		for _, dev := range devices {
+			if !dev.Active || dev.Identity == (omemo.PublicKey{}) {
+				continue
+			}
+			fingerprints = append(fingerprints, ui.Fingerprint{
+				JID:         j,
+				Device:      dev.ID,
+				Fingerprint: dev.Identity.Fingerprint(),
+				Trust:       dev.Trust,
+			})
+		}
+	}
+	pane.ShowFingerprints(e.JID, own, fingerprints)
+	pane.Redraw()
+}
+
+// openChannel joins the channel from a bookmark using the nick from the
+// bookmark or, if it does not have one, the nick from the config file.
+func openChannel(e bookmarks.Channel, c *client.Client, acct account, debug, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p := c.Printer()
+	// Prefer the nick from the bookmark, then the one from the config file.
+	nick := e.Nick
+	if nick == "" {
+		nick = acct.Name
+	}
+	if nick == "" {
+		nick = c.LocalAddr().Localpart()
+	}
+	j, err := e.JID.WithResource(nick)
+	if err != nil {
+		logger.Print(p.Sprintf("invalid nick %s: %v", nick, err))
 		return
 	}
-	debug.Print(p.Sprintf("fetching scrollback before %v for %v…", t, e.JID))
-	_, _, _, screenHeight := pane.GetRect()
-	_, err = history.Fetch(ctx, history.Query{
-		With:    e.JID,
-		End:     t,
-		Limit:   uint64(2 * screenHeight), // #nosec G115
-		Reverse: true,
-		Last:    true,
-	}, c.Session.LocalAddr().Bare(), c.Session)
+	var opts []muc.Option
+	if e.Password != "" {
+		opts = append(opts, muc.Password(e.Password))
+	}
+	debug.Print(p.Sprintf("joining room %v…", j))
+	err = c.JoinMUC(ctx, j, opts...)
 	if err != nil {
-		debug.Print(p.Sprintf("error fetching scrollback for %v: %v", e.JID, err))
+		logger.Print(p.Sprintf("error joining room %s: %v", e.JID, err))
+	}
+}
+
+func pullToRefresh(e event.PullToRefreshChat, c *client.Client, pane *ui.UI, db *storage.DB, debug, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p := c.Printer()
+	_, _, _, screenHeight := pane.GetRect()
+	limit := uint64(2 * screenHeight) // #nosec G115
+
+	if c.Joined(e.JID) {
+		// Channel messages are not in our own archive, page back from the oldest
+		// message that we have from the channel's archive instead.
+		if !c.Archived(e.JID) {
+			debug.Print(p.Sprintf("channel %s does not have an archive", e.JID))
+			return
+		}
+		beforeID, err := db.ChannelBeforeID(ctx, e.JID)
+		if err != nil {
+			logger.Print(p.Sprintf("error fetching earliest message info for %v from database: %v", e, err))
+			return
+		}
+		// If we don't have anything from the archive yet, start with the most
+		// recent page.
+		debug.Print(p.Sprintf("fetching scrollback before %q for %v…", beforeID, e.JID))
+		_, err = history.Fetch(ctx, history.Query{
+			PageID: beforeID,
+			Limit:  limit,
+			Last:   true,
+		}, e.JID.Bare(), c.Session)
+		if err != nil {
+			debug.Print(p.Sprintf("error fetching scrollback for %v: %v", e.JID, err))
+		}
+	} else {
+		// TODO: if mam:2#extended is supported, use archive ID
+		_, t, err := db.BeforeID(ctx, e.JID)
+		if err != nil {
+			logger.Print(p.Sprintf("error fetching earliest message info for %v from database: %v", e, err))
+			return
+		}
+		if t.IsZero() {
+			debug.Print(p.Sprintf("no scrollback for %v", e.JID))
+			return
+		}
+		debug.Print(p.Sprintf("fetching scrollback before %v for %v…", t, e.JID))
+		_, err = history.Fetch(ctx, history.Query{
+			With:    e.JID,
+			End:     t,
+			Limit:   limit,
+			Reverse: true,
+			Last:    true,
+		}, c.Session.LocalAddr().Bare(), c.Session)
+		if err != nil {
+			debug.Print(p.Sprintf("error fetching scrollback for %v: %v", e.JID, err))
+		}
 	}
-	if err := loadBuffer(ctx, pane, db, roster.Item(e), "", logger); err != nil {
+	if err := loadBuffer(ctx, pane, db, roster.Item(e), "", 0, logger); err != nil {
 		logger.Print(p.Sprintf("error loading scrollback into pane for %v: %v", e.JID, err))
 		return
 	}
 	// TODO: scroll to an offset that keeps context so that we don't lose
 	// our position.
 }
+
+// setAffiliation changes the affiliation of someone with a channel and shows
+// the affected list again if it was changed from the list dialog.
+func setAffiliation(e event.SetAffiliation, c *client.Client, pane *ui.UI, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p := c.Printer()
+	who := e.Nick
+	if !e.JID.Equal(jid.JID{}) {
+		who = e.JID.String()
+	}
+	err := c.SetAffiliation(ctx, e.Room, e.JID, e.Nick, e.Affiliation, e.Reason)
+	if err != nil {
+		logger.Print(p.Sprintf("error changing the affiliation of %s with %s: %v", who, e.Room, err))
+	}
+	if e.ShowList {
+		showAffiliations(event.LoadAffiliations{
+			Room:        e.Room,
+			Affiliation: e.List,
+		}, c, pane, logger)
+	}
+}
+
+// showAffiliations fetches everyone with an affiliation, such as the ban list,
+// and shows them in the UI.
+func showAffiliations(e event.LoadAffiliations, c *client.Client, pane *ui.UI, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p := c.Printer()
+	items, err := c.Affiliations(ctx, e.Room, e.Affiliation)
+	if err != nil {
+		logger.Print(p.Sprintf("error fetching the %s list of %s: %v", e.Affiliation, e.Room, err))
+		return
+	}
+	pane.ShowAffiliations(e.Room, c.Affiliation(e.Room), e.Affiliation, items)
+}
+
+// configureChannel shows the configuration form of room and submits it.
+// If create is true the channel is joined first so that the server creates it
+// and it is bookmarked once the form has been submitted.
+func configureChannel(room jid.JID, create bool, c *client.Client, pane *ui.UI, acct account, debug, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p := c.Printer()
+	var created bool
+	if create {
+		openChannel(bookmarks.Channel{JID: room}, c, acct, debug, logger)
+		created = c.Created(room)
+		if !created {
+			logger.Print(p.Sprintf("channel %s already exists, showing its configuration instead", room))
+		}
+	}
+	config, err := c.ChannelConfig(ctx, room)
+	if err != nil {
+		logger.Print(p.Sprintf("error fetching the configuration of %s: %v", room, err))
+		return
+	}
+
+	saveBtn := p.Sprintf("Save")
+	cancelBtn := p.Sprintf("Cancel")
+	pane.ShowForm(config, p.Sprintf("Configure %s", room), []string{saveBtn, cancelBtn}, func(label string) {
+		pane.SelectRoster()
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if label != saveBtn {
+				err := c.CancelChannelConfig(ctx, room)
+				if err != nil {
+					logger.Print(p.Sprintf("error canceling the configuration of %s: %v", room, err))
+				}
+				return
+			}
+			err := c.SetChannelConfig(ctx, room, config)
+			if err != nil {
+				logger.Print(p.Sprintf("error saving the configuration of %s: %v", room, err))
+				return
+			}
+			// Bookmark channels that we created so that they are still listed the
+			// next time bookmarks are fetched.
+			// Updating the bookmark sends an event.UpdateBookmark, which publishes
+			// it to the server.
+			if created {
+				pane.UpdateBookmarks(bookmarks.Channel{JID: room})
+			}
+		}()
+	})
+}